@@ -2,6 +2,7 @@ package api
 
 import (
 	"fmt"
+	"net/url"
 	"sort"
 	"time"
 )
@@ -58,6 +59,19 @@ func (a *Allocations) Stats(alloc *Allocation, q *QueryOptions) (*AllocResourceU
 	return &resp, err
 }
 
+// LxcContainer returns the state of the container created by the LXC driver
+// for the task of the allocation.
+func (a *Allocations) LxcContainer(alloc *Allocation, task string, q *QueryOptions) (*LxcContainer, error) {
+	nodeClient, err := a.client.GetNodeClient(alloc.NodeID, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp LxcContainer
+	_, err = nodeClient.query("/v1/client/allocation/"+alloc.ID+"/lxc?task="+url.QueryEscape(task), &resp, nil)
+	return &resp, err
+}
+
 func (a *Allocations) GC(alloc *Allocation, q *QueryOptions) error {
 	nodeClient, err := a.client.GetNodeClient(alloc.NodeID, q)
 	if err != nil {
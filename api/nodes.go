@@ -1,8 +1,11 @@
 package api
 
 import (
+	"fmt"
+	"net/url"
 	"sort"
 	"strconv"
+	"time"
 )
 
 // Nodes is used to query node-related API endpoints
@@ -94,6 +97,112 @@ func (n *Nodes) GC(nodeID string, q *QueryOptions) error {
 	return err
 }
 
+// LxcContainers lists the containers created by the LXC driver on the node.
+func (n *Nodes) LxcContainers(nodeID string, q *QueryOptions) ([]*LxcContainer, error) {
+	nodeClient, err := n.client.GetNodeClient(nodeID, q)
+	if err != nil {
+		return nil, err
+	}
+	var resp []*LxcContainer
+	if _, err := nodeClient.query("/v1/client/lxc/containers", &resp, nil); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// LxcContainer returns the named container created by the LXC driver on the
+// node.
+func (n *Nodes) LxcContainer(nodeID, name string, q *QueryOptions) (*LxcContainer, error) {
+	nodeClient, err := n.client.GetNodeClient(nodeID, q)
+	if err != nil {
+		return nil, err
+	}
+	var resp LxcContainer
+	if _, err := nodeClient.query("/v1/client/lxc/container/"+name, &resp, nil); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// PruneLxcContainers destroys the orphaned containers created by the LXC
+// driver on the node and returns the ones that were removed.
+func (n *Nodes) PruneLxcContainers(nodeID string, q *QueryOptions) ([]*LxcContainer, error) {
+	nodeClient, err := n.client.GetNodeClient(nodeID, q)
+	if err != nil {
+		return nil, err
+	}
+	var resp []*LxcContainer
+	if _, err := nodeClient.putQuery("/v1/client/lxc/prune", nil, &resp, nil); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// DestroyLxcContainers stops and destroys all the containers created by the
+// LXC driver on the node, destroying parallelism containers at once, or the
+// client's default if zero. The driver must be in maintenance.
+func (n *Nodes) DestroyLxcContainers(nodeID string, parallelism int, q *QueryOptions) (*LxcDestroyReport, error) {
+	nodeClient, err := n.client.GetNodeClient(nodeID, q)
+	if err != nil {
+		return nil, err
+	}
+	path := "/v1/client/lxc/destroy"
+	if parallelism > 0 {
+		path += fmt.Sprintf("?parallelism=%d", parallelism)
+	}
+	var resp LxcDestroyReport
+	if _, err := nodeClient.putQuery(path, nil, &resp, nil); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// BackupLxcContainer backs up the named container created by the LXC driver
+// on the node to the client's backup destination. If checkpoint is set, the
+// state of the container's processes is included.
+func (n *Nodes) BackupLxcContainer(nodeID, name string, checkpoint bool, q *QueryOptions) (*LxcBackup, error) {
+	nodeClient, err := n.client.GetNodeClient(nodeID, q)
+	if err != nil {
+		return nil, err
+	}
+	var resp LxcBackup
+	path := fmt.Sprintf("/v1/client/lxc/container/%s/backup?checkpoint=%t", name, checkpoint)
+	if _, err := nodeClient.putQuery(path, nil, &resp, nil); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// LxcMaintenance returns whether the LXC driver on the node is in
+// maintenance.
+func (n *Nodes) LxcMaintenance(nodeID string, q *QueryOptions) (*LxcMaintenance, error) {
+	nodeClient, err := n.client.GetNodeClient(nodeID, q)
+	if err != nil {
+		return nil, err
+	}
+	var resp LxcMaintenance
+	if _, err := nodeClient.query("/v1/client/lxc/maintenance", &resp, nil); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SetLxcMaintenance puts the LXC driver on the node into maintenance, where
+// it starts no new containers while the node stays eligible for other
+// drivers, or takes it out of it.
+func (n *Nodes) SetLxcMaintenance(nodeID string, enable bool, reason string, q *QueryOptions) (*LxcMaintenance, error) {
+	nodeClient, err := n.client.GetNodeClient(nodeID, q)
+	if err != nil {
+		return nil, err
+	}
+	var resp LxcMaintenance
+	path := fmt.Sprintf("/v1/client/lxc/maintenance?enable=%t&reason=%s", enable, url.QueryEscape(reason))
+	if _, err := nodeClient.putQuery(path, nil, &resp, nil); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 // Node is used to deserialize a node entry.
 type Node struct {
 	ID                string
@@ -198,3 +307,42 @@ func (a AllocationSort) Less(i, j int) bool {
 func (a AllocationSort) Swap(i, j int) {
 	a[i], a[j] = a[j], a[i]
 }
+
+// LxcContainer describes a container created by the LXC driver on a node.
+type LxcContainer struct {
+	Name           string
+	AllocID        string
+	Task           string
+	State          string
+	InitPid        int
+	Uptime         time.Duration
+	LxcPath        string
+	RootFS         string
+	Orphaned       bool
+	PreservedUntil time.Time
+}
+
+// LxcBackup describes a backup of a container created by the LXC driver.
+type LxcBackup struct {
+	Container  string
+	Location   string
+	Size       int64
+	Checkpoint bool
+	Duration   time.Duration
+}
+
+// LxcDestroyReport describes the destruction of all the containers created
+// by the LXC driver on a node.
+type LxcDestroyReport struct {
+	Destroyed []string
+	Failed    map[string]string
+	Duration  time.Duration
+}
+
+// LxcMaintenance describes whether the LXC driver on a node is in
+// maintenance.
+type LxcMaintenance struct {
+	Paused bool
+	Reason string
+	Since  time.Time
+}
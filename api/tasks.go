@@ -32,10 +32,25 @@ type CpuStats struct {
 	Measured         []string
 }
 
-// ResourceUsage holds information related to cpu and memory stats
+// FilesystemStats holds the usage of a filesystem
+type FilesystemStats struct {
+	Used      uint64
+	Available uint64
+}
+
+// DiskStats holds filesystem usage related stats
+type DiskStats struct {
+	Used        uint64
+	Available   uint64
+	Filesystems map[string]*FilesystemStats
+	Measured    []string
+}
+
+// ResourceUsage holds information related to cpu, memory and disk stats
 type ResourceUsage struct {
 	MemoryStats *MemoryStats
 	CpuStats    *CpuStats
+	DiskStats   *DiskStats
 }
 
 // TaskResourceUsage holds aggregated resource usage of all processes in a Task
@@ -300,6 +300,9 @@ func NewClient(cfg *config.Config, consulCatalog consul.CatalogAPI, consulServic
 	// Start collecting stats
 	go c.emitStats()
 
+	// Prune the containers the LXC driver left behind
+	go c.pruneLxcContainers()
+
 	c.logger.Printf("[INFO] client: Node ID %q", c.NodeID())
 	return c, nil
 }
@@ -390,6 +393,14 @@ func (c *Client) reloadTLSConnections(newConfig *nconfig.TLSConfig) error {
 
 // Reload allows a client to reload its configuration on the fly
 func (c *Client) Reload(newConfig *config.Config) error {
+	c.reloadLxcOptions(newConfig.Options)
+
+	c.configLock.RLock()
+	tlsChanged := !c.config.TLSConfig.Equals(newConfig.TLSConfig)
+	c.configLock.RUnlock()
+	if !tlsChanged {
+		return nil
+	}
 	return c.reloadTLSConnections(newConfig.TLSConfig)
 }
 
@@ -3,23 +3,26 @@
 package driver
 
 import (
-	"context"
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"syscall"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	multierror "github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/nomad/client/config"
 	"github.com/hashicorp/nomad/client/fingerprint"
 	"github.com/hashicorp/nomad/client/stats"
-	"github.com/hashicorp/nomad/helper/fields"
 	"github.com/hashicorp/nomad/nomad/structs"
-	"github.com/mitchellh/mapstructure"
 
 	dstructs "github.com/hashicorp/nomad/client/driver/structs"
 	cstructs "github.com/hashicorp/nomad/client/structs"
@@ -36,15 +39,29 @@ const (
 	lxcVolumesConfigOption  = "lxc.volumes.enabled"
 	lxcVolumesConfigDefault = true
 
-	// containerMonitorIntv is the interval at which the driver checks if the
-	// container is still alive
-	containerMonitorIntv = 2 * time.Second
+	// lxcNameCollisionConfigOption is the key for the policy applied when a
+	// container with the task's name already exists, such as one left behind
+	// by a crash. It is one of the lxcNameCollision* values.
+	lxcNameCollisionConfigOption  = "driver.lxc.name_collision"
+	lxcNameCollisionFail          = "fail"
+	lxcNameCollisionRecreate      = "recreate"
+	lxcNameCollisionAdopt         = "adopt"
+	lxcNameCollisionConfigDefault = lxcNameCollisionFail
+
+	// lxcSharedVolumesDir is the directory of the shared alloc dir holding
+	// the allocation's shared volumes
+	lxcSharedVolumesDir = "volumes"
+
+	// lxcContainerResKey is the CreatedResources key for containers
+	lxcContainerResKey = "container"
 )
 
 var (
 	LXCMeasuredCpuStats = []string{"System Mode", "User Mode", "Percent"}
 
 	LXCMeasuredMemStats = []string{"RSS", "Cache", "Swap", "Max Usage", "Kernel Usage", "Kernel Max Usage"}
+
+	LXCMeasuredDiskStats = []string{"Used", "Available"}
 )
 
 // Add the lxc driver to the list of builtin drivers
@@ -56,126 +73,21 @@ func init() {
 type LxcDriver struct {
 	DriverContext
 	fingerprint.StaticFingerprinter
-}
 
-// LxcDriverConfig is the configuration of the LXC Container
-type LxcDriverConfig struct {
-	Template             string
-	Distro               string
-	Release              string
-	Arch                 string
-	ImageVariant         string   `mapstructure:"image_variant"`
-	ImageServer          string   `mapstructure:"image_server"`
-	GPGKeyID             string   `mapstructure:"gpg_key_id"`
-	GPGKeyServer         string   `mapstructure:"gpg_key_server"`
-	DisableGPGValidation bool     `mapstructure:"disable_gpg"`
-	FlushCache           bool     `mapstructure:"flush_cache"`
-	ForceCache           bool     `mapstructure:"force_cache"`
-	TemplateArgs         []string `mapstructure:"template_args"`
-	LogLevel             string   `mapstructure:"log_level"`
-	Verbosity            string
-	Volumes              []string `mapstructure:"volumes"`
+	backend lxcBackend
 }
 
 // NewLxcDriver returns a new instance of the LXC driver
 func NewLxcDriver(ctx *DriverContext) Driver {
-	return &LxcDriver{DriverContext: *ctx}
-}
-
-// Validate validates the lxc driver configuration
-func (d *LxcDriver) Validate(config map[string]interface{}) error {
-	fd := &fields.FieldData{
-		Raw: config,
-		Schema: map[string]*fields.FieldSchema{
-			"template": {
-				Type:     fields.TypeString,
-				Required: true,
-			},
-			"distro": {
-				Type:     fields.TypeString,
-				Required: false,
-			},
-			"release": {
-				Type:     fields.TypeString,
-				Required: false,
-			},
-			"arch": {
-				Type:     fields.TypeString,
-				Required: false,
-			},
-			"image_variant": {
-				Type:     fields.TypeString,
-				Required: false,
-			},
-			"image_server": {
-				Type:     fields.TypeString,
-				Required: false,
-			},
-			"gpg_key_id": {
-				Type:     fields.TypeString,
-				Required: false,
-			},
-			"gpg_key_server": {
-				Type:     fields.TypeString,
-				Required: false,
-			},
-			"disable_gpg": {
-				Type:     fields.TypeString,
-				Required: false,
-			},
-			"flush_cache": {
-				Type:     fields.TypeString,
-				Required: false,
-			},
-			"force_cache": {
-				Type:     fields.TypeString,
-				Required: false,
-			},
-			"template_args": {
-				Type:     fields.TypeArray,
-				Required: false,
-			},
-			"log_level": {
-				Type:     fields.TypeString,
-				Required: false,
-			},
-			"verbosity": {
-				Type:     fields.TypeString,
-				Required: false,
-			},
-			"volumes": {
-				Type:     fields.TypeArray,
-				Required: false,
-			},
-		},
-	}
-
-	if err := fd.Validate(); err != nil {
-		return err
-	}
-
-	volumes, _ := fd.GetOk("volumes")
-	for _, volDesc := range volumes.([]interface{}) {
-		volStr := volDesc.(string)
-		paths := strings.Split(volStr, ":")
-		if len(paths) != 2 {
-			return fmt.Errorf("invalid volume bind mount entry: '%s'", volStr)
-		}
-		if len(paths[0]) == 0 || len(paths[1]) == 0 {
-			return fmt.Errorf("invalid volume bind mount entry: '%s'", volStr)
-		}
-		if paths[1][0] == '/' {
-			return fmt.Errorf("unsupported absolute container mount point: '%s'", paths[1])
-		}
-	}
-
-	return nil
+	d := &LxcDriver{DriverContext: *ctx, backend: defaultLxcBackend}
+	d.config = lxcReloaded.apply(ctx.config)
+	return d
 }
 
 func (d *LxcDriver) Abilities() DriverAbilities {
 	return DriverAbilities{
-		SendSignals: false,
-		Exec:        false,
+		SendSignals: true,
+		Exec:        true,
 	}
 }
 
@@ -189,29 +101,80 @@ func (d *LxcDriver) Fingerprint(cfg *config.Config, node *structs.Node) (bool, e
 	if !enabled && !cfg.DevMode {
 		return false, nil
 	}
-	version := lxc.Version()
+	version := d.backend.Version()
 	if version == "" {
 		return false, nil
 	}
 	node.Attributes["driver.lxc.version"] = version
 	node.Attributes["driver.lxc"] = "1"
+	paused := d.fingerprintMaintenance(cfg, node)
+
+	// The driver fingerprinted periodically is created when the client
+	// starts, so pick up the options reloaded since
+	d.config = lxcReloaded.apply(cfg)
 
 	// Advertise if this node supports lxc volumes
 	if d.config.ReadBoolDefault(lxcVolumesConfigOption, lxcVolumesConfigDefault) {
 		node.Attributes["driver."+lxcVolumesConfigOption] = "1"
 	}
+	if d.config.ReadBoolDefault(lxcPrivilegedProvisionConfigOption, lxcPrivilegedProvisionConfigDefault) {
+		node.Attributes[lxcPrivilegedProvisionConfigOption] = "1"
+	} else {
+		delete(node.Attributes, lxcPrivilegedProvisionConfigOption)
+	}
+
+	d.fingerprintKernel(node)
+	d.fingerprintTools(node)
+	d.fingerprintLVM(node)
+	d.fingerprintVolumeGroups(node)
+	d.fingerprintBridges(node)
+	d.fingerprintCoreDumps(node)
+	d.fingerprintSwapAccounting(node)
+	d.fingerprintArches(node)
+	d.fingerprintPrepull(node)
+	d.fingerprintWarmPool()
+	d.fingerprintBtrfs(node)
+
+	return !paused, nil
+}
 
-	return true, nil
+// Periodic fingerprints the driver periodically, as the storage committed on
+// the node changes as containers come and go.
+func (d *LxcDriver) Periodic() (bool, time.Duration) {
+	return true, lxcFingerprintPeriod
 }
 
-func (d *LxcDriver) Prestart(*ExecContext, *structs.Task) (*PrestartResponse, error) {
-	return nil, nil
+func (d *LxcDriver) Prestart(_ *ExecContext, task *structs.Task) (*PrestartResponse, error) {
+	if err := d.checkMaintenance(); err != nil {
+		return nil, err
+	}
+	if err := d.prestartCheck(task); err != nil {
+		return nil, err
+	}
+
+	// The container is kept across restarts of the task, which reuse it,
+	// and destroyed by Cleanup once the task is done with
+	resp := NewPrestartResponse()
+	resp.CreatedResources.Add(lxcContainerResKey, lxcContainerName(task.Name, d.DriverContext.allocID))
+	return resp, nil
 }
 
 // Start starts the LXC Driver
 func (d *LxcDriver) Start(ctx *ExecContext, task *structs.Task) (*StartResponse, error) {
 	sresp, err, errCleanup := d.startWithCleanup(ctx, task)
 	if err != nil {
+		name := lxcContainerName(task.Name, d.DriverContext.allocID)
+		d.recordStartFailure(name, err)
+
+		// Creating and destroying the container of a task failing over and
+		// over churns through storage, so crash looping containers are kept
+		// for the next attempt to reuse
+		if d.crashLooping(name, lxcFailureReason(name, err)) && d.keepForRetry(name) {
+			return sresp, err
+		}
+		if d.preserveFailedStart(ctx, task, name) {
+			return sresp, err
+		}
 		if cleanupErr := errCleanup(); cleanupErr != nil {
 			d.logger.Printf("[ERR] error occurred while cleaning up from error in Start: %v", cleanupErr)
 		}
@@ -221,17 +184,34 @@ func (d *LxcDriver) Start(ctx *ExecContext, task *structs.Task) (*StartResponse,
 
 func (d *LxcDriver) startWithCleanup(ctx *ExecContext, task *structs.Task) (*StartResponse, error, func() error) {
 	noCleanup := func() error { return nil }
-	var driverConfig LxcDriverConfig
-	if err := mapstructure.WeakDecode(task.Config, &driverConfig); err != nil {
+	driverConfig, err := NewLxcDriverConfig(task, ctx.TaskEnv)
+	if err != nil {
+		return nil, err, noCleanup
+	}
+	enforceSwap, err := d.checkSwapAccounting(driverConfig.Limits[0])
+	if err != nil {
+		return nil, err, noCleanup
+	}
+	if err := d.checkArch(driverConfig.Arch); err != nil {
+		return nil, err, noCleanup
+	}
+	containerName := lxcContainerName(task.Name, d.DriverContext.allocID)
+	lxcPath, err := d.selectLxcPath(containerName)
+	if err != nil {
 		return nil, err, noCleanup
 	}
-	lxcPath := lxc.DefaultConfigPath()
-	if path := d.config.Read("driver.lxc.path"); path != "" {
-		lxcPath = path
+	if err := d.checkBackend(driverConfig.Backend, lxcPath); err != nil {
+		return nil, err, noCleanup
+	}
+
+	// Containers of images kept in the warm pool are claimed from it rather
+	// than created
+	var claimed bool
+	if image, ok := driverConfig.poolImage(); ok && !lxcContainerDefined(d.backend, lxcPath, containerName) {
+		claimed = d.claimPooled(image, containerName, lxcPath)
 	}
 
-	containerName := fmt.Sprintf("%s-%s", task.Name, d.DriverContext.allocID)
-	c, err := lxc.NewContainer(containerName, lxcPath)
+	c, err := d.backend.NewContainer(containerName, lxcPath)
 	if err != nil {
 		return nil, fmt.Errorf("unable to initialize container: %v", err), noCleanup
 	}
@@ -247,18 +227,19 @@ func (d *LxcDriver) startWithCleanup(ctx *ExecContext, task *structs.Task) (*Sta
 	}
 	c.SetVerbosity(verbosity)
 
+	// The exit status of the container is read from its log at the info
+	// level, so less verbose levels are raised to it
 	var logLevel lxc.LogLevel
 	switch driverConfig.LogLevel {
 	case "trace":
 		logLevel = lxc.TRACE
 	case "debug":
 		logLevel = lxc.DEBUG
-	case "info":
+	case "", "info":
+		logLevel = lxc.INFO
+	case "warn", "error":
+		d.logger.Printf("[DEBUG] driver.lxc: raising log_level %q of container %q to info to read its exit status", driverConfig.LogLevel, containerName)
 		logLevel = lxc.INFO
-	case "warn":
-		logLevel = lxc.WARN
-	case "", "error":
-		logLevel = lxc.ERROR
 	default:
 		return nil, fmt.Errorf("lxc driver config 'log_level' can only be trace, debug, info, warn or error"), noCleanup
 	}
@@ -267,23 +248,178 @@ func (d *LxcDriver) startWithCleanup(ctx *ExecContext, task *structs.Task) (*Sta
 	logFile := filepath.Join(ctx.TaskDir.Dir, fmt.Sprintf("%v-lxc.log", task.Name))
 	c.SetLogFile(logFile)
 
-	options := lxc.TemplateOptions{
-		Template:             driverConfig.Template,
-		Distro:               driverConfig.Distro,
-		Release:              driverConfig.Release,
-		Arch:                 driverConfig.Arch,
-		FlushCache:           driverConfig.FlushCache,
-		DisableGPGValidation: driverConfig.DisableGPGValidation,
-		ExtraArgs:            driverConfig.TemplateArgs,
+	configHash, err := driverConfig.hash()
+	if err != nil {
+		return nil, err, noCleanup
 	}
 
-	if err := c.Create(options); err != nil {
-		return nil, fmt.Errorf("unable to create container: %v", err), noCleanup
+	var adopted bool
+	if !claimed {
+		if adopted, err = d.handleNameCollision(c, configHash); err != nil {
+			return nil, err, noCleanup
+		}
 	}
 
-	// Set the network type to none
-	if err := c.SetConfigItem("lxc.network.type", "none"); err != nil {
-		return nil, fmt.Errorf("error setting network type configuration: %v", err), c.Destroy
+	if claimed {
+		if err := writeLxcConfigHash(c, configHash); err != nil {
+			d.logger.Printf("[WARN] driver.lxc: unable to record config hash of container %q: %v", containerName, err)
+		}
+	} else if !adopted {
+		template, err := lxcTemplate(ctx.TaskDir.Dir, driverConfig.Template)
+		if err != nil {
+			return nil, err, noCleanup
+		}
+		var archiveArgs []string
+		if driverConfig.RootfsArchive != "" {
+			template, archiveArgs, err = rootfsArchiveTemplate(ctx.TaskDir.Dir, driverConfig.RootfsArchive)
+			if err != nil {
+				return nil, err, noCleanup
+			}
+		}
+
+		options := lxc.TemplateOptions{
+			Template:             template,
+			Backend:              lxcBackends[driverConfig.Backend],
+			Distro:               driverConfig.Distro,
+			Release:              driverConfig.Release,
+			Arch:                 driverConfig.Arch,
+			Variant:              driverConfig.ImageVariant,
+			Server:               driverConfig.ImageServer,
+			KeyID:                driverConfig.GPGKeyID,
+			KeyServer:            driverConfig.GPGKeyServer,
+			FlushCache:           driverConfig.FlushCache,
+			ForceCache:           driverConfig.ForceCache,
+			DisableGPGValidation: driverConfig.DisableGPGValidation,
+			ExtraArgs:            append(archiveArgs, driverConfig.TemplateArgs...),
+		}
+		d.applyImageMirrors(&options)
+		if driverConfig.Template == lxcDownloadTemplate {
+			d.applyImageServerAuth(&options, driverConfig)
+		}
+		if driverConfig.ImageSerial != "" {
+			d.pinImageSerial(&options, driverConfig.ImageSerial)
+		}
+
+		// The oci template pulls and unpacks the image given by its URL
+		if driverConfig.OCIImage != "" {
+			if err := d.checkOCITools(); err != nil {
+				return nil, err, noCleanup
+			}
+			options.ExtraArgs = append([]string{"--url", lxcOCIImageURL(driverConfig.OCIImage)}, options.ExtraArgs...)
+		}
+
+		if err := d.checkStorageSpace(driverConfig.Backend, lxcPath); err != nil {
+			return nil, err, noCleanup
+		}
+		if err := d.createContainer(c, options, d.templateEnv(driverConfig), d.createTimeout(driverConfig)); err != nil {
+			return nil, structs.NewRecoverableError(fmt.Errorf("unable to create container: %v", err), structs.IsRecoverable(err)), noCleanup
+		}
+		if driverConfig.ImageSerial != "" {
+			if err := d.checkImageSerial(options, driverConfig.ImageSerial); err != nil {
+				return nil, err, c.Destroy
+			}
+		}
+		if driverConfig.RootfsSizeMB > 0 {
+			if err := d.limitRootfsSize(c, driverConfig.RootfsSizeMB); err != nil {
+				return nil, err, c.Destroy
+			}
+		}
+		if err := writeLxcConfigHash(c, configHash); err != nil {
+			d.logger.Printf("[WARN] driver.lxc: unable to record config hash of container %q: %v", containerName, err)
+		}
+	}
+
+	// Copy task directory paths, such as unpacked artifacts, into the
+	// container before it starts
+	if len(driverConfig.RootfsCopies) != 0 {
+		if err := d.copyIntoRootfs(c, ctx.TaskDir.Dir, driverConfig.RootfsCopies); err != nil {
+			return nil, err, c.Destroy
+		}
+	}
+
+	if err := setLxcNetwork(c, driverConfig.Network[0]); err != nil {
+		return nil, err, c.Destroy
+	}
+
+	// Name the host side of the veth pair of containers whose traffic is
+	// shaped, to find it once the container runs
+	bandwidth := d.containerBandwidth(driverConfig, task)
+	if bandwidth.shaped() {
+		if err := setLxcVethPair(c, lxcVethPair(c.Name())); err != nil {
+			return nil, err, c.Destroy
+		}
+	}
+
+	// Containers joining another task's network share its interface, which
+	// the task's ports are forwarded to and its services advertise
+	netConfig, netContainer := driverConfig.Network[0], c
+	if task := driverConfig.sharedNetworkTask(); task != "" {
+		var shared lxcContainerAPI
+		netConfig, shared, err = d.shareNetwork(c, task)
+		if err != nil {
+			return nil, err, c.Destroy
+		}
+		defer d.backend.Release(shared)
+		netContainer = shared
+	}
+	if path := driverConfig.sharedNetns(); path != "" {
+		if err := d.joinNetns(c, path); err != nil {
+			return nil, err, c.Destroy
+		}
+	}
+
+	// Write the console output, which is the only place some early boot
+	// failures show up, next to the task's logs so it can be streamed
+	// through the logs API
+	if driverConfig.ConsoleLog {
+		consoleLog := filepath.Join(ctx.TaskDir.LogDir, fmt.Sprintf("%s.console.0", task.Name))
+		if err := c.SetConfigItem("lxc.console.logfile", consoleLog); err != nil {
+			return nil, fmt.Errorf("error setting console log file: %v", err), c.Destroy
+		}
+	}
+
+	if err := setContainerMode(c, driverConfig, task, ctx.TaskDir.LogDir); err != nil {
+		return nil, err, c.Destroy
+	}
+
+	if err := d.setContainerTime(c, driverConfig); err != nil {
+		return nil, err, c.Destroy
+	}
+
+	// Set the container's name resolution ahead of provisioning, which may
+	// need it
+	dnsMount, err := d.setContainerDNS(c, driverConfig, ctx.TaskDir.Dir)
+	if err != nil {
+		return nil, err, c.Destroy
+	}
+	hostsMount, err := d.setContainerHosts(c, driverConfig, ctx.TaskDir.Dir)
+	if err != nil {
+		return nil, err, c.Destroy
+	}
+
+	defaults := d.defaultEnv()
+	for k, v := range lxcPortEnv(task, driverConfig.PortMap) {
+		defaults[k] = v
+	}
+	vars := containerEnv(defaults, task, ctx.TaskEnv, driverConfig.Timezone)
+
+	// Provision newly created containers once their root filesystem is
+	// complete. Reused containers were provisioned when created.
+	if !adopted && len(driverConfig.ProvisionCmds) != 0 {
+		if err := d.provisionRootfs(c, driverConfig.ProvisionCmds, vars); err != nil {
+			return nil, err, c.Destroy
+		}
+	}
+	if err := setContainerEnv(c, vars); err != nil {
+		return nil, err, c.Destroy
+	}
+
+	if err := d.setContainerCgroup(c, driverConfig, ctx.TaskEnv); err != nil {
+		return nil, err, c.Destroy
+	}
+
+	if err := setHaltSignal(c, task); err != nil {
+		return nil, err, c.Destroy
 	}
 
 	// Bind mount the shared alloc dir and task local dir in the container
@@ -292,23 +428,74 @@ func (d *LxcDriver) startWithCleanup(ctx *ExecContext, task *structs.Task) (*Sta
 		fmt.Sprintf("%s alloc none rw,bind,create=dir", ctx.TaskDir.SharedAllocDir),
 		fmt.Sprintf("%s secrets none rw,bind,create=dir", ctx.TaskDir.SecretsDir),
 	}
+	aaMounts := []lxcAppArmorMount{{Target: "local"}, {Target: "alloc"}, {Target: "secrets"}}
 
 	volumesEnabled := d.config.ReadBoolDefault(lxcVolumesConfigOption, lxcVolumesConfigDefault)
 
-	for _, volDesc := range driverConfig.Volumes {
-		// the format was checked in Validate()
-		paths := strings.Split(volDesc, ":")
-
-		if filepath.IsAbs(paths[0]) {
+	for _, m := range driverConfig.Mounts {
+		source := m.Source
+		if filepath.IsAbs(source) {
 			if !volumesEnabled {
 				return nil, fmt.Errorf("absolute bind-mount volume in config but '%v' is false", lxcVolumesConfigOption), c.Destroy
 			}
 		} else {
 			// Relative source paths are treated as relative to alloc dir
-			paths[0] = filepath.Join(ctx.TaskDir.Dir, paths[0])
+			source = filepath.Join(ctx.TaskDir.Dir, source)
+		}
+
+		mode := "rw"
+		if m.ReadOnly {
+			mode = "ro"
+		}
+		mounts = append(mounts, fmt.Sprintf("%s %s none %s,bind,create=dir", source, m.Target, mode))
+		aaMounts = append(aaMounts, lxcAppArmorMount{Target: m.Target, ReadOnly: m.ReadOnly})
+	}
+
+	// Shared volumes are created by the first task of the allocation using
+	// them and removed with the allocation directory
+	for _, v := range driverConfig.SharedVolumes {
+		source := filepath.Join(ctx.TaskDir.SharedAllocDir, lxcSharedVolumesDir, v.Name)
+		if err := os.MkdirAll(source, 0777); err != nil {
+			return nil, fmt.Errorf("unable to create shared volume %q: %v", v.Name, err), c.Destroy
+		}
+
+		mode := "rw"
+		if v.ReadOnly {
+			mode = "ro"
+		}
+		mounts = append(mounts, fmt.Sprintf("%s %s none %s,bind,create=dir", source, v.Target, mode))
+		aaMounts = append(aaMounts, lxcAppArmorMount{Target: v.Target, ReadOnly: v.ReadOnly})
+	}
+
+	// Collect the core dumps of the container's processes in the task
+	// directory, where they are removed with the allocation
+	coreDumps := newLxcCoreDumps(driverConfig.CoreDumps, ctx.TaskDir.Dir)
+	if coreDumps != nil {
+		mnt, err := d.setCoreDumps(c, coreDumps)
+		if err != nil {
+			return nil, err, c.Destroy
 		}
+		mounts = append(mounts, mnt)
+		aaMounts = append(aaMounts, lxcAppArmorMount{Target: strings.TrimPrefix(lxcCoreDumpsTarget, "/")})
+	}
 
-		mounts = append(mounts, fmt.Sprintf("%s %s none rw,bind,create=dir", paths[0], paths[1]))
+	// Project the secrets into the container read-only, each mounting a copy
+	// of its file with the configured mode and owner
+	secrets, err := lxcSecrets(c, ctx.TaskDir.SecretsDir, driverConfig.Secrets)
+	if err != nil {
+		return nil, err, c.Destroy
+	}
+	for i, s := range secrets {
+		if _, err := s.project(); err != nil {
+			return nil, fmt.Errorf("secret[%d]: %v", i, err), c.Destroy
+		}
+		target := strings.TrimPrefix(driverConfig.Secrets[i].Target, "/")
+		mounts = append(mounts, fmt.Sprintf("%s %s none ro,bind,create=file", s.Path, target))
+	}
+	for _, mnt := range []string{dnsMount, hostsMount} {
+		if mnt != "" {
+			mounts = append(mounts, mnt)
+		}
 	}
 
 	for _, mnt := range mounts {
@@ -317,16 +504,35 @@ func (d *LxcDriver) startWithCleanup(ctx *ExecContext, task *structs.Task) (*Sta
 		}
 	}
 
+	// Confine the container to a profile generated from its config. From
+	// here on the profile is unloaded with the container.
+	if err := d.loadAppArmorProfile(c, aaMounts, driverConfig); err != nil {
+		return nil, err, c.Destroy
+	}
+	destroyCleanup := func() error {
+		if err := unloadAppArmorProfile(d.backend, c); err != nil {
+			d.logger.Printf("[WARN] driver.lxc: %v", err)
+		}
+		return c.Destroy()
+	}
+
+	// Ship the container's logs from what it writes once started
+	logShipping := d.newLxcLogShipping(driverConfig, task, ctx.TaskEnv, ctx.TaskDir.LogDir)
+
 	// Start the container
-	if err := c.Start(); err != nil {
-		return nil, fmt.Errorf("unable to start container: %v", err), c.Destroy
+	if err := d.startContainer(c); err != nil {
+		return nil, fmt.Errorf("unable to start container: %v", err), destroyCleanup
+	}
+
+	if err := unpreserveContainer(c); err != nil {
+		d.logger.Printf("[WARN] driver.lxc: unable to clear preservation of container %q: %v", containerName, err)
 	}
 
 	stopAndDestroyCleanup := func() error {
 		if err := c.Stop(); err != nil {
 			return err
 		}
-		return c.Destroy()
+		return destroyCleanup()
 	}
 
 	// Set the resource limits
@@ -337,8 +543,42 @@ func (d *LxcDriver) startWithCleanup(ctx *ExecContext, task *structs.Task) (*Sta
 		return nil, fmt.Errorf("unable to set cpu shares: %v", err), stopAndDestroyCleanup
 	}
 
+	limits := driverConfig.Limits[0]
+	if limits.MemorySwapMB > 0 && enforceSwap {
+		swap := lxc.ByteSize(task.Resources.MemoryMB+limits.MemorySwapMB) * lxc.MB
+		if err := c.SetMemorySwapLimit(swap); err != nil {
+			return nil, fmt.Errorf("unable to set memory swap limit: %v", err), stopAndDestroyCleanup
+		}
+	}
+	if limits.CPUSetCPUs != "" {
+		if err := c.SetCgroupItem("cpuset.cpus", limits.CPUSetCPUs); err != nil {
+			return nil, fmt.Errorf("unable to set cpuset cpus: %v", err), stopAndDestroyCleanup
+		}
+	}
+	if limits.PidsMax > 0 {
+		if err := c.SetCgroupItem("pids.max", strconv.Itoa(limits.PidsMax)); err != nil {
+			return nil, fmt.Errorf("unable to set pids limit: %v", err), stopAndDestroyCleanup
+		}
+	}
+	if bandwidth.shaped() {
+		if err := d.shapeBandwidth(c.Name(), bandwidth); err != nil {
+			return nil, err, stopAndDestroyCleanup
+		}
+	}
+
+	// Forward the task's ports to the container once it has an address
+	network := d.containerNetwork(netContainer, netConfig)
+	forwards, err := d.forwardPorts(c.Name(), task, netConfig, driverConfig.PortMap, network)
+	if err != nil {
+		return nil, err, stopAndDestroyCleanup
+	}
+	if network != nil && len(driverConfig.PortMap) != 0 {
+		network.PortMap = driverConfig.PortMap
+	}
+
 	h := lxcDriverHandle{
 		container:      c,
+		backend:        d.backend,
 		initPid:        c.InitPid(),
 		lxcPath:        lxcPath,
 		logger:         d.logger,
@@ -349,14 +589,163 @@ func (d *LxcDriver) startWithCleanup(ctx *ExecContext, task *structs.Task) (*Sta
 		systemCpuStats: stats.NewCpuStats(),
 		waitCh:         make(chan *dstructs.WaitResult, 1),
 		doneCh:         make(chan bool, 1),
+		sync:           newLxcSync(driverConfig.Sync, ctx.TaskDir.SharedAllocDir),
+		stopStages:     newLxcStop(driverConfig.Stop),
+		emitEvent:      d.emitEvent,
+		statsInterval:  d.statsInterval(),
+
+		env:               vars,
+		logFile:           logFile,
+		signalPidfile:     driverConfig.SignalPidfile,
+		secrets:           secrets,
+		usageAlerts:       driverConfig.usageAlerts(),
+		publishMetrics:    d.publishMetrics(),
+		systemdInterval:   newLxcSystemdInterval(driverConfig.Systemd),
+		recycleAt:         lxcRecycleAt(driverConfig, time.Now()),
+		preserveOnFailure: driverConfig.preserveOnFailure(),
+		started:           time.Now(),
+
+		crashLoopThreshold: d.config.ReadIntDefault(lxcCrashLoopThresholdConfigOption, lxcCrashLoopThresholdDefault),
+		portForwards:       forwards,
+		coreDumps:          coreDumps,
+		volumes:            lxcVolumeTargets(driverConfig),
+		logShipping:        logShipping,
 	}
 
 	go h.run()
 
-	return &StartResponse{Handle: &h}, nil, noCleanup
+	return &StartResponse{Handle: &h, Network: network}, nil, noCleanup
+}
+
+// handleNameCollision handles a container with the task's name already
+// existing. A usable container created from the same task config, such as
+// one left behind by a retried Start, is reused. Otherwise the configured
+// policy is applied. It returns whether the existing container is to be
+// adopted instead of creating a new one.
+func (d *LxcDriver) handleNameCollision(c lxcContainerAPI, configHash string) (bool, error) {
+	if !c.Defined() {
+		return false, nil
+	}
+
+	name := c.Name()
+	if state := c.State(); (state == lxc.STOPPED || state == lxc.RUNNING) && readLxcConfigHash(c) == configHash {
+		if state == lxc.RUNNING {
+			if err := c.Stop(); err != nil {
+				return false, fmt.Errorf("unable to stop existing container %q: %v", name, err)
+			}
+		}
+		d.logger.Printf("[INFO] driver.lxc: reusing existing container %q with matching config", name)
+		d.emitEvent("Reusing existing container %q created from the same config", name)
+		return true, nil
+	}
+
+	policy := d.config.ReadDefault(lxcNameCollisionConfigOption, lxcNameCollisionConfigDefault)
+	switch policy {
+	case lxcNameCollisionFail:
+		return false, fmt.Errorf("container %q already exists", name)
+	case lxcNameCollisionRecreate, lxcNameCollisionAdopt:
+	default:
+		return false, fmt.Errorf("invalid %s %q: must be one of %s, %s or %s", lxcNameCollisionConfigOption,
+			policy, lxcNameCollisionFail, lxcNameCollisionRecreate, lxcNameCollisionAdopt)
+	}
+
+	if c.Running() {
+		if err := c.Stop(); err != nil {
+			return false, fmt.Errorf("unable to stop existing container %q: %v", name, err)
+		}
+	}
+
+	if policy == lxcNameCollisionAdopt {
+		d.logger.Printf("[INFO] driver.lxc: adopting existing container %q", name)
+		d.emitEvent("Container %q already exists, restarting it", name)
+		return true, nil
+	}
+
+	d.logger.Printf("[INFO] driver.lxc: destroying existing container %q", name)
+	d.emitEvent("Container %q already exists, destroying and recreating it", name)
+	if err := unloadAppArmorProfile(d.backend, c); err != nil {
+		d.logger.Printf("[WARN] driver.lxc: %v", err)
+	}
+	if err := c.Destroy(); err != nil {
+		return false, fmt.Errorf("unable to destroy existing container %q: %v", name, err)
+	}
+	return false, nil
+}
+
+// lxcConfigHashFile is the file in the container's directory recording the
+// hash of the task config it was created from.
+const lxcConfigHashFile = "nomad-config-hash"
+
+// hash returns a hash identifying the task config, used to recognize
+// containers created from the same config.
+func (c *LxcDriverConfig) hash() (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("unable to hash task config: %v", err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
+
+// readLxcConfigHash returns the config hash recorded for the container, or
+// an empty string if none is.
+func readLxcConfigHash(c lxcContainerAPI) string {
+	data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(c.ConfigFileName()), lxcConfigHashFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// writeLxcConfigHash records the hash of the config the container was
+// created from in its directory, which is removed with the container.
+func writeLxcConfigHash(c lxcContainerAPI, configHash string) error {
+	path := filepath.Join(filepath.Dir(c.ConfigFileName()), lxcConfigHashFile)
+	return ioutil.WriteFile(path, []byte(configHash+"\n"), 0644)
+}
+
+// Cleanup destroys the containers recorded by Prestart. Containers that
+// failed to be destroyed are left in the resources for the cleanup to be
+// retried.
+func (d *LxcDriver) Cleanup(_ *ExecContext, res *CreatedResources) error {
+	retry := false
+	var merr multierror.Error
+	for key, resources := range res.Resources {
+		switch key {
+		case lxcContainerResKey:
+			for _, name := range resources {
+				if err := d.cleanupContainer(name); err != nil {
+					retry = true
+					merr.Errors = append(merr.Errors, err)
+					continue
+				}
+				res.Remove(lxcContainerResKey, name)
+			}
+		default:
+			d.logger.Printf("[ERR] driver.lxc: unknown resource to cleanup: %q", key)
+		}
+	}
+	return structs.NewRecoverableError(merr.ErrorOrNil(), retry)
 }
 
-func (d *LxcDriver) Cleanup(*ExecContext, *CreatedResources) error { return nil }
+// cleanupContainer destroys the named container of a task, if it was
+// created.
+func (d *LxcDriver) cleanupContainer(name string) error {
+	c, err := d.backend.NewContainer(name, findLxcPath(d.backend, d.config, name))
+	if err != nil {
+		return fmt.Errorf("unable to open container %q: %v", name, err)
+	}
+	defer d.backend.Release(c)
+	if !c.Defined() {
+		return nil
+	}
+	if until := lxcPreservedUntil(c); time.Now().Before(until) {
+		d.logger.Printf("[INFO] driver.lxc: keeping preserved container %q until %s", name, until.Format(time.RFC3339))
+		d.destroyPreserved(name, until)
+		return nil
+	}
+	d.logger.Printf("[INFO] driver.lxc: destroying container %q", name)
+	return destroyLxcContainer(d.backend, c)
+}
 
 // Open creates the driver to monitor an existing LXC container
 func (d *LxcDriver) Open(ctx *ExecContext, handleID string) (DriverHandle, error) {
@@ -364,22 +753,22 @@ func (d *LxcDriver) Open(ctx *ExecContext, handleID string) (DriverHandle, error
 	if err := json.Unmarshal([]byte(handleID), pid); err != nil {
 		return nil, fmt.Errorf("Failed to parse handle '%s': %v", handleID, err)
 	}
-
-	var container *lxc.Container
-	containers := lxc.Containers(pid.LxcPath)
-	for _, c := range containers {
-		if c.Name() == pid.ContainerName {
-			container = &c
-			break
-		}
+	if pid.Version > lxcHandleVersion {
+		return nil, fmt.Errorf("handle of container %q has version %d, newer than the supported version %d", pid.ContainerName, pid.Version, lxcHandleVersion)
 	}
 
-	if container == nil {
-		return nil, fmt.Errorf("container %v not found", pid.ContainerName)
+	container, err := openLxcContainer(d.backend, pid.ContainerName, pid.LxcPath)
+	if err != nil {
+		return nil, err
+	}
+	migrateLxcPID(ctx, container, pid)
+	if pid.LogShipping != nil {
+		pid.LogShipping.skipToEnd()
 	}
 
 	handle := lxcDriverHandle{
 		container:      container,
+		backend:        d.backend,
 		initPid:        container.InitPid(),
 		lxcPath:        pid.LxcPath,
 		logger:         d.logger,
@@ -390,6 +779,24 @@ func (d *LxcDriver) Open(ctx *ExecContext, handleID string) (DriverHandle, error
 		systemCpuStats: stats.NewCpuStats(),
 		waitCh:         make(chan *dstructs.WaitResult, 1),
 		doneCh:         make(chan bool, 1),
+		sync:           pid.Sync,
+		stopStages:     pid.Stop,
+		emitEvent:      d.emitEvent,
+		statsInterval:  d.statsInterval(),
+
+		env:               pid.Env,
+		logFile:           pid.LogFile,
+		signalPidfile:     pid.SignalPidfile,
+		secrets:           pid.Secrets,
+		usageAlerts:       pid.UsageAlerts,
+		publishMetrics:    d.publishMetrics(),
+		systemdInterval:   pid.SystemdInterval,
+		recycleAt:         pid.RecycleAt,
+		preserveOnFailure: pid.PreserveOnFailure,
+		portForwards:      pid.PortForwards,
+		coreDumps:         pid.CoreDumps,
+		volumes:           pid.Volumes,
+		logShipping:       pid.LogShipping,
 	}
 	go handle.run()
 
@@ -398,7 +805,8 @@ func (d *LxcDriver) Open(ctx *ExecContext, handleID string) (DriverHandle, error
 
 // lxcDriverHandle allows controlling the lifecycle of an lxc container
 type lxcDriverHandle struct {
-	container *lxc.Container
+	container lxcContainerAPI
+	backend   lxcBackend
 	initPid   int
 	lxcPath   string
 
@@ -413,21 +821,138 @@ type lxcDriverHandle struct {
 
 	waitCh chan *dstructs.WaitResult
 	doneCh chan bool
+
+	// sync is the optional sync step run before the container is stopped,
+	// and stopStages the optional stages of stopping it before the LXC
+	// shutdown
+	sync       *lxcSync
+	stopStages *lxcStop
+	emitEvent  LogEventFn
+
+	// env is the environment of the container, which commands executed in
+	// it get too
+	env []string
+
+	// logFile is the container's LXC log, which its exit status is read
+	// from
+	logFile string
+
+	// stopping is set once the driver stops the container, whose exit is
+	// then not a failure
+	stopping int32
+
+	// signalPidfile is the PID file in the container of the process
+	// signals are sent to, or "" to signal the init process
+	signalPidfile string
+
+	// secrets are the secrets projected into the container, kept up to date
+	// with their sources
+	secrets     []*lxcSecret
+	secretsLock sync.Mutex
+
+	// attachTimeout is whether the container's timeout command can kill
+	// the commands attached to it, or nil until it is probed
+	attachTimeout     *bool
+	attachTimeoutLock sync.Mutex
+
+	// usageAlerts are the thresholds of the container's file usage that
+	// are reported when crossed, and publishMetrics is whether the usage is
+	// published as metrics. The alert states are only accessed by the
+	// stats collector.
+	usageAlerts         *LxcUsageAlertsConfig
+	publishMetrics      bool
+	openFilesAlert      bool
+	inotifyWatchesAlert bool
+
+	// systemdInterval is how often the container's systemd is checked for
+	// failed units, or zero if it isn't
+	systemdInterval time.Duration
+
+	// recycleAt is when the container reaches its maximum uptime and is
+	// recycled, or the zero time if it isn't
+	recycleAt time.Time
+
+	// preserveOnFailure is how long the container is kept after exiting
+	// unsuccessfully rather than destroyed with the task, or zero if it
+	// isn't
+	preserveOnFailure time.Duration
+
+	// portForwards are the forwards of the task's ports to the container,
+	// removed once it stops
+	portForwards []*lxcPortForward
+
+	// coreDumps is the task's dumps directory, or nil if the task doesn't
+	// collect core dumps
+	coreDumps *lxcCoreDumps
+
+	// volumes are the mount points of the task's volumes in the container,
+	// whose disk usage is reported with its root filesystem's
+	volumes []string
+
+	// logShipping is the shipping of the container's logs, or nil if they
+	// aren't shipped
+	logShipping *lxcLogShipping
+
+	// started is when the driver started the container, or the zero time if
+	// the handle was recovered, and crashLoopThreshold how many exits right
+	// after starting make the container crash looping
+	started            time.Time
+	crashLoopThreshold int
+
+	// latestStats is the resource usage last sampled by the node's stats
+	// collector
+	statsInterval time.Duration
+	latestStats   *cstructs.TaskResourceUsage
+	statsLock     sync.RWMutex
 }
 
 type lxcPID struct {
+	// Version is the version of the handle's schema, see lxcHandleVersion
+	Version int
+
 	ContainerName string
 	InitPid       int
 	LxcPath       string
 	KillTimeout   time.Duration
+	Sync          *lxcSync
+	Stop          *lxcStop
+
+	Env               []string
+	LogFile           string
+	SignalPidfile     string
+	Secrets           []*lxcSecret
+	UsageAlerts       *LxcUsageAlertsConfig
+	SystemdInterval   time.Duration
+	RecycleAt         time.Time
+	PreserveOnFailure time.Duration
+	PortForwards      []*lxcPortForward
+	CoreDumps         *lxcCoreDumps
+	Volumes           []string
+	LogShipping       *lxcLogShipping
 }
 
 func (h *lxcDriverHandle) ID() string {
 	pid := lxcPID{
+		Version:       lxcHandleVersion,
 		ContainerName: h.container.Name(),
 		InitPid:       h.initPid,
 		LxcPath:       h.lxcPath,
 		KillTimeout:   h.killTimeout,
+		Sync:          h.sync,
+		Stop:          h.stopStages,
+
+		Env:               h.env,
+		LogFile:           h.logFile,
+		SignalPidfile:     h.signalPidfile,
+		Secrets:           h.secrets,
+		UsageAlerts:       h.usageAlerts,
+		SystemdInterval:   h.systemdInterval,
+		RecycleAt:         h.recycleAt,
+		PreserveOnFailure: h.preserveOnFailure,
+		PortForwards:      h.portForwards,
+		CoreDumps:         h.coreDumps,
+		Volumes:           h.volumes,
+		LogShipping:       h.logShipping,
 	}
 	data, err := json.Marshal(pid)
 	if err != nil {
@@ -445,30 +970,53 @@ func (h *lxcDriverHandle) Update(task *structs.Task) error {
 	return nil
 }
 
-func (h *lxcDriverHandle) Exec(ctx context.Context, cmd string, args []string) ([]byte, int, error) {
-	return nil, 0, fmt.Errorf("lxc driver cannot execute commands")
+func (h *lxcDriverHandle) Kill() error {
+	h.stop()
+	close(h.doneCh)
+	return nil
 }
 
-func (h *lxcDriverHandle) Kill() error {
+// stop syncs the container's data if configured and shuts the container
+// down, after sending it the graceful stop signal if configured. Containers
+// that don't shut down within the timeout are stopped, and their init
+// process killed if LXC fails to stop them.
+func (h *lxcDriverHandle) stop() {
 	name := h.container.Name()
+	atomic.StoreInt32(&h.stopping, 1)
+
+	if h.sync != nil && h.container.Running() {
+		if err := h.syncData(); err != nil {
+			h.logger.Printf("[ERR] driver.lxc: syncing data from container %q failed: %v", name, err)
+			h.emitEvent("Syncing container data failed: %v", err)
+		}
+	}
+
+	if h.stopStages != nil && h.stopStages.SignalTimeout > 0 && h.container.Running() {
+		if h.stopWithSignal() {
+			return
+		}
+	}
 
 	h.logger.Printf("[INFO] driver.lxc: shutting down container %q", name)
-	if err := h.container.Shutdown(h.killTimeout); err != nil {
+	if err := h.container.Shutdown(h.shutdownTimeout()); err != nil {
 		h.logger.Printf("[INFO] driver.lxc: shutting down container %q failed: %v", name, err)
 		if err := h.container.Stop(); err != nil {
 			h.logger.Printf("[ERR] driver.lxc: error stopping container %q: %v", name, err)
+			h.killInit()
 		}
 	}
-
-	close(h.doneCh)
-	return nil
 }
 
-func (h *lxcDriverHandle) Signal(s os.Signal) error {
-	return fmt.Errorf("LXC does not support signals")
+// Stats returns the latest resource usage sampled by the node's stats
+// collector.
+func (h *lxcDriverHandle) Stats() (*cstructs.TaskResourceUsage, error) {
+	h.statsLock.RLock()
+	defer h.statsLock.RUnlock()
+	return h.latestStats, nil
 }
 
-func (h *lxcDriverHandle) Stats() (*cstructs.TaskResourceUsage, error) {
+// sampleStats reads the resource usage of the container from its cgroups.
+func (h *lxcDriverHandle) sampleStats() (*cstructs.TaskResourceUsage, error) {
 	cpuStats, err := h.container.CPUStats()
 	if err != nil {
 		return nil, nil
@@ -549,6 +1097,7 @@ func (h *lxcDriverHandle) Stats() (*cstructs.TaskResourceUsage, error) {
 		ResourceUsage: &cstructs.ResourceUsage{
 			CpuStats:    cs,
 			MemoryStats: ms,
+			DiskStats:   h.sampleDiskStats(),
 		},
 		Timestamp: t.UTC().UnixNano(),
 	}
@@ -558,25 +1107,94 @@ func (h *lxcDriverHandle) Stats() (*cstructs.TaskResourceUsage, error) {
 
 func (h *lxcDriverHandle) run() {
 	defer close(h.waitCh)
-	timer := time.NewTimer(containerMonitorIntv)
-	for {
-		select {
-		case <-timer.C:
-			process, err := os.FindProcess(h.initPid)
-			if err != nil {
-				h.waitCh <- &dstructs.WaitResult{Err: err}
-				return
-			}
-			if err := process.Signal(syscall.Signal(0)); err != nil {
-				h.waitCh <- &dstructs.WaitResult{}
-				return
-			}
-			timer.Reset(containerMonitorIntv)
-		case <-h.doneCh:
-			h.waitCh <- &dstructs.WaitResult{}
-			return
-		}
+	defer h.removePortForwards()
+	lxcStats.register(h, h.statsInterval)
+	defer lxcStats.deregister(h)
+
+	// Detect the container stopping through LXC's state monitor, and its
+	// processes exiting through notifications on its cgroup where they are
+	// available, which precede the container being marked stopped
+	stopWatchCh := make(chan bool)
+	defer close(stopWatchCh)
+	stoppedCh := watchContainerStopped(h.container, stopWatchCh)
+	var emptyCh <-chan struct{}
+	if events, err := lxcCgroupEvents(h.initPid); err != nil {
+		h.logger.Printf("[DEBUG] driver.lxc: not watching cgroup of container %q: %v", h.container.Name(), err)
+	} else if emptyCh, err = watchCgroupEmpty(events, stopWatchCh); err != nil {
+		h.logger.Printf("[DEBUG] driver.lxc: unable to watch %s of container %q: %v", events, h.container.Name(), err)
+	}
+
+	if h.systemdInterval > 0 {
+		go h.monitorSystemd(stopWatchCh)
+	}
+	if len(h.secrets) != 0 {
+		go h.monitorSecrets(stopWatchCh)
+	}
+	if h.coreDumps != nil {
+		go h.monitorCoreDumps(stopWatchCh)
+	}
+	if h.logShipping != nil {
+		go h.shipLogs(stopWatchCh)
+	}
+
+	var recycleCh <-chan time.Time
+	if !h.recycleAt.IsZero() {
+		recycleTimer := time.NewTimer(time.Until(h.recycleAt))
+		defer recycleTimer.Stop()
+		recycleCh = recycleTimer.C
+	}
+
+	select {
+	case <-stoppedCh:
+		h.waitCh <- h.exited()
+	case <-emptyCh:
+		h.waitCh <- h.exited()
+	case <-recycleCh:
+		h.waitCh <- h.recycle()
+	case <-h.doneCh:
+		h.waitCh <- &dstructs.WaitResult{}
+	}
+}
+
+// isLxcTemplatePath returns whether the template refers to a script on disk
+// rather than the name of a template installed with LXC.
+func isLxcTemplatePath(template string) bool {
+	return strings.ContainsRune(template, filepath.Separator)
+}
+
+// lxcTemplate returns the template to pass to LXC. Relative template paths,
+// such as scripts fetched into local/ by the artifact stanza, are resolved
+// against the task directory, validated and made executable.
+func lxcTemplate(taskDir, template string) (string, error) {
+	if !isLxcTemplatePath(template) || filepath.IsAbs(template) {
+		return template, nil
+	}
+
+	path := filepath.Join(taskDir, template)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to find template %q in task directory: %v", template, err)
+	}
+	if !fi.Mode().IsRegular() {
+		return "", fmt.Errorf("template %q is not a regular file", template)
+	}
+
+	// Catch archives that were not unpacked and other obvious mistakes
+	// before LXC fails with an unhelpful exec error
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read template %q: %v", template, err)
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil || !(bytes.HasPrefix(magic, []byte("#!")) || bytes.Equal(magic, []byte("\x7fELF"))) {
+		return "", fmt.Errorf("template %q is neither a script nor an executable", template)
+	}
+
+	if err := os.Chmod(path, fi.Mode().Perm()|0111); err != nil {
+		return "", fmt.Errorf("unable to make template %q executable: %v", template, err)
 	}
+	return path, nil
 }
 
 func keysToVal(line string) (string, uint64, error) {
@@ -0,0 +1,130 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// lxcAppArmorConfigOption is the key for generating an AppArmor profile
+	// for each container from its task config, in place of LXC's generic
+	// container profile.
+	lxcAppArmorConfigOption = "driver.lxc.apparmor_profiles"
+
+	// lxcAppArmorProfileFile is the file in the container's directory
+	// holding its generated profile, so it can be unloaded when the
+	// container is destroyed.
+	lxcAppArmorProfileFile = "nomad-apparmor"
+
+	// sysAppArmorEnabled reports whether AppArmor is enabled in the kernel
+	sysAppArmorEnabled = "/sys/module/apparmor/parameters/enabled"
+)
+
+// lxcAppArmorMount is a bind mount of the container that its profile
+// accounts for.
+type lxcAppArmorMount struct {
+	// Target is the mount point relative to the container's root
+	Target   string
+	ReadOnly bool
+}
+
+// lxcAppArmorProfileName returns the name of the profile generated for the
+// container.
+func lxcAppArmorProfileName(containerName string) string {
+	return "nomad-" + containerName
+}
+
+// lxcAppArmorProfile generates the profile of the container. It starts from
+// LXC's base container abstraction and narrows it down to what the task
+// config declares: read-only mounts may not be written to or remounted,
+// bind mounts may not be unmounted to reveal what is beneath them, and
+// containers sharing the host's network or cgroup namespace lose the
+// corresponding raw access.
+func lxcAppArmorProfile(name string, mounts []lxcAppArmorMount, config *LxcDriverConfig) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Generated by Nomad for the container %s, do not edit\n", name)
+	b.WriteString("#include <tunables/global>\n\n")
+	fmt.Fprintf(&b, "profile %s flags=(attach_disconnected,mediate_deleted) {\n", lxcAppArmorProfileName(name))
+	b.WriteString("  #include <abstractions/lxc/container-base>\n\n")
+
+	// Mounting devpts would remount the host's
+	b.WriteString("  deny mount fstype=devpts,\n")
+	if config.CgroupNamespace != lxcCgroupNamespaceHost {
+		b.WriteString("  mount fstype=cgroup -> /sys/fs/cgroup/**,\n")
+		b.WriteString("  mount fstype=cgroup2 -> /sys/fs/cgroup/**,\n")
+	} else {
+		b.WriteString("  deny mount fstype=cgroup,\n")
+		b.WriteString("  deny mount fstype=cgroup2,\n")
+	}
+
+	if config.sharesHostNetwork() && config.NetworkMode != lxcNetworkModeHost {
+		// The container shares the host's network namespace without having
+		// asked to observe its traffic
+		b.WriteString("  deny network raw,\n")
+		b.WriteString("  deny network packet,\n")
+	}
+
+	for _, m := range mounts {
+		target := path.Join("/", m.Target)
+		fmt.Fprintf(&b, "\n  deny umount %s/,\n", target)
+		if m.ReadOnly {
+			fmt.Fprintf(&b, "  deny remount %s/,\n", target)
+			fmt.Fprintf(&b, "  deny %s/** wl,\n", target)
+		}
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// loadAppArmorProfile generates and loads the profile of the container and
+// confines the container to it, if enabled on the client.
+func (d *LxcDriver) loadAppArmorProfile(c lxcContainerAPI, mounts []lxcAppArmorMount, config *LxcDriverConfig) error {
+	if !d.config.ReadBoolDefault(lxcAppArmorConfigOption, false) {
+		return nil
+	}
+
+	file := filepath.Join(filepath.Dir(c.ConfigFileName()), lxcAppArmorProfileFile)
+	profile := lxcAppArmorProfile(c.Name(), mounts, config)
+	if err := ioutil.WriteFile(file, []byte(profile), 0644); err != nil {
+		return fmt.Errorf("unable to write apparmor profile: %v", err)
+	}
+
+	out, err := d.backend.CombinedOutput(context.Background(), "apparmor_parser", "--replace", "--write-cache", file)
+	if err != nil {
+		return fmt.Errorf("unable to load apparmor profile: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	// LXC 2.1 renamed lxc.aa_profile to lxc.apparmor.profile
+	name := lxcAppArmorProfileName(c.Name())
+	if err := c.SetConfigItem("lxc.apparmor.profile", name); err != nil {
+		if err := c.SetConfigItem("lxc.aa_profile", name); err != nil {
+			return fmt.Errorf("error setting apparmor profile: %v", err)
+		}
+	}
+	return nil
+}
+
+// unloadAppArmorProfile unloads the profile generated for the container, if
+// any. It must be called before the container is destroyed, which removes
+// the profile file.
+func unloadAppArmorProfile(backend lxcBackend, c lxcContainerAPI) error {
+	file := filepath.Join(filepath.Dir(c.ConfigFileName()), lxcAppArmorProfileFile)
+	if _, err := os.Stat(file); err != nil {
+		return nil
+	}
+
+	out, err := backend.CombinedOutput(context.Background(), "apparmor_parser", "--remove", file)
+	if err != nil {
+		return fmt.Errorf("unable to unload apparmor profile of container %q: %v: %s", c.Name(), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+// lxcQemuPrefix is the prefix of the binfmt_misc handlers registered for
+// qemu's user mode emulation, such as "qemu-aarch64".
+const lxcQemuPrefix = "qemu-"
+
+var (
+	// binfmtMiscDir is where the kernel's binfmt_misc handlers are listed.
+	binfmtMiscDir = "/proc/sys/fs/binfmt_misc"
+
+	// lxcArchAliases maps the kernel's and Go's architecture names to the
+	// ones of LXC images.
+	lxcArchAliases = map[string]string{
+		"x86_64":  "amd64",
+		"386":     "i386",
+		"i686":    "i386",
+		"aarch64": "arm64",
+		"arm":     "armhf",
+		"armv7l":  "armhf",
+		"ppc64le": "ppc64el",
+	}
+
+	// lxcCompatArches are the architectures the node's CPU runs natively in
+	// addition to its own.
+	lxcCompatArches = map[string][]string{
+		"amd64": {"i386"},
+		"arm64": {"armhf", "armel"},
+	}
+)
+
+// normalizeLxcArch returns the image architecture name of the architecture.
+func normalizeLxcArch(arch string) string {
+	if alias, ok := lxcArchAliases[arch]; ok {
+		return alias
+	}
+	return arch
+}
+
+// lxcNativeArch returns whether the node runs the architecture natively.
+func lxcNativeArch(arch string) bool {
+	host := normalizeLxcArch(runtime.GOARCH)
+	if arch == host {
+		return true
+	}
+	for _, compat := range lxcCompatArches[host] {
+		if arch == compat {
+			return true
+		}
+	}
+	return false
+}
+
+// lxcEmulatedArches returns the architectures the node emulates through qemu
+// binfmt_misc handlers. Only enabled handlers with the F flag are usable in
+// containers, as the kernel then opens the interpreter when it is registered
+// rather than looking it up in the container's root filesystem.
+func lxcEmulatedArches() map[string]bool {
+	entries, err := ioutil.ReadDir(binfmtMiscDir)
+	if err != nil {
+		return nil
+	}
+	arches := make(map[string]bool)
+	for _, fi := range entries {
+		if !strings.HasPrefix(fi.Name(), lxcQemuPrefix) {
+			continue
+		}
+		raw, err := ioutil.ReadFile(filepath.Join(binfmtMiscDir, fi.Name()))
+		if err != nil {
+			continue
+		}
+		var enabled, fixed bool
+		for _, line := range strings.Split(string(raw), "\n") {
+			fields := strings.Fields(line)
+			switch {
+			case len(fields) == 1 && fields[0] == "enabled":
+				enabled = true
+			case len(fields) == 2 && fields[0] == "flags:":
+				fixed = strings.Contains(fields[1], "F")
+			}
+		}
+		if enabled && fixed {
+			arches[normalizeLxcArch(strings.TrimPrefix(fi.Name(), lxcQemuPrefix))] = true
+		}
+	}
+	return arches
+}
+
+// fingerprintArches advertises the foreign architectures whose containers
+// the node runs through emulation, as driver.lxc.arch.<arch>.emulated.
+func (d *LxcDriver) fingerprintArches(node *structs.Node) {
+	for key := range node.Attributes {
+		if strings.HasPrefix(key, "driver.lxc.arch.") && strings.HasSuffix(key, ".emulated") {
+			delete(node.Attributes, key)
+		}
+	}
+	var emulated []string
+	for arch := range lxcEmulatedArches() {
+		if !lxcNativeArch(arch) {
+			node.Attributes["driver.lxc.arch."+arch+".emulated"] = "1"
+			emulated = append(emulated, arch)
+		}
+	}
+	if len(emulated) != 0 {
+		sort.Strings(emulated)
+		d.logger.Printf("[DEBUG] driver.lxc: emulating architectures %s", strings.Join(emulated, ", "))
+	}
+}
+
+// checkArch returns an error if the node can't run containers of the task's
+// architecture, rather than letting the container fail to start with an
+// exec format error. Containers of emulated architectures are started with
+// a task event, as they run considerably slower.
+func (d *LxcDriver) checkArch(arch string) error {
+	if arch == "" {
+		return nil
+	}
+	arch = normalizeLxcArch(arch)
+	if lxcNativeArch(arch) {
+		return nil
+	}
+	if !lxcEmulatedArches()[arch] {
+		return fmt.Errorf("arch %q is neither native nor emulated on this node, see the driver.lxc.arch.%s.emulated attribute", arch, arch)
+	}
+	d.emitEvent("Running %s container through emulation", arch)
+	return nil
+}
@@ -0,0 +1,252 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+// lxcContainerAPI is the part of the liblxc container API used by the
+// driver. It is implemented by *lxc.Container, and by an in-memory fake in
+// tests.
+type lxcContainerAPI interface {
+	Name() string
+	Defined() bool
+	Running() bool
+	State() lxc.State
+	InitPid() int
+	Wait(state lxc.State, timeout time.Duration) bool
+	IPv4Address(interfaceName string) ([]string, error)
+	IPv6Address(interfaceName string) ([]string, error)
+
+	Start() error
+	Stop() error
+	Shutdown(timeout time.Duration) error
+	Destroy() error
+	Rename(name string) error
+	Freeze() error
+	Unfreeze() error
+	Checkpoint(opts lxc.CheckpointOptions) error
+
+	SetVerbosity(verbosity lxc.Verbosity)
+	SetLogLevel(level lxc.LogLevel) error
+	SetLogFile(filename string) error
+
+	ConfigPath() string
+	ConfigFileName() string
+	ConfigItem(key string) []string
+	SetConfigItem(key, value string) error
+	CgroupItem(key string) []string
+	SetCgroupItem(key, value string) error
+	SetMemoryLimit(limit lxc.ByteSize) error
+	SetMemorySwapLimit(limit lxc.ByteSize) error
+
+	CPUTime() (time.Duration, error)
+	CPUStats() (map[string]int64, error)
+	RunCommandStatus(args []string, options lxc.AttachOptions) (int, error)
+}
+
+// lxcBackend is everything the driver does outside of the process: opening
+// containers through liblxc and running host commands such as rsync and the
+// LVM tools. Tests substitute a fake to exercise the driver without LXC.
+type lxcBackend interface {
+	// Version returns the liblxc version, or "" if it isn't available.
+	Version() string
+
+	// DefaultLxcPath returns the LXC path used if none is configured.
+	DefaultLxcPath() string
+
+	// NewContainer opens the named container, which may not be defined yet.
+	NewContainer(name, lxcPath string) (lxcContainerAPI, error)
+
+	// Release releases the resources of a container opened by
+	// NewContainer that isn't used anymore.
+	Release(c lxcContainerAPI)
+
+	// DefinedContainerNames returns the names of the containers defined
+	// under the LXC path.
+	DefinedContainerNames(lxcPath string) []string
+
+	// Create creates the container by running lxc-create with the template
+	// options, and returns the command's combined output. The template
+	// runs with the given environment rather than the client's, and is
+	// killed once the context is done.
+	Create(ctx context.Context, c lxcContainerAPI, options lxc.TemplateOptions, env []string) ([]byte, error)
+
+	// LookPath searches for an executable like exec.LookPath.
+	LookPath(file string) (string, error)
+
+	// Output runs a command and returns its standard output. Failures are
+	// returned as an *exec.ExitError holding the standard error.
+	Output(ctx context.Context, name string, args ...string) ([]byte, error)
+
+	// CombinedOutput runs a command and returns its combined standard
+	// output and standard error.
+	CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error)
+
+	// CombinedOutputWithInput is CombinedOutput with the input written to
+	// the command's standard input.
+	CombinedOutputWithInput(ctx context.Context, input []byte, name string, args ...string) ([]byte, error)
+}
+
+// defaultLxcBackend is the backend used outside of tests.
+var defaultLxcBackend lxcBackend = liblxcBackend{}
+
+// liblxcBackend is the lxcBackend using liblxc and the host's commands.
+type liblxcBackend struct{}
+
+func (liblxcBackend) Version() string {
+	return lxc.Version()
+}
+
+func (liblxcBackend) DefaultLxcPath() string {
+	return lxc.DefaultConfigPath()
+}
+
+func (liblxcBackend) NewContainer(name, lxcPath string) (lxcContainerAPI, error) {
+	c, err := lxc.NewContainer(name, lxcPath)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (liblxcBackend) Release(c lxcContainerAPI) {
+	if container, ok := c.(*lxc.Container); ok {
+		lxc.Release(container)
+	}
+}
+
+func (liblxcBackend) DefinedContainerNames(lxcPath string) []string {
+	return lxc.DefinedContainerNames(lxcPath)
+}
+
+func (liblxcBackend) Create(ctx context.Context, c lxcContainerAPI, options lxc.TemplateOptions, env []string) ([]byte, error) {
+	var out bytes.Buffer
+	cmd := exec.Command("lxc-create", lxcCreateArgs(c.Name(), c.ConfigPath(), options)...)
+	cmd.Env = env
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	// The template runs as a child of lxc-create, so the whole process
+	// group is killed once the context is done
+	waitCh := make(chan error, 1)
+	go func() {
+		waitCh <- cmd.Wait()
+	}()
+	var err error
+	select {
+	case err = <-waitCh:
+	case <-ctx.Done():
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-waitCh
+		err = ctx.Err()
+	}
+	if err != nil {
+		return out.Bytes(), err
+	}
+
+	// The container was defined by another process, so its config has to
+	// be loaded
+	if container, ok := c.(*lxc.Container); ok {
+		if err := container.LoadConfigFile(c.ConfigFileName()); err != nil {
+			return out.Bytes(), fmt.Errorf("unable to load config of created container: %v", err)
+		}
+	}
+	return out.Bytes(), nil
+}
+
+func (liblxcBackend) LookPath(file string) (string, error) {
+	return exec.LookPath(file)
+}
+
+func (liblxcBackend) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).Output()
+}
+
+func (liblxcBackend) CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+func (liblxcBackend) CombinedOutputWithInput(ctx context.Context, input []byte, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = bytes.NewReader(input)
+	return cmd.CombinedOutput()
+}
+
+// openLxcContainer opens the named container with the backend, returning an
+// error if it isn't defined. The container must be released by the caller.
+func openLxcContainer(backend lxcBackend, name, lxcPath string) (lxcContainerAPI, error) {
+	c, err := backend.NewContainer(name, lxcPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open container %q: %v", name, err)
+	}
+	if !c.Defined() {
+		backend.Release(c)
+		return nil, fmt.Errorf("container %q not found", name)
+	}
+	return c, nil
+}
+
+// lxcCreateArgs returns the lxc-create arguments creating the named
+// container under the LXC path from the template options, as liblxc's
+// create does. Containers are created from the download template into a
+// directory unless the options say otherwise.
+func lxcCreateArgs(name, lxcPath string, options lxc.TemplateOptions) []string {
+	template := options.Template
+	if template == "" {
+		template = lxcDownloadTemplate
+	}
+	backend := options.Backend
+	if backend == 0 {
+		backend = lxc.Directory
+	}
+	args := []string{"-n", name, "-P", lxcPath, "-t", template, "-B", backend.String(), "--"}
+
+	if template == lxcDownloadTemplate {
+		args = append(args, "--dist", options.Distro, "--release", options.Release, "--arch", options.Arch)
+		if options.Variant != "" {
+			args = append(args, "--variant", options.Variant)
+		}
+		if options.Server != "" {
+			args = append(args, "--server", options.Server)
+		}
+		if options.KeyID != "" {
+			args = append(args, "--keyid", options.KeyID)
+		}
+		if options.KeyServer != "" {
+			args = append(args, "--keyserver", options.KeyServer)
+		}
+		if options.DisableGPGValidation {
+			args = append(args, "--no-validate")
+		}
+		if options.FlushCache {
+			args = append(args, "--flush-cache")
+		}
+		if options.ForceCache {
+			args = append(args, "--force-cache")
+		}
+	} else {
+		if options.Release != "" {
+			args = append(args, "--release", options.Release)
+		}
+		if options.Arch != "" {
+			args = append(args, "--arch", options.Arch)
+		}
+		if options.FlushCache {
+			args = append(args, "--flush-cache")
+		}
+	}
+	return append(args, options.ExtraArgs...)
+}
@@ -0,0 +1,168 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/client/config"
+
+	cstructs "github.com/hashicorp/nomad/client/structs"
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+const (
+	// lxcBackupDestinationConfigOption is the key for where container
+	// backups are uploaded to: a directory on the client or a remote rsync
+	// target.
+	lxcBackupDestinationConfigOption = "driver.lxc.backup_destination"
+
+	// lxcBackupTimeoutConfigOption is the key for how long a backup may
+	// take, including the upload.
+	lxcBackupTimeoutConfigOption = "driver.lxc.backup_timeout"
+	lxcBackupTimeoutDefault      = time.Hour
+)
+
+// lxcBackupArchiveName returns the file name of a backup of the container
+// taken at the given time.
+func lxcBackupArchiveName(name string, t time.Time) string {
+	return fmt.Sprintf("%s-%s.tar.gz", name, t.UTC().Format("20060102T150405Z"))
+}
+
+// BackupLxcContainer packages the root filesystem of the named container
+// created by the LXC driver, along with its LXC config and optionally its
+// CRIU process state, and uploads the archive to the configured backup
+// destination. Running containers are frozen while their state is copied.
+func BackupLxcContainer(cfg *config.Config, name string, checkpoint bool) (*cstructs.LxcBackup, error) {
+	return backupLxcContainer(defaultLxcBackend, lxcReloaded.apply(cfg), name, checkpoint, time.Now())
+}
+
+func backupLxcContainer(backend lxcBackend, cfg *config.Config, name string, checkpoint bool, now time.Time) (*cstructs.LxcBackup, error) {
+	if _, _, ok := parseLxcContainerName(name); !ok {
+		return nil, fmt.Errorf("container %q was not created by the lxc driver", name)
+	}
+	dest := cfg.Read(lxcBackupDestinationConfigOption)
+	if dest == "" {
+		return nil, fmt.Errorf("no backup destination configured, see the %s client option", lxcBackupDestinationConfigOption)
+	}
+
+	c, err := openLxcContainer(backend, name, findLxcPath(backend, cfg, name))
+	if err != nil {
+		return nil, err
+	}
+	defer backend.Release(c)
+
+	running := c.Running()
+	if checkpoint && !running {
+		return nil, fmt.Errorf("container %q must be running to checkpoint it", name)
+	}
+
+	workDir, err := ioutil.TempDir("", "nomad-lxc-backup")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ReadDurationDefault(lxcBackupTimeoutConfigOption, lxcBackupTimeoutDefault))
+	defer cancel()
+
+	start := time.Now()
+	if err := snapshotLxcContainer(ctx, backend, c, workDir, running, checkpoint); err != nil {
+		return nil, err
+	}
+	if err := copyRegularFile(c.ConfigFileName(), filepath.Join(workDir, "config"), 0644); err != nil {
+		return nil, fmt.Errorf("unable to copy container config: %v", err)
+	}
+
+	// Package the snapshot and upload it
+	archiveName := lxcBackupArchiveName(name, now)
+	archive := filepath.Join(workDir, archiveName)
+	args := []string{"-C", workDir, "-czf", archive, "config", "rootfs.tar"}
+	if checkpoint {
+		args = append(args, "checkpoint")
+	}
+	if out, err := backend.CombinedOutput(ctx, "tar", args...); err != nil {
+		return nil, fmt.Errorf("unable to package backup: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	fi, err := os.Stat(archive)
+	if err != nil {
+		return nil, fmt.Errorf("unable to package backup: %v", err)
+	}
+
+	location, err := uploadLxcBackup(ctx, backend, archive, dest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cstructs.LxcBackup{
+		Container:  name,
+		Location:   location,
+		Size:       fi.Size(),
+		Checkpoint: checkpoint,
+		Duration:   time.Since(start),
+	}, nil
+}
+
+// snapshotLxcContainer writes the container's root filesystem to rootfs.tar
+// in the work dir, and its CRIU state to the checkpoint dir if requested.
+// Running containers are frozen until both are written, so they are
+// consistent with each other.
+func snapshotLxcContainer(ctx context.Context, backend lxcBackend, c lxcContainerAPI, workDir string, running, checkpoint bool) error {
+	// The mounted root filesystem of a running container is reachable
+	// through its init process whatever its backing store
+	var root string
+	if running {
+		root = fmt.Sprintf("/proc/%d/root", c.InitPid())
+		if err := c.Freeze(); err != nil {
+			return fmt.Errorf("unable to freeze container: %v", err)
+		}
+		defer c.Unfreeze()
+	} else {
+		dir, err := lxcRootfsDir(c)
+		if err != nil {
+			return fmt.Errorf("unable to back up container: %v", err)
+		}
+		root = dir
+	}
+
+	if checkpoint {
+		opts := lxc.CheckpointOptions{Directory: filepath.Join(workDir, "checkpoint")}
+		if err := c.Checkpoint(opts); err != nil {
+			return fmt.Errorf("unable to checkpoint container: %v", err)
+		}
+	}
+
+	// Bind mounts such as the task directories are left out
+	out, err := backend.CombinedOutput(ctx, "tar", "--one-file-system", "--numeric-owner", "-C", root, "-cf", filepath.Join(workDir, "rootfs.tar"), ".")
+	if err != nil {
+		return fmt.Errorf("unable to copy root filesystem: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// uploadLxcBackup moves the archive to the backup destination and returns
+// its location there.
+func uploadLxcBackup(ctx context.Context, backend lxcBackend, archive, dest string) (string, error) {
+	if isRemoteSyncDestination(dest) {
+		location := strings.TrimSuffix(dest, "/") + "/" + filepath.Base(archive)
+		if out, err := backend.CombinedOutput(ctx, "rsync", archive, location); err != nil {
+			return "", fmt.Errorf("unable to upload backup: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return location, nil
+	}
+
+	if err := os.MkdirAll(dest, 0700); err != nil {
+		return "", fmt.Errorf("unable to create backup destination: %v", err)
+	}
+	location := filepath.Join(dest, filepath.Base(archive))
+	if err := copyRegularFile(archive, location, 0600); err != nil {
+		return "", fmt.Errorf("unable to upload backup: %v", err)
+	}
+	return location, nil
+}
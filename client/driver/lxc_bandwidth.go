@@ -0,0 +1,128 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+const (
+	// lxcEnforceNetworkMbitsConfigOption is the key for shaping the traffic
+	// of containers with a veth network to the mbits of the task's network
+	// resources, so noisy tasks can't saturate the node's interfaces.
+	lxcEnforceNetworkMbitsConfigOption = "driver.lxc.enforce_network_mbits"
+
+	// lxcVethPairPrefix prefixes the host side of the veth pair of
+	// containers whose traffic is shaped
+	lxcVethPairPrefix = "nomad"
+
+	// lxcMinBurstBytes is the smallest burst of shaped traffic, which must
+	// hold at least a full frame
+	lxcMinBurstBytes = 32 * 1024
+)
+
+// lxcBandwidth is the bandwidth a container's traffic is shaped to, by
+// direction from the container's point of view, in Mbits per second. Zero
+// leaves a direction unshaped.
+type lxcBandwidth struct {
+	Egress  int
+	Ingress int
+}
+
+// shaped returns whether any direction is shaped.
+func (b lxcBandwidth) shaped() bool {
+	return b.Egress > 0 || b.Ingress > 0
+}
+
+// containerBandwidth returns the bandwidth the container of the task is
+// shaped to. The limits of the task config take precedence over the mbits of
+// its network resources, which are only enforced if the client is
+// configured to. Only veth networks are shaped.
+func (d *LxcDriver) containerBandwidth(config *LxcDriverConfig, task *structs.Task) lxcBandwidth {
+	if len(config.Network) == 0 || config.Network[0].Type != lxcNetworkVeth {
+		return lxcBandwidth{}
+	}
+	limits := config.Limits[0]
+	b := lxcBandwidth{Egress: limits.EgressMbits, Ingress: limits.IngressMbits}
+	if d.config.ReadBoolDefault(lxcEnforceNetworkMbitsConfigOption, false) && task.Resources != nil {
+		var mbits int
+		for _, n := range task.Resources.Networks {
+			mbits += n.MBits
+		}
+		if b.Egress == 0 {
+			b.Egress = mbits
+		}
+		if b.Ingress == 0 {
+			b.Ingress = mbits
+		}
+	}
+	return b
+}
+
+// lxcVethPair returns the name of the host side of the container's veth
+// pair. It is derived from the container's name, as LXC otherwise picks a
+// random one, and fits the kernel's limit on interface names.
+func lxcVethPair(name string) string {
+	sum := sha1.Sum([]byte(name))
+	return lxcVethPairPrefix + hex.EncodeToString(sum[:])[:15-len(lxcVethPairPrefix)]
+}
+
+// setLxcVethPair names the host side of the container's veth pair.
+func setLxcVethPair(c lxcContainerAPI, pair string) error {
+	if err := c.SetConfigItem("lxc.net.0.veth.pair", pair); err != nil {
+		if err := c.SetConfigItem("lxc.network.veth.pair", pair); err != nil {
+			return fmt.Errorf("error setting veth pair name: %v", err)
+		}
+	}
+	return nil
+}
+
+// lxcBurst returns the tc burst of traffic shaped to the rate, allowing
+// 10ms of traffic at once.
+func lxcBurst(mbits int) string {
+	burst := mbits * 1000 * 1000 / 8 / 100
+	if burst < lxcMinBurstBytes {
+		burst = lxcMinBurstBytes
+	}
+	return strconv.Itoa(burst)
+}
+
+// lxcShapingCommands returns the tc commands shaping the traffic of the host
+// side of a veth pair. Traffic to the container leaves the host through it
+// and is queued by a token bucket filter. Traffic from the container enters
+// the host through it, where it can't be queued, so it is policed instead.
+func lxcShapingCommands(dev string, b lxcBandwidth) [][]string {
+	var cmds [][]string
+	if b.Ingress > 0 {
+		rate := fmt.Sprintf("%dmbit", b.Ingress)
+		cmds = append(cmds, []string{"qdisc", "replace", "dev", dev, "root", "tbf", "rate", rate, "burst", lxcBurst(b.Ingress), "latency", "50ms"})
+	}
+	if b.Egress > 0 {
+		rate := fmt.Sprintf("%dmbit", b.Egress)
+		cmds = append(cmds,
+			[]string{"qdisc", "replace", "dev", dev, "handle", "ffff:", "ingress"},
+			[]string{"filter", "add", "dev", dev, "parent", "ffff:", "protocol", "all", "u32", "match", "u32", "0", "0",
+				"police", "rate", rate, "burst", lxcBurst(b.Egress), "drop", "flowid", ":1"})
+	}
+	return cmds
+}
+
+// shapeBandwidth shapes the traffic of the running container. The qdiscs
+// are removed with its veth pair once it stops.
+func (d *LxcDriver) shapeBandwidth(name string, b lxcBandwidth) error {
+	dev := lxcVethPair(name)
+	for _, args := range lxcShapingCommands(dev, b) {
+		if out, err := d.backend.CombinedOutput(context.Background(), "tc", args...); err != nil {
+			return fmt.Errorf("unable to shape bandwidth of container %q: %v: %s", name, err, strings.TrimSpace(string(out)))
+		}
+	}
+	d.logger.Printf("[DEBUG] driver.lxc: shaped traffic of container %q on %s to %d Mbit/s egress and %d Mbit/s ingress", name, dev, b.Egress, b.Ingress)
+	return nil
+}
@@ -0,0 +1,108 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+const (
+	// lxcCacheDirConfigOption is the key for the directory templates cache
+	// images in, exported to them as LXC_CACHE_PATH. It may be shared by
+	// clients, as the templates caching the same image take turns.
+	lxcCacheDirConfigOption = "driver.lxc.cache_dir"
+	lxcCacheDirDefault      = "/var/cache/lxc"
+
+	// lxcCacheLockPrefix prefixes the lock files of the cache
+	lxcCacheLockPrefix = ".nomad-"
+
+	// lxcCacheLockPollIntv is how often a cache lock held by another
+	// template is tried again
+	lxcCacheLockPollIntv = 250 * time.Millisecond
+)
+
+// lxcCacheLockNameRe matches the characters replaced in the names of lock
+// files.
+var lxcCacheLockNameRe = regexp.MustCompile(`[^a-zA-Z0-9_.]+`)
+
+// cacheDir returns the directory templates cache images in.
+func (d *LxcDriver) cacheDir() string {
+	return d.config.ReadDefault(lxcCacheDirConfigOption, lxcCacheDirDefault)
+}
+
+// lxcCacheLockName returns the name of the lock file of the cache of the
+// image of the template options, such as
+// ".nomad-download-ubuntu-bionic-amd64.lock".
+func lxcCacheLockName(options lxc.TemplateOptions) string {
+	var parts []string
+	for _, part := range []string{filepath.Base(options.Template), options.Distro, options.Release, options.Arch, options.Variant} {
+		if part != "" {
+			parts = append(parts, lxcCacheLockNameRe.ReplaceAllString(part, "_"))
+		}
+	}
+	return lxcCacheLockPrefix + strings.Join(parts, "-") + ".lock"
+}
+
+// lockTemplateCache locks the cache of the image of the template options,
+// so that templates creating containers from the same image at once don't
+// download it more than once or corrupt the cache, and returns the function
+// unlocking it. The lock is a file lock, held across the clients sharing
+// the cache. An error is returned if the context is done before the lock is
+// acquired. The oci template and rootfs archives don't cache images.
+func (d *LxcDriver) lockTemplateCache(ctx context.Context, options lxc.TemplateOptions) (func(), error) {
+	if options.Template == lxcOCITemplate || filepath.Base(options.Template) == lxcRootfsArchiveTemplateName {
+		return func() {}, nil
+	}
+
+	dir := d.cacheDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create cache directory: %v", err)
+	}
+	path := filepath.Join(dir, lxcCacheLockName(options))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open cache lock: %v", err)
+	}
+	fd := int(f.Fd())
+	err = syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		d.logger.Printf("[DEBUG] driver.lxc: waiting for cache lock %q", path)
+		d.emitEvent("Waiting for another container to finish with the image cache")
+	}
+
+	// Blocking locks can't be interrupted, so the lock is polled for
+	for err == syscall.EWOULDBLOCK {
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(lxcCacheLockPollIntv):
+		}
+		err = syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to lock cache: %v", err)
+	}
+	return func() {
+		syscall.Flock(fd, syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// templateEnv returns the variables of the environment of the template
+// creating the container: its cache directory and proxy settings.
+func (d *LxcDriver) templateEnv(config *LxcDriverConfig) map[string]string {
+	env := d.containerProxy(config).env()
+	env["LXC_CACHE_PATH"] = d.cacheDir()
+	return env
+}
@@ -0,0 +1,71 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/nomad/client/driver/env"
+)
+
+const (
+	// lxcCgroupParentConfigOption is the key for the cgroup, relative to the
+	// cgroup root, that containers are placed under, such as
+	// "nomad.slice/${NOMAD_ALLOC_ID}". It is interpolated with the task
+	// environment.
+	lxcCgroupParentConfigOption = "driver.lxc.cgroup_parent"
+
+	// The values of the cgroup_namespace task config
+	lxcCgroupNamespacePrivate = "private"
+	lxcCgroupNamespaceHost    = "host"
+
+	// procCgroupNamespace exists if the kernel supports cgroup namespaces
+	procCgroupNamespace = "/proc/self/ns/cgroup"
+)
+
+// lxcCgroupDir returns the cgroup of the container relative to the cgroup
+// root, or "" if no cgroup parent is configured.
+func lxcCgroupDir(parent, containerName string, taskEnv *env.TaskEnv) (string, error) {
+	if parent == "" {
+		return "", nil
+	}
+	if taskEnv != nil {
+		parent = taskEnv.ReplaceEnv(parent)
+	}
+
+	parent = strings.Trim(parent, "/")
+	if parent == "" || filepath.Clean(parent) != parent || strings.HasPrefix(parent, "..") {
+		return "", fmt.Errorf("invalid cgroup parent %q", parent)
+	}
+	return filepath.Join(parent, containerName), nil
+}
+
+// setContainerCgroup configures the cgroup namespace and cgroup parent of the
+// container before it starts.
+func (d *LxcDriver) setContainerCgroup(c lxcContainerAPI, config *LxcDriverConfig, taskEnv *env.TaskEnv) error {
+	switch config.CgroupNamespace {
+	case lxcCgroupNamespacePrivate:
+		// LXC unshares the cgroup namespace whenever the kernel supports it
+		if _, err := os.Stat(procCgroupNamespace); err != nil {
+			return fmt.Errorf("cgroup namespaces are not supported by the kernel")
+		}
+	case lxcCgroupNamespaceHost:
+		if err := c.SetConfigItem("lxc.namespace.keep", "cgroup"); err != nil {
+			return fmt.Errorf("error sharing the host cgroup namespace, which requires LXC 3.0 or later: %v", err)
+		}
+	}
+
+	dir, err := lxcCgroupDir(d.config.Read(lxcCgroupParentConfigOption), c.Name(), taskEnv)
+	if err != nil {
+		return err
+	}
+	if dir != "" {
+		if err := c.SetConfigItem("lxc.cgroup.dir", dir); err != nil {
+			return fmt.Errorf("error setting cgroup parent, which requires LXC 3.0 or later: %v", err)
+		}
+	}
+	return nil
+}
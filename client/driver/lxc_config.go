@@ -0,0 +1,1113 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/nomad/client/driver/env"
+	"github.com/hashicorp/nomad/helper/fields"
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/mitchellh/mapstructure"
+)
+
+// lxcDownloadTemplate is the template fetching prebuilt images from an
+// image server, and the only one taking most of the image keys.
+const lxcDownloadTemplate = "download"
+
+// LxcDriverConfig is the configuration of the LXC Container
+type LxcDriverConfig struct {
+	Template             string
+	OCIImage             string `mapstructure:"oci_image"`
+	RootfsArchive        string `mapstructure:"rootfs_archive"`
+	Backend              string `mapstructure:"backend"`
+	RootfsSizeMB         int    `mapstructure:"rootfs_size_mb"`
+	Mode                 string
+	Command              string
+	Args                 []string
+	Distro               string
+	Release              string
+	Arch                 string
+	ImageVariant         string   `mapstructure:"image_variant"`
+	ImageServer          string   `mapstructure:"image_server"`
+	ImageSerial          string   `mapstructure:"image_serial"`
+	ImageServerUsername  string   `mapstructure:"image_server_username"`
+	ImageServerPassword  string   `mapstructure:"image_server_password"`
+	ImageServerToken     string   `mapstructure:"image_server_token"`
+	HTTPProxy            string   `mapstructure:"http_proxy"`
+	HTTPSProxy           string   `mapstructure:"https_proxy"`
+	NoProxy              string   `mapstructure:"no_proxy"`
+	GPGKeyID             string   `mapstructure:"gpg_key_id"`
+	GPGKeyServer         string   `mapstructure:"gpg_key_server"`
+	DisableGPGValidation bool     `mapstructure:"disable_gpg"`
+	FlushCache           bool     `mapstructure:"flush_cache"`
+	ForceCache           bool     `mapstructure:"force_cache"`
+	TemplateArgs         []string `mapstructure:"template_args"`
+	LogLevel             string   `mapstructure:"log_level"`
+	Verbosity            string
+	Volumes              []string `mapstructure:"volumes"`
+	ConsoleLog           bool     `mapstructure:"console_log"`
+	Timezone             string   `mapstructure:"timezone"`
+	CgroupNamespace      string   `mapstructure:"cgroup_namespace"`
+	MaxUptime            string   `mapstructure:"max_uptime"`
+	MaxUptimeJitter      string   `mapstructure:"max_uptime_jitter"`
+	PreserveOnFailure    string   `mapstructure:"preserve_on_failure"`
+	CreateTimeout        string   `mapstructure:"create_timeout"`
+	ProvisionCmds        []string `mapstructure:"provision_cmds"`
+	SignalPidfile        string   `mapstructure:"signal_pidfile"`
+	DNSServers           []string `mapstructure:"dns_servers"`
+	DNSSearchDomains     []string `mapstructure:"dns_search_domains"`
+	ExtraHosts           []string `mapstructure:"extra_hosts"`
+	NetworkMode          string   `mapstructure:"network_mode"`
+
+	PortMapRaw []map[string]string `mapstructure:"port_map"`
+	PortMap    map[string]int      `mapstructure:"-"`
+
+	Image   []LxcImageConfig   `mapstructure:"image"`
+	Mounts  []LxcMountConfig   `mapstructure:"mount"`
+	Network []LxcNetworkConfig `mapstructure:"network"`
+	Limits  []LxcLimitsConfig  `mapstructure:"limits"`
+	Sync    []LxcSyncConfig    `mapstructure:"sync"`
+	Systemd []LxcSystemdConfig `mapstructure:"systemd"`
+	Stop    []LxcStopConfig    `mapstructure:"stop"`
+
+	TimeOffset []LxcTimeOffsetConfig `mapstructure:"time_offset"`
+
+	SharedVolumes []LxcSharedVolumeConfig `mapstructure:"shared_volume"`
+	RootfsCopies  []LxcRootfsCopyConfig   `mapstructure:"rootfs_copy"`
+	Secrets       []LxcSecretConfig       `mapstructure:"secret"`
+	UsageAlerts   []LxcUsageAlertsConfig  `mapstructure:"usage_alerts"`
+	CoreDumps     []LxcCoreDumpsConfig    `mapstructure:"core_dumps"`
+}
+
+// LxcImageConfig is the image block of the task config. It is an
+// alternative to the flat image keys and is merged into them.
+type LxcImageConfig struct {
+	Distro               string
+	Release              string
+	Arch                 string
+	Variant              string
+	Server               string
+	Serial               string
+	GPGKeyID             string `mapstructure:"gpg_key_id"`
+	GPGKeyServer         string `mapstructure:"gpg_key_server"`
+	DisableGPGValidation bool   `mapstructure:"disable_gpg"`
+	FlushCache           bool   `mapstructure:"flush_cache"`
+	ForceCache           bool   `mapstructure:"force_cache"`
+}
+
+// LxcMountConfig is a mount block of the task config, bind-mounting a host or
+// task directory path into the container.
+type LxcMountConfig struct {
+	Source   string
+	Target   string
+	ReadOnly bool `mapstructure:"readonly"`
+}
+
+// LxcNetworkConfig is the network block of the task config. Veth networks
+// attach the container's interface to a host bridge, and macvlan and ipvlan
+// networks to a parent host interface, optionally with a static address,
+// gateway and routes.
+type LxcNetworkConfig struct {
+	Type        string
+	Bridge      string
+	Parent      string
+	Mode        string
+	Name        string
+	IPv4Address string   `mapstructure:"ipv4_address"`
+	IPv4Gateway string   `mapstructure:"ipv4_gateway"`
+	IPv6Address string   `mapstructure:"ipv6_address"`
+	IPv6Gateway string   `mapstructure:"ipv6_gateway"`
+	Routes      []string `mapstructure:"routes"`
+	HWAddr      string   `mapstructure:"hwaddr"`
+	VlanID      int      `mapstructure:"vlan_id"`
+
+	// IPv6AcceptRA is nil if the block leaves the kernel's default
+	IPv6AcceptRA *bool `mapstructure:"ipv6_accept_ra"`
+}
+
+// LxcLimitsConfig is the limits block of the task config, holding resource
+// limits beyond the task's cpu and memory resources.
+type LxcLimitsConfig struct {
+	CPUSetCPUs   string `mapstructure:"cpuset_cpus"`
+	MemorySwapMB int    `mapstructure:"memory_swap_mb"`
+	PidsMax      int    `mapstructure:"pids_max"`
+	EgressMbits  int    `mapstructure:"egress_mbits"`
+	IngressMbits int    `mapstructure:"ingress_mbits"`
+}
+
+// LxcSyncConfig is the sync block of the task config, copying paths out of
+// the container with rsync before it is stopped.
+type LxcSyncConfig struct {
+	Paths       []string
+	Destination string
+	Args        []string
+	Timeout     string
+}
+
+// LxcSystemdConfig is the systemd block of the task config, periodically
+// checking the container's systemd for failed units.
+type LxcSystemdConfig struct {
+	Interval string
+}
+
+// LxcStopConfig is the stop block of the task config, sending a graceful
+// signal ahead of the LXC shutdown and bounding each stage.
+type LxcStopConfig struct {
+	Signal          string
+	SignalTimeout   string `mapstructure:"signal_timeout"`
+	ShutdownTimeout string `mapstructure:"shutdown_timeout"`
+}
+
+// LxcTimeOffsetConfig is the time_offset block of the task config, shifting
+// the container's clocks in a time namespace.
+type LxcTimeOffsetConfig struct {
+	Monotonic string
+	Boottime  string
+}
+
+// LxcSharedVolumeConfig is a shared_volume block of the task config. Every
+// task of an allocation naming the same volume mounts the same directory,
+// which is created once per allocation.
+type LxcSharedVolumeConfig struct {
+	Name     string
+	Target   string
+	ReadOnly bool `mapstructure:"readonly"`
+}
+
+// LxcRootfsCopyConfig is a rootfs_copy block of the task config, copying a
+// path of the task directory, such as an unpacked artifact, into the
+// container's root filesystem before it starts.
+type LxcRootfsCopyConfig struct {
+	Source string
+	Target string
+}
+
+// LxcUsageAlertsConfig is the usage_alerts block of the task config,
+// reporting the container's open files or inotify watches exceeding the
+// thresholds.
+type LxcUsageAlertsConfig struct {
+	OpenFiles      int `mapstructure:"open_files"`
+	InotifyWatches int `mapstructure:"inotify_watches"`
+}
+
+// LxcCoreDumpsConfig is the core_dumps block of the task config, collecting
+// the core dumps of the container's processes in the task directory.
+type LxcCoreDumpsConfig struct {
+	MaxSizeMB int `mapstructure:"max_size_mb"`
+}
+
+// LxcSecretConfig is a secret block of the task config, projecting a file of
+// the task's secrets dir, such as one rendered by a template, read-only into
+// the container with its own mode and owner.
+type LxcSecretConfig struct {
+	Source string
+	Target string
+	Mode   string
+	UID    int `mapstructure:"uid"`
+	GID    int `mapstructure:"gid"`
+}
+
+// validatePaths checks that the secret's source stays in the secrets
+// directory and that its target is a clean absolute container path. The
+// paths are checked again once interpolated.
+func (s *LxcSecretConfig) validatePaths(i int) []error {
+	var errs []error
+	if filepath.IsAbs(s.Source) {
+		errs = append(errs, fmt.Errorf("secret[%d]: source %q must be relative to the secrets directory", i, s.Source))
+	} else if escapes, err := structs.PathEscapesAllocDir("", s.Source); err != nil || escapes {
+		errs = append(errs, fmt.Errorf("secret[%d]: source %q escapes the secrets directory", i, s.Source))
+	}
+	if !filepath.IsAbs(s.Target) || filepath.Clean(s.Target) != s.Target || s.Target == "/" {
+		errs = append(errs, fmt.Errorf("secret[%d]: target %q must be a clean absolute container path", i, s.Target))
+	}
+	return errs
+}
+
+var (
+	// lxcBlockSchemas are the schemas of the blocks that may be nested in
+	// the task config.
+	lxcBlockSchemas = map[string]map[string]*fields.FieldSchema{
+		"image": {
+			"distro":         {Type: fields.TypeString},
+			"release":        {Type: fields.TypeString},
+			"arch":           {Type: fields.TypeString},
+			"variant":        {Type: fields.TypeString},
+			"server":         {Type: fields.TypeString},
+			"serial":         {Type: fields.TypeString},
+			"gpg_key_id":     {Type: fields.TypeString},
+			"gpg_key_server": {Type: fields.TypeString},
+			"disable_gpg":    {Type: fields.TypeBool},
+			"flush_cache":    {Type: fields.TypeBool},
+			"force_cache":    {Type: fields.TypeBool},
+		},
+		"mount": {
+			"source":   {Type: fields.TypeString, Required: true},
+			"target":   {Type: fields.TypeString, Required: true},
+			"readonly": {Type: fields.TypeBool},
+		},
+		"network": {
+			"type":         {Type: fields.TypeString},
+			"bridge":       {Type: fields.TypeString},
+			"parent":       {Type: fields.TypeString},
+			"mode":         {Type: fields.TypeString},
+			"name":         {Type: fields.TypeString},
+			"ipv4_address": {Type: fields.TypeString},
+			"ipv4_gateway": {Type: fields.TypeString},
+			"ipv6_address": {Type: fields.TypeString},
+			"ipv6_gateway": {Type: fields.TypeString},
+			"routes":       {Type: fields.TypeArray},
+			"hwaddr":       {Type: fields.TypeString},
+			"vlan_id":      {Type: fields.TypeInt},
+
+			"ipv6_accept_ra": {Type: fields.TypeBool},
+		},
+		"limits": {
+			"cpuset_cpus":    {Type: fields.TypeString},
+			"memory_swap_mb": {Type: fields.TypeInt},
+			"pids_max":       {Type: fields.TypeInt},
+			"egress_mbits":   {Type: fields.TypeInt},
+			"ingress_mbits":  {Type: fields.TypeInt},
+		},
+		"sync": {
+			"paths":       {Type: fields.TypeArray},
+			"destination": {Type: fields.TypeString},
+			"args":        {Type: fields.TypeArray},
+			"timeout":     {Type: fields.TypeString},
+		},
+		"systemd": {
+			"interval": {Type: fields.TypeString},
+		},
+		"stop": {
+			"signal":           {Type: fields.TypeString},
+			"signal_timeout":   {Type: fields.TypeString},
+			"shutdown_timeout": {Type: fields.TypeString},
+		},
+		"time_offset": {
+			"monotonic": {Type: fields.TypeString},
+			"boottime":  {Type: fields.TypeString},
+		},
+		"shared_volume": {
+			"name":     {Type: fields.TypeString, Required: true},
+			"target":   {Type: fields.TypeString, Required: true},
+			"readonly": {Type: fields.TypeBool},
+		},
+		"rootfs_copy": {
+			"source": {Type: fields.TypeString, Required: true},
+			"target": {Type: fields.TypeString, Required: true},
+		},
+		"usage_alerts": {
+			"open_files":      {Type: fields.TypeInt},
+			"inotify_watches": {Type: fields.TypeInt},
+		},
+		"core_dumps": {
+			"max_size_mb": {Type: fields.TypeInt},
+		},
+		"secret": {
+			"source": {Type: fields.TypeString, Required: true},
+			"target": {Type: fields.TypeString, Required: true},
+			"mode":   {Type: fields.TypeString},
+			"uid":    {Type: fields.TypeInt},
+			"gid":    {Type: fields.TypeInt},
+		},
+	}
+
+	// lxcRepeatableBlocks are the nested blocks that may be given more than
+	// once.
+	lxcRepeatableBlocks = map[string]bool{
+		"mount":         true,
+		"shared_volume": true,
+		"rootfs_copy":   true,
+		"secret":        true,
+	}
+
+	// sharedVolumeNameRe matches the allowed names of shared volumes.
+	sharedVolumeNameRe = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+	// lxcImageVariantRe matches the image variants of the download
+	// template, such as "default" or "cloud".
+	lxcImageVariantRe = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+	// lxcImageServerRe matches the image servers of the download template,
+	// which are host names without a scheme or path.
+	lxcImageServerRe = regexp.MustCompile(`^[a-zA-Z0-9.-]+(:[0-9]+)?$`)
+
+	// lxcImageSerialRe matches the serials of the images of the download
+	// template, such as "20181017_07:42".
+	lxcImageSerialRe = regexp.MustCompile(`^[a-zA-Z0-9_:.-]+$`)
+
+	// cpusetRe matches a cpuset list such as "0-3,6".
+	cpusetRe = regexp.MustCompile(`^[0-9]+(-[0-9]+)?(,[0-9]+(-[0-9]+)?)*$`)
+)
+
+// Validate validates the lxc driver configuration
+func (d *LxcDriver) Validate(config map[string]interface{}) error {
+	fd := &fields.FieldData{
+		Raw: config,
+		Schema: map[string]*fields.FieldSchema{
+			"template": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"oci_image": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"rootfs_archive": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"backend": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"rootfs_size_mb": {
+				Type:     fields.TypeInt,
+				Required: false,
+			},
+			"mode": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"command": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"args": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"distro": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"release": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"arch": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"image_variant": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"image_server": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"image_serial": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"image_server_username": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"image_server_password": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"image_server_token": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"http_proxy": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"https_proxy": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"no_proxy": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"gpg_key_id": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"gpg_key_server": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"disable_gpg": {
+				Type:     fields.TypeBool,
+				Required: false,
+			},
+			"flush_cache": {
+				Type:     fields.TypeBool,
+				Required: false,
+			},
+			"force_cache": {
+				Type:     fields.TypeBool,
+				Required: false,
+			},
+			"template_args": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"log_level": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"verbosity": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"volumes": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"console_log": {
+				Type:     fields.TypeBool,
+				Required: false,
+			},
+			"timezone": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"cgroup_namespace": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"max_uptime": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"max_uptime_jitter": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"preserve_on_failure": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"create_timeout": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"provision_cmds": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"signal_pidfile": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"dns_servers": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"dns_search_domains": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"extra_hosts": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"network_mode": {
+				Type:     fields.TypeString,
+				Required: false,
+			},
+			"port_map": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"image": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"mount": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"network": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"limits": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"sync": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"systemd": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"stop": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"time_offset": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"shared_volume": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"rootfs_copy": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"secret": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"usage_alerts": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+			"core_dumps": {
+				Type:     fields.TypeArray,
+				Required: false,
+			},
+		},
+	}
+
+	if err := fd.Validate(); err != nil {
+		return err
+	}
+
+	if err := validateLxcBlocks(config); err != nil {
+		return err
+	}
+
+	// Templates given as a relative path are resolved against the task
+	// directory, so they must not escape it
+	template := fd.Get("template").(string)
+	if isLxcTemplatePath(template) && !filepath.IsAbs(template) {
+		escapes, err := structs.PathEscapesAllocDir("", template)
+		if err != nil {
+			return fmt.Errorf("invalid template path %q: %v", template, err)
+		}
+		if escapes {
+			return fmt.Errorf("template path %q escapes the task directory", template)
+		}
+	}
+
+	for _, volDesc := range fd.Get("volumes").([]interface{}) {
+		volStr := volDesc.(string)
+		paths := strings.Split(volStr, ":")
+		if len(paths) != 2 {
+			return fmt.Errorf("invalid volume bind mount entry: '%s'", volStr)
+		}
+		if len(paths[0]) == 0 || len(paths[1]) == 0 {
+			return fmt.Errorf("invalid volume bind mount entry: '%s'", volStr)
+		}
+		if paths[1][0] == '/' {
+			return fmt.Errorf("unsupported absolute container mount point: '%s'", paths[1])
+		}
+	}
+
+	var driverConfig LxcDriverConfig
+	if err := mapstructure.WeakDecode(config, &driverConfig); err != nil {
+		return err
+	}
+	return driverConfig.validate()
+}
+
+// validateLxcBlocks checks the nested blocks of the task config against
+// their schemas, reporting errors with the block's name and index.
+func validateLxcBlocks(config map[string]interface{}) error {
+	var mErr multierror.Error
+	for name, schema := range lxcBlockSchemas {
+		raw, ok := config[name]
+		if !ok {
+			continue
+		}
+
+		blocks, err := lxcConfigBlocks(raw)
+		if err != nil {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("%s: %v", name, err))
+			continue
+		}
+		if len(blocks) > 1 && !lxcRepeatableBlocks[name] {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("only one %s block is allowed, found %d", name, len(blocks)))
+			continue
+		}
+
+		for i, block := range blocks {
+			fd := &fields.FieldData{Raw: block, Schema: schema}
+			if err := fd.Validate(); err != nil {
+				mErr.Errors = append(mErr.Errors, fmt.Errorf("%s[%d]: %v", name, i, err))
+			}
+		}
+	}
+	return mErr.ErrorOrNil()
+}
+
+// lxcConfigBlocks converts the raw value of a nested block to its list of
+// blocks. Blocks are decoded as a list of maps from HCL and as a list of
+// interfaces from JSON.
+func lxcConfigBlocks(raw interface{}) ([]map[string]interface{}, error) {
+	switch v := raw.(type) {
+	case []map[string]interface{}:
+		return v, nil
+	case map[string]interface{}:
+		return []map[string]interface{}{v}, nil
+	case []interface{}:
+		blocks := make([]map[string]interface{}, 0, len(v))
+		for i, b := range v {
+			block, ok := b.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("entry %d is not a block", i)
+			}
+			blocks = append(blocks, block)
+		}
+		return blocks, nil
+	default:
+		return nil, fmt.Errorf("expected a block, got %T", raw)
+	}
+}
+
+// validate checks the decoded task config for values the schema can't
+// catch.
+func (c *LxcDriverConfig) validate() error {
+	var mErr multierror.Error
+
+	if len(c.Image) != 0 {
+		image := c.Image[0]
+		conflicts := map[string]bool{
+			"distro":         image.Distro != "" && c.Distro != "",
+			"release":        image.Release != "" && c.Release != "",
+			"arch":           image.Arch != "" && c.Arch != "",
+			"image_variant":  image.Variant != "" && c.ImageVariant != "",
+			"image_server":   image.Server != "" && c.ImageServer != "",
+			"image_serial":   image.Serial != "" && c.ImageSerial != "",
+			"gpg_key_id":     image.GPGKeyID != "" && c.GPGKeyID != "",
+			"gpg_key_server": image.GPGKeyServer != "" && c.GPGKeyServer != "",
+		}
+		for key, conflict := range conflicts {
+			if conflict {
+				mErr.Errors = append(mErr.Errors, fmt.Errorf("image[0]: %q is also set outside of the image block", key))
+			}
+		}
+	}
+
+	mErr.Errors = append(mErr.Errors, c.validateOCIImage()...)
+	mErr.Errors = append(mErr.Errors, c.validateRootfsArchive()...)
+	mErr.Errors = append(mErr.Errors, c.validateBackend()...)
+	mErr.Errors = append(mErr.Errors, c.validateTemplateOptions()...)
+	mErr.Errors = append(mErr.Errors, c.validateImageServerAuth()...)
+	mErr.Errors = append(mErr.Errors, c.validateProxy()...)
+	mErr.Errors = append(mErr.Errors, c.validateMode()...)
+	mErr.Errors = append(mErr.Errors, c.validateStop()...)
+
+	for i, m := range c.Mounts {
+		if filepath.IsAbs(m.Target) {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("mount[%d]: unsupported absolute container mount point: %q", i, m.Target))
+		}
+	}
+
+	targets := make(map[string]bool)
+	for i, v := range c.SharedVolumes {
+		if !sharedVolumeNameRe.MatchString(v.Name) || v.Name == "." || v.Name == ".." {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("shared_volume[%d]: invalid name %q", i, v.Name))
+		}
+		if filepath.IsAbs(v.Target) {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("shared_volume[%d]: unsupported absolute container mount point: %q", i, v.Target))
+		}
+		if targets[v.Target] {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("shared_volume[%d]: duplicate mount point %q", i, v.Target))
+		}
+		targets[v.Target] = true
+	}
+
+	for i, cp := range c.RootfsCopies {
+		if filepath.IsAbs(cp.Source) {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("rootfs_copy[%d]: source %q must be relative to the task directory", i, cp.Source))
+		} else if escapes, err := structs.PathEscapesAllocDir("", cp.Source); err != nil || escapes {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("rootfs_copy[%d]: source %q escapes the task directory", i, cp.Source))
+		}
+		if !filepath.IsAbs(cp.Target) {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("rootfs_copy[%d]: target %q must be an absolute container path", i, cp.Target))
+		}
+	}
+
+	secretTargets := make(map[string]bool)
+	for i, s := range c.Secrets {
+		mErr.Errors = append(mErr.Errors, s.validatePaths(i)...)
+		if secretTargets[s.Target] {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("secret[%d]: duplicate target %q", i, s.Target))
+		}
+		secretTargets[s.Target] = true
+		if s.Mode != "" {
+			if m, err := strconv.ParseUint(s.Mode, 8, 32); err != nil || m > 0777 {
+				mErr.Errors = append(mErr.Errors, fmt.Errorf("secret[%d]: invalid mode %q, must be octal permissions such as \"0600\"", i, s.Mode))
+			}
+		}
+		if s.UID < 0 || s.GID < 0 {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("secret[%d]: uid and gid must not be negative", i))
+		}
+	}
+
+	if len(c.Network) != 0 {
+		mErr.Errors = append(mErr.Errors, c.Network[0].validate()...)
+	}
+	mErr.Errors = append(mErr.Errors, c.validateNetworkMode()...)
+	if len(c.PortMapRaw) != 0 && c.sharedNetworkTask() == "" && (len(c.Network) == 0 || c.Network[0].Type != lxcNetworkVeth) {
+		mErr.Errors = append(mErr.Errors, fmt.Errorf("port_map requires a network of type %q or joining another task's", lxcNetworkVeth))
+	}
+	for _, m := range c.PortMapRaw {
+		for label, port := range m {
+			// Interpolated ports are checked once the task's environment
+			// is known
+			if strings.Contains(port, "${") {
+				continue
+			}
+			if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+				mErr.Errors = append(mErr.Errors, fmt.Errorf("port_map: invalid port %q for %q", port, label))
+			}
+		}
+	}
+
+	if len(c.UsageAlerts) != 0 && (c.UsageAlerts[0].OpenFiles < 0 || c.UsageAlerts[0].InotifyWatches < 0) {
+		mErr.Errors = append(mErr.Errors, fmt.Errorf("usage_alerts[0]: thresholds must not be negative"))
+	}
+	if len(c.CoreDumps) != 0 && c.CoreDumps[0].MaxSizeMB < 0 {
+		mErr.Errors = append(mErr.Errors, fmt.Errorf("core_dumps[0]: max_size_mb must not be negative"))
+	}
+
+	if len(c.Limits) != 0 {
+		limits := c.Limits[0]
+		if limits.CPUSetCPUs != "" && !cpusetRe.MatchString(limits.CPUSetCPUs) {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("limits[0]: invalid cpuset_cpus %q", limits.CPUSetCPUs))
+		}
+		if limits.MemorySwapMB < 0 {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("limits[0]: memory_swap_mb must not be negative"))
+		}
+		if limits.PidsMax < 0 {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("limits[0]: pids_max must not be negative"))
+		}
+		if limits.EgressMbits < 0 || limits.IngressMbits < 0 {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("limits[0]: egress_mbits and ingress_mbits must not be negative"))
+		} else if (limits.EgressMbits > 0 || limits.IngressMbits > 0) && (len(c.Network) == 0 || c.Network[0].Type != lxcNetworkVeth) {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("limits[0]: egress_mbits and ingress_mbits require a network of type %q", lxcNetworkVeth))
+		}
+	}
+
+	if len(c.Sync) != 0 {
+		sync := c.Sync[0]
+		if len(sync.Paths) == 0 {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("sync[0]: at least one path is required"))
+		}
+		for _, p := range sync.Paths {
+			if !filepath.IsAbs(p) || filepath.Clean(p) != p {
+				mErr.Errors = append(mErr.Errors, fmt.Errorf("sync[0]: path %q must be a clean absolute container path", p))
+			}
+		}
+		if dest := sync.Destination; dest != "" && !isRemoteSyncDestination(dest) {
+			escapes, err := structs.PathEscapesAllocDir("", dest)
+			if err != nil {
+				mErr.Errors = append(mErr.Errors, fmt.Errorf("sync[0]: invalid destination %q: %v", dest, err))
+			} else if escapes || filepath.IsAbs(dest) {
+				mErr.Errors = append(mErr.Errors, fmt.Errorf("sync[0]: destination %q must be within the allocation directory", dest))
+			}
+		}
+		if sync.Timeout != "" {
+			if timeout, err := time.ParseDuration(sync.Timeout); err != nil {
+				mErr.Errors = append(mErr.Errors, fmt.Errorf("sync[0]: invalid timeout %q: %v", sync.Timeout, err))
+			} else if timeout <= 0 {
+				mErr.Errors = append(mErr.Errors, fmt.Errorf("sync[0]: timeout must be positive"))
+			}
+		}
+	}
+
+	switch c.CgroupNamespace {
+	case "", lxcCgroupNamespacePrivate, lxcCgroupNamespaceHost:
+	default:
+		mErr.Errors = append(mErr.Errors, fmt.Errorf("cgroup_namespace must be %q or %q, got %q", lxcCgroupNamespacePrivate, lxcCgroupNamespaceHost, c.CgroupNamespace))
+	}
+
+	if c.Timezone != "" && !lxcTimezoneRe.MatchString(c.Timezone) {
+		mErr.Errors = append(mErr.Errors, fmt.Errorf("invalid timezone %q", c.Timezone))
+	}
+
+	for _, s := range c.DNSServers {
+		if !strings.Contains(s, "${") && net.ParseIP(s) == nil {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("dns_servers: invalid address %q", s))
+		}
+	}
+	for _, s := range c.DNSSearchDomains {
+		if s == "" || strings.ContainsAny(s, " \t\n") {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("dns_search_domains: invalid domain %q", s))
+		}
+	}
+	for _, entry := range c.ExtraHosts {
+		_, ip, err := parseLxcExtraHost(entry)
+		if err != nil {
+			mErr.Errors = append(mErr.Errors, err)
+		} else if !strings.Contains(ip, "${") && net.ParseIP(ip) == nil {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("extra_hosts: invalid address %q in %q", ip, entry))
+		}
+	}
+
+	if len(c.TimeOffset) != 0 {
+		offsets := []struct{ clock, offset string }{
+			{"monotonic", c.TimeOffset[0].Monotonic},
+			{"boottime", c.TimeOffset[0].Boottime},
+		}
+		for _, o := range offsets {
+			if o.offset == "" {
+				continue
+			}
+			if _, err := time.ParseDuration(o.offset); err != nil {
+				mErr.Errors = append(mErr.Errors, fmt.Errorf("time_offset[0]: invalid %s offset %q: %v", o.clock, o.offset, err))
+			}
+		}
+	}
+
+	for i, cmd := range c.ProvisionCmds {
+		if strings.TrimSpace(cmd) == "" {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("provision_cmds[%d] must not be empty", i))
+		}
+	}
+
+	if p := c.SignalPidfile; p != "" && (!filepath.IsAbs(p) || filepath.Clean(p) != p) {
+		mErr.Errors = append(mErr.Errors, fmt.Errorf("signal_pidfile %q must be a clean absolute container path", p))
+	}
+
+	if c.MaxUptime != "" {
+		if d, err := time.ParseDuration(c.MaxUptime); err != nil {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("invalid max_uptime %q: %v", c.MaxUptime, err))
+		} else if d <= 0 {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("max_uptime must be positive"))
+		}
+	}
+	if c.MaxUptimeJitter != "" {
+		if c.MaxUptime == "" {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("max_uptime_jitter requires max_uptime"))
+		}
+		if d, err := time.ParseDuration(c.MaxUptimeJitter); err != nil {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("invalid max_uptime_jitter %q: %v", c.MaxUptimeJitter, err))
+		} else if d < 0 {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("max_uptime_jitter must not be negative"))
+		}
+	}
+	if c.PreserveOnFailure != "" {
+		if d, err := time.ParseDuration(c.PreserveOnFailure); err != nil {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("invalid preserve_on_failure %q: %v", c.PreserveOnFailure, err))
+		} else if d <= 0 {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("preserve_on_failure must be positive"))
+		}
+	}
+
+	if c.CreateTimeout != "" {
+		if d, err := time.ParseDuration(c.CreateTimeout); err != nil {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("invalid create_timeout %q: %v", c.CreateTimeout, err))
+		} else if d <= 0 {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("create_timeout must be positive"))
+		}
+	}
+
+	if len(c.Systemd) != 0 && c.Systemd[0].Interval != "" {
+		interval := c.Systemd[0].Interval
+		if d, err := time.ParseDuration(interval); err != nil {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("systemd[0]: invalid interval %q: %v", interval, err))
+		} else if d < lxcSystemdIntervalMin {
+			mErr.Errors = append(mErr.Errors, fmt.Errorf("systemd[0]: interval must be at least %s", lxcSystemdIntervalMin))
+		}
+	}
+
+	return mErr.ErrorOrNil()
+}
+
+// validateTemplateOptions checks the image keys against the template. The
+// download template requires the distro, release and arch of the image,
+// while other templates are only passed the release, arch and flush_cache,
+// so setting the other keys would be silently ignored.
+func (c *LxcDriverConfig) validateTemplateOptions() []error {
+	var image LxcImageConfig
+	if len(c.Image) != 0 {
+		image = c.Image[0]
+	}
+
+	template := c.Template
+	switch {
+	case c.OCIImage != "":
+		template = lxcOCITemplate
+	case c.RootfsArchive != "":
+		template = "rootfs_archive"
+	}
+	if template != lxcDownloadTemplate {
+		downloadOnly := []struct {
+			key string
+			set bool
+		}{
+			{"distro", c.Distro != "" || image.Distro != ""},
+			{"image_variant", c.ImageVariant != "" || image.Variant != ""},
+			{"image_server", c.ImageServer != "" || image.Server != ""},
+			{"image_serial", c.ImageSerial != "" || image.Serial != ""},
+			{"image_server_username", c.ImageServerUsername != ""},
+			{"image_server_password", c.ImageServerPassword != ""},
+			{"image_server_token", c.ImageServerToken != ""},
+			{"gpg_key_id", c.GPGKeyID != "" || image.GPGKeyID != ""},
+			{"gpg_key_server", c.GPGKeyServer != "" || image.GPGKeyServer != ""},
+			{"disable_gpg", c.DisableGPGValidation || image.DisableGPGValidation},
+			{"force_cache", c.ForceCache || image.ForceCache},
+		}
+		var errs []error
+		for _, o := range downloadOnly {
+			if o.set {
+				errs = append(errs, fmt.Errorf("%q is only supported by the %s template, not %q", o.key, lxcDownloadTemplate, template))
+			}
+		}
+		return errs
+	}
+
+	var errs []error
+	required := []struct {
+		key, value string
+	}{
+		{"distro", firstNonEmpty(c.Distro, image.Distro)},
+		{"release", firstNonEmpty(c.Release, image.Release)},
+		{"arch", firstNonEmpty(c.Arch, image.Arch)},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			errs = append(errs, fmt.Errorf("%q is required by the %s template", r.key, lxcDownloadTemplate))
+		}
+	}
+	if variant := firstNonEmpty(c.ImageVariant, image.Variant); variant != "" && !lxcImageVariantRe.MatchString(variant) {
+		errs = append(errs, fmt.Errorf("invalid image variant %q", variant))
+	}
+	if server := firstNonEmpty(c.ImageServer, image.Server); server != "" && !lxcImageServerRe.MatchString(server) {
+		errs = append(errs, fmt.Errorf("invalid image_server %q: must be a host name, optionally with a port", server))
+	}
+	if serial := firstNonEmpty(c.ImageSerial, image.Serial); serial != "" && !lxcImageSerialRe.MatchString(serial) {
+		errs = append(errs, fmt.Errorf("invalid image_serial %q", serial))
+	}
+	return errs
+}
+
+// NewLxcDriverConfig decodes the task config, merging the nested image
+// block into the flat image keys and the volumes into the mounts. Mount and
+// secret sources and targets, the image server credentials, the OCI image,
+// the rootfs archive, the DNS settings and the extra hosts are interpolated
+// with the task environment.
+func NewLxcDriverConfig(task *structs.Task, env *env.TaskEnv) (*LxcDriverConfig, error) {
+	var c LxcDriverConfig
+	if err := mapstructure.WeakDecode(task.Config, &c); err != nil {
+		return nil, err
+	}
+
+	if len(c.Image) != 0 {
+		image := c.Image[0]
+		c.Distro = firstNonEmpty(c.Distro, image.Distro)
+		c.Release = firstNonEmpty(c.Release, image.Release)
+		c.Arch = firstNonEmpty(c.Arch, image.Arch)
+		c.ImageVariant = firstNonEmpty(c.ImageVariant, image.Variant)
+		c.ImageServer = firstNonEmpty(c.ImageServer, image.Server)
+		c.ImageSerial = firstNonEmpty(c.ImageSerial, image.Serial)
+		c.GPGKeyID = firstNonEmpty(c.GPGKeyID, image.GPGKeyID)
+		c.GPGKeyServer = firstNonEmpty(c.GPGKeyServer, image.GPGKeyServer)
+		c.DisableGPGValidation = c.DisableGPGValidation || image.DisableGPGValidation
+		c.FlushCache = c.FlushCache || image.FlushCache
+		c.ForceCache = c.ForceCache || image.ForceCache
+	}
+
+	for _, volDesc := range c.Volumes {
+		// the format was checked in Validate()
+		paths := strings.Split(volDesc, ":")
+		c.Mounts = append(c.Mounts, LxcMountConfig{
+			Source: paths[0],
+			Target: paths[1],
+		})
+	}
+
+	for i, m := range c.Mounts {
+		c.Mounts[i].Source = env.ReplaceEnv(m.Source)
+		c.Mounts[i].Target = env.ReplaceEnv(m.Target)
+		if filepath.IsAbs(c.Mounts[i].Target) {
+			return nil, fmt.Errorf("unsupported absolute container mount point: %q", c.Mounts[i].Target)
+		}
+	}
+	for i, v := range c.SharedVolumes {
+		c.SharedVolumes[i].Target = env.ReplaceEnv(v.Target)
+		if filepath.IsAbs(c.SharedVolumes[i].Target) {
+			return nil, fmt.Errorf("unsupported absolute container mount point: %q", c.SharedVolumes[i].Target)
+		}
+	}
+
+	for i, s := range c.Secrets {
+		c.Secrets[i].Source = env.ReplaceEnv(s.Source)
+		c.Secrets[i].Target = env.ReplaceEnv(s.Target)
+		if errs := c.Secrets[i].validatePaths(i); len(errs) != 0 {
+			return nil, errs[0]
+		}
+	}
+
+	c.ImageServerUsername = env.ReplaceEnv(c.ImageServerUsername)
+	c.ImageServerPassword = env.ReplaceEnv(c.ImageServerPassword)
+	c.ImageServerToken = env.ReplaceEnv(c.ImageServerToken)
+
+	if c.RootfsArchive != "" {
+		c.RootfsArchive = env.ReplaceEnv(c.RootfsArchive)
+		if err := c.validateRootfsArchivePath(); err != nil {
+			return nil, err
+		}
+	}
+	if c.OCIImage != "" {
+		c.OCIImage = env.ReplaceEnv(c.OCIImage)
+		c.Template = lxcOCITemplate
+	}
+
+	if c.Mode == "" {
+		c.Mode = lxcModeSystem
+	}
+	c.Command = env.ReplaceEnv(c.Command)
+	c.Args = env.ParseAndReplace(c.Args)
+
+	for i, s := range c.DNSServers {
+		c.DNSServers[i] = env.ReplaceEnv(s)
+	}
+	for i, s := range c.DNSSearchDomains {
+		c.DNSSearchDomains[i] = env.ReplaceEnv(s)
+	}
+	for i, s := range c.ExtraHosts {
+		c.ExtraHosts[i] = env.ReplaceEnv(s)
+	}
+
+	portMap, err := parseLxcPortMap(c.PortMapRaw, env)
+	if err != nil {
+		return nil, err
+	}
+	c.PortMap = portMap
+
+	if len(c.Network) == 0 {
+		c.Network = []LxcNetworkConfig{{}}
+	}
+	if c.Network[0].Type == "" {
+		c.Network[0].Type = "none"
+	}
+	if len(c.Limits) == 0 {
+		c.Limits = []LxcLimitsConfig{{}}
+	}
+
+	return &c, nil
+}
+
+// firstNonEmpty returns the first of the strings that is not empty.
+func firstNonEmpty(s ...string) string {
+	for _, v := range s {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
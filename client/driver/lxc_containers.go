@@ -0,0 +1,193 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/client/config"
+	"github.com/shirou/gopsutil/process"
+
+	cstructs "github.com/hashicorp/nomad/client/structs"
+)
+
+// lxcPruneIntervalConfigOption is the key for how often the client prunes
+// the orphaned containers created by the driver, starting when it starts.
+// Zero disables pruning, leaving orphaned containers to be pruned by the
+// operator.
+const lxcPruneIntervalConfigOption = "driver.lxc.prune_interval"
+
+// LxcPruneInterval returns how often the client prunes orphaned containers,
+// or zero if it doesn't.
+func LxcPruneInterval(cfg *config.Config) time.Duration {
+	return cfg.ReadDurationDefault(lxcPruneIntervalConfigOption, 0)
+}
+
+// lxcAllocIDRe matches the allocation ID suffix of the containers created by
+// the driver.
+var lxcAllocIDRe = regexp.MustCompile(`-([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})$`)
+
+// lxcContainerName returns the name of the container running the task of the
+// given allocation.
+func lxcContainerName(task, allocID string) string {
+	return fmt.Sprintf("%s-%s", task, allocID)
+}
+
+// parseLxcContainerName splits a container name created by the driver into
+// its task name and allocation ID. Containers not created by the driver are
+// reported as not ok.
+func parseLxcContainerName(name string) (task, allocID string, ok bool) {
+	m := lxcAllocIDRe.FindStringSubmatchIndex(name)
+	if m == nil || m[0] == 0 {
+		return "", "", false
+	}
+	return name[:m[0]], name[m[2]:m[3]], true
+}
+
+// LxcContainers returns the containers created by the LXC driver under the
+// configured LXC paths.
+func LxcContainers(cfg *config.Config) ([]*cstructs.LxcContainer, error) {
+	var containers []*cstructs.LxcContainer
+	for _, path := range readLxcPaths(cfg) {
+		for _, name := range defaultLxcBackend.DefinedContainerNames(path) {
+			if _, _, ok := parseLxcContainerName(name); !ok {
+				continue
+			}
+			container, err := lxcContainer(path, name)
+			if err != nil {
+				return nil, err
+			}
+			containers = append(containers, container)
+		}
+	}
+	return containers, nil
+}
+
+// LxcContainer returns the named container created by the LXC driver.
+func LxcContainer(cfg *config.Config, name string) (*cstructs.LxcContainer, error) {
+	if _, _, ok := parseLxcContainerName(name); !ok {
+		return nil, fmt.Errorf("container %q was not created by the lxc driver", name)
+	}
+	return lxcContainer(findLxcPath(defaultLxcBackend, cfg, name), name)
+}
+
+// LxcTaskContainer returns the container created by the LXC driver for the
+// task of the given allocation.
+func LxcTaskContainer(cfg *config.Config, allocID, task string) (*cstructs.LxcContainer, error) {
+	name := lxcContainerName(task, allocID)
+	return lxcContainer(findLxcPath(defaultLxcBackend, cfg, name), name)
+}
+
+// DestroyLxcContainer stops and destroys the named container created by the
+// LXC driver. Containers preserved after their task failed are kept until
+// their preservation runs out.
+func DestroyLxcContainer(cfg *config.Config, name string) error {
+	return destroyNamedLxcContainer(cfg, name, false)
+}
+
+// PruneLxcContainers destroys the orphaned containers among the given ones,
+// except those still preserved after their task failed, and returns the ones
+// that were removed.
+func PruneLxcContainers(cfg *config.Config, containers []*cstructs.LxcContainer, logger *log.Logger) ([]*cstructs.LxcContainer, error) {
+	now := time.Now()
+	var pruned []*cstructs.LxcContainer
+	for _, container := range containers {
+		if !container.Orphaned || now.Before(container.PreservedUntil) {
+			continue
+		}
+		logger.Printf("[INFO] driver.lxc: pruning orphaned container %q", container.Name)
+		if err := DestroyLxcContainer(cfg, container.Name); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, container)
+	}
+	return pruned, nil
+}
+
+// destroyNamedLxcContainer stops and destroys the named container created by
+// the LXC driver. Preserved containers are only destroyed if force is set.
+func destroyNamedLxcContainer(cfg *config.Config, name string, force bool) error {
+	if _, _, ok := parseLxcContainerName(name); !ok {
+		return fmt.Errorf("container %q was not created by the lxc driver", name)
+	}
+
+	c, err := openLxcContainer(defaultLxcBackend, name, findLxcPath(defaultLxcBackend, cfg, name))
+	if err != nil {
+		return err
+	}
+	defer defaultLxcBackend.Release(c)
+	if until := lxcPreservedUntil(c); !force && time.Now().Before(until) {
+		return fmt.Errorf("container %q is preserved until %s", name, until.Format(time.RFC3339))
+	}
+	return destroyLxcContainer(defaultLxcBackend, c)
+}
+
+// destroyLxcContainer stops the container if it is running and destroys it
+// along with its AppArmor profile.
+func destroyLxcContainer(backend lxcBackend, c lxcContainerAPI) error {
+	name := c.Name()
+	if c.Running() {
+		if err := c.Stop(); err != nil {
+			return fmt.Errorf("unable to stop container %q: %v", name, err)
+		}
+	}
+	if err := unloadAppArmorProfile(backend, c); err != nil {
+		return err
+	}
+	if err := c.Destroy(); err != nil {
+		return fmt.Errorf("unable to destroy container %q: %v", name, err)
+	}
+	return nil
+}
+
+// lxcContainer describes the named container under the LXC path.
+func lxcContainer(path, name string) (*cstructs.LxcContainer, error) {
+	c, err := openLxcContainer(defaultLxcBackend, name, path)
+	if err != nil {
+		return nil, err
+	}
+	defer defaultLxcBackend.Release(c)
+
+	task, allocID, _ := parseLxcContainerName(name)
+	container := &cstructs.LxcContainer{
+		Name:    name,
+		AllocID: allocID,
+		Task:    task,
+		State:   c.State().String(),
+		LxcPath: path,
+	}
+	if c.Running() {
+		container.InitPid = c.InitPid()
+		container.Uptime = lxcUptime(container.InitPid)
+	}
+	if until := lxcPreservedUntil(c); time.Now().Before(until) {
+		container.PreservedUntil = until
+	}
+
+	// LXC 2.1 renamed lxc.rootfs to lxc.rootfs.path
+	for _, key := range []string{"lxc.rootfs.path", "lxc.rootfs"} {
+		if rootfs := c.ConfigItem(key); len(rootfs) != 0 && rootfs[0] != "" {
+			container.RootFS = strings.TrimSpace(rootfs[0])
+			break
+		}
+	}
+	return container, nil
+}
+
+// lxcUptime returns how long the container's init process has been running,
+// or zero if it can't be determined.
+func lxcUptime(initPid int) time.Duration {
+	p, err := process.NewProcess(int32(initPid))
+	if err != nil {
+		return 0
+	}
+	created, err := p.CreateTime()
+	if err != nil {
+		return 0
+	}
+	return time.Since(time.Unix(0, created*int64(time.Millisecond))).Truncate(time.Second)
+}
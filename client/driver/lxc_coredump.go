@@ -0,0 +1,210 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+const (
+	// lxcCoreDumpsConfigOption is the client option pointing the kernel's
+	// core_pattern at the dumps directory of containers, so processes of
+	// LXC tasks with a core_dumps block leave their core dumps in the task
+	// directory.
+	lxcCoreDumpsConfigOption = "driver.lxc.core_dumps"
+
+	// lxcCoreDumpsAttr is the node attribute set while the kernel writes
+	// core dumps to the dumps directory of containers
+	lxcCoreDumpsAttr = "driver.lxc.core_dumps"
+
+	// lxcCoreDumpsTarget is the directory of the container the task's dumps
+	// directory is mounted at. core_pattern is resolved in the mount
+	// namespace of the crashing process, so one pattern serves every
+	// container.
+	lxcCoreDumpsTarget = "/var/crash/nomad"
+
+	// lxcCorePattern is the core_pattern set by the driver, naming dumps
+	// after the executable, the process' PID in its namespace and the time.
+	lxcCorePattern = lxcCoreDumpsTarget + "/core.%e.%p.%t"
+
+	// lxcCoreDumpsDir is the dumps directory in the task directory, which
+	// is removed with the allocation
+	lxcCoreDumpsDir = "dumps"
+
+	// lxcCoreDumpsMaxSizeDefault is the size in MB the dumps of a task are
+	// capped to if the core_dumps block doesn't set max_size_mb
+	lxcCoreDumpsMaxSizeDefault = 1024
+
+	// lxcCoreDumpsPollIntv is how often the dumps directory is checked for
+	// new dumps
+	lxcCoreDumpsPollIntv = 5 * time.Second
+)
+
+// procCorePattern is the kernel's core_pattern.
+var procCorePattern = "/proc/sys/kernel/core_pattern"
+
+// lxcCoreDumps is the dumps directory of a task and the size its dumps are
+// capped to.
+type lxcCoreDumps struct {
+	Dir      string
+	MaxBytes int64
+}
+
+// newLxcCoreDumps resolves the core_dumps block of the task config, or
+// returns nil if it isn't given.
+func newLxcCoreDumps(config []LxcCoreDumpsConfig, taskDir string) *lxcCoreDumps {
+	if len(config) == 0 {
+		return nil
+	}
+	maxSize := config[0].MaxSizeMB
+	if maxSize == 0 {
+		maxSize = lxcCoreDumpsMaxSizeDefault
+	}
+	return &lxcCoreDumps{
+		Dir:      filepath.Join(taskDir, lxcCoreDumpsDir),
+		MaxBytes: int64(maxSize) * 1024 * 1024,
+	}
+}
+
+// fingerprintCoreDumps points the kernel's core_pattern at the dumps
+// directory of containers if the client is configured to, and advertises
+// whether it does. The pattern is only written if it differs, so operators
+// changing it back are noticed rather than overridden silently.
+func (d *LxcDriver) fingerprintCoreDumps(node *structs.Node) {
+	raw, err := ioutil.ReadFile(procCorePattern)
+	if err != nil {
+		d.logger.Printf("[DEBUG] driver.lxc: unable to read core_pattern: %v", err)
+		return
+	}
+	pattern := strings.TrimSpace(string(raw))
+	if pattern != lxcCorePattern && d.config.ReadBoolDefault(lxcCoreDumpsConfigOption, false) {
+		if err := ioutil.WriteFile(procCorePattern, []byte(lxcCorePattern), 0644); err != nil {
+			d.logger.Printf("[WARN] driver.lxc: unable to set core_pattern: %v", err)
+		} else {
+			d.logger.Printf("[INFO] driver.lxc: changed core_pattern from %q to %q", pattern, lxcCorePattern)
+			pattern = lxcCorePattern
+		}
+	}
+	if pattern == lxcCorePattern {
+		node.Attributes[lxcCoreDumpsAttr] = "1"
+	} else {
+		delete(node.Attributes, lxcCoreDumpsAttr)
+	}
+}
+
+// setCoreDumps creates the task's dumps directory and caps the size of the
+// core dumps of the container's processes, returning the mount entry of the
+// directory.
+func (d *LxcDriver) setCoreDumps(c lxcContainerAPI, dumps *lxcCoreDumps) (string, error) {
+	if err := os.MkdirAll(dumps.Dir, 0777); err != nil {
+		return "", fmt.Errorf("unable to create core dumps directory: %v", err)
+	}
+	// The directory is written to by the container's users
+	if err := os.Chmod(dumps.Dir, 0777|os.ModeSticky); err != nil {
+		return "", fmt.Errorf("unable to create core dumps directory: %v", err)
+	}
+
+	// LXC 2.1 renamed lxc.limit to lxc.prlimit
+	limit := strconv.FormatInt(dumps.MaxBytes, 10)
+	if err := c.SetConfigItem("lxc.prlimit.core", limit); err != nil {
+		if err := c.SetConfigItem("lxc.limit.core", limit); err != nil {
+			return "", fmt.Errorf("unable to set core dump size limit: %v", err)
+		}
+	}
+
+	if raw, err := ioutil.ReadFile(procCorePattern); err == nil && strings.TrimSpace(string(raw)) != lxcCorePattern {
+		d.emitEvent("Core dumps are not collected as the node's core_pattern is %q", strings.TrimSpace(string(raw)))
+	}
+	return fmt.Sprintf("%s %s none rw,bind,create=dir", dumps.Dir, strings.TrimPrefix(lxcCoreDumpsTarget, "/")), nil
+}
+
+// monitorCoreDumps checks the task's dumps directory for new dumps until
+// stopCh is closed, reporting them as task events and removing the oldest
+// dumps once they exceed the task's cap. Dumps are reported once their size
+// stops changing, as the kernel may still be writing them.
+func (h *lxcDriverHandle) monitorCoreDumps(stopCh <-chan bool) {
+	// Dumps present when monitoring starts, such as after the client
+	// restarted, were reported already
+	reported := make(map[string]bool)
+	for _, fi := range readCoreDumps(h.coreDumps.Dir) {
+		reported[fi.Name()] = true
+	}
+	pending := make(map[string]int64)
+
+	ticker := time.NewTicker(lxcCoreDumpsPollIntv)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+		h.checkCoreDumps(reported, pending)
+	}
+}
+
+// checkCoreDumps reports the dumps written since the last check and
+// enforces the cap on the task's dumps.
+func (h *lxcDriverHandle) checkCoreDumps(reported map[string]bool, pending map[string]int64) {
+	dumps := readCoreDumps(h.coreDumps.Dir)
+	var total int64
+	for _, fi := range dumps {
+		total += fi.Size()
+		name := fi.Name()
+		if reported[name] {
+			continue
+		}
+		if size, ok := pending[name]; !ok || size != fi.Size() {
+			pending[name] = fi.Size()
+			continue
+		}
+		delete(pending, name)
+		reported[name] = true
+		h.logger.Printf("[WARN] driver.lxc: process of container %q dumped core to %s", h.container.Name(), filepath.Join(h.coreDumps.Dir, name))
+		h.emitEvent("Process dumped core: %s (%d MB)", filepath.Join(lxcCoreDumpsDir, name), fi.Size()/1024/1024)
+	}
+
+	// Remove the oldest dumps, the first ones listed, beyond the cap
+	for _, fi := range dumps {
+		if total <= h.coreDumps.MaxBytes {
+			break
+		}
+		if _, ok := pending[fi.Name()]; ok {
+			continue
+		}
+		if err := os.Remove(filepath.Join(h.coreDumps.Dir, fi.Name())); err != nil {
+			h.logger.Printf("[WARN] driver.lxc: unable to remove core dump: %v", err)
+			continue
+		}
+		h.logger.Printf("[INFO] driver.lxc: removed core dump %q of container %q to stay under %d MB", fi.Name(), h.container.Name(), h.coreDumps.MaxBytes/1024/1024)
+		total -= fi.Size()
+	}
+}
+
+// readCoreDumps lists the regular files of the dumps directory, oldest
+// first.
+func readCoreDumps(dir string) []os.FileInfo {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var dumps []os.FileInfo
+	for _, fi := range entries {
+		if fi.Mode().IsRegular() {
+			dumps = append(dumps, fi)
+		}
+	}
+	sort.SliceStable(dumps, func(i, j int) bool {
+		return dumps[i].ModTime().Before(dumps[j].ModTime())
+	})
+	return dumps
+}
@@ -0,0 +1,124 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+const (
+	// lxcCrashLoopThresholdConfigOption is the key for how many times a
+	// container may fail to start, or exit right after starting, within
+	// lxcCrashLoopWindow before it is considered crash looping. Crash
+	// looping containers are kept for the task's retries rather than being
+	// destroyed and created again. 0 disables the detection.
+	lxcCrashLoopThresholdConfigOption = "driver.lxc.crash_loop_threshold"
+	lxcCrashLoopThresholdDefault      = 3
+
+	// lxcCrashLoopWindow is the window over which a container's crashes are
+	// counted
+	lxcCrashLoopWindow = 10 * time.Minute
+
+	// lxcCrashLoopMinUptime is how long a container must run for its exit
+	// not to count as a crash. Running that long clears its crashes.
+	lxcCrashLoopMinUptime = 30 * time.Second
+)
+
+// lxcCrashLoops tracks the recent crashes of the node's containers by name.
+// A task's container keeps its name across restarts, so the crashes of its
+// retries add up.
+var lxcCrashLoops = &lxcCrashLoopTracker{}
+
+type lxcCrashLoopTracker struct {
+	crashes map[string][]time.Time
+	lock    sync.Mutex
+}
+
+// record records a crash of the named container and returns how many it had
+// within the window.
+func (t *lxcCrashLoopTracker) record(name string, now time.Time) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.crashes == nil {
+		t.crashes = make(map[string][]time.Time)
+	}
+	var recent []time.Time
+	for _, crash := range t.crashes[name] {
+		if now.Sub(crash) < lxcCrashLoopWindow {
+			recent = append(recent, crash)
+		}
+	}
+	recent = append(recent, now)
+	t.crashes[name] = recent
+	return len(recent)
+}
+
+// clear forgets the crashes of the named container.
+func (t *lxcCrashLoopTracker) clear(name string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.crashes, name)
+}
+
+// crashLooping records a crash of the named container and returns whether
+// it is crash looping.
+func (d *LxcDriver) crashLooping(name string, reason string) bool {
+	threshold := d.config.ReadIntDefault(lxcCrashLoopThresholdConfigOption, lxcCrashLoopThresholdDefault)
+	return lxcCrashLooping(name, reason, threshold, d.logger, d.emitEvent)
+}
+
+// lxcCrashLooping records a crash of the named container and returns whether
+// it crashed at least threshold times within the window, emitting a task
+// event identifying the loop if so.
+func lxcCrashLooping(name, reason string, threshold int, logger *log.Logger, emitEvent LogEventFn) bool {
+	crashes := lxcCrashLoops.record(name, time.Now())
+	if threshold <= 0 || crashes < threshold {
+		return false
+	}
+	metrics.IncrCounter([]string{"client", "lxc", "crash_loops"}, 1)
+	logger.Printf("[WARN] driver.lxc: container %q is crash looping with %d crashes in %v, last: %s", name, crashes, lxcCrashLoopWindow, reason)
+	emitEvent("Container is crash looping with %d crashes in %v, last: %s", crashes, lxcCrashLoopWindow, reason)
+	return true
+}
+
+// keepForRetry stops the named container that failed to start while crash
+// looping, keeping it and its storage for the task's next attempt to reuse
+// as it was created from the same config.
+func (d *LxcDriver) keepForRetry(name string) bool {
+	c, err := openLxcContainer(d.backend, name, findLxcPath(d.backend, d.config, name))
+	if err != nil {
+		return false
+	}
+	defer d.backend.Release(c)
+	if readLxcConfigHash(c) == "" {
+		return false
+	}
+	if c.Running() {
+		if err := c.Stop(); err != nil {
+			d.logger.Printf("[WARN] driver.lxc: unable to stop crash looping container %q: %v", name, err)
+			return false
+		}
+	}
+	d.logger.Printf("[INFO] driver.lxc: keeping crash looping container %q for retries", name)
+	d.emitEvent("Keeping the container for retries rather than recreating it")
+	return true
+}
+
+// recordExit records the container exiting on its own, which counts as a
+// crash if it had only just started. Containers recovered after the client
+// restarted have no start time and aren't tracked.
+func (h *lxcDriverHandle) recordExit() {
+	if h.started.IsZero() {
+		return
+	}
+	name := h.container.Name()
+	if time.Since(h.started) >= lxcCrashLoopMinUptime {
+		lxcCrashLoops.clear(name)
+		return
+	}
+	lxcCrashLooping(name, "exited right after starting", h.crashLoopThreshold, h.logger, h.emitEvent)
+}
@@ -0,0 +1,77 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad/client/config"
+
+	cstructs "github.com/hashicorp/nomad/client/structs"
+)
+
+// lxcDestroyParallelismDefault is how many containers are destroyed at once
+// when decommissioning a node, if not given.
+const lxcDestroyParallelismDefault = 8
+
+// DestroyLxcContainers stops and destroys all the containers created by the
+// LXC driver on the client, along with their storage, destroying parallelism
+// containers at once. It is meant for decommissioning a node faster than the
+// allocations would be torn down one at a time, so the driver must be in
+// maintenance: otherwise the tasks of the destroyed containers would be
+// restarted in new ones. Progress is logged as containers are destroyed, and
+// containers that failed to be destroyed are reported along with the
+// destroyed ones. Containers preserved after their task failed are destroyed
+// too.
+func DestroyLxcContainers(cfg *config.Config, parallelism int, logger *log.Logger) (*cstructs.LxcDestroyReport, error) {
+	m, err := LxcMaintenance(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !m.Paused {
+		return nil, fmt.Errorf("lxc driver must be in maintenance to destroy all containers, otherwise their tasks are restarted")
+	}
+	if parallelism <= 0 {
+		parallelism = lxcDestroyParallelismDefault
+	}
+
+	containers, err := LxcContainers(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	report := &cstructs.LxcDestroyReport{Failed: make(map[string]string)}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallelism)
+	for _, container := range containers {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			err := destroyNamedLxcContainer(cfg, name, true)
+			<-sem
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				report.Failed[name] = err.Error()
+				logger.Printf("[ERR] driver.lxc: failed to destroy container %q: %v", name, err)
+			} else {
+				report.Destroyed = append(report.Destroyed, name)
+			}
+			done := len(report.Destroyed) + len(report.Failed)
+			logger.Printf("[INFO] driver.lxc: destroyed %d/%d containers", done, len(containers))
+		}(container.Name)
+	}
+	wg.Wait()
+
+	sort.Strings(report.Destroyed)
+	report.Duration = time.Since(start)
+	return report, nil
+}
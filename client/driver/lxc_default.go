@@ -0,0 +1,75 @@
+//+build !linux !lxc
+
+package driver
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/hashicorp/nomad/client/config"
+	cstructs "github.com/hashicorp/nomad/client/structs"
+)
+
+// errLxcUnsupported is returned when the agent was built without LXC support
+var errLxcUnsupported = fmt.Errorf("lxc driver is not supported by this build of Nomad")
+
+// LxcContainers returns an error as the LXC driver is not built in.
+func LxcContainers(*config.Config) ([]*cstructs.LxcContainer, error) {
+	return nil, errLxcUnsupported
+}
+
+// LxcContainer returns an error as the LXC driver is not built in.
+func LxcContainer(*config.Config, string) (*cstructs.LxcContainer, error) {
+	return nil, errLxcUnsupported
+}
+
+// LxcTaskContainer returns an error as the LXC driver is not built in.
+func LxcTaskContainer(*config.Config, string, string) (*cstructs.LxcContainer, error) {
+	return nil, errLxcUnsupported
+}
+
+// LxcPruneInterval returns zero as the LXC driver is not built in.
+func LxcPruneInterval(*config.Config) time.Duration {
+	return 0
+}
+
+// DestroyLxcContainer returns an error as the LXC driver is not built in.
+func DestroyLxcContainer(*config.Config, string) error {
+	return errLxcUnsupported
+}
+
+// PruneLxcContainers returns an error as the LXC driver is not built in.
+func PruneLxcContainers(*config.Config, []*cstructs.LxcContainer, *log.Logger) ([]*cstructs.LxcContainer, error) {
+	return nil, errLxcUnsupported
+}
+
+// BackupLxcContainer returns an error as the LXC driver is not built in.
+func BackupLxcContainer(*config.Config, string, bool) (*cstructs.LxcBackup, error) {
+	return nil, errLxcUnsupported
+}
+
+// DestroyLxcContainers returns an error as the LXC driver is not built in.
+func DestroyLxcContainers(*config.Config, int, *log.Logger) (*cstructs.LxcDestroyReport, error) {
+	return nil, errLxcUnsupported
+}
+
+// LxcMaintenance returns an error as the LXC driver is not built in.
+func LxcMaintenance(*config.Config) (*cstructs.LxcMaintenance, error) {
+	return nil, errLxcUnsupported
+}
+
+// ReloadLxcOptions does nothing as the LXC driver is not built in.
+func ReloadLxcOptions(*config.Config, map[string]string, *log.Logger) []string {
+	return nil
+}
+
+// IsLxcReloadableOption returns false as the LXC driver is not built in.
+func IsLxcReloadableOption(string) bool {
+	return false
+}
+
+// SetLxcMaintenance returns an error as the LXC driver is not built in.
+func SetLxcMaintenance(*config.Config, bool, string) (*cstructs.LxcMaintenance, error) {
+	return nil, errLxcUnsupported
+}
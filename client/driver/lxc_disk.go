@@ -0,0 +1,60 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"syscall"
+
+	cstructs "github.com/hashicorp/nomad/client/structs"
+)
+
+// lxcVolumeTargets returns the mount points in the container of the task's
+// volumes, whose filesystems are reported along with the root filesystem.
+func lxcVolumeTargets(config *LxcDriverConfig) []string {
+	var targets []string
+	for _, m := range config.Mounts {
+		targets = append(targets, m.Target)
+	}
+	for _, v := range config.SharedVolumes {
+		targets = append(targets, v.Target)
+	}
+	return targets
+}
+
+// sampleDiskStats returns the usage of the container's root filesystem and
+// volumes. They are looked up through the root of its init process, so
+// they are found whatever the rootfs backend and however the volumes are
+// mounted. Filesystems mounted more than once, such as volumes on the same
+// host filesystem, are only counted once in the totals.
+func (h *lxcDriverHandle) sampleDiskStats() *cstructs.DiskStats {
+	root := fmt.Sprintf("/proc/%d/root", h.initPid)
+	ds := &cstructs.DiskStats{
+		Filesystems: make(map[string]*cstructs.FilesystemStats),
+		Measured:    LXCMeasuredDiskStats,
+	}
+	counted := make(map[syscall.Fsid]bool)
+	for _, target := range append([]string{"/"}, h.volumes...) {
+		target = path.Join("/", target)
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(filepath.Join(root, target), &st); err != nil {
+			continue
+		}
+		fs := &cstructs.FilesystemStats{
+			Used:      (st.Blocks - st.Bfree) * uint64(st.Bsize),
+			Available: st.Bavail * uint64(st.Bsize),
+		}
+		ds.Filesystems[target] = fs
+		if !counted[st.Fsid] {
+			counted[st.Fsid] = true
+			ds.Used += fs.Used
+			ds.Available += fs.Available
+		}
+	}
+	if len(ds.Filesystems) == 0 {
+		return nil
+	}
+	return ds
+}
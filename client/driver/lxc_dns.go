@@ -0,0 +1,221 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// lxcResolvConfFile is the resolv.conf generated in the task directory for
+// containers whose root filesystem can't be written to directly.
+const lxcResolvConfFile = "resolv.conf"
+
+// hostResolvConf is the host's resolv.conf, whose nameservers containers use
+// if the task only sets search domains.
+var hostResolvConf = "/etc/resolv.conf"
+
+// lxcResolvConf returns the resolv.conf of a container using the given
+// nameservers and search domains.
+func lxcResolvConf(servers, search []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by Nomad from the task's DNS settings\n")
+	for _, s := range servers {
+		fmt.Fprintf(&buf, "nameserver %s\n", s)
+	}
+	if len(search) != 0 {
+		fmt.Fprintf(&buf, "search %s\n", strings.Join(search, " "))
+	}
+	return buf.Bytes()
+}
+
+// lxcHostNameservers returns the nameservers of the host's resolv.conf.
+// Loopback nameservers, such as a local caching resolver, are only reachable
+// from containers sharing the host's network.
+func lxcHostNameservers(hostNetwork bool) ([]string, error) {
+	data, err := ioutil.ReadFile(hostResolvConf)
+	if err != nil {
+		return nil, err
+	}
+	var servers []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		ip := net.ParseIP(fields[1])
+		if ip == nil || (ip.IsLoopback() && !hostNetwork) {
+			continue
+		}
+		servers = append(servers, fields[1])
+	}
+	return servers, scanner.Err()
+}
+
+// setContainerDNS replaces the resolv.conf the container's image shipped
+// with one using the task's DNS settings, before the container is
+// provisioned and started. Directory backed root filesystems have their
+// /etc/resolv.conf replaced, including links to a local resolver such as
+// systemd-resolved's. Other root filesystems get a generated file bind
+// mounted over it, whose mount entry is returned.
+func (d *LxcDriver) setContainerDNS(c lxcContainerAPI, config *LxcDriverConfig, taskDir string) (string, error) {
+	if len(config.DNSServers) == 0 && len(config.DNSSearchDomains) == 0 {
+		return "", nil
+	}
+
+	servers := config.DNSServers
+	if len(servers) == 0 {
+		var err error
+		servers, err = lxcHostNameservers(config.sharesHostNetwork())
+		if err != nil {
+			return "", fmt.Errorf("unable to read the host's nameservers: %v", err)
+		}
+		if len(servers) == 0 {
+			d.emitEvent("Container has no nameservers as the host has none it can reach")
+		}
+	}
+	resolvConf := lxcResolvConf(servers, config.DNSSearchDomains)
+
+	rootfs, err := lxcRootfsDir(c)
+	if err != nil {
+		d.logger.Printf("[DEBUG] driver.lxc: mounting resolv.conf into container %q: %v", c.Name(), err)
+		path := filepath.Join(taskDir, lxcResolvConfFile)
+		if err := ioutil.WriteFile(path, resolvConf, 0644); err != nil {
+			return "", fmt.Errorf("unable to write resolv.conf: %v", err)
+		}
+		return fmt.Sprintf("%s etc/resolv.conf none ro,bind,create=file", path), nil
+	}
+	if err := setRootfsResolvConf(rootfs, resolvConf); err != nil {
+		return "", fmt.Errorf("unable to write resolv.conf: %v", err)
+	}
+	return "", nil
+}
+
+// setRootfsResolvConf replaces /etc/resolv.conf of the root filesystem,
+// which may be a link resolving outside of it.
+func setRootfsResolvConf(rootfs string, resolvConf []byte) error {
+	etc, err := rootfsPath(rootfs, "/etc")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(etc, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(etc, "resolv.conf")
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return ioutil.WriteFile(path, resolvConf, 0644)
+}
+
+const (
+	// lxcHostsFile is the hosts file generated in the task directory for
+	// containers whose root filesystem can't be written to directly.
+	lxcHostsFile = "hosts"
+
+	// lxcHostsBegin and lxcHostsEnd delimit the task's extra_hosts in the
+	// container's /etc/hosts, so they are replaced rather than repeated
+	// when a container is reused.
+	lxcHostsBegin = "# BEGIN Nomad extra_hosts"
+	lxcHostsEnd   = "# END Nomad extra_hosts"
+
+	// lxcDefaultHosts are the entries of generated hosts files besides the
+	// task's extra_hosts.
+	lxcDefaultHosts = "127.0.0.1\tlocalhost\n::1\tlocalhost ip6-localhost ip6-loopback\n"
+)
+
+// parseLxcExtraHost splits an entry of extra_hosts, given as host:ip as for
+// the docker driver, into the host name and address. IPv6 addresses contain
+// colons themselves, so the entry is split at the first.
+func parseLxcExtraHost(entry string) (string, string, error) {
+	parts := strings.SplitN(entry, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || strings.ContainsAny(parts[0], " \t\n#") {
+		return "", "", fmt.Errorf("extra_hosts: invalid entry %q, expected host:ip", entry)
+	}
+	return parts[0], parts[1], nil
+}
+
+// lxcExtraHosts returns the delimited block of hosts entries of the task's
+// extra_hosts.
+func lxcExtraHosts(extraHosts []string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(lxcHostsBegin + "\n")
+	for _, entry := range extraHosts {
+		// the format was checked in Validate()
+		host, ip, _ := parseLxcExtraHost(entry)
+		fmt.Fprintf(&buf, "%s\t%s\n", ip, host)
+	}
+	buf.WriteString(lxcHostsEnd + "\n")
+	return buf.Bytes()
+}
+
+// setContainerHosts adds the task's extra_hosts to the container's
+// /etc/hosts before it is provisioned and started. The entries of directory
+// backed root filesystems are kept. Other root filesystems get a generated
+// file bind mounted over it, whose mount entry is returned.
+func (d *LxcDriver) setContainerHosts(c lxcContainerAPI, config *LxcDriverConfig, taskDir string) (string, error) {
+	if len(config.ExtraHosts) == 0 {
+		return "", nil
+	}
+	extraHosts := lxcExtraHosts(config.ExtraHosts)
+
+	rootfs, err := lxcRootfsDir(c)
+	if err != nil {
+		d.logger.Printf("[DEBUG] driver.lxc: mounting hosts file into container %q: %v", c.Name(), err)
+		path := filepath.Join(taskDir, lxcHostsFile)
+		if err := ioutil.WriteFile(path, append([]byte(lxcDefaultHosts), extraHosts...), 0644); err != nil {
+			return "", fmt.Errorf("unable to write hosts file: %v", err)
+		}
+		return fmt.Sprintf("%s etc/hosts none ro,bind,create=file", path), nil
+	}
+	if err := setRootfsHosts(rootfs, extraHosts); err != nil {
+		return "", fmt.Errorf("unable to set extra hosts: %v", err)
+	}
+	return "", nil
+}
+
+// setRootfsHosts replaces the extra hosts block of /etc/hosts of the root
+// filesystem, keeping its other entries.
+func setRootfsHosts(rootfs string, extraHosts []byte) error {
+	path, err := rootfsPath(rootfs, "/etc/hosts")
+	if err != nil {
+		return err
+	}
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		raw = []byte(lxcDefaultHosts)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	var inBlock bool
+	for _, line := range strings.SplitAfter(string(raw), "\n") {
+		switch strings.TrimSpace(line) {
+		case lxcHostsBegin:
+			inBlock = true
+			continue
+		case lxcHostsEnd:
+			inBlock = false
+			continue
+		}
+		if !inBlock {
+			buf.WriteString(line)
+		}
+	}
+	if buf.Len() != 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	buf.Write(extraHosts)
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
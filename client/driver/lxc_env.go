@@ -0,0 +1,71 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/nomad/client/driver/env"
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+// lxcDefaultEnvConfigPrefix is the prefix of the client options setting the
+// default environment of every container, e.g. "driver.lxc.env.LANG".
+const lxcDefaultEnvConfigPrefix = "driver.lxc.env."
+
+// defaultEnv returns the default container environment from the client
+// options.
+func (d *LxcDriver) defaultEnv() map[string]string {
+	defaults := make(map[string]string)
+	for key, value := range d.config.Options {
+		if name := strings.TrimPrefix(key, lxcDefaultEnvConfigPrefix); name != key && name != "" {
+			defaults[name] = value
+		}
+	}
+	return defaults
+}
+
+// containerEnv returns the environment of the container's init process as
+// sorted KEY=value pairs. The client's default environment has the lowest
+// precedence and is overridden by the task's env stanza, then by the
+// timezone.
+func containerEnv(defaults map[string]string, task *structs.Task, taskEnv *env.TaskEnv, timezone string) []string {
+	merged := make(map[string]string, len(defaults)+len(task.Env))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range task.Env {
+		// Use the interpolated value of the task environment
+		if taskEnv != nil {
+			if interpolated, ok := taskEnv.EnvMap[k]; ok {
+				v = interpolated
+			}
+		}
+		merged[k] = v
+	}
+	if timezone != "" {
+		merged["TZ"] = timezone
+	}
+
+	vars := make([]string, 0, len(merged))
+	for k, v := range merged {
+		vars = append(vars, k+"="+v)
+	}
+	sort.Strings(vars)
+	return vars
+}
+
+// setContainerEnv sets the environment of the container's init process.
+func setContainerEnv(c lxcContainerAPI, vars []string) error {
+	for _, v := range vars {
+		if strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("environment variable %q must not contain a newline", strings.SplitN(v, "=", 2)[0])
+		}
+		if err := c.SetConfigItem("lxc.environment", v); err != nil {
+			return fmt.Errorf("error setting environment variable: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+const (
+	// lxcFailureWindowConfigOption is the key for the window over which
+	// container start failures on the node are aggregated into a single
+	// summary, which is reported at most once per window.
+	lxcFailureWindowConfigOption = "driver.lxc.failure_summary_window"
+	lxcFailureWindowDefault      = 5 * time.Minute
+
+	// lxcFailureReasonMaxLen bounds the length of the failure reasons
+	// summarized, which are error messages
+	lxcFailureReasonMaxLen = 120
+)
+
+// lxcFailures aggregates the container start failures of the node, so that
+// operators get one actionable summary rather than having to correlate the
+// failures of hundreds of allocations.
+var lxcFailures = &lxcFailureSummary{}
+
+// lxcFailureSummary counts container start failures by reason. The first
+// failure of a window schedules the summary of the window, so summaries
+// are rate limited to one per window however many containers fail.
+type lxcFailureSummary struct {
+	window  time.Duration
+	logger  *log.Logger
+	reasons map[string]int
+	timer   *time.Timer
+	lock    sync.Mutex
+}
+
+// recordStartFailure records that the named container failed to start.
+func (d *LxcDriver) recordStartFailure(name string, err error) {
+	metrics.IncrCounter([]string{"client", "lxc", "start_failures"}, 1)
+	window := d.config.ReadDurationDefault(lxcFailureWindowConfigOption, lxcFailureWindowDefault)
+	lxcFailures.record(d.logger, window, lxcFailureReason(name, err))
+}
+
+// lxcFailureReason returns the reason of a failure with the container's
+// name removed, so that the same failure of different containers is
+// counted together.
+func lxcFailureReason(name string, err error) string {
+	reason := strings.Replace(err.Error(), name, "<container>", -1)
+	if len(reason) > lxcFailureReasonMaxLen {
+		reason = reason[:lxcFailureReasonMaxLen] + "..."
+	}
+	return reason
+}
+
+func (s *lxcFailureSummary) record(logger *log.Logger, window time.Duration, reason string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.reasons == nil {
+		s.reasons = make(map[string]int)
+	}
+	s.reasons[reason]++
+	if s.timer == nil {
+		s.window = window
+		s.logger = logger
+		s.timer = time.AfterFunc(window, s.flush)
+	}
+}
+
+// flush reports the summary of the current window and starts a new one.
+func (s *lxcFailureSummary) flush() {
+	s.lock.Lock()
+	summary := s.summary()
+	logger := s.logger
+	s.reasons = nil
+	s.timer = nil
+	s.lock.Unlock()
+
+	if summary != "" && logger != nil {
+		logger.Printf("[WARN] driver.lxc: %s", summary)
+	}
+}
+
+// summary describes the failures of the current window, most common reasons
+// first, or returns "" if there were none.
+func (s *lxcFailureSummary) summary() string {
+	if len(s.reasons) == 0 {
+		return ""
+	}
+
+	total := 0
+	reasons := make([]string, 0, len(s.reasons))
+	for reason, count := range s.reasons {
+		total += count
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if s.reasons[reasons[i]] != s.reasons[reasons[j]] {
+			return s.reasons[reasons[i]] > s.reasons[reasons[j]]
+		}
+		return reasons[i] < reasons[j]
+	})
+
+	parts := make([]string, len(reasons))
+	for i, reason := range reasons {
+		parts[i] = fmt.Sprintf("%d with %q", s.reasons[reason], reason)
+	}
+	return fmt.Sprintf("%d container(s) failed to start in the last %v: %s", total, s.window, strings.Join(parts, ", "))
+}
@@ -0,0 +1,162 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	dstructs "github.com/hashicorp/nomad/client/driver/structs"
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+// lxcAttachPath is the PATH of the commands run in the container, which
+// don't inherit the client's environment.
+const lxcAttachPath = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// Exec runs the command in the container's namespaces with the container's
+// environment, as for script checks. The combined output is truncated to
+// the last dstructs.CheckBufSize bytes.
+func (h *lxcDriverHandle) Exec(ctx context.Context, cmd string, args []string) ([]byte, int, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		// No deadline set on context; default to 1 minute
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+	}
+
+	out, code, err := h.attach(ctx, append([]string{cmd}, args...), h.env)
+	if len(out) > dstructs.CheckBufSize {
+		out = out[len(out)-dstructs.CheckBufSize:]
+	}
+	return out, code, err
+}
+
+// attach runs the command in the container with lxc-attach semantics and
+// returns its combined output and exit code. The environment is cleared
+// apart from the PATH and the given variables. If the context is done first
+// an error is returned and the command's output is discarded. Attached
+// commands can't be interrupted from the host, so if the context has a
+// deadline the command is run with the container's timeout command, which
+// kills it once the deadline passes rather than leaving it running. Images
+// whose timeout command doesn't take its arguments run the command as is.
+func (h *lxcDriverHandle) attach(ctx context.Context, args []string, env []string) ([]byte, int, error) {
+	name := args[0]
+	if deadline, ok := ctx.Deadline(); ok && h.attachTimeoutSupported(ctx) {
+		args = append(lxcAttachTimeout(time.Until(deadline)), args...)
+	}
+	out, code, err := h.runAttached(ctx, args, env)
+	if err != nil && ctx.Err() != nil {
+		return nil, 0, fmt.Errorf("running %q in container: %v", name, ctx.Err())
+	}
+	return out, code, err
+}
+
+// attachTimeoutSupported returns whether the container has a timeout
+// command taking the arguments of lxcAttachTimeout, probing it the first
+// time. Images without one, or with an old busybox timeout only taking -t,
+// would otherwise fail every command.
+func (h *lxcDriverHandle) attachTimeoutSupported(ctx context.Context) bool {
+	h.attachTimeoutLock.Lock()
+	defer h.attachTimeoutLock.Unlock()
+	if h.attachTimeout != nil {
+		return *h.attachTimeout
+	}
+
+	_, code, err := h.runAttached(ctx, append(lxcAttachTimeout(time.Second), "true"), nil)
+	if err != nil {
+		// The container may not be running, so it is probed again
+		return false
+	}
+	supported := code == 0
+	if !supported {
+		h.logger.Printf("[WARN] driver.lxc: container %q has no usable timeout command, commands run in it can't be killed at their deadline", h.container.Name())
+	}
+	h.attachTimeout = &supported
+	return supported
+}
+
+// runAttached runs the command in the container for attach, returning an
+// error if the context is done first.
+func (h *lxcDriverHandle) runAttached(ctx context.Context, args []string, env []string) ([]byte, int, error) {
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return nil, 0, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		devNull.Close()
+		return nil, 0, err
+	}
+
+	// Read concurrently so a long output can't fill the pipe and block
+	// the command
+	outCh := make(chan []byte, 1)
+	go func() {
+		out, _ := ioutil.ReadAll(r)
+		r.Close()
+		outCh <- out
+	}()
+
+	opts := lxc.DefaultAttachOptions
+	opts.ClearEnv = true
+	opts.Env = append([]string{lxcAttachPath}, env...)
+	opts.StdinFd = devNull.Fd()
+	opts.StdoutFd = w.Fd()
+	opts.StderrFd = w.Fd()
+
+	type result struct {
+		status int
+		err    error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		status, err := h.container.RunCommandStatus(args, opts)
+		w.Close()
+		devNull.Close()
+		resultCh <- result{status, err}
+	}()
+
+	select {
+	case res := <-resultCh:
+		out := <-outCh
+		if res.err != nil {
+			return out, 0, res.err
+		}
+		code, err := lxcAttachExitCode(res.status)
+		return out, code, err
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+}
+
+// lxcAttachTimeout returns the command prefix killing an attached command
+// once the timeout passes. The timeout is rounded up to whole seconds, which
+// all timeout commands support, so that the context is done first.
+func lxcAttachTimeout(timeout time.Duration) []string {
+	secs := int(math.Ceil(timeout.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	return []string{"timeout", "-s", "KILL", strconv.Itoa(secs)}
+}
+
+// lxcAttachExitCode returns the exit code of an attached command from the
+// wait status returned by liblxc. Commands killed by a signal exit with 128
+// plus the signal number, as in a shell.
+func lxcAttachExitCode(status int) (int, error) {
+	if status < 0 {
+		return 0, fmt.Errorf("unable to attach to container")
+	}
+	ws := syscall.WaitStatus(status)
+	if ws.Signaled() {
+		return 128 + int(ws.Signal()), nil
+	}
+	return ws.ExitStatus(), nil
+}
@@ -0,0 +1,103 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	dstructs "github.com/hashicorp/nomad/client/driver/structs"
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+const (
+	// lxcExitStatusTimeout is how long to wait for LXC to reap the init
+	// process of a container that exited and log its exit status
+	lxcExitStatusTimeout = 5 * time.Second
+
+	// lxcExitStatusPollIntv is how often the container's state is checked
+	// while waiting for it to be stopped
+	lxcExitStatusPollIntv = 100 * time.Millisecond
+)
+
+// lxcExitStatusRe matches the line LXC logs at the info level when a
+// container's init process exits with a non-zero status or is killed by a
+// signal. Successful exits aren't logged.
+var lxcExitStatusRe = regexp.MustCompile(`Child <(\d+)> ended on (error|signal) \((\d+)\)`)
+
+// exited returns the result of the container's init process exiting. The
+// container exiting once the driver stops it isn't a failure.
+func (h *lxcDriverHandle) exited() *dstructs.WaitResult {
+	if atomic.LoadInt32(&h.stopping) != 0 {
+		return &dstructs.WaitResult{}
+	}
+	h.recordExit()
+	res := h.exitResult()
+	h.preserveIfFailed(res)
+	return res
+}
+
+// exitResult returns the result of the container's init process exiting on
+// its own. The init process is reaped by LXC's monitor process, which logs
+// its exit status before marking the container stopped. If the exit status
+// can't be determined the result is a failure, so that the task is restarted
+// rather than reported successful.
+func (h *lxcDriverHandle) exitResult() *dstructs.WaitResult {
+	name := h.container.Name()
+	deadline := time.Now().Add(lxcExitStatusTimeout)
+	for h.container.State() != lxc.STOPPED {
+		if time.Now().After(deadline) {
+			h.logger.Printf("[WARN] driver.lxc: container %q wasn't stopped after its init exited, exit status unknown", name)
+			return dstructs.NewWaitResult(0, 0, fmt.Errorf("exit status unknown: container wasn't stopped after its init exited"))
+		}
+		time.Sleep(lxcExitStatusPollIntv)
+	}
+
+	code, signal, err := lxcExitStatus(h.logFile, h.initPid)
+	if err != nil {
+		h.logger.Printf("[WARN] driver.lxc: unable to determine exit status of container %q: %v", name, err)
+		return dstructs.NewWaitResult(0, 0, fmt.Errorf("exit status unknown: %v", err))
+	}
+	if signal == int(syscall.SIGINT) {
+		// The kernel sends SIGINT to the init of a PID namespace that
+		// called reboot(2) to power off, as on a clean shutdown from
+		// within the container
+		return &dstructs.WaitResult{}
+	}
+	return dstructs.NewWaitResult(code, signal, nil)
+}
+
+// lxcExitStatus returns the exit code of the init process from the
+// container's log, and the signal that killed it if any. Init processes
+// killed by a signal exit with 128 plus the signal number, as LXC reports.
+func lxcExitStatus(logFile string, initPid int) (int, int, error) {
+	data, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The log is kept across restarts of the container, so the last status
+	// of the init process is used
+	pid := strconv.Itoa(initPid)
+	matches := lxcExitStatusRe.FindAllStringSubmatch(string(data), -1)
+	for i := len(matches) - 1; i >= 0; i-- {
+		m := matches[i]
+		if m[1] != pid {
+			continue
+		}
+		n, err := strconv.Atoi(m[3])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid exit status %q", m[3])
+		}
+		if m[2] == "signal" {
+			return 128 + n, n, nil
+		}
+		return n, 0, nil
+	}
+	return 0, 0, nil
+}
@@ -0,0 +1,504 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+// fakeLxcBackend is an in-memory lxcBackend. Containers keep their state
+// between opens like containers on disk would, and commands are recorded
+// rather than run.
+type fakeLxcBackend struct {
+	containers map[string]*fakeLxcContainer
+	commands   [][]string
+	inputs     [][]byte
+	lock       sync.Mutex
+
+	// createErr and startErr are returned by Create and the containers'
+	// Start
+	createErr error
+	startErr  error
+
+	// createDelay delays creating containers, like a template stuck
+	// downloading its image, and createIgnoresCtx lets the template finish
+	// after its context is done, like one exiting as it is killed
+	createDelay      time.Duration
+	createIgnoresCtx bool
+
+	// run, if set, is called for the commands run through the backend
+	run func(name string, args []string) ([]byte, error)
+
+	// paths are the executables found by LookPath
+	paths map[string]string
+
+	// attach, if set, is called for the commands run in containers and
+	// returns their output and wait status
+	attach func(args []string, env []string) (string, int)
+}
+
+func newFakeLxcBackend() *fakeLxcBackend {
+	return &fakeLxcBackend{containers: make(map[string]*fakeLxcContainer)}
+}
+
+func (b *fakeLxcBackend) Version() string         { return "2.0.8" }
+func (b *fakeLxcBackend) DefaultLxcPath() string  { return "/var/lib/lxc" }
+func (b *fakeLxcBackend) Release(lxcContainerAPI) {}
+
+func (b *fakeLxcBackend) NewContainer(name, lxcPath string) (lxcContainerAPI, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	key := filepath.Join(lxcPath, name)
+	c, ok := b.containers[key]
+	if !ok {
+		c = &fakeLxcContainer{
+			backend: b,
+			name:    name,
+			path:    lxcPath,
+			state:   lxc.STOPPED,
+			initPid: -1,
+			config:  make(map[string][]string),
+			cgroup:  make(map[string][]string),
+		}
+		b.containers[key] = c
+	}
+	return c, nil
+}
+
+func (b *fakeLxcBackend) DefinedContainerNames(lxcPath string) []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var names []string
+	for _, c := range b.containers {
+		if c.path == lxcPath && c.Defined() {
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
+
+func (b *fakeLxcBackend) Create(ctx context.Context, c lxcContainerAPI, options lxc.TemplateOptions, env []string) ([]byte, error) {
+	if err := b.createErr; err != nil {
+		return nil, err
+	}
+	// Like LXC, the container is defined before its template runs
+	fc := c.(*fakeLxcContainer)
+	if err := fc.define(); err != nil {
+		return nil, err
+	}
+	if b.createIgnoresCtx {
+		ctx = context.Background()
+	}
+	select {
+	case <-time.After(b.createDelay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return nil, fc.create(options, env)
+}
+
+// container returns the container as last opened, or nil.
+func (b *fakeLxcBackend) container(name, lxcPath string) *fakeLxcContainer {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.containers[filepath.Join(lxcPath, name)]
+}
+
+func (b *fakeLxcBackend) LookPath(file string) (string, error) {
+	if path, ok := b.paths[file]; ok {
+		return path, nil
+	}
+	return "", fmt.Errorf("%s not found", file)
+}
+
+func (b *fakeLxcBackend) Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return b.CombinedOutput(ctx, name, args...)
+}
+
+func (b *fakeLxcBackend) CombinedOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	b.lock.Lock()
+	b.commands = append(b.commands, append([]string{name}, args...))
+	run := b.run
+	b.lock.Unlock()
+	if run == nil {
+		return nil, nil
+	}
+
+	// Like commands killed by exec.CommandContext, commands outliving their
+	// context fail
+	type result struct {
+		out []byte
+		err error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		out, err := run(name, args)
+		resultCh <- result{out, err}
+	}()
+	select {
+	case res := <-resultCh:
+		return res.out, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (b *fakeLxcBackend) CombinedOutputWithInput(ctx context.Context, input []byte, name string, args ...string) ([]byte, error) {
+	b.lock.Lock()
+	b.inputs = append(b.inputs, input)
+	b.lock.Unlock()
+	return b.CombinedOutput(ctx, name, args...)
+}
+
+// fakeLxcContainer is a container of the fakeLxcBackend. Its root filesystem
+// is a directory under the LXC path, and it runs as the test process.
+type fakeLxcContainer struct {
+	backend *fakeLxcBackend
+	name    string
+	path    string
+
+	defined bool
+	state   lxc.State
+	initPid int
+	config  map[string][]string
+	cgroup  map[string][]string
+	lock    sync.Mutex
+
+	// ipv4 and ipv6 are the addresses of the container's interfaces while
+	// it is running
+	ipv4 map[string][]string
+	ipv6 map[string][]string
+
+	// logLevel is the level liblxc logs at
+	logLevel lxc.LogLevel
+
+	// options are the template options the container was created with, and
+	// environ the environment its template would have run with
+	options lxc.TemplateOptions
+	environ []string
+}
+
+func (c *fakeLxcContainer) Name() string { return c.name }
+
+func (c *fakeLxcContainer) Defined() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.defined
+}
+
+func (c *fakeLxcContainer) Running() bool {
+	return c.State() == lxc.RUNNING
+}
+
+func (c *fakeLxcContainer) State() lxc.State {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.state
+}
+
+func (c *fakeLxcContainer) InitPid() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.initPid
+}
+
+func (c *fakeLxcContainer) IPv4Address(interfaceName string) ([]string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.state != lxc.RUNNING || len(c.ipv4[interfaceName]) == 0 {
+		return nil, lxc.ErrIPv4Addresses
+	}
+	return c.ipv4[interfaceName], nil
+}
+
+func (c *fakeLxcContainer) IPv6Address(interfaceName string) ([]string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.state != lxc.RUNNING || len(c.ipv6[interfaceName]) == 0 {
+		return nil, lxc.ErrIPv6Addresses
+	}
+	return c.ipv6[interfaceName], nil
+}
+
+func (c *fakeLxcContainer) Wait(state lxc.State, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for c.State() != state {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// define defines the container with its config, as LXC does before running
+// its template.
+func (c *fakeLxcContainer) define() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.defined {
+		return fmt.Errorf("container %q already exists", c.name)
+	}
+	if err := os.MkdirAll(filepath.Join(c.path, c.name), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(c.ConfigFileName(), []byte("lxc.uts.name = "+c.name+"\n"), 0644); err != nil {
+		return err
+	}
+	c.config["lxc.uts.name"] = []string{c.name}
+	c.defined = true
+	return nil
+}
+
+// create completes the defined container as if its template ran with the
+// options and environment.
+func (c *fakeLxcContainer) create(options lxc.TemplateOptions, environ []string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	rootfs := filepath.Join(c.path, c.name, "rootfs")
+	if err := os.MkdirAll(rootfs, 0755); err != nil {
+		return err
+	}
+	// Like liblxc, the root filesystem is prefixed by its backend, and ZFS
+	// backed ones are named by their dataset
+	switch options.Backend {
+	case lxc.Btrfs:
+		c.config["lxc.rootfs.path"] = []string{"btrfs:" + rootfs}
+	case lxc.ZFS:
+		c.config["lxc.rootfs.path"] = []string{"zfs:lxc/" + c.name}
+	case lxc.LVM:
+		c.config["lxc.rootfs.path"] = []string{"lvm:/dev/lxc/" + c.name}
+	case lxc.Overlayfs:
+		c.config["lxc.rootfs.path"] = []string{"overlay:" + rootfs + ":" + filepath.Join(c.path, c.name, "delta0")}
+	default:
+		c.config["lxc.rootfs.path"] = []string{"dir:" + rootfs}
+	}
+	c.options = options
+	c.environ = environ
+	return nil
+}
+
+func (c *fakeLxcContainer) Start() error {
+	if err := c.backend.startErr; err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.defined {
+		return fmt.Errorf("container %q is not defined", c.name)
+	}
+	c.state = lxc.RUNNING
+	c.initPid = os.Getpid()
+	return nil
+}
+
+func (c *fakeLxcContainer) Stop() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.state != lxc.RUNNING {
+		return fmt.Errorf("container %q is not running", c.name)
+	}
+	c.state = lxc.STOPPED
+	c.initPid = -1
+	return nil
+}
+
+func (c *fakeLxcContainer) Shutdown(time.Duration) error {
+	return c.Stop()
+}
+
+func (c *fakeLxcContainer) Destroy() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.defined {
+		return fmt.Errorf("container %q is not defined", c.name)
+	}
+	if c.state == lxc.RUNNING {
+		return fmt.Errorf("container %q is running", c.name)
+	}
+	c.defined = false
+	c.config = make(map[string][]string)
+	return os.RemoveAll(filepath.Join(c.path, c.name))
+}
+
+// Rename moves the container to the new name. Like liblxc, the renamed
+// container is opened anew, and this one is left undefined.
+func (c *fakeLxcContainer) Rename(name string) error {
+	b := c.backend
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.defined {
+		return fmt.Errorf("container %q is not defined", c.name)
+	}
+	if c.state != lxc.STOPPED {
+		return fmt.Errorf("container %q is not stopped", c.name)
+	}
+	key := filepath.Join(c.path, name)
+	if other, ok := b.containers[key]; ok && other.Defined() {
+		return fmt.Errorf("container %q already exists", name)
+	}
+
+	oldDir, newDir := filepath.Join(c.path, c.name), filepath.Join(c.path, name)
+	if err := os.Rename(oldDir, newDir); err != nil {
+		return err
+	}
+	config := c.config
+	if v := config["lxc.rootfs.path"]; len(v) != 0 {
+		config["lxc.rootfs.path"] = []string{strings.Replace(v[0], oldDir, newDir, 1)}
+	}
+	config["lxc.uts.name"] = []string{name}
+	b.containers[key] = &fakeLxcContainer{
+		backend: b,
+		name:    name,
+		path:    c.path,
+		defined: true,
+		state:   lxc.STOPPED,
+		initPid: -1,
+		config:  config,
+		cgroup:  make(map[string][]string),
+		options: c.options,
+		environ: c.environ,
+	}
+	c.defined = false
+	c.config = make(map[string][]string)
+	return nil
+}
+
+func (c *fakeLxcContainer) Freeze() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.state != lxc.RUNNING {
+		return fmt.Errorf("container %q is not running", c.name)
+	}
+	c.state = lxc.FROZEN
+	return nil
+}
+
+func (c *fakeLxcContainer) Unfreeze() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.state != lxc.FROZEN {
+		return fmt.Errorf("container %q is not frozen", c.name)
+	}
+	c.state = lxc.RUNNING
+	return nil
+}
+
+func (c *fakeLxcContainer) Checkpoint(opts lxc.CheckpointOptions) error {
+	if c.State() != lxc.FROZEN && !c.Running() {
+		return fmt.Errorf("container %q is not running", c.name)
+	}
+	return os.MkdirAll(opts.Directory, 0700)
+}
+
+func (c *fakeLxcContainer) SetVerbosity(lxc.Verbosity)     {}
+func (c *fakeLxcContainer) SetLogLevel(level lxc.LogLevel) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.logLevel = level
+	return nil
+}
+func (c *fakeLxcContainer) SetLogFile(string) error        { return nil }
+
+func (c *fakeLxcContainer) ConfigPath() string { return c.path }
+
+func (c *fakeLxcContainer) ConfigFileName() string {
+	return filepath.Join(c.path, c.name, "config")
+}
+
+func (c *fakeLxcContainer) ConfigItem(key string) []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.config[key]
+}
+
+func (c *fakeLxcContainer) SetConfigItem(key, value string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// Like liblxc, keys that may be repeated accumulate their values
+	if key == "lxc.mount.entry" || key == "lxc.environment" {
+		c.config[key] = append(c.config[key], value)
+	} else {
+		c.config[key] = []string{value}
+	}
+	return nil
+}
+
+func (c *fakeLxcContainer) CgroupItem(key string) []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.cgroup[key]
+}
+
+func (c *fakeLxcContainer) SetCgroupItem(key, value string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.state != lxc.RUNNING {
+		return fmt.Errorf("container %q is not running", c.name)
+	}
+	c.cgroup[key] = []string{value}
+	return nil
+}
+
+func (c *fakeLxcContainer) SetMemoryLimit(limit lxc.ByteSize) error {
+	return c.SetCgroupItem("memory.limit_in_bytes", fmt.Sprintf("%d", int64(limit)))
+}
+
+func (c *fakeLxcContainer) SetMemorySwapLimit(limit lxc.ByteSize) error {
+	return c.SetCgroupItem("memory.memsw.limit_in_bytes", fmt.Sprintf("%d", int64(limit)))
+}
+
+func (c *fakeLxcContainer) CPUTime() (time.Duration, error) {
+	return time.Second, nil
+}
+
+func (c *fakeLxcContainer) CPUStats() (map[string]int64, error) {
+	return map[string]int64{"user": 1, "system": 1}, nil
+}
+
+func (c *fakeLxcContainer) RunCommandStatus(args []string, options lxc.AttachOptions) (int, error) {
+	c.backend.lock.Lock()
+	c.backend.commands = append(c.backend.commands, append([]string{"lxc-attach", "-n", c.name, "--"}, args...))
+	attach := c.backend.attach
+	c.backend.lock.Unlock()
+	if attach == nil {
+		return 0, nil
+	}
+
+	// Write to the descriptor without taking ownership of it
+	out, status := attach(args, options.Env)
+	if _, err := syscall.Write(int(options.StdoutFd), []byte(out)); err != nil {
+		return -1, err
+	}
+	return status, nil
+}
+
+// hasConfig returns whether the container has a config item containing the
+// value.
+func (c *fakeLxcContainer) hasConfig(key, value string) bool {
+	for _, v := range c.ConfigItem(key) {
+		if strings.Contains(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// createFakeLxcContainer creates the container with the backend, as if from
+// the default template.
+func createFakeLxcContainer(backend *fakeLxcBackend, c lxcContainerAPI) error {
+	_, err := backend.Create(context.Background(), c, lxc.TemplateOptions{}, nil)
+	return err
+}
@@ -0,0 +1,169 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+const (
+	// lxcFileStatsIntv is how often the open files of the containers are
+	// counted, which walks the file descriptors of all their processes and
+	// is costlier than reading their cgroups
+	lxcFileStatsIntv = 10 * time.Second
+
+	// procInotifyLink is what the file descriptors of inotify instances
+	// link to
+	procInotifyLink = "anon_inode:inotify"
+)
+
+// inotifyWatchPrefix starts each watch of an inotify instance in the
+// file descriptor's fdinfo.
+var inotifyWatchPrefix = []byte("inotify wd:")
+
+// lxcFileUsage is the file descriptor and inotify usage of a container,
+// summed over its processes. Inotify watches are limited per user rather
+// than per container, so a single container can exhaust them for every
+// task on the node.
+type lxcFileUsage struct {
+	OpenFiles        int
+	InotifyInstances int
+	InotifyWatches   int
+}
+
+// usageAlerts returns the usage alert thresholds of the task config, or nil
+// if it sets none.
+func (c *LxcDriverConfig) usageAlerts() *LxcUsageAlertsConfig {
+	if len(c.UsageAlerts) == 0 {
+		return nil
+	}
+	return &c.UsageAlerts[0]
+}
+
+// publishMetrics returns whether the usage of containers is published as
+// metrics labeled with their allocation, like the allocation metrics of
+// the client.
+func (d *LxcDriver) publishMetrics() bool {
+	return d.config.PublishAllocationMetrics && !d.config.DisableTaggedMetrics
+}
+
+// lxcPidNamespaces returns the processes of the node grouped by PID
+// namespace, so the processes of every container are found with a single
+// walk of /proc.
+func lxcPidNamespaces() (map[string][]int, error) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	namespaces := make(map[string][]int)
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		ns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/pid", pid))
+		if err != nil {
+			// The process exited
+			continue
+		}
+		namespaces[ns] = append(namespaces[ns], pid)
+	}
+	return namespaces, nil
+}
+
+// lxcProcessFileUsage adds the open files and inotify usage of the process
+// to the usage.
+func lxcProcessFileUsage(pid int, usage *lxcFileUsage) {
+	fdDir := fmt.Sprintf("/proc/%d/fd", pid)
+	fds, err := ioutil.ReadDir(fdDir)
+	if err != nil {
+		return
+	}
+	usage.OpenFiles += len(fds)
+
+	for _, fd := range fds {
+		if link, err := os.Readlink(filepath.Join(fdDir, fd.Name())); err != nil || link != procInotifyLink {
+			continue
+		}
+		usage.InotifyInstances++
+
+		info, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/fdinfo/%s", pid, fd.Name()))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(info))
+		for scanner.Scan() {
+			if bytes.HasPrefix(scanner.Bytes(), inotifyWatchPrefix) {
+				usage.InotifyWatches++
+			}
+		}
+	}
+}
+
+// sampleFileUsage counts the open files and inotify usage of the
+// container's processes, which are those in the PID namespace of its init
+// process.
+func (h *lxcDriverHandle) sampleFileUsage(namespaces map[string][]int) (*lxcFileUsage, error) {
+	ns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/pid", h.initPid))
+	if err != nil {
+		return nil, err
+	}
+	usage := &lxcFileUsage{}
+	for _, pid := range namespaces[ns] {
+		lxcProcessFileUsage(pid, usage)
+	}
+	return usage, nil
+}
+
+// recordFileUsage records the file usage of the container, publishing it as
+// metrics if enabled and reporting when it crosses the task config's alert
+// thresholds.
+func (h *lxcDriverHandle) recordFileUsage(usage *lxcFileUsage) {
+	if h.publishMetrics {
+		name := h.container.Name()
+		task, allocID, _ := parseLxcContainerName(name)
+		labels := []metrics.Label{
+			{Name: "alloc_id", Value: allocID},
+			{Name: "task", Value: task},
+			{Name: "container", Value: name},
+		}
+		metrics.SetGaugeWithLabels([]string{"client", "lxc", "open_files"}, float32(usage.OpenFiles), labels)
+		metrics.SetGaugeWithLabels([]string{"client", "lxc", "inotify_instances"}, float32(usage.InotifyInstances), labels)
+		metrics.SetGaugeWithLabels([]string{"client", "lxc", "inotify_watches"}, float32(usage.InotifyWatches), labels)
+	}
+
+	if h.usageAlerts == nil {
+		return
+	}
+	h.openFilesAlert = h.checkUsageAlert("open files", usage.OpenFiles, h.usageAlerts.OpenFiles, h.openFilesAlert)
+	h.inotifyWatchesAlert = h.checkUsageAlert("inotify watches", usage.InotifyWatches, h.usageAlerts.InotifyWatches, h.inotifyWatchesAlert)
+}
+
+// checkUsageAlert reports the usage crossing the alert threshold, in either
+// direction, and returns whether it is above it. A zero threshold disables
+// the alert.
+func (h *lxcDriverHandle) checkUsageAlert(what string, value, threshold int, alerting bool) bool {
+	if threshold <= 0 {
+		return false
+	}
+	name := h.container.Name()
+	above := value > threshold
+	switch {
+	case above && !alerting:
+		h.logger.Printf("[WARN] driver.lxc: container %q has %d %s, above the alert threshold of %d", name, value, what, threshold)
+		h.emitEvent("Container has %d %s, above the alert threshold of %d", value, what, threshold)
+	case !above && alerting:
+		h.logger.Printf("[INFO] driver.lxc: container %q is back under the alert threshold of %d %s", name, threshold, what)
+		h.emitEvent("Container is back under the alert threshold of %d %s", threshold, what)
+	}
+	return above
+}
@@ -0,0 +1,406 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+const (
+	// Proc files used to detect kernel features
+	procFilesystems   = "/proc/filesystems"
+	procOSRelease     = "/proc/sys/kernel/osrelease"
+	maxUserNamespaces = "/proc/sys/user/max_user_namespaces"
+
+	// lxcStorageOvercommitConfigOption is the key for the percentage of the
+	// capacity of the node's thin pools that may be committed to thin
+	// volumes before the node is advertised as over-committed.
+	lxcStorageOvercommitConfigOption = "driver.lxc.storage_overcommit_percent"
+	lxcStorageOvercommitDefault      = 100
+
+	// lxcFingerprintPeriod is how often the driver is fingerprinted, keeping
+	// the storage attributes current
+	lxcFingerprintPeriod = time.Minute
+)
+
+// lxcFingerprintExecTimeout bounds the commands run to fingerprint the
+// driver. The client fingerprints with its config locked, so a tool hanging,
+// as the LVM tools do on lock contention or a missing PV, mustn't block it.
+var lxcFingerprintExecTimeout = 10 * time.Second
+
+var (
+	// lxcTools are the LXC userspace tools whose presence and version are
+	// advertised, keyed by their attribute name.
+	lxcTools = map[string]string{
+		"apparmor":   "apparmor_parser",
+		"attach":     "lxc-attach",
+		"checkpoint": "lxc-checkpoint",
+		"create":     "lxc-create",
+		"criu":       "criu",
+		"lvm":        "lvcreate",
+		"skopeo":     "skopeo",
+		"umoci":      "umoci",
+		"zfs":        "zfs",
+	}
+
+	// lxcFingerprintedTemplates are the templates whose presence is
+	// advertised, as the driver relies on them for the image keys and
+	// oci_image.
+	lxcFingerprintedTemplates = []string{lxcDownloadTemplate, lxcOCITemplate}
+
+	// lxcTemplateDirs are the directories LXC templates are installed to by
+	// common distribution packages.
+	lxcTemplateDirs = []string{
+		"/usr/share/lxc/templates",
+		"/usr/local/share/lxc/templates",
+	}
+
+	// toolVersionRe matches the first version number in a tool's output.
+	toolVersionRe = regexp.MustCompile(`[0-9]+\.[0-9]+(\.[0-9]+)?`)
+)
+
+// lxcIdmappedMountsMinKernel is the first kernel supporting mount_setattr(2),
+// which idmapped mounts for unprivileged containers rely on.
+var lxcIdmappedMountsMinKernel = version.Must(version.NewVersion("5.12"))
+
+// lxcTimeNamespaceMinKernel is the first kernel supporting time namespaces,
+// which the time_offset task config relies on.
+var lxcTimeNamespaceMinKernel = version.Must(version.NewVersion("5.6"))
+
+// lxcKernelAttributes are the attributes set by fingerprintKernel, removed
+// before each fingerprint so that features going away, such as the overlay
+// module being unloaded, aren't advertised anymore.
+var lxcKernelAttributes = []string{
+	"driver.lxc.overlayfs",
+	"driver.lxc.cgroup_namespaces",
+	"driver.lxc.apparmor",
+	"driver.lxc.userns.max",
+	"driver.lxc.idmapped_mounts",
+	"driver.lxc.time_namespaces",
+}
+
+// fingerprintKernel advertises the kernel features that decide whether
+// unprivileged or overlay backed containers can run on the node.
+func (d *LxcDriver) fingerprintKernel(node *structs.Node) {
+	for _, key := range lxcKernelAttributes {
+		delete(node.Attributes, key)
+	}
+
+	if f, err := os.Open(procFilesystems); err == nil {
+		if kernelHasFilesystem(f, "overlay") {
+			node.Attributes["driver.lxc.overlayfs"] = "1"
+		}
+		f.Close()
+	}
+
+	if _, err := os.Stat(procCgroupNamespace); err == nil {
+		node.Attributes["driver.lxc.cgroup_namespaces"] = "1"
+	}
+
+	if raw, err := ioutil.ReadFile(sysAppArmorEnabled); err == nil && strings.TrimSpace(string(raw)) == "Y" {
+		node.Attributes["driver.lxc.apparmor"] = "1"
+	}
+
+	if raw, err := ioutil.ReadFile(maxUserNamespaces); err == nil {
+		if max, err := strconv.Atoi(strings.TrimSpace(string(raw))); err == nil {
+			node.Attributes["driver.lxc.userns.max"] = strconv.Itoa(max)
+		}
+	}
+
+	release, err := ioutil.ReadFile(procOSRelease)
+	if err != nil {
+		d.logger.Printf("[WARN] driver.lxc: unable to determine kernel version: %v", err)
+		return
+	}
+	kernel, err := parseKernelVersion(strings.TrimSpace(string(release)))
+	if err != nil {
+		d.logger.Printf("[WARN] driver.lxc: unable to parse kernel version: %v", err)
+		return
+	}
+	if kernel.Compare(lxcIdmappedMountsMinKernel) >= 0 {
+		node.Attributes["driver.lxc.idmapped_mounts"] = "1"
+	}
+	if kernel.Compare(lxcTimeNamespaceMinKernel) >= 0 {
+		node.Attributes["driver.lxc.time_namespaces"] = "1"
+	}
+}
+
+// kernelHasFilesystem returns whether the filesystem type is listed in the
+// /proc/filesystems formatted input.
+func kernelHasFilesystem(r io.Reader, fstype string) bool {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && fields[len(fields)-1] == fstype {
+			return true
+		}
+	}
+	return false
+}
+
+// parseKernelVersion parses the numeric part of a kernel release such as
+// "4.15.0-20-generic", ignoring the distribution suffix.
+func parseKernelVersion(release string) (*version.Version, error) {
+	if i := strings.IndexFunc(release, func(r rune) bool {
+		return r != '.' && (r < '0' || r > '9')
+	}); i != -1 {
+		release = release[:i]
+	}
+	return version.NewVersion(strings.TrimSuffix(release, "."))
+}
+
+// fingerprintTools advertises the LXC userspace tools and templates that
+// optional driver features depend on.
+func (d *LxcDriver) fingerprintTools(node *structs.Node) {
+	for attr, tool := range lxcTools {
+		key := "driver.lxc." + attr + ".version"
+		path, err := d.backend.LookPath(tool)
+		if err != nil {
+			delete(node.Attributes, key)
+			continue
+		}
+
+		if version, ok := lxcToolVersions.get(path); ok {
+			node.Attributes[key] = version
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), lxcFingerprintExecTimeout)
+		out, err := d.backend.CombinedOutput(ctx, path, "--version")
+		cancel()
+		if err != nil {
+			d.logger.Printf("[DEBUG] driver.lxc: unable to determine %s version: %v", tool, err)
+			delete(node.Attributes, key)
+			continue
+		}
+		version := parseToolVersion(out)
+		if version == "" {
+			d.logger.Printf("[DEBUG] driver.lxc: unable to parse %s version from %q", tool, out)
+			delete(node.Attributes, key)
+			continue
+		}
+		lxcToolVersions.set(path, version)
+		node.Attributes[key] = version
+	}
+
+	for _, template := range lxcFingerprintedTemplates {
+		key := "driver.lxc.template." + template
+		delete(node.Attributes, key)
+		for _, dir := range lxcTemplateDirs {
+			if _, err := os.Stat(filepath.Join(dir, "lxc-"+template)); err == nil {
+				node.Attributes[key] = "1"
+				break
+			}
+		}
+	}
+}
+
+// lxcToolVersions caches the versions of the tools by path, so that they
+// aren't run on every fingerprint.
+var lxcToolVersions = &lxcToolVersionCache{versions: make(map[string]lxcToolVersion)}
+
+// lxcToolVersionCache caches the versions of executables until they change.
+type lxcToolVersionCache struct {
+	versions map[string]lxcToolVersion
+	lock     sync.Mutex
+}
+
+// lxcToolVersion is the version of an executable as of its modification
+// time and size.
+type lxcToolVersion struct {
+	modTime time.Time
+	size    int64
+	version string
+}
+
+// get returns the cached version of the executable, unless it changed since.
+func (c *lxcToolVersionCache) get(path string) (string, bool) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	v, ok := c.versions[path]
+	if !ok || !v.modTime.Equal(fi.ModTime()) || v.size != fi.Size() {
+		return "", false
+	}
+	return v.version, true
+}
+
+// set caches the version of the executable.
+func (c *lxcToolVersionCache) set(path, version string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.versions[path] = lxcToolVersion{modTime: fi.ModTime(), size: fi.Size(), version: version}
+}
+
+// parseToolVersion returns the first version number in the output of a
+// tool's --version flag, such as "2.0.8" or "Version: 3.6".
+func parseToolVersion(out []byte) string {
+	return string(toolVersionRe.Find(bytes.TrimSpace(out)))
+}
+
+// fingerprintLVM advertises the LVM thin pools available on the node as a
+// sorted, comma separated list of vg/pool names, along with how much of
+// their capacity is committed to thin volumes such as container clones.
+// Thin pools can be over-committed, so placement can steer clear of nodes
+// whose pools are likely to run out of space.
+func (d *LxcDriver) fingerprintLVM(node *structs.Node) {
+	for _, key := range []string{"driver.lxc.lvm.pools", "driver.lxc.storage.capacity_mb",
+		"driver.lxc.storage.committed_mb", "driver.lxc.storage.overcommitted"} {
+		delete(node.Attributes, key)
+	}
+
+	lvs, err := d.backend.LookPath("lvs")
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lxcFingerprintExecTimeout)
+	defer cancel()
+	out, err := d.backend.Output(ctx, lvs, "--noheadings", "--units", "m", "--nosuffix",
+		"--separator", ",", "-o", "vg_name,lv_name,lv_attr,lv_size,pool_lv")
+	if err != nil {
+		d.logger.Printf("[DEBUG] driver.lxc: unable to list logical volumes: %v", err)
+		return
+	}
+	pools := parseThinPools(out)
+	if len(pools) == 0 {
+		return
+	}
+
+	var names []string
+	var capacity, committed int64
+	for _, pool := range pools {
+		names = append(names, pool.name)
+		capacity += pool.sizeMB
+		committed += pool.committedMB
+	}
+	node.Attributes["driver.lxc.lvm.pools"] = strings.Join(names, ",")
+	node.Attributes["driver.lxc.storage.capacity_mb"] = strconv.FormatInt(capacity, 10)
+	node.Attributes["driver.lxc.storage.committed_mb"] = strconv.FormatInt(committed, 10)
+
+	// Constraints compare attributes as strings, so the over-commitment is
+	// advertised as a flag against the configured limit
+	limit := d.config.ReadIntDefault(lxcStorageOvercommitConfigOption, lxcStorageOvercommitDefault)
+	overcommitted := "0"
+	if committed*100 > capacity*int64(limit) {
+		overcommitted = "1"
+	}
+	node.Attributes["driver.lxc.storage.overcommitted"] = overcommitted
+}
+
+// lxcThinPool is an LVM thin pool and the storage committed to its thin
+// volumes, in megabytes.
+type lxcThinPool struct {
+	name        string
+	sizeMB      int64
+	committedMB int64
+}
+
+// parseThinPools returns the thin pools in the output of "lvs --noheadings
+// --units m --nosuffix --separator , -o vg_name,lv_name,lv_attr,lv_size,pool_lv",
+// sorted by their vg/lv names.
+func parseThinPools(out []byte) []*lxcThinPool {
+	pools := make(map[string]*lxcThinPool)
+	committed := make(map[string]int64)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ",")
+		if len(fields) != 5 {
+			continue
+		}
+		size, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			continue
+		}
+
+		// The first lv_attr character is the volume type, 't' for thin
+		// pools and 'V' for thin volumes
+		switch {
+		case strings.HasPrefix(fields[2], "t"):
+			name := fields[0] + "/" + fields[1]
+			pools[name] = &lxcThinPool{name: name, sizeMB: int64(size)}
+		case strings.HasPrefix(fields[2], "V") && fields[4] != "":
+			committed[fields[0]+"/"+fields[4]] += int64(size)
+		}
+	}
+
+	names := make([]string, 0, len(pools))
+	for name, pool := range pools {
+		pool.committedMB = committed[name]
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sorted := make([]*lxcThinPool, len(names))
+	for i, name := range names {
+		sorted[i] = pools[name]
+	}
+	return sorted
+}
+
+// lxcVolumeGroupAttrPrefix prefixes the attributes of the node's volume
+// groups, such as "driver.lxc.lvm.vg.vg0.free_mb".
+const lxcVolumeGroupAttrPrefix = "driver.lxc.lvm.vg."
+
+// fingerprintVolumeGroups advertises the size and free space of each of the
+// node's LVM volume groups, so that jobs can be constrained to nodes with
+// enough storage in the group they need.
+func (d *LxcDriver) fingerprintVolumeGroups(node *structs.Node) {
+	for key := range node.Attributes {
+		if strings.HasPrefix(key, lxcVolumeGroupAttrPrefix) {
+			delete(node.Attributes, key)
+		}
+	}
+
+	vgs, err := d.backend.LookPath("vgs")
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), lxcFingerprintExecTimeout)
+	defer cancel()
+	out, err := d.backend.Output(ctx, vgs, "--noheadings", "--units", "m", "--nosuffix",
+		"--separator", ",", "-o", "vg_name,vg_size,vg_free")
+	if err != nil {
+		d.logger.Printf("[DEBUG] driver.lxc: unable to list volume groups: %v", err)
+		return
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), ",")
+		if len(fields) != 3 || fields[0] == "" {
+			continue
+		}
+		size, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		free, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			continue
+		}
+		prefix := lxcVolumeGroupAttrPrefix + fields[0] + "."
+		node.Attributes[prefix+"size_mb"] = strconv.FormatInt(int64(size), 10)
+		node.Attributes[prefix+"free_mb"] = strconv.FormatInt(int64(free), 10)
+	}
+}
@@ -0,0 +1,52 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// lxcHandleVersion is the version of the handle written by ID. It is
+// incremented, with a migration added to lxcHandleMigrations, whenever
+// recovering a container needs handle fields that older clients didn't
+// write.
+const lxcHandleVersion = 1
+
+// lxcHandleMigrations upgrade a handle of the version at their index to the
+// next version, so containers started by older clients are recovered after
+// an upgrade instead of being orphaned.
+var lxcHandleMigrations = []func(ctx *ExecContext, c lxcContainerAPI, pid *lxcPID){
+	migrateLxcHandleV0,
+}
+
+// migrateLxcPID upgrades the handle of the container to the current
+// version. Handles of newer versions are rejected by Open, as the fields
+// they added would be lost.
+func migrateLxcPID(ctx *ExecContext, c lxcContainerAPI, pid *lxcPID) {
+	for ; pid.Version < lxcHandleVersion; pid.Version++ {
+		lxcHandleMigrations[pid.Version](ctx, c, pid)
+	}
+}
+
+// migrateLxcHandleV0 upgrades unversioned handles, which may lack the LXC
+// log the exit status is read from and the environment commands are run in
+// the container with. Both are recovered from where the driver puts them.
+func migrateLxcHandleV0(ctx *ExecContext, c lxcContainerAPI, pid *lxcPID) {
+	if pid.LogFile == "" && ctx != nil && ctx.TaskDir != nil {
+		if task, _, ok := parseLxcContainerName(pid.ContainerName); ok {
+			pid.LogFile = filepath.Join(ctx.TaskDir.Dir, fmt.Sprintf("%v-lxc.log", task))
+		}
+	}
+	if pid.Env == nil && c.Running() {
+		if environ, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/environ", c.InitPid())); err == nil {
+			for _, v := range bytes.Split(environ, []byte{0}) {
+				if len(v) != 0 {
+					pid.Env = append(pid.Env, string(v))
+				}
+			}
+		}
+	}
+}
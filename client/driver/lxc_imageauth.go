@@ -0,0 +1,83 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"net/url"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+const (
+	// lxcImageServerUsernameConfigOption, lxcImageServerPasswordConfigOption
+	// and lxcImageServerTokenConfigOption are the keys for the credentials
+	// of the image servers of the download template, used for tasks that
+	// don't set their own.
+	lxcImageServerUsernameConfigOption = "driver.lxc.image_server_username"
+	lxcImageServerPasswordConfigOption = "driver.lxc.image_server_password"
+	lxcImageServerTokenConfigOption    = "driver.lxc.image_server_token"
+
+	// lxcImageServerTokenUsername is the username tokens are sent with when
+	// none is set
+	lxcImageServerTokenUsername = "token"
+
+	// lxcDefaultImageServer is the image server of the download template
+	// when none is set
+	lxcDefaultImageServer = "images.linuxcontainers.org"
+)
+
+// validateImageServerAuth checks the credential keys, which only the
+// download template takes and of which a token replaces the password.
+func (c *LxcDriverConfig) validateImageServerAuth() []error {
+	var errs []error
+	if c.ImageServerToken != "" && c.ImageServerPassword != "" {
+		errs = append(errs, fmt.Errorf("image_server_token and image_server_password are mutually exclusive"))
+	}
+	if c.ImageServerPassword != "" && c.ImageServerUsername == "" {
+		errs = append(errs, fmt.Errorf("image_server_password requires image_server_username"))
+	}
+	return errs
+}
+
+// imageServerCredentials returns the credentials for the image server, from
+// the task config or else the client's options, or nil if there are none.
+// The download template fetches images with wget, which can only send basic
+// auth, so tokens are sent as the password.
+func (d *LxcDriver) imageServerCredentials(config *LxcDriverConfig) *url.Userinfo {
+	username, password, token := config.ImageServerUsername, config.ImageServerPassword, config.ImageServerToken
+	if username == "" && token == "" {
+		username = d.config.Read(lxcImageServerUsernameConfigOption)
+		password = d.config.Read(lxcImageServerPasswordConfigOption)
+		token = d.config.Read(lxcImageServerTokenConfigOption)
+	}
+	if token != "" {
+		password = token
+		if username == "" {
+			username = lxcImageServerTokenUsername
+		}
+	}
+	switch {
+	case username == "":
+		return nil
+	case password == "":
+		return url.User(username)
+	}
+	return url.UserPassword(username, password)
+}
+
+// applyImageServerAuth adds the credentials for the image server to the
+// server of the template options, which the download template puts in the
+// URLs it fetches.
+func (d *LxcDriver) applyImageServerAuth(options *lxc.TemplateOptions, config *LxcDriverConfig) {
+	auth := d.imageServerCredentials(config)
+	if auth == nil {
+		return
+	}
+	server := options.Server
+	if server == "" {
+		server = lxcDefaultImageServer
+	}
+	d.logger.Printf("[DEBUG] driver.lxc: authenticating to image server %q as %q", server, auth.Username())
+	options.Server = auth.String() + "@" + server
+}
@@ -0,0 +1,288 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/client/driver/env"
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+const (
+	// lxcLogShipperConfigOption is the key for where the output of LXC
+	// containers is shipped as it is written: a host command started for
+	// each container, reading the records on its standard input, or a unix
+	// socket given as unix:<path>. It lets sites with centralized logging
+	// ingest the logs of each container without scraping files on disk.
+	lxcLogShipperConfigOption = "driver.lxc.log_shipper"
+
+	// lxcLogShipperSocketPrefix prefixes the path of a unix socket shipper
+	lxcLogShipperSocketPrefix = "unix:"
+
+	// lxcLogShipIntv is how often the container's logs are read for new
+	// lines, and lxcLogShipperRetryIntv how long after failing the shipper
+	// is started or connected to again
+	lxcLogShipIntv         = time.Second
+	lxcLogShipperRetryIntv = 5 * time.Second
+
+	// lxcLogShipMaxRead bounds how much of a log is read at once, and so
+	// the length of the lines shipped
+	lxcLogShipMaxRead = 1024 * 1024
+)
+
+// lxcLogShipping is the shipping of a container's logs, persisted in its
+// handle.
+type lxcLogShipping struct {
+	Shipper string
+
+	// Files are the logs shipped by their stream, and offsets how much of
+	// them was shipped
+	Files   map[string]string
+	offsets map[string]int64
+
+	// buf holds what is read of the logs, reused across reads
+	buf []byte
+
+	// Record holds the metadata of the records of the container's lines
+	Record lxcLogRecord
+}
+
+// lxcLogRecord is the JSON record written to the log shipper for each line
+// of the container's logs, on a line of its own.
+type lxcLogRecord struct {
+	AllocID       string
+	JobName       string
+	NodeID        string
+	Task          string
+	ContainerName string
+	Stream        string
+	Time          time.Time
+	Line          string
+}
+
+// newLxcLogShipping returns the shipping of the container's logs if a
+// shipper is configured, or nil if it isn't or the container writes no
+// logs. The output of the command of app mode containers is shipped as
+// stdout, and the console of containers logging it as console. Shipping
+// starts at the current end of the logs, as they are appended to when the
+// container restarts.
+func (d *LxcDriver) newLxcLogShipping(config *LxcDriverConfig, task *structs.Task, taskEnv *env.TaskEnv, logDir string) *lxcLogShipping {
+	shipper := strings.TrimSpace(d.config.Read(lxcLogShipperConfigOption))
+	if shipper == "" {
+		return nil
+	}
+	files := make(map[string]string)
+	if config.Mode == lxcModeApp {
+		files["stdout"] = filepath.Join(logDir, fmt.Sprintf("%s.stdout.0", task.Name))
+	}
+	if config.ConsoleLog {
+		files["console"] = filepath.Join(logDir, fmt.Sprintf("%s.console.0", task.Name))
+	}
+	if len(files) == 0 {
+		d.logger.Printf("[DEBUG] driver.lxc: not shipping logs of task %q, which sets neither mode %q nor console_log", task.Name, lxcModeApp)
+		return nil
+	}
+
+	s := &lxcLogShipping{
+		Shipper: shipper,
+		Files:   files,
+		Record: lxcLogRecord{
+			AllocID:       d.DriverContext.allocID,
+			Task:          task.Name,
+			ContainerName: lxcContainerName(task.Name, d.DriverContext.allocID),
+		},
+	}
+	if taskEnv != nil {
+		s.Record.JobName = taskEnv.EnvMap[env.JobName]
+	}
+	if d.DriverContext.node != nil {
+		s.Record.NodeID = d.DriverContext.node.ID
+	}
+	s.skipToEnd()
+	return s
+}
+
+// skipToEnd marks the logs as shipped up to their current end. Recovered
+// handles skip what was written while the client was down, rather than
+// shipping again what was shipped before it restarted.
+func (s *lxcLogShipping) skipToEnd() {
+	s.offsets = make(map[string]int64)
+	for stream, path := range s.Files {
+		if fi, err := os.Stat(path); err == nil {
+			s.offsets[stream] = fi.Size()
+		}
+	}
+}
+
+// shipLogs ships the lines appended to the container's logs until stopped,
+// shipping what remains once it is.
+func (h *lxcDriverHandle) shipLogs(stopCh <-chan bool) {
+	shipper := &lxcLogShipper{shipper: h.logShipping.Shipper, name: h.container.Name(), logger: h.logger}
+	defer shipper.close()
+
+	ticker := time.NewTicker(lxcLogShipIntv)
+	defer ticker.Stop()
+	for {
+		stopped := false
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			stopped = true
+		}
+		for stream := range h.logShipping.Files {
+			h.shipStream(shipper, stream)
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+// shipStream ships the complete lines appended to the stream's log since
+// it was last shipped. Lines the shipper fails to take are shipped again
+// once it is back. Logs are only read once they grew, and only what was
+// appended.
+func (h *lxcDriverHandle) shipStream(shipper *lxcLogShipper, stream string) {
+	s := h.logShipping
+	fi, err := os.Stat(s.Files[stream])
+	if err != nil {
+		return
+	}
+	offset := s.offsets[stream]
+	if fi.Size() < offset {
+		// The log was truncated
+		offset = 0
+		s.offsets[stream] = offset
+	}
+	size := fi.Size() - offset
+	if size == 0 {
+		return
+	}
+	if size > lxcLogShipMaxRead {
+		size = lxcLogShipMaxRead
+	}
+
+	f, err := os.Open(s.Files[stream])
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if int64(cap(s.buf)) < size {
+		s.buf = make([]byte, size)
+	}
+	n, err := f.ReadAt(s.buf[:size], offset)
+	if err != nil && err != io.EOF {
+		return
+	}
+	data := s.buf[:n]
+	for len(data) != 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			if len(data) < lxcLogShipMaxRead {
+				// Wait for the rest of the line
+				break
+			}
+			i = len(data) - 1
+		}
+		record := s.Record
+		record.Stream = stream
+		record.Time = time.Now()
+		record.Line = strings.TrimSuffix(string(data[:i+1]), "\n")
+		raw, err := json.Marshal(record)
+		if err != nil {
+			break
+		}
+		if !shipper.write(append(raw, '\n')) {
+			break
+		}
+		offset += int64(i + 1)
+		data = data[i+1:]
+	}
+	s.offsets[stream] = offset
+}
+
+// lxcLogShipper writes records to the shipper of a container's logs,
+// starting or connecting to it when first needed and again after it
+// fails.
+type lxcLogShipper struct {
+	shipper string
+	name    string
+	logger  *log.Logger
+
+	w       io.WriteCloser
+	retryAt time.Time
+}
+
+// write writes the record to the shipper, returning whether it succeeded.
+func (s *lxcLogShipper) write(record []byte) bool {
+	if s.w == nil {
+		if time.Now().Before(s.retryAt) {
+			return false
+		}
+		w, err := openLxcLogShipper(s.shipper)
+		if err != nil {
+			s.logger.Printf("[WARN] driver.lxc: unable to start log shipper of container %q: %v", s.name, err)
+			s.retryAt = time.Now().Add(lxcLogShipperRetryIntv)
+			return false
+		}
+		s.w = w
+	}
+	if _, err := s.w.Write(record); err != nil {
+		s.logger.Printf("[WARN] driver.lxc: log shipper of container %q failed: %v", s.name, err)
+		s.close()
+		s.retryAt = time.Now().Add(lxcLogShipperRetryIntv)
+		return false
+	}
+	return true
+}
+
+func (s *lxcLogShipper) close() {
+	if s.w != nil {
+		s.w.Close()
+		s.w = nil
+	}
+}
+
+// openLxcLogShipper connects to the shipper's socket, or starts its command.
+func openLxcLogShipper(shipper string) (io.WriteCloser, error) {
+	if strings.HasPrefix(shipper, lxcLogShipperSocketPrefix) {
+		return net.Dial("unix", strings.TrimPrefix(shipper, lxcLogShipperSocketPrefix))
+	}
+	args := strings.Fields(shipper)
+	cmd := exec.Command(args[0], args[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &lxcLogShipperCmd{cmd: cmd, stdin: stdin}, nil
+}
+
+// lxcLogShipperCmd is a shipper command, which exits once its standard
+// input is closed.
+type lxcLogShipperCmd struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+}
+
+func (c *lxcLogShipperCmd) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+func (c *lxcLogShipperCmd) Close() error {
+	c.stdin.Close()
+	return c.cmd.Wait()
+}
@@ -0,0 +1,108 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/nomad/client/config"
+	"github.com/hashicorp/nomad/nomad/structs"
+
+	cstructs "github.com/hashicorp/nomad/client/structs"
+)
+
+const (
+	// lxcMaintenanceFile is the file in the client's state directory
+	// recording that the driver is in maintenance, so it stays paused
+	// across restarts of the client, such as for liblxc upgrades.
+	lxcMaintenanceFile = "lxc-maintenance.json"
+
+	// lxcPausedAttr is the node attribute set while the driver is in
+	// maintenance, in place of the driver.lxc attribute
+	lxcPausedAttr = "driver.lxc.paused"
+)
+
+// LxcMaintenance returns whether the LXC driver on the client is in
+// maintenance, refusing to start new containers.
+func LxcMaintenance(cfg *config.Config) (*cstructs.LxcMaintenance, error) {
+	data, err := ioutil.ReadFile(filepath.Join(cfg.StateDir, lxcMaintenanceFile))
+	if os.IsNotExist(err) {
+		return &cstructs.LxcMaintenance{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var m cstructs.LxcMaintenance
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid lxc maintenance state: %v", err)
+	}
+	m.Paused = true
+	return &m, nil
+}
+
+// SetLxcMaintenance puts the LXC driver on the client into maintenance, or
+// takes it out of it. In maintenance the driver is not fingerprinted, so no
+// new LXC tasks are placed on the node, and tasks already placed fail to
+// start with a recoverable error. Running containers are not affected.
+func SetLxcMaintenance(cfg *config.Config, paused bool, reason string) (*cstructs.LxcMaintenance, error) {
+	file := filepath.Join(cfg.StateDir, lxcMaintenanceFile)
+	if !paused {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		return &cstructs.LxcMaintenance{}, nil
+	}
+
+	// Pausing again keeps the time maintenance started
+	m, err := LxcMaintenance(cfg)
+	if err != nil || !m.Paused {
+		m = &cstructs.LxcMaintenance{Paused: true, Since: time.Now().UTC()}
+	}
+	m.Reason = reason
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return nil, fmt.Errorf("unable to record lxc maintenance: %v", err)
+	}
+	return m, nil
+}
+
+// fingerprintMaintenance withdraws the driver from scheduling while it is
+// in maintenance and returns whether it is.
+func (d *LxcDriver) fingerprintMaintenance(cfg *config.Config, node *structs.Node) bool {
+	m, err := LxcMaintenance(cfg)
+	if err != nil {
+		d.logger.Printf("[WARN] driver.lxc: %v", err)
+		return false
+	}
+	if !m.Paused {
+		delete(node.Attributes, lxcPausedAttr)
+		return false
+	}
+	delete(node.Attributes, "driver.lxc")
+	node.Attributes[lxcPausedAttr] = "1"
+	return true
+}
+
+// checkMaintenance returns a recoverable error if the driver is in
+// maintenance, so tasks placed before it was paused are retried according
+// to their restart policy.
+func (d *LxcDriver) checkMaintenance() error {
+	m, err := LxcMaintenance(d.config)
+	if err != nil || !m.Paused {
+		return err
+	}
+	err = fmt.Errorf("lxc driver is paused for maintenance")
+	if m.Reason != "" {
+		err = fmt.Errorf("lxc driver is paused for maintenance: %s", m.Reason)
+	}
+	return structs.NewRecoverableError(err, true)
+}
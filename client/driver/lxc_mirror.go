@@ -0,0 +1,42 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+const (
+	// lxcImageMirrorConfigOption is the key for the image server used by
+	// the download template in place of the one in the task config.
+	lxcImageMirrorConfigOption = "driver.lxc.image_mirror"
+
+	// lxcKeyServerMirrorConfigOption is the key for the GPG key server used
+	// in place of the one in the task config.
+	lxcKeyServerMirrorConfigOption = "driver.lxc.gpg_key_server_mirror"
+)
+
+// mirrorOption reads a mirror option, preferring the override for the node's
+// datacenter given as "<option>.<datacenter>".
+func (d *LxcDriver) mirrorOption(option string) string {
+	if d.node != nil && d.node.Datacenter != "" {
+		if mirror := d.config.Read(option + "." + d.node.Datacenter); mirror != "" {
+			return mirror
+		}
+	}
+	return d.config.Read(option)
+}
+
+// applyImageMirrors rewrites the image and key servers of the template
+// options to the configured mirrors, so that the same task config works on
+// clients without access to the public servers.
+func (d *LxcDriver) applyImageMirrors(options *lxc.TemplateOptions) {
+	if mirror := d.mirrorOption(lxcImageMirrorConfigOption); mirror != "" {
+		d.logger.Printf("[DEBUG] driver.lxc: using image server mirror %q in place of %q", mirror, options.Server)
+		options.Server = mirror
+	}
+	if mirror := d.mirrorOption(lxcKeyServerMirrorConfigOption); mirror != "" {
+		d.logger.Printf("[DEBUG] driver.lxc: using GPG key server mirror %q in place of %q", mirror, options.KeyServer)
+		options.KeyServer = mirror
+	}
+}
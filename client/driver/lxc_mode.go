@@ -0,0 +1,109 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+const (
+	// lxcModeSystem runs the init system of the container's image, as a
+	// lightweight VM. It is the default mode.
+	lxcModeSystem = "system"
+
+	// lxcModeApp runs the task's command as the container's init process,
+	// so the task exits with it.
+	lxcModeApp = "app"
+)
+
+// validateMode checks the keys that only apply to one of the modes.
+func (c *LxcDriverConfig) validateMode() []error {
+	var errs []error
+	switch c.Mode {
+	case "", lxcModeSystem:
+		if c.Command != "" || len(c.Args) != 0 {
+			errs = append(errs, fmt.Errorf("command and args require mode %q", lxcModeApp))
+		}
+	case lxcModeApp:
+		if c.Command == "" {
+			errs = append(errs, fmt.Errorf("mode %q requires a command", lxcModeApp))
+		}
+		for _, arg := range append([]string{c.Command}, c.Args...) {
+			if strings.Contains(arg, "'") && strings.Contains(arg, `"`) {
+				errs = append(errs, fmt.Errorf("argument %q can't contain both single and double quotes", arg))
+			}
+		}
+		// The command's output is the container's console, and as init it
+		// receives the task's signals and has no systemd to monitor
+		invalid := map[string]bool{
+			"console_log":    c.ConsoleLog,
+			"signal_pidfile": c.SignalPidfile != "",
+			"systemd":        len(c.Systemd) != 0,
+		}
+		for key, set := range invalid {
+			if set {
+				errs = append(errs, fmt.Errorf("%s requires mode %q", key, lxcModeSystem))
+			}
+		}
+	default:
+		errs = append(errs, fmt.Errorf("mode must be %q or %q, got %q", lxcModeSystem, lxcModeApp, c.Mode))
+	}
+	return errs
+}
+
+// lxcInitCmd returns the value of lxc.init.cmd running the command, quoting
+// the arguments LXC would otherwise split.
+func lxcInitCmd(command string, args []string) string {
+	var quoted []string
+	for _, arg := range append([]string{command}, args...) {
+		switch {
+		case arg != "" && !strings.ContainsAny(arg, " \t\n'\""):
+			quoted = append(quoted, arg)
+		case strings.Contains(arg, "'"):
+			quoted = append(quoted, `"`+arg+`"`)
+		default:
+			quoted = append(quoted, "'"+arg+"'")
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// setContainerMode configures how the container boots. Containers in app
+// mode run the task's command as their init, write its output to the task's
+// stdout log so it can be read with nomad logs, and are stopped with SIGTERM
+// rather than the SIGPWR an init system expects, unless the task sets a
+// kill signal.
+func setContainerMode(c lxcContainerAPI, config *LxcDriverConfig, task *structs.Task, logDir string) error {
+	if config.Mode != lxcModeApp {
+		return nil
+	}
+
+	// LXC 2.1 renamed lxc.init_cmd to lxc.init.cmd
+	initCmd := lxcInitCmd(config.Command, config.Args)
+	if err := c.SetConfigItem("lxc.init.cmd", initCmd); err != nil {
+		if err := c.SetConfigItem("lxc.init_cmd", initCmd); err != nil {
+			return fmt.Errorf("error setting init command: %v", err)
+		}
+	}
+
+	stdout := filepath.Join(logDir, fmt.Sprintf("%s.stdout.0", task.Name))
+	if err := c.SetConfigItem("lxc.console.logfile", stdout); err != nil {
+		return fmt.Errorf("error setting console log file: %v", err)
+	}
+
+	if task.KillSignal == "" {
+		value := strconv.Itoa(int(syscall.SIGTERM))
+		if err := c.SetConfigItem("lxc.signal.halt", value); err != nil {
+			if err := c.SetConfigItem("lxc.haltsignal", value); err != nil {
+				return fmt.Errorf("error setting kill signal: %v", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+const (
+	// cgroupV2Mount is where the unified cgroup hierarchy is mounted
+	cgroupV2Mount = "/sys/fs/cgroup"
+
+	// lxcStateWaitTimeout bounds each wait for the container to stop, so
+	// that waiting ends soon after the handle stops watching the container
+	lxcStateWaitTimeout = 10 * time.Second
+)
+
+// watchContainerStopped waits for the container to stop through LXC's state
+// monitor and returns a channel that is closed once it has. Unlike polling
+// the init process, this can't be fooled by its PID being reused. Waiting
+// ends when stopCh is closed.
+func watchContainerStopped(c lxcContainerAPI, stopCh <-chan bool) <-chan struct{} {
+	stoppedCh := make(chan struct{})
+	go func() {
+		for !c.Wait(lxc.STOPPED, lxcStateWaitTimeout) {
+			select {
+			case <-stopCh:
+				return
+			default:
+			}
+		}
+		close(stoppedCh)
+	}()
+	return stoppedCh
+}
+
+// parseUnifiedCgroup returns the path of the process in the unified cgroup
+// hierarchy from the contents of /proc/<pid>/cgroup.
+func parseUnifiedCgroup(r io.Reader) (string, bool) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "0::") {
+			return strings.TrimPrefix(line, "0::"), true
+		}
+	}
+	return "", false
+}
+
+// cgroupPopulated returns whether the cgroup.events formatted input reports
+// processes in the cgroup or its descendants.
+func cgroupPopulated(r io.Reader) (bool, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "populated" {
+			return fields[1] != "0", nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	return false, fmt.Errorf("populated key not found")
+}
+
+// lxcCgroupEvents returns the cgroup.events file of the cgroup containing the
+// process, if the node uses the unified cgroup hierarchy.
+func lxcCgroupEvents(pid int) (string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	cgroup, ok := parseUnifiedCgroup(f)
+	if !ok {
+		return "", fmt.Errorf("process %d is not in a unified cgroup", pid)
+	}
+	events := filepath.Join(cgroupV2Mount, cgroup, "cgroup.events")
+	if _, err := os.Stat(events); err != nil {
+		return "", err
+	}
+	return events, nil
+}
+
+// readCgroupPopulated reads whether the cgroup of the cgroup.events file has
+// any processes left.
+func readCgroupPopulated(events string) (bool, error) {
+	f, err := os.Open(events)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return cgroupPopulated(f)
+}
+
+// watchCgroupEmpty watches the cgroup.events file with inotify and returns a
+// channel that is closed once the cgroup has no processes left. The watch is
+// removed when stopCh is closed.
+func watchCgroupEmpty(events string, stopCh <-chan bool) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	wd, err := syscall.InotifyAddWatch(fd, events, syscall.IN_MODIFY)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	// Check after the watch is in place so an exit can't be missed
+	if populated, err := readCgroupPopulated(events); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	} else if !populated {
+		syscall.Close(fd)
+		emptyCh := make(chan struct{})
+		close(emptyCh)
+		return emptyCh, nil
+	}
+
+	// Removing the watch queues an IN_IGNORED event, unblocking the reader
+	go func() {
+		<-stopCh
+		syscall.InotifyRmWatch(fd, uint32(wd))
+	}()
+
+	emptyCh := make(chan struct{})
+	go func() {
+		defer syscall.Close(fd)
+		buf := make([]byte, syscall.SizeofInotifyEvent*16+syscall.NAME_MAX+1)
+		for {
+			n, err := syscall.Read(fd, buf)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil || n < syscall.SizeofInotifyEvent {
+				return
+			}
+
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				if event.Mask&syscall.IN_IGNORED != 0 {
+					// The watch was removed or the cgroup is gone
+					if _, err := os.Stat(events); os.IsNotExist(err) {
+						close(emptyCh)
+					}
+					return
+				}
+				offset += syscall.SizeofInotifyEvent + int(event.Len)
+			}
+
+			if populated, err := readCgroupPopulated(events); err == nil && !populated {
+				close(emptyCh)
+				return
+			}
+		}
+	}()
+	return emptyCh, nil
+}
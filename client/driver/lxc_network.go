@@ -0,0 +1,589 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cstructs "github.com/hashicorp/nomad/client/structs"
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+const (
+	// lxcNetworkVeth is the network type connecting the container to a host
+	// bridge through a veth pair
+	lxcNetworkVeth = "veth"
+
+	// lxcNetworkMacvlan and lxcNetworkIpvlan are the network types giving the
+	// container an interface on a host interface, so that it appears
+	// directly on that interface's network without a bridge
+	lxcNetworkMacvlan = "macvlan"
+	lxcNetworkIpvlan  = "ipvlan"
+
+	// lxcNetworkVlan is the network type giving the container a VLAN
+	// interface on a host interface, tagging its traffic with the network's
+	// VLAN ID
+	lxcNetworkVlan = "vlan"
+
+	// lxcMaxVlanID is the highest VLAN ID, as 0 and 4095 are reserved
+	lxcMaxVlanID = 4094
+
+	// lxcNetworkModeHost is the network_mode of containers deliberately
+	// sharing the host's network namespace, which unlike containers merely
+	// without a network block may use raw and packet sockets to observe the
+	// host's traffic. Their other namespaces are still their own.
+	lxcNetworkModeHost = "host"
+
+	// lxcNetworkModeTaskPrefix prefixes the name of the task of the same
+	// allocation whose network namespace a container joins, as with
+	// network_mode = "task:proxy". The tasks then share their interfaces,
+	// addresses and ports, like the containers of a pod.
+	lxcNetworkModeTaskPrefix = "task:"
+
+	// lxcNetworkModeNetnsPrefix prefixes the named network namespace, or the
+	// path of the network namespace, a container joins, as with
+	// network_mode = "netns:mesh". This lets containers run in namespaces
+	// set up outside of Nomad, such as by CNI plugins, alongside a sidecar
+	// proxy.
+	lxcNetworkModeNetnsPrefix = "netns:"
+
+	// lxcShareNetworkWaitTimeout is how long a container joining another
+	// task's network namespace waits for that task's container to run, or
+	// joining a network namespace for it to be created
+	lxcShareNetworkWaitTimeout = time.Minute
+
+	// lxcDefaultInterface is the name of the container's interface if the
+	// network block doesn't set one
+	lxcDefaultInterface = "eth0"
+
+	// lxcGatewayAuto is the gateway LXC sets to the address of the bridge
+	lxcGatewayAuto = "auto"
+
+	// lxcRoutesHookFile is the file in the container's directory holding the
+	// hook adding the network block's routes to the container
+	lxcRoutesHookFile = "nomad-routes"
+
+	// lxcIPWaitConfigOption is the key for how long Start waits for the
+	// interface of a container without a static address to get one, for
+	// example through DHCP, to advertise it for the task's services.
+	lxcIPWaitConfigOption = "driver.lxc.ip_wait_timeout"
+	lxcIPWaitDefault      = 10 * time.Second
+
+	// lxcIPPollIntv is how often the container's address is polled for
+	lxcIPPollIntv = 500 * time.Millisecond
+
+	// sysClassNet lists the network interfaces of the host
+	sysClassNet = "/sys/class/net"
+)
+
+// netnsDir is where the named network namespaces of the host are bound.
+var netnsDir = "/var/run/netns"
+
+// lxcLegacyNetworkKeys are the network keys renamed by LXC 2.1, by their
+// current name.
+var lxcLegacyNetworkKeys = map[string]string{
+	"ipv4.address": "ipv4",
+	"ipv6.address": "ipv6",
+}
+
+// lxcNetworkModes are the modes of the macvlan and ipvlan network types.
+var lxcNetworkModes = map[string][]string{
+	lxcNetworkMacvlan: {"private", "vepa", "bridge", "passthru"},
+	lxcNetworkIpvlan:  {"l2", "l3", "l3s"},
+}
+
+// lxcInterfaceNameRe matches valid network interface names, which the kernel
+// limits to 15 characters.
+var lxcInterfaceNameRe = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,15}$`)
+
+// validate returns the errors of the network block.
+func (n *LxcNetworkConfig) validate() []error {
+	var errs []error
+	switch n.Type {
+	case "", "none":
+		if n.Bridge != "" || n.Name != "" || n.Parent != "" || n.Mode != "" || n.HWAddr != "" || n.VlanID != 0 {
+			errs = append(errs, fmt.Errorf("network[0]: bridge, parent, mode, name, hwaddr and vlan_id require a network type other than %q", "none"))
+		}
+		if n.IPv4Address != "" || n.IPv4Gateway != "" || n.IPv6Address != "" || n.IPv6Gateway != "" || len(n.Routes) != 0 || n.IPv6AcceptRA != nil {
+			errs = append(errs, fmt.Errorf("network[0]: static addressing requires a network type other than %q", "none"))
+		}
+		return errs
+	case lxcNetworkVeth:
+		if n.Bridge == "" {
+			errs = append(errs, fmt.Errorf("network[0]: bridge is required for type %q", lxcNetworkVeth))
+		} else if !lxcInterfaceNameRe.MatchString(n.Bridge) {
+			errs = append(errs, fmt.Errorf("network[0]: invalid bridge name %q", n.Bridge))
+		}
+		if n.Parent != "" || n.Mode != "" {
+			errs = append(errs, fmt.Errorf("network[0]: parent and mode require type %q or %q", lxcNetworkMacvlan, lxcNetworkIpvlan))
+		}
+	case lxcNetworkVlan:
+		if n.Parent == "" {
+			errs = append(errs, fmt.Errorf("network[0]: parent is required for type %q", n.Type))
+		} else if !lxcInterfaceNameRe.MatchString(n.Parent) {
+			errs = append(errs, fmt.Errorf("network[0]: invalid parent interface name %q", n.Parent))
+		}
+		if n.VlanID == 0 {
+			errs = append(errs, fmt.Errorf("network[0]: vlan_id is required for type %q", n.Type))
+		}
+		if n.Bridge != "" || n.Mode != "" {
+			errs = append(errs, fmt.Errorf("network[0]: bridge and mode are not supported by type %q", n.Type))
+		}
+		if n.IPv4Gateway == lxcGatewayAuto || n.IPv6Gateway == lxcGatewayAuto {
+			errs = append(errs, fmt.Errorf("network[0]: gateway %q requires type %q", lxcGatewayAuto, lxcNetworkVeth))
+		}
+	case lxcNetworkMacvlan, lxcNetworkIpvlan:
+		if n.Parent == "" {
+			errs = append(errs, fmt.Errorf("network[0]: parent is required for type %q", n.Type))
+		} else if !lxcInterfaceNameRe.MatchString(n.Parent) {
+			errs = append(errs, fmt.Errorf("network[0]: invalid parent interface name %q", n.Parent))
+		}
+		if n.Mode != "" && !lxcNetworkModeValid(n.Type, n.Mode) {
+			errs = append(errs, fmt.Errorf("network[0]: invalid %s mode %q, must be one of %s", n.Type, n.Mode, strings.Join(lxcNetworkModes[n.Type], ", ")))
+		}
+		if n.Bridge != "" {
+			errs = append(errs, fmt.Errorf("network[0]: bridge requires type %q", lxcNetworkVeth))
+		}
+		if n.IPv4Gateway == lxcGatewayAuto || n.IPv6Gateway == lxcGatewayAuto {
+			errs = append(errs, fmt.Errorf("network[0]: gateway %q requires type %q", lxcGatewayAuto, lxcNetworkVeth))
+		}
+		if n.VlanID != 0 {
+			errs = append(errs, fmt.Errorf("network[0]: vlan_id requires type %q or %q", lxcNetworkVeth, lxcNetworkVlan))
+		}
+	default:
+		return append(errs, fmt.Errorf("network[0]: unsupported network type %q", n.Type))
+	}
+	if n.Name != "" && !lxcInterfaceNameRe.MatchString(n.Name) {
+		errs = append(errs, fmt.Errorf("network[0]: invalid interface name %q", n.Name))
+	}
+	if n.HWAddr != "" && !lxcHWAddrValid(n.HWAddr) {
+		errs = append(errs, fmt.Errorf("network[0]: invalid hwaddr %q, expected a MAC address such as 00:16:3e:12:34:56", n.HWAddr))
+	}
+	if n.VlanID < 0 || n.VlanID > lxcMaxVlanID {
+		errs = append(errs, fmt.Errorf("network[0]: vlan_id must be between 1 and %d", lxcMaxVlanID))
+	}
+	return append(errs, n.validateAddressing()...)
+}
+
+// validateNetworkMode returns the errors of the network_mode, which
+// conflicts with network blocks giving the container an interface.
+func (c *LxcDriverConfig) validateNetworkMode() []error {
+	if c.NetworkMode == "" {
+		return nil
+	}
+	if c.NetworkMode != lxcNetworkModeHost && c.sharedNetworkTask() == "" && c.sharedNetns() == "" {
+		return []error{fmt.Errorf("network_mode must be %q, %q followed by a task name or %q followed by a network namespace, got %q",
+			lxcNetworkModeHost, lxcNetworkModeTaskPrefix, lxcNetworkModeNetnsPrefix, c.NetworkMode)}
+	}
+	if ns := strings.TrimPrefix(c.NetworkMode, lxcNetworkModeNetnsPrefix); ns != c.NetworkMode && !filepath.IsAbs(ns) && strings.Contains(ns, "/") {
+		return []error{fmt.Errorf("network_mode: network namespace %q must be a name or an absolute path", ns)}
+	}
+	if len(c.Network) != 0 && c.Network[0].Type != "" && c.Network[0].Type != "none" {
+		return []error{fmt.Errorf("network_mode %q conflicts with network type %q", c.NetworkMode, c.Network[0].Type)}
+	}
+	return nil
+}
+
+// sharedNetworkTask returns the task whose network namespace the container
+// joins, or "" if it doesn't join one.
+func (c *LxcDriverConfig) sharedNetworkTask() string {
+	if !strings.HasPrefix(c.NetworkMode, lxcNetworkModeTaskPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(c.NetworkMode, lxcNetworkModeTaskPrefix)
+}
+
+// sharedNetns returns the path of the network namespace the container
+// joins, or "" if it doesn't join one. Names refer to the namespaces of
+// netnsDir, as created by ip netns add.
+func (c *LxcDriverConfig) sharedNetns() string {
+	if !strings.HasPrefix(c.NetworkMode, lxcNetworkModeNetnsPrefix) {
+		return ""
+	}
+	ns := strings.TrimPrefix(c.NetworkMode, lxcNetworkModeNetnsPrefix)
+	if ns == "" || filepath.IsAbs(ns) {
+		return ns
+	}
+	return filepath.Join(netnsDir, ns)
+}
+
+// sharesHostNetwork returns whether the container shares the host's network
+// namespace.
+func (c *LxcDriverConfig) sharesHostNetwork() bool {
+	return len(c.Network) != 0 && c.Network[0].Type == "none" && c.sharedNetworkTask() == "" && c.sharedNetns() == ""
+}
+
+// lxcNetworkModeValid returns whether the mode is one of the network type's.
+func lxcNetworkModeValid(networkType, mode string) bool {
+	for _, m := range lxcNetworkModes[networkType] {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// lxcHWAddrValid returns whether the MAC address is valid. LXC replaces the
+// x digits of a MAC address, as in 00:16:3e:xx:xx:xx, with random ones.
+func lxcHWAddrValid(hwaddr string) bool {
+	mac, err := net.ParseMAC(strings.Replace(strings.ToLower(hwaddr), "x", "0", -1))
+	return err == nil && len(mac) == 6
+}
+
+// hasInterface returns whether the network gives the container its own
+// network namespace and interface, rather than sharing the host's.
+func (n *LxcNetworkConfig) hasInterface() bool {
+	switch n.Type {
+	case lxcNetworkVeth, lxcNetworkMacvlan, lxcNetworkIpvlan, lxcNetworkVlan:
+		return true
+	}
+	return false
+}
+
+// validateAddressing returns the errors of the static addressing of a
+// network block with an interface. The gateways and routes of a family
+// require an address of that family, as they are unreachable until the
+// interface has one.
+func (n *LxcNetworkConfig) validateAddressing() []error {
+	var errs []error
+	if n.IPv4Address != "" {
+		if ip, _, err := net.ParseCIDR(n.IPv4Address); err != nil || ip.To4() == nil {
+			errs = append(errs, fmt.Errorf("network[0]: invalid ipv4_address %q, expected an IPv4 address and prefix length", n.IPv4Address))
+		}
+	} else if n.IPv4Gateway != "" {
+		errs = append(errs, fmt.Errorf("network[0]: ipv4_gateway requires an ipv4_address"))
+	}
+	if n.IPv4Gateway != "" && n.IPv4Gateway != lxcGatewayAuto {
+		if ip := net.ParseIP(n.IPv4Gateway); ip == nil || ip.To4() == nil {
+			errs = append(errs, fmt.Errorf("network[0]: invalid ipv4_gateway %q", n.IPv4Gateway))
+		}
+	}
+
+	if n.IPv6Address != "" {
+		if ip, _, err := net.ParseCIDR(n.IPv6Address); err != nil || ip.To4() != nil {
+			errs = append(errs, fmt.Errorf("network[0]: invalid ipv6_address %q, expected an IPv6 address and prefix length", n.IPv6Address))
+		}
+	} else if n.IPv6Gateway != "" {
+		errs = append(errs, fmt.Errorf("network[0]: ipv6_gateway requires an ipv6_address"))
+	}
+	if n.IPv6Gateway != "" && n.IPv6Gateway != lxcGatewayAuto {
+		if ip := net.ParseIP(n.IPv6Gateway); ip == nil || ip.To4() != nil {
+			errs = append(errs, fmt.Errorf("network[0]: invalid ipv6_gateway %q", n.IPv6Gateway))
+		}
+	}
+
+	for i, route := range n.Routes {
+		dest, _, err := parseLxcRoute(route)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("network[0]: routes[%d]: %v", i, err))
+		case dest.IP.To4() != nil && n.IPv4Address == "":
+			errs = append(errs, fmt.Errorf("network[0]: routes[%d]: IPv4 routes require an ipv4_address", i))
+		case dest.IP.To4() == nil && n.IPv6Address == "":
+			errs = append(errs, fmt.Errorf("network[0]: routes[%d]: IPv6 routes require an ipv6_address", i))
+		}
+	}
+	return errs
+}
+
+// parseLxcRoute parses a route of the network block, which is written as
+// "<destination> via <gateway>", e.g. "10.8.0.0/16 via 192.168.1.1". The
+// destination and gateway must be of the same family.
+func parseLxcRoute(route string) (*net.IPNet, net.IP, error) {
+	fields := strings.Fields(route)
+	if len(fields) != 3 || fields[1] != "via" {
+		return nil, nil, fmt.Errorf("invalid route %q, expected \"<destination> via <gateway>\"", route)
+	}
+	_, dest, err := net.ParseCIDR(fields[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid route destination %q", fields[0])
+	}
+	gateway := net.ParseIP(fields[2])
+	if gateway == nil || (gateway.To4() == nil) != (dest.IP.To4() == nil) {
+		return nil, nil, fmt.Errorf("invalid route gateway %q", fields[2])
+	}
+	return dest, gateway, nil
+}
+
+// setLxcNetwork configures the container's network. Containers without a
+// network share the host's.
+func setLxcNetwork(c lxcContainerAPI, n LxcNetworkConfig) error {
+	if n.Type == lxcNetworkVeth && !hostBridgeExists(n.Bridge) {
+		return fmt.Errorf("bridge %q not found on the node", n.Bridge)
+	}
+	if n.Parent != "" && !hostInterfaceExists(n.Parent) {
+		return fmt.Errorf("parent interface %q not found on the node", n.Parent)
+	}
+
+	// LXC 2.1 replaced the lxc.network keys, where setting the type adds a
+	// network, with indexed lxc.net.<n> keys
+	prefix := "lxc.net.0."
+	legacy := false
+	if err := c.SetConfigItem(prefix+"type", n.Type); err != nil {
+		prefix = "lxc.network."
+		legacy = true
+	}
+	if legacy && n.Type == lxcNetworkIpvlan {
+		return fmt.Errorf("ipvlan networks require LXC 3.2 or later")
+	}
+	for _, item := range lxcNetworkItems(n) {
+		key := item[0]
+		if legacyKey, ok := lxcLegacyNetworkKeys[key]; ok && legacy {
+			key = legacyKey
+		}
+		if err := c.SetConfigItem(prefix+key, item[1]); err != nil {
+			return fmt.Errorf("error setting network %s configuration: %v", item[0], err)
+		}
+	}
+
+	// Router advertisements are configured through the interface's sysctl
+	// in the container's network namespace
+	if n.IPv6AcceptRA != nil {
+		value := "0"
+		if *n.IPv6AcceptRA {
+			value = "1"
+		}
+		if err := c.SetConfigItem(fmt.Sprintf("lxc.sysctl.net.ipv6.conf.%s.accept_ra", n.interfaceName()), value); err != nil {
+			return fmt.Errorf("ipv6_accept_ra requires LXC 3.0 or later: %v", err)
+		}
+	}
+	return setLxcRoutes(c, n.Routes)
+}
+
+// lxcNetworkItems returns the config items of the network, by their key
+// under the network's prefix.
+func lxcNetworkItems(n LxcNetworkConfig) [][2]string {
+	items := [][2]string{{"type", n.Type}}
+	if !n.hasInterface() {
+		return items
+	}
+	name := n.interfaceName()
+	link := n.Bridge
+	if n.Type != lxcNetworkVeth {
+		link = n.Parent
+	}
+	items = append(items, [2]string{"link", link}, [2]string{"name", name}, [2]string{"flags", "up"})
+	if n.Mode != "" {
+		items = append(items, [2]string{n.Type + ".mode", n.Mode})
+	}
+	if n.HWAddr != "" {
+		items = append(items, [2]string{"hwaddr", n.HWAddr})
+	}
+	if n.VlanID != 0 {
+		// veth networks tag the container's traffic on VLAN aware bridges
+		key := "vlan.id"
+		if n.Type == lxcNetworkVeth {
+			key = "veth.vlan.id"
+		}
+		items = append(items, [2]string{key, strconv.Itoa(n.VlanID)})
+	}
+	if n.IPv4Address != "" {
+		items = append(items, [2]string{"ipv4.address", n.IPv4Address})
+	}
+	if n.IPv4Gateway != "" {
+		items = append(items, [2]string{"ipv4.gateway", n.IPv4Gateway})
+	}
+	if n.IPv6Address != "" {
+		items = append(items, [2]string{"ipv6.address", n.IPv6Address})
+	}
+	if n.IPv6Gateway != "" {
+		items = append(items, [2]string{"ipv6.gateway", n.IPv6Gateway})
+	}
+	return items
+}
+
+// setLxcRoutes adds the routes to the container's network namespace before
+// its init runs. LXC has no config items for routes, so they are added by a
+// start-host hook generated in the container's directory, which enters the
+// container's network namespace from the host so the container's root
+// filesystem needs no networking tools.
+func setLxcRoutes(c lxcContainerAPI, routes []string) error {
+	if len(routes) == 0 {
+		return nil
+	}
+
+	var b bytes.Buffer
+	b.WriteString("#!/bin/sh\n")
+	fmt.Fprintf(&b, "# Generated by Nomad for the container %s, do not edit\n", c.Name())
+	b.WriteString("set -e\n")
+	for _, route := range routes {
+		// The routes were checked by validate()
+		dest, gateway, _ := parseLxcRoute(route)
+		fmt.Fprintf(&b, "nsenter --target \"$LXC_PID\" --net ip route replace %s via %s\n", dest, gateway)
+	}
+
+	file := filepath.Join(filepath.Dir(c.ConfigFileName()), lxcRoutesHookFile)
+	if err := ioutil.WriteFile(file, b.Bytes(), 0755); err != nil {
+		return fmt.Errorf("unable to write routes hook: %v", err)
+	}
+	if err := c.SetConfigItem("lxc.hook.start-host", file); err != nil {
+		return fmt.Errorf("routes require LXC 3.0 or later: %v", err)
+	}
+	return nil
+}
+
+// containerNetwork returns the network of the started container, so that the
+// task's services advertise the container's address rather than the host's.
+// Containers sharing the host's network have none, and a container whose
+// interface gets no address in time falls back to the host's. IPv4 addresses
+// are preferred, and a global IPv6 address is only advertised if the
+// interface gets no IPv4 address in time.
+func (d *LxcDriver) containerNetwork(c lxcContainerAPI, n LxcNetworkConfig) *cstructs.DriverNetwork {
+	if !n.hasInterface() {
+		return nil
+	}
+
+	// The addresses were checked by validate()
+	for _, addr := range []string{n.IPv4Address, n.IPv6Address} {
+		if addr != "" {
+			ip, _, _ := net.ParseCIDR(addr)
+			return &cstructs.DriverNetwork{IP: ip.String(), AutoAdvertise: true}
+		}
+	}
+
+	name := n.interfaceName()
+	deadline := time.Now().Add(d.config.ReadDurationDefault(lxcIPWaitConfigOption, lxcIPWaitDefault))
+	for {
+		if ips, err := c.IPv4Address(name); err == nil && len(ips) != 0 {
+			return &cstructs.DriverNetwork{IP: ips[0], AutoAdvertise: true}
+		}
+		if !c.Running() || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(lxcIPPollIntv)
+	}
+	if ips, err := c.IPv6Address(name); err == nil {
+		for _, addr := range ips {
+			if ip := net.ParseIP(addr); ip != nil && ip.IsGlobalUnicast() {
+				return &cstructs.DriverNetwork{IP: ip.String(), AutoAdvertise: true}
+			}
+		}
+	}
+	d.logger.Printf("[WARN] driver.lxc: no address found on interface %s of container %q, services will advertise the host's", name, c.Name())
+	return nil
+}
+
+// shareNetwork makes the container join the network namespace of the
+// container of the named task of the same allocation, waiting for it to
+// run as the tasks of a group start concurrently. It returns the network
+// config of the joined container, whose interface the container shares.
+func (d *LxcDriver) shareNetwork(c lxcContainerAPI, task string) (LxcNetworkConfig, lxcContainerAPI, error) {
+	name := lxcContainerName(task, d.DriverContext.allocID)
+	if name == c.Name() {
+		return LxcNetworkConfig{}, nil, fmt.Errorf("network_mode: task can't join its own network")
+	}
+
+	deadline := time.Now().Add(lxcShareNetworkWaitTimeout)
+	for {
+		shared, err := openLxcContainer(d.backend, name, findLxcPath(d.backend, d.config, name))
+		if err == nil && shared.Running() {
+			// LXC resolves names in the container's own LXC path, so the
+			// namespace is shared by the PID of the container's init
+			pid := strconv.Itoa(shared.InitPid())
+			if err := c.SetConfigItem("lxc.namespace.share.net", pid); err != nil {
+				d.backend.Release(shared)
+				return LxcNetworkConfig{}, nil, fmt.Errorf("network_mode %q requires LXC 3.1 or later: %v", lxcNetworkModeTaskPrefix+task, err)
+			}
+			d.logger.Printf("[DEBUG] driver.lxc: container %q joins the network of container %q", c.Name(), name)
+			return lxcContainerNetworkConfig(shared), shared, nil
+		}
+		if err == nil {
+			d.backend.Release(shared)
+		}
+		if time.Now().After(deadline) {
+			return LxcNetworkConfig{}, nil, structs.NewRecoverableError(
+				fmt.Errorf("container of task %q whose network is to be joined isn't running", task), true)
+		}
+		time.Sleep(lxcIPPollIntv)
+	}
+}
+
+// joinNetns makes the container join the network namespace at path, waiting
+// for it to be created as whatever sets it up may run concurrently with the
+// task.
+func (d *LxcDriver) joinNetns(c lxcContainerAPI, path string) error {
+	deadline := time.Now().Add(lxcShareNetworkWaitTimeout)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("unable to find network namespace %q: %v", path, err)
+		}
+		if time.Now().After(deadline) {
+			return structs.NewRecoverableError(fmt.Errorf("network namespace %q to be joined doesn't exist", path), true)
+		}
+		time.Sleep(lxcIPPollIntv)
+	}
+	if err := c.SetConfigItem("lxc.namespace.share.net", path); err != nil {
+		return fmt.Errorf("network_mode %q requires LXC 4.0 or later: %v", lxcNetworkModeNetnsPrefix+path, err)
+	}
+	d.logger.Printf("[DEBUG] driver.lxc: container %q joins network namespace %q", c.Name(), path)
+	return nil
+}
+
+// lxcContainerNetworkConfig returns the type and interface name of the
+// container's network from its config.
+func lxcContainerNetworkConfig(c lxcContainerAPI) LxcNetworkConfig {
+	var n LxcNetworkConfig
+	for _, prefix := range []string{"lxc.net.0.", "lxc.network."} {
+		if v := c.ConfigItem(prefix + "type"); len(v) != 0 && v[0] != "" {
+			n.Type = v[0]
+			if name := c.ConfigItem(prefix + "name"); len(name) != 0 {
+				n.Name = name[0]
+			}
+			break
+		}
+	}
+	return n
+}
+
+// interfaceName returns the name of the container's interface.
+func (n *LxcNetworkConfig) interfaceName() string {
+	if n.Name == "" {
+		return lxcDefaultInterface
+	}
+	return n.Name
+}
+
+// hostBridgeExists returns whether the host has the named bridge.
+func hostBridgeExists(name string) bool {
+	_, err := os.Stat(filepath.Join(sysClassNet, name, "bridge"))
+	return err == nil
+}
+
+// hostInterfaceExists returns whether the host has the named interface.
+func hostInterfaceExists(name string) bool {
+	_, err := os.Stat(filepath.Join(sysClassNet, name))
+	return err == nil
+}
+
+// fingerprintBridges advertises the host's bridges, which veth networks of
+// tasks may attach to.
+func (d *LxcDriver) fingerprintBridges(node *structs.Node) {
+	ifaces, err := ioutil.ReadDir(sysClassNet)
+	if err != nil {
+		return
+	}
+	var bridges []string
+	for _, iface := range ifaces {
+		if hostBridgeExists(iface.Name()) {
+			bridges = append(bridges, iface.Name())
+		}
+	}
+	if len(bridges) == 0 {
+		delete(node.Attributes, "driver.lxc.bridges")
+		return
+	}
+	sort.Strings(bridges)
+	node.Attributes["driver.lxc.bridges"] = strings.Join(bridges, ",")
+}
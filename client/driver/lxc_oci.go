@@ -0,0 +1,89 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// lxcOCITemplate is the template creating containers from OCI images,
+	// which it copies with skopeo and unpacks with umoci.
+	lxcOCITemplate = "oci"
+
+	// lxcOCIDefaultTransport is the transport of OCI image references given
+	// without one, pulling them from a registry
+	lxcOCIDefaultTransport = "docker://"
+)
+
+var (
+	// lxcOCITools are the host tools the oci template requires.
+	lxcOCITools = []string{"skopeo", "umoci"}
+
+	// lxcOCITransports are the skopeo transports of OCI image references.
+	lxcOCITransports = []string{"docker://", "oci:", "oci-archive:", "docker-archive:", "dir:"}
+)
+
+// lxcOCIImageURL returns the skopeo URL of the OCI image reference, such as
+// "docker://alpine:3.8" for "alpine:3.8".
+func lxcOCIImageURL(image string) string {
+	for _, transport := range lxcOCITransports {
+		if strings.HasPrefix(image, transport) {
+			return image
+		}
+	}
+	return lxcOCIDefaultTransport + image
+}
+
+// validateOCIImage checks the oci_image key against the template keys. The
+// oci template takes none of the image keys, and fails on the release, arch
+// and flush_cache flags passed to other templates.
+func (c *LxcDriverConfig) validateOCIImage() []error {
+	if c.OCIImage == "" {
+		if c.Template == "" && c.RootfsArchive == "" {
+			return []error{fmt.Errorf("one of template, oci_image or rootfs_archive is required")}
+		}
+		return nil
+	}
+
+	var errs []error
+	if strings.ContainsAny(c.OCIImage, " \t\n") {
+		errs = append(errs, fmt.Errorf("invalid oci_image %q", c.OCIImage))
+	}
+	var image LxcImageConfig
+	if len(c.Image) != 0 {
+		image = c.Image[0]
+	}
+	invalid := []struct {
+		key string
+		set bool
+	}{
+		{"template", c.Template != ""},
+		{"image", len(c.Image) != 0},
+		{"release", c.Release != "" || image.Release != ""},
+		{"arch", c.Arch != "" || image.Arch != ""},
+		{"flush_cache", c.FlushCache || image.FlushCache},
+	}
+	for _, o := range invalid {
+		if o.set {
+			errs = append(errs, fmt.Errorf("%q can't be combined with oci_image", o.key))
+		}
+	}
+	return errs
+}
+
+// checkOCITools returns an error if the tools the oci template requires are
+// missing, which it would otherwise only report in the container's log.
+func (d *LxcDriver) checkOCITools() error {
+	var missing []string
+	for _, tool := range lxcOCITools {
+		if _, err := d.backend.LookPath(tool); err != nil {
+			missing = append(missing, tool)
+		}
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("oci_image requires %s on the client, missing %s", strings.Join(lxcOCITools, " and "), strings.Join(missing, ", "))
+	}
+	return nil
+}
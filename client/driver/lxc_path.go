@@ -0,0 +1,135 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hashicorp/nomad/client/config"
+)
+
+const (
+	// lxcPathConfigOption is the key for the LXC paths containers are
+	// created under. It is a comma separated list of candidates in order of
+	// preference, such as a dedicated mount followed by a fallback.
+	lxcPathConfigOption = "driver.lxc.path"
+
+	// lxcPathRequireMountConfigOption is the key for requiring candidate LXC
+	// paths to be mount points, so that a path whose dedicated mount is
+	// missing isn't filled up in its place.
+	lxcPathRequireMountConfigOption = "driver.lxc.path_require_mount"
+
+	// accessWrite is the W_OK mode of access(2)
+	accessWrite = 0x2
+)
+
+// readLxcPaths returns the candidate LXC paths in order of preference.
+func readLxcPaths(cfg *config.Config) []string {
+	var paths []string
+	for _, path := range strings.Split(cfg.Read(lxcPathConfigOption), ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return []string{defaultLxcBackend.DefaultLxcPath()}
+	}
+	return paths
+}
+
+// readLxcPath returns the preferred LXC path containers are created under.
+func readLxcPath(cfg *config.Config) string {
+	return readLxcPaths(cfg)[0]
+}
+
+// findLxcPath returns the candidate LXC path the named container is defined
+// under, or the preferred path if it isn't defined.
+func findLxcPath(backend lxcBackend, cfg *config.Config, name string) string {
+	for _, path := range readLxcPaths(cfg) {
+		if lxcContainerDefined(backend, path, name) {
+			return path
+		}
+	}
+	return readLxcPath(cfg)
+}
+
+// lxcContainerDefined returns whether the named container is defined under
+// the LXC path.
+func lxcContainerDefined(backend lxcBackend, path, name string) bool {
+	for _, defined := range backend.DefinedContainerNames(path) {
+		if defined == name {
+			return true
+		}
+	}
+	return false
+}
+
+// selectLxcPath returns the LXC path to create the named container under.
+// An existing container is used where it is. Otherwise the first usable
+// candidate is chosen, skipping those whose mount is missing or read-only.
+// A single candidate is used without being checked, as there is nothing to
+// fail over to.
+func (d *LxcDriver) selectLxcPath(name string) (string, error) {
+	paths := readLxcPaths(d.config)
+	if len(paths) == 1 {
+		return paths[0], nil
+	}
+	for _, path := range paths {
+		if lxcContainerDefined(d.backend, path, name) {
+			return path, nil
+		}
+	}
+
+	requireMount := d.config.ReadBoolDefault(lxcPathRequireMountConfigOption, false)
+	var errs []string
+	for _, path := range paths {
+		if err := checkLxcPath(path, requireMount); err != nil {
+			d.logger.Printf("[WARN] driver.lxc: LXC path %s is unusable: %v", path, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", path, err))
+			continue
+		}
+		if len(errs) != 0 {
+			d.emitEvent("Using fallback LXC path %s, unusable: %s", path, strings.Join(errs, "; "))
+		}
+		return path, nil
+	}
+	return "", fmt.Errorf("no usable LXC path: %s", strings.Join(errs, "; "))
+}
+
+// checkLxcPath returns why containers can't be created under the LXC path,
+// or nil if they can.
+func checkLxcPath(path string, requireMount bool) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("path is missing")
+		}
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("path is not a directory")
+	}
+
+	if requireMount && filepath.Dir(path) != path {
+		parent, err := os.Stat(filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		if fi.Sys().(*syscall.Stat_t).Dev == parent.Sys().(*syscall.Stat_t).Dev {
+			return fmt.Errorf("path is not a mount point")
+		}
+	}
+
+	// Fails with EROFS on read-only filesystems, even for root
+	if err := syscall.Access(path, accessWrite); err != nil {
+		if err == syscall.EROFS {
+			return fmt.Errorf("path is read-only")
+		}
+		return fmt.Errorf("path is not writable: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+const (
+	// lxcCreateTimeoutConfigOption is the key for how long creating a
+	// container from its template may take. Zero disables the timeout.
+	lxcCreateTimeoutConfigOption = "driver.lxc.create_timeout"
+
+	// lxcCreateParallelismConfigOption and lxcStartParallelismConfigOption
+	// are the keys for how many containers may be created or started on the
+	// node at once. Zero allows any number.
+	lxcCreateParallelismConfigOption = "driver.lxc.create_parallelism"
+	lxcStartParallelismConfigOption  = "driver.lxc.start_parallelism"
+)
+
+var (
+	// lxcCreatePhase and lxcStartPhase limit how many containers are
+	// created and started on the node at once
+	lxcCreatePhase = &lxcPhase{name: "create", option: lxcCreateParallelismConfigOption}
+	lxcStartPhase  = &lxcPhase{name: "start", option: lxcStartParallelismConfigOption}
+)
+
+// lxcPhase limits the parallelism of a phase of starting containers across
+// all tasks on the node. The limit is read from the client config on each
+// use, so it follows reloads of the config.
+type lxcPhase struct {
+	name   string
+	option string
+
+	active int
+	cond   *sync.Cond
+	lock   sync.Mutex
+}
+
+// acquire waits for a slot in the phase, emitting a task event if it has to
+// wait, and returns the function releasing it. An error is returned if the
+// context is done before a slot is free. Lowering the limit doesn't
+// interrupt the phases running, but holds back others until enough of them
+// finished.
+func (p *lxcPhase) acquire(ctx context.Context, d *LxcDriver) (func(), error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.cond == nil {
+		p.cond = sync.NewCond(&p.lock)
+	}
+	if p.full(d) {
+		p.lock.Unlock()
+		d.emitEvent("Waiting for another container %s to finish", p.name)
+
+		// Wake the waiting task once the context is done
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		go func() {
+			select {
+			case <-ctx.Done():
+				p.lock.Lock()
+				p.cond.Broadcast()
+				p.lock.Unlock()
+			case <-stopCh:
+			}
+		}()
+
+		p.lock.Lock()
+		for p.full(d) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			p.cond.Wait()
+		}
+	}
+	p.active++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.lock.Lock()
+			p.active--
+			p.lock.Unlock()
+			p.cond.Broadcast()
+		})
+	}, nil
+}
+
+// full returns whether the phase has no free slot. The limit is read from
+// the options as last reloaded, as the driver's may predate the reload.
+func (p *lxcPhase) full(d *LxcDriver) bool {
+	limit := lxcReloaded.apply(d.config).ReadIntDefault(p.option, 0)
+	return limit > 0 && p.active >= limit
+}
+
+// wake makes the tasks waiting for a slot check the limit again, once it
+// may have been raised.
+func (p *lxcPhase) wake() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.cond != nil {
+		p.cond.Broadcast()
+	}
+}
+
+// createTimeout returns how long creating the container of the task config
+// may take: its create_timeout, or the client's create timeout.
+func (d *LxcDriver) createTimeout(config *LxcDriverConfig) time.Duration {
+	if config.CreateTimeout != "" {
+		// The format was checked in Validate()
+		timeout, _ := time.ParseDuration(config.CreateTimeout)
+		return timeout
+	}
+	return d.config.ReadDurationDefault(lxcCreateTimeoutConfigOption, 0)
+}
+
+// createContainer creates the container from its template, subject to the
+// create parallelism and the timeout, with the variables in the template's
+// environment and holding the lock of the template's cache. The timeout
+// bounds the whole create, waiting for a slot and for the cache included, so
+// a template stuck downloading its image fails its task rather than holding
+// back the others. The template is then killed and the container destroyed.
+// Zero disables the timeout.
+func (d *LxcDriver) createContainer(c lxcContainerAPI, options lxc.TemplateOptions, env map[string]string, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	err := d.create(ctx, c, options, env)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		if c.Defined() {
+			if err := c.Destroy(); err != nil {
+				d.logger.Printf("[ERR] driver.lxc: unable to destroy container %q after create timed out: %v", c.Name(), err)
+			}
+		}
+		return structs.NewRecoverableError(fmt.Errorf("creating container timed out after %v", timeout), true)
+	}
+	return err
+}
+
+// create creates the container once it has a create slot and the lock of
+// the template's cache, unless the context is done first.
+func (d *LxcDriver) create(ctx context.Context, c lxcContainerAPI, options lxc.TemplateOptions, env map[string]string) error {
+	release, err := lxcCreatePhase.acquire(ctx, d)
+	if err != nil {
+		return err
+	}
+	defer release()
+	unlock, err := d.lockTemplateCache(ctx, options)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	out, err := d.backend.Create(ctx, c, options, lxcTemplateEnviron(env))
+	if output := strings.TrimSpace(string(out)); err != nil && output != "" {
+		return fmt.Errorf("%v: %s", err, output)
+	}
+	return err
+}
+
+// startContainer starts the container, subject to the start parallelism.
+func (d *LxcDriver) startContainer(c lxcContainerAPI) error {
+	release, err := lxcStartPhase.acquire(context.Background(), d)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return c.Start()
+}
+
+// lxcTemplateEnviron returns the environment templates run with: the
+// client's, with the variables set. The client's own environment is left as
+// is, as the other processes it starts inherit it.
+func lxcTemplateEnviron(vars map[string]string) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name := kv
+		if i := strings.Index(kv, "="); i != -1 {
+			name = kv[:i]
+		}
+		if _, ok := vars[name]; !ok {
+			env = append(env, kv)
+		}
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, name+"="+vars[name])
+	}
+	return env
+}
@@ -0,0 +1,225 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/nomad/helper/uuid"
+)
+
+const (
+	// lxcWarmPoolConfigOption is the key for the number of stopped
+	// containers kept created from each image of driver.lxc.prepull_images,
+	// for tasks using the image to claim instead of creating their own.
+	lxcWarmPoolConfigOption = "driver.lxc.warm_pool_size"
+
+	// lxcPoolContainerPrefix prefixes the names of the containers of the
+	// warm pool, which aren't created for tasks
+	lxcPoolContainerPrefix = "nomad-pool-"
+
+	// lxcPoolCreatingPrefix prefixes the names of the containers being
+	// created for the warm pool. LXC defines containers before their
+	// template runs, so they are only renamed into the pool once created
+	// rather than claimed or destroyed half created.
+	lxcPoolCreatingPrefix = "nomad-pooling-"
+)
+
+// lxcPoolContainerPrefixFor returns the prefix of the names of the pooled
+// containers of the image, followed by a random suffix.
+func lxcPoolContainerPrefixFor(image lxcPrepullImage) string {
+	return lxcPoolContainerPrefix + lxcCacheLockNameRe.ReplaceAllString(image.String(), "_") + "-"
+}
+
+// poolImage returns the image the task's container can be claimed from the
+// warm pool for. Only containers the download template would create exactly
+// as the pool does, from the default image server without extra template
+// arguments onto the dir backend, can be claimed.
+func (c *LxcDriverConfig) poolImage() (lxcPrepullImage, bool) {
+	if c.Template != lxcDownloadTemplate || c.ImageServer != "" || c.ImageSerial != "" ||
+		c.GPGKeyID != "" || c.GPGKeyServer != "" || c.FlushCache || len(c.TemplateArgs) != 0 ||
+		(c.Backend != "" && c.Backend != lxcBackendDir) {
+		return lxcPrepullImage{}, false
+	}
+	return lxcPrepullImage{Distro: c.Distro, Release: c.Release, Arch: c.Arch, Variant: c.ImageVariant}, true
+}
+
+// lxcPool serializes the claims of pooled containers, so that a container
+// isn't claimed by two tasks at once, and tracks the images whose pool is
+// being filled and the containers being created for them.
+var lxcPool = &lxcPoolTracker{}
+
+type lxcPoolTracker struct {
+	claimLock sync.Mutex
+	fills     lxcPrepullTracker
+
+	// creating are the paths of the containers being created for the
+	// pools, guarded by the claim lock
+	creating map[string]bool
+}
+
+// pooledContainers returns the sorted names of the pooled containers of the
+// image under the LXC path.
+func (d *LxcDriver) pooledContainers(image lxcPrepullImage, lxcPath string) []string {
+	prefix := lxcPoolContainerPrefixFor(image)
+	var names []string
+	for _, name := range d.backend.DefinedContainerNames(lxcPath) {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// claimPooled renames a pooled container of the image to the task's
+// container, returning whether one was claimed. The pool is refilled in the
+// background.
+func (d *LxcDriver) claimPooled(image lxcPrepullImage, name, lxcPath string) bool {
+	if d.config.ReadIntDefault(lxcWarmPoolConfigOption, 0) <= 0 {
+		return false
+	}
+	lxcPool.claimLock.Lock()
+	defer lxcPool.claimLock.Unlock()
+
+	for _, pooled := range d.pooledContainers(image, lxcPath) {
+		c, err := openLxcContainer(d.backend, pooled, lxcPath)
+		if err != nil {
+			continue
+		}
+		err = c.Rename(name)
+		d.backend.Release(c)
+		if err != nil {
+			d.logger.Printf("[WARN] driver.lxc: unable to claim pooled container %q: %v", pooled, err)
+			continue
+		}
+		d.logger.Printf("[DEBUG] driver.lxc: claimed pooled container %q as %q", pooled, name)
+		d.emitEvent("Claimed a container of image %s from the warm pool", image)
+		d.fillPool(image, lxcPath)
+		return true
+	}
+	return false
+}
+
+// fingerprintWarmPool keeps the configured number of containers pooled for
+// each cached image of the prepull option, filling the pools in the
+// background, and destroys the pooled containers no longer wanted.
+func (d *LxcDriver) fingerprintWarmPool() {
+	images, err := parseLxcPrepullImages(d.config.Read(lxcPrepullConfigOption))
+	if err != nil {
+		return
+	}
+	size := d.config.ReadIntDefault(lxcWarmPoolConfigOption, 0)
+	lxcPath := readLxcPath(d.config)
+
+	wanted := make(map[string]bool)
+	for _, image := range images {
+		pooled := d.pooledContainers(image, lxcPath)
+		for i, name := range pooled {
+			if i < size {
+				wanted[name] = true
+			}
+		}
+		if size > 0 && len(pooled) < size && d.cachedImageSerial(image.templateOptions()) != "" {
+			d.fillPool(image, lxcPath)
+		}
+	}
+
+	// Containers left half created, as by a client crash, are destroyed
+	// too
+	for _, name := range d.backend.DefinedContainerNames(lxcPath) {
+		if strings.HasPrefix(name, lxcPoolContainerPrefix) && !wanted[name] {
+			lxcPool.claimLock.Lock()
+			d.destroyPooled(name, lxcPath)
+			lxcPool.claimLock.Unlock()
+		} else if strings.HasPrefix(name, lxcPoolCreatingPrefix) {
+			lxcPool.claimLock.Lock()
+			if !lxcPool.creating[filepath.Join(lxcPath, name)] {
+				d.destroyPooled(name, lxcPath)
+			}
+			lxcPool.claimLock.Unlock()
+		}
+	}
+}
+
+// fillPool creates the pooled containers missing from the image's pool in
+// the background, unless its pool is already being filled.
+func (d *LxcDriver) fillPool(image lxcPrepullImage, lxcPath string) {
+	if !lxcPool.fills.start(image.String()) {
+		return
+	}
+	pd := d.backgroundDriver()
+	go func() {
+		defer lxcPool.fills.done(image.String())
+		size := pd.config.ReadIntDefault(lxcWarmPoolConfigOption, 0)
+		for missing := size - len(pd.pooledContainers(image, lxcPath)); missing > 0; missing-- {
+			if !pd.createPooled(image, lxcPath) {
+				return
+			}
+		}
+	}()
+}
+
+// createPooled creates a stopped container from the image for the pool, with
+// the client's mirrors, credentials and proxy settings like prepulled
+// images. The container is created under a name out of the pool, and only
+// renamed into it once created.
+func (d *LxcDriver) createPooled(image lxcPrepullImage, lxcPath string) bool {
+	suffix := uuid.Generate()[:8]
+	creating := lxcPoolCreatingPrefix + suffix
+	name := lxcPoolContainerPrefixFor(image) + suffix
+	c, err := d.backend.NewContainer(creating, lxcPath)
+	if err != nil {
+		d.logger.Printf("[WARN] driver.lxc: unable to create pooled container of image %s: %v", image, err)
+		return false
+	}
+	defer d.backend.Release(c)
+
+	key := filepath.Join(lxcPath, creating)
+	lxcPool.claimLock.Lock()
+	if lxcPool.creating == nil {
+		lxcPool.creating = make(map[string]bool)
+	}
+	lxcPool.creating[key] = true
+	lxcPool.claimLock.Unlock()
+	defer func() {
+		lxcPool.claimLock.Lock()
+		delete(lxcPool.creating, key)
+		lxcPool.claimLock.Unlock()
+	}()
+
+	options := image.templateOptions()
+	d.applyImageMirrors(&options)
+	config := &LxcDriverConfig{}
+	d.applyImageServerAuth(&options, config)
+	if err := d.createContainer(c, options, d.templateEnv(config), d.createTimeout(config)); err != nil {
+		d.logger.Printf("[WARN] driver.lxc: unable to create pooled container of image %s: %v", image, err)
+		if c.Defined() {
+			c.Destroy()
+		}
+		return false
+	}
+	if err := c.Rename(name); err != nil {
+		d.logger.Printf("[WARN] driver.lxc: unable to add container %q to the pool of image %s: %v", creating, image, err)
+		c.Destroy()
+		return false
+	}
+	d.logger.Printf("[DEBUG] driver.lxc: created pooled container %q", name)
+	return true
+}
+
+// destroyPooled destroys the named pooled container.
+func (d *LxcDriver) destroyPooled(name, lxcPath string) {
+	c, err := openLxcContainer(d.backend, name, lxcPath)
+	if err != nil {
+		return
+	}
+	defer d.backend.Release(c)
+	d.logger.Printf("[DEBUG] driver.lxc: destroying pooled container %q", name)
+	if err := c.Destroy(); err != nil {
+		d.logger.Printf("[WARN] driver.lxc: unable to destroy pooled container %q: %v", name, err)
+	}
+}
@@ -0,0 +1,193 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/nomad/client/driver/env"
+	"github.com/hashicorp/nomad/nomad/structs"
+
+	cstructs "github.com/hashicorp/nomad/client/structs"
+)
+
+// lxcPortProtocols are the protocols the task's ports are forwarded for, as
+// ports are allocated for both.
+var lxcPortProtocols = []string{"tcp", "udp"}
+
+// lxcPortForward forwards a port of the host to a port of a container with
+// a veth network, through iptables DNAT rules.
+type lxcPortForward struct {
+	Container     string
+	Proto         string
+	HostIP        string
+	HostPort      int
+	ContainerIP   string
+	ContainerPort int
+}
+
+// parseLxcPortMap parses the port_map of the task config, which maps port
+// labels to the ports the task listens on in the container.
+func parseLxcPortMap(raw []map[string]string, taskEnv *env.TaskEnv) (map[string]int, error) {
+	portMap := make(map[string]int)
+	for _, m := range raw {
+		for k, v := range m {
+			if taskEnv != nil {
+				k, v = taskEnv.ReplaceEnv(k), taskEnv.ReplaceEnv(v)
+			}
+			p, err := strconv.Atoi(v)
+			if err != nil || p <= 0 || p > 65535 {
+				return nil, fmt.Errorf("port_map: invalid port %q for %q", v, k)
+			}
+			portMap[k] = p
+		}
+	}
+	return portMap, nil
+}
+
+// lxcTaskPorts calls fn with the host IP of each port allocated to the task.
+func lxcTaskPorts(task *structs.Task, fn func(ip string, port structs.Port)) {
+	if task.Resources == nil {
+		return
+	}
+	for _, n := range task.Resources.Networks {
+		for _, p := range n.ReservedPorts {
+			fn(n.IP, p)
+		}
+		for _, p := range n.DynamicPorts {
+			fn(n.IP, p)
+		}
+	}
+}
+
+// lxcPortEnv returns the port variables of the task's environment, as set by
+// the client for other drivers, for the container's init process.
+// NOMAD_PORT_<label> is the port the task listens on in the container, which
+// differs from the host's port if the port is mapped.
+func lxcPortEnv(task *structs.Task, portMap map[string]int) map[string]string {
+	vars := make(map[string]string)
+	lxcTaskPorts(task, func(ip string, p structs.Port) {
+		port := strconv.Itoa(p.Value)
+		vars[env.IpPrefix+p.Label] = ip
+		vars[env.HostPortPrefix+p.Label] = port
+		vars[env.AddrPrefix+p.Label] = net.JoinHostPort(ip, port)
+		vars[env.PortPrefix+p.Label] = port
+		if mapped, ok := portMap[p.Label]; ok {
+			vars[env.PortPrefix+p.Label] = strconv.Itoa(mapped)
+		}
+	})
+	return vars
+}
+
+// lxcPortForwards returns the forwards of the task's ports to the container
+// at the given address. Ports are forwarded to the same port of the
+// container unless mapped.
+func lxcPortForwards(name string, task *structs.Task, portMap map[string]int, containerIP string) []*lxcPortForward {
+	var forwards []*lxcPortForward
+	lxcTaskPorts(task, func(ip string, p structs.Port) {
+		containerPort := p.Value
+		if mapped, ok := portMap[p.Label]; ok {
+			containerPort = mapped
+		}
+		for _, proto := range lxcPortProtocols {
+			forwards = append(forwards, &lxcPortForward{
+				Container:     name,
+				Proto:         proto,
+				HostIP:        ip,
+				HostPort:      p.Value,
+				ContainerIP:   containerIP,
+				ContainerPort: containerPort,
+			})
+		}
+	})
+	return forwards
+}
+
+// rules returns the iptables rules of the forward as the table, chain and
+// rule specification. Connections to the host port are translated to the
+// container's, whether they come from outside or from the host itself, and
+// accepted ahead of any restrictive forwarding policy.
+func (f *lxcPortForward) rules() [][]string {
+	dest := net.JoinHostPort(f.ContainerIP, strconv.Itoa(f.ContainerPort))
+	comment := []string{"-m", "comment", "--comment", "nomad: " + f.Container}
+	dnat := append([]string{"-d", f.HostIP, "-p", f.Proto, "--dport", strconv.Itoa(f.HostPort)}, comment...)
+	dnat = append(dnat, "-j", "DNAT", "--to-destination", dest)
+	accept := append([]string{"-d", f.ContainerIP, "-p", f.Proto, "--dport", strconv.Itoa(f.ContainerPort)}, comment...)
+	accept = append(accept, "-j", "ACCEPT")
+	return [][]string{
+		append([]string{"nat", "PREROUTING"}, dnat...),
+		append([]string{"nat", "OUTPUT"}, dnat...),
+		append([]string{"filter", "FORWARD"}, accept...),
+	}
+}
+
+// lxcIptables adds or deletes the rule given as table, chain and
+// specification.
+func lxcIptables(backend lxcBackend, action string, rule []string) error {
+	args := append([]string{"-w", "-t", rule[0], action, rule[1]}, rule[2:]...)
+	if out, err := backend.CombinedOutput(context.Background(), "iptables", args...); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// forwardPorts forwards the task's ports to the container if it has its own
+// network, and returns the forwards so they are removed once the container
+// stops. Containers sharing the host's network listen on the host's ports.
+func (d *LxcDriver) forwardPorts(name string, task *structs.Task, n LxcNetworkConfig, portMap map[string]int, network *cstructs.DriverNetwork) ([]*lxcPortForward, error) {
+	if n.Type != lxcNetworkVeth {
+		return nil, nil
+	}
+	var ports bool
+	lxcTaskPorts(task, func(string, structs.Port) { ports = true })
+	if !ports {
+		return nil, nil
+	}
+	if network == nil {
+		return nil, fmt.Errorf("unable to forward ports: container %q has no address", name)
+	}
+	if ip := net.ParseIP(network.IP); ip == nil || ip.To4() == nil {
+		return nil, fmt.Errorf("unable to forward ports: container %q has no IPv4 address", name)
+	}
+
+	forwards := lxcPortForwards(name, task, portMap, network.IP)
+	for i, f := range forwards {
+		for j, rule := range f.rules() {
+			action := "-A"
+			if rule[1] == "FORWARD" {
+				action = "-I"
+			}
+			if err := lxcIptables(d.backend, action, rule); err != nil {
+				removeLxcPortForwards(d.backend, d.logger, forwards[:i])
+				for _, added := range f.rules()[:j] {
+					lxcIptables(d.backend, "-D", added)
+				}
+				return nil, fmt.Errorf("unable to forward port %d/%s to container %q: %v", f.HostPort, f.Proto, name, err)
+			}
+		}
+		d.logger.Printf("[DEBUG] driver.lxc: forwarding %s:%d/%s to %s:%d", f.HostIP, f.HostPort, f.Proto, f.ContainerIP, f.ContainerPort)
+	}
+	return forwards, nil
+}
+
+// removePortForwards removes the forwards of the task's ports once the
+// container has stopped, so its ports can be allocated to other tasks.
+func (h *lxcDriverHandle) removePortForwards() {
+	removeLxcPortForwards(h.backend, h.logger, h.portForwards)
+}
+
+// removeLxcPortForwards removes the iptables rules of the forwards.
+func removeLxcPortForwards(backend lxcBackend, logger *log.Logger, forwards []*lxcPortForward) {
+	for _, f := range forwards {
+		for _, rule := range f.rules() {
+			if err := lxcIptables(backend, "-D", rule); err != nil {
+				logger.Printf("[WARN] driver.lxc: unable to remove port forward of container %q: %v", f.Container, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,183 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+const (
+	// lxcPrepullConfigOption is the key for the images of the download
+	// template cached on the node ahead of the tasks using them, as a comma
+	// separated list of distro/release/arch images, optionally followed by
+	// their variant. Caching them spares the first task placed on the node
+	// the download of its image.
+	lxcPrepullConfigOption = "driver.lxc.prepull_images"
+
+	// lxcPrepullContainerPrefix prefixes the names of the containers
+	// created to cache images, which aren't created for tasks
+	lxcPrepullContainerPrefix = "nomad-prepull-"
+)
+
+// lxcPrepullImage is an image of the download template cached ahead of the
+// tasks using it.
+type lxcPrepullImage struct {
+	Distro  string
+	Release string
+	Arch    string
+	Variant string
+}
+
+func (i lxcPrepullImage) String() string {
+	s := i.Distro + "/" + i.Release + "/" + i.Arch
+	if i.Variant != "" {
+		s += "/" + i.Variant
+	}
+	return s
+}
+
+// templateOptions returns the options of the download template fetching
+// the image.
+func (i lxcPrepullImage) templateOptions() lxc.TemplateOptions {
+	return lxc.TemplateOptions{
+		Template: lxcDownloadTemplate,
+		Distro:   i.Distro,
+		Release:  i.Release,
+		Arch:     i.Arch,
+		Variant:  i.Variant,
+	}
+}
+
+// parseLxcPrepullImages parses the images of the prepull option.
+func parseLxcPrepullImages(value string) ([]lxcPrepullImage, error) {
+	var images []lxcPrepullImage
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		parts := strings.Split(s, "/")
+		if len(parts) != 3 && len(parts) != 4 {
+			return nil, fmt.Errorf("invalid image %q: must be distro/release/arch, optionally followed by /variant", s)
+		}
+		for _, part := range parts {
+			if !lxcImageVariantRe.MatchString(part) || part == "." || part == ".." {
+				return nil, fmt.Errorf("invalid image %q", s)
+			}
+		}
+		image := lxcPrepullImage{Distro: parts[0], Release: parts[1], Arch: parts[2]}
+		if len(parts) == 4 {
+			image.Variant = parts[3]
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// lxcPrepulls tracks the images being cached, which are cached once at a
+// time.
+var lxcPrepulls = &lxcPrepullTracker{}
+
+type lxcPrepullTracker struct {
+	running map[string]bool
+	lock    sync.Mutex
+}
+
+// start marks the image as being cached, returning false if it already is.
+func (t *lxcPrepullTracker) start(image string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.running == nil {
+		t.running = make(map[string]bool)
+	}
+	if t.running[image] {
+		return false
+	}
+	t.running[image] = true
+	return true
+}
+
+func (t *lxcPrepullTracker) done(image string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.running, image)
+}
+
+// fingerprintPrepull advertises the images of the prepull option cached on
+// the node as a comma separated list, and starts caching the others in the
+// background.
+func (d *LxcDriver) fingerprintPrepull(node *structs.Node) {
+	delete(node.Attributes, "driver.lxc.images")
+	images, err := parseLxcPrepullImages(d.config.Read(lxcPrepullConfigOption))
+	if err != nil {
+		d.logger.Printf("[WARN] driver.lxc: ignoring %s: %v", lxcPrepullConfigOption, err)
+		return
+	}
+
+	var cached []string
+	for _, image := range images {
+		if d.cachedImageSerial(image.templateOptions()) != "" {
+			cached = append(cached, image.String())
+			continue
+		}
+		if lxcPrepulls.start(image.String()) {
+			go func(image lxcPrepullImage) {
+				defer lxcPrepulls.done(image.String())
+				d.prepull(image)
+			}(image)
+		}
+	}
+	if len(cached) != 0 {
+		sort.Strings(cached)
+		node.Attributes["driver.lxc.images"] = strings.Join(cached, ",")
+	}
+}
+
+// backgroundDriver returns a copy of the fingerprinted driver for work done
+// in the background, which has no task to emit events for.
+func (d *LxcDriver) backgroundDriver() *LxcDriver {
+	bd := &LxcDriver{DriverContext: d.DriverContext, backend: d.backend}
+	bd.emitEvent = func(string, ...interface{}) {}
+	return bd
+}
+
+// prepull caches the image by creating a container from it with the
+// download template, subject to the create parallelism and the client's
+// mirrors, credentials and proxy settings, and destroying it.
+func (d *LxcDriver) prepull(image lxcPrepullImage) {
+	pd := d.backgroundDriver()
+	name := lxcPrepullContainerPrefix + lxcCacheLockNameRe.ReplaceAllString(image.String(), "_")
+	c, err := pd.backend.NewContainer(name, readLxcPath(pd.config))
+	if err != nil {
+		pd.logger.Printf("[WARN] driver.lxc: unable to cache image %s: %v", image, err)
+		return
+	}
+	defer pd.backend.Release(c)
+
+	// Containers left behind by a client that stopped while caching are
+	// recreated
+	if c.Defined() {
+		if err := c.Destroy(); err != nil {
+			pd.logger.Printf("[WARN] driver.lxc: unable to cache image %s: %v", image, err)
+			return
+		}
+	}
+
+	pd.logger.Printf("[INFO] driver.lxc: caching image %s", image)
+	options := image.templateOptions()
+	pd.applyImageMirrors(&options)
+	config := &LxcDriverConfig{}
+	pd.applyImageServerAuth(&options, config)
+	if err := pd.createContainer(c, options, pd.templateEnv(config), pd.createTimeout(config)); err != nil {
+		pd.logger.Printf("[WARN] driver.lxc: unable to cache image %s: %v", image, err)
+		return
+	}
+	if err := c.Destroy(); err != nil {
+		pd.logger.Printf("[WARN] driver.lxc: unable to destroy container %q caching image %s: %v", name, image, err)
+	}
+}
@@ -0,0 +1,131 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	dstructs "github.com/hashicorp/nomad/client/driver/structs"
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+// lxcPreserveFile is the file in the container's directory recording until
+// when the container is preserved after failing. It is removed with the
+// container.
+const lxcPreserveFile = ".nomad-preserve-until"
+
+// lxcPreservePath returns the path of the preserve file of the container.
+func lxcPreservePath(c lxcContainerAPI) string {
+	return filepath.Join(filepath.Dir(c.ConfigFileName()), lxcPreserveFile)
+}
+
+// preserveContainer records that the container is to be kept until the given
+// time rather than destroyed when the task is cleaned up.
+func preserveContainer(c lxcContainerAPI, until time.Time) error {
+	return ioutil.WriteFile(lxcPreservePath(c), []byte(until.UTC().Format(time.RFC3339)+"\n"), 0644)
+}
+
+// lxcPreservedUntil returns until when the container is preserved, or the
+// zero time if it isn't.
+func lxcPreservedUntil(c lxcContainerAPI) time.Time {
+	raw, err := ioutil.ReadFile(lxcPreservePath(c))
+	if err != nil {
+		return time.Time{}
+	}
+	until, err := time.Parse(time.RFC3339, strings.TrimSpace(string(raw)))
+	if err != nil {
+		return time.Time{}
+	}
+	return until
+}
+
+// unpreserveContainer clears the preservation of a container that started
+// again, so that it is destroyed when the task is cleaned up unless it fails
+// again.
+func unpreserveContainer(c lxcContainerAPI) error {
+	if err := os.Remove(lxcPreservePath(c)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// preserveOnFailure returns how long the task's container is preserved
+// after failing, or zero if it isn't.
+func (c *LxcDriverConfig) preserveOnFailure() time.Duration {
+	// The duration was checked by validate()
+	d, _ := time.ParseDuration(c.PreserveOnFailure)
+	return d
+}
+
+// preserveIfFailed preserves the container for the configured time if it
+// exited unsuccessfully, for its root filesystem to be inspected.
+func (h *lxcDriverHandle) preserveIfFailed(res *dstructs.WaitResult) {
+	if h.preserveOnFailure <= 0 || res.Successful() {
+		return
+	}
+	preserveFailed(h.container, time.Now().Add(h.preserveOnFailure), h.logger, h.emitEvent)
+}
+
+// preserveFailed preserves the failed container until the given time.
+func preserveFailed(c lxcContainerAPI, until time.Time, logger *log.Logger, emitEvent LogEventFn) {
+	name := c.Name()
+	if err := preserveContainer(c, until); err != nil {
+		logger.Printf("[WARN] driver.lxc: unable to preserve failed container %q: %v", name, err)
+		return
+	}
+	logger.Printf("[INFO] driver.lxc: preserving failed container %q until %s", name, until.Format(time.RFC3339))
+	emitEvent("Preserving the failed container until %s", until.Format(time.RFC3339))
+}
+
+// preserveFailedStart stops and preserves the named container that failed to
+// start if the task config preserves failed containers. Only containers
+// created from the task config, whose creation completed, are preserved.
+func (d *LxcDriver) preserveFailedStart(ctx *ExecContext, task *structs.Task, name string) bool {
+	config, err := NewLxcDriverConfig(task, ctx.TaskEnv)
+	if err != nil || config.preserveOnFailure() <= 0 {
+		return false
+	}
+
+	c, err := openLxcContainer(d.backend, name, findLxcPath(d.backend, d.config, name))
+	if err != nil {
+		return false
+	}
+	defer d.backend.Release(c)
+	if readLxcConfigHash(c) == "" {
+		return false
+	}
+	if c.Running() {
+		if err := c.Stop(); err != nil {
+			d.logger.Printf("[WARN] driver.lxc: unable to stop failed container %q: %v", name, err)
+			return false
+		}
+	}
+	preserveFailed(c, time.Now().Add(config.preserveOnFailure()), d.logger, d.emitEvent)
+	return true
+}
+
+// destroyPreserved destroys the named container once it is no longer
+// preserved. Containers whose timer is lost to the client restarting are left
+// to be pruned once their preservation runs out.
+func (d *LxcDriver) destroyPreserved(name string, until time.Time) {
+	time.AfterFunc(time.Until(until), func() {
+		c, err := openLxcContainer(d.backend, name, findLxcPath(d.backend, d.config, name))
+		if err != nil {
+			return
+		}
+		defer d.backend.Release(c)
+		if next := lxcPreservedUntil(c); next.After(until) {
+			d.destroyPreserved(name, next)
+			return
+		}
+		d.logger.Printf("[INFO] driver.lxc: destroying preserved container %q", name)
+		if err := destroyLxcContainer(d.backend, c); err != nil {
+			d.logger.Printf("[ERR] driver.lxc: %v", err)
+		}
+	})
+}
@@ -0,0 +1,91 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+const (
+	// lxcPrestartCheckConfigOption is the key for a host command run before
+	// each LXC task starts, which rejects the task by exiting non-zero. It
+	// lets sites enforce local policies the scheduler doesn't know about.
+	lxcPrestartCheckConfigOption = "driver.lxc.prestart_check"
+
+	// lxcPrestartCheckTimeoutConfigOption is the key for how long the
+	// prestart check may run
+	lxcPrestartCheckTimeoutConfigOption = "driver.lxc.prestart_check_timeout"
+	lxcPrestartCheckTimeoutDefault      = 30 * time.Second
+
+	// lxcPrestartCheckMessageMaxLen bounds the length of the check's output
+	// reported as the reason the task was rejected
+	lxcPrestartCheckMessageMaxLen = 1024
+)
+
+// lxcPrestartCheckInput is the JSON written to the prestart check's
+// standard input.
+type lxcPrestartCheckInput struct {
+	AllocID       string
+	NodeID        string
+	Task          string
+	ContainerName string
+	Config        map[string]interface{}
+	Resources     *structs.Resources
+}
+
+// prestartCheck runs the configured prestart check for the task. A check
+// exiting non-zero fails the task with its output as the reason, and isn't
+// retried on the node. Failing to run the check is recoverable.
+func (d *LxcDriver) prestartCheck(task *structs.Task) error {
+	args := strings.Fields(d.config.Read(lxcPrestartCheckConfigOption))
+	if len(args) == 0 {
+		return nil
+	}
+
+	input := lxcPrestartCheckInput{
+		AllocID:       d.DriverContext.allocID,
+		Task:          task.Name,
+		ContainerName: lxcContainerName(task.Name, d.DriverContext.allocID),
+		Config:        task.Config,
+		Resources:     task.Resources,
+	}
+	if d.DriverContext.node != nil {
+		input.NodeID = d.DriverContext.node.ID
+	}
+	stdin, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("unable to encode prestart check input: %v", err)
+	}
+
+	timeout := d.config.ReadDurationDefault(lxcPrestartCheckTimeoutConfigOption, lxcPrestartCheckTimeoutDefault)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	out, err := d.backend.CombinedOutputWithInput(ctx, stdin, args[0], args[1:]...)
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return structs.NewRecoverableError(fmt.Errorf("prestart check timed out after %v", timeout), true)
+	}
+	if _, ok := err.(*exec.ExitError); !ok {
+		return structs.NewRecoverableError(fmt.Errorf("unable to run prestart check: %v", err), true)
+	}
+
+	msg := strings.TrimSpace(string(out))
+	if len(msg) > lxcPrestartCheckMessageMaxLen {
+		msg = msg[:lxcPrestartCheckMessageMaxLen] + "..."
+	}
+	if msg == "" {
+		msg = err.Error()
+	}
+	d.logger.Printf("[WARN] driver.lxc: prestart check rejected task %q of allocation %s: %s", task.Name, d.DriverContext.allocID, msg)
+	return structs.NewRecoverableError(fmt.Errorf("rejected by prestart check: %s", msg), false)
+}
@@ -0,0 +1,86 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// lxcProvisionTimeoutConfigOption is the key for how long each of the
+	// task's provisioning commands may run.
+	lxcProvisionTimeoutConfigOption = "driver.lxc.provision_timeout"
+	lxcProvisionTimeoutDefault      = 5 * time.Minute
+
+	// lxcPrivilegedProvisionConfigOption is the key for allowing the
+	// provisioning commands of privileged containers, which run as the
+	// host's root with nothing but a chroot between them and the host.
+	lxcPrivilegedProvisionConfigOption  = "driver.lxc.privileged_provision"
+	lxcPrivilegedProvisionConfigDefault = false
+
+	// lxcProvisionOutputMaxLen bounds the output of a failed provisioning
+	// command included in its error
+	lxcProvisionOutputMaxLen = 1024
+)
+
+// provisionRootfs runs the task's provisioning commands with /bin/sh in a
+// chroot of the newly created container's root filesystem, before the
+// container boots. The commands get the container's environment rather than
+// the client's, and run in a user namespace with the container's ID map so
+// that the files they create are owned by the container's users rather than
+// the host's. Privileged containers, sharing the host's IDs, are only
+// provisioned if the operator allows it, as their commands run as the host's
+// root.
+func (d *LxcDriver) provisionRootfs(c lxcContainerAPI, cmds []string, env []string) error {
+	rootfs, err := lxcRootfsDir(c)
+	if err != nil {
+		return fmt.Errorf("unable to provision container: %v", err)
+	}
+	idmap, err := lxcUsernsexecArgs(c)
+	if err != nil {
+		return fmt.Errorf("unable to provision container: %v", err)
+	}
+	if len(idmap) == 0 && !d.config.ReadBoolDefault(lxcPrivilegedProvisionConfigOption, lxcPrivilegedProvisionConfigDefault) {
+		return fmt.Errorf("provision_cmds of privileged containers run as the host's root, and require '%v' to be true", lxcPrivilegedProvisionConfigOption)
+	}
+	timeout := d.config.ReadDurationDefault(lxcProvisionTimeoutConfigOption, lxcProvisionTimeoutDefault)
+
+	for i, cmd := range cmds {
+		d.emitEvent("Running provisioning command %d of %d", i+1, len(cmds))
+
+		argv := append([]string{"env", "-i", lxcAttachPath}, env...)
+		argv = append(argv, "chroot", rootfs, "/bin/sh", "-c", cmd)
+		if len(idmap) != 0 {
+			argv = append(append(append([]string{"lxc-usernsexec"}, idmap...), "--"), argv...)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		out, err := d.backend.CombinedOutput(ctx, argv[0], argv[1:]...)
+		cancel()
+		if err != nil {
+			output := strings.TrimSpace(string(out))
+			if len(output) > lxcProvisionOutputMaxLen {
+				output = "..." + output[len(output)-lxcProvisionOutputMaxLen:]
+			}
+			return fmt.Errorf("provisioning command %d failed: %v: %s", i+1, err, output)
+		}
+	}
+	return nil
+}
+
+// lxcUsernsexecArgs returns the lxc-usernsexec arguments mapping the IDs as
+// the container's lxc.idmap entries do, or none if the container shares the
+// host's IDs.
+func lxcUsernsexecArgs(c lxcContainerAPI) ([]string, error) {
+	var args []string
+	for _, entry := range lxcIDMap(c) {
+		fields := strings.Fields(entry)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid ID map %q", entry)
+		}
+		args = append(args, "-m", strings.Join(fields, ":"))
+	}
+	return args, nil
+}
@@ -0,0 +1,64 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	// lxcHTTPProxyConfigOption, lxcHTTPSProxyConfigOption and
+	// lxcNoProxyConfigOption are the keys for the proxy settings exported
+	// to the templates creating containers, used for the keys tasks don't
+	// set.
+	lxcHTTPProxyConfigOption  = "driver.lxc.http_proxy"
+	lxcHTTPSProxyConfigOption = "driver.lxc.https_proxy"
+	lxcNoProxyConfigOption    = "driver.lxc.no_proxy"
+)
+
+// lxcProxy are the proxy settings of a container's template.
+type lxcProxy struct {
+	HTTP    string
+	HTTPS   string
+	NoProxy string
+}
+
+// env returns the environment variables of the settings, in both of the
+// cases tools read them in.
+func (p lxcProxy) env() map[string]string {
+	env := make(map[string]string)
+	for name, value := range map[string]string{"http_proxy": p.HTTP, "https_proxy": p.HTTPS, "no_proxy": p.NoProxy} {
+		if value != "" {
+			env[name] = value
+			env[strings.ToUpper(name)] = value
+		}
+	}
+	return env
+}
+
+// validateProxy checks the proxy keys are http or https URLs.
+func (c *LxcDriverConfig) validateProxy() []error {
+	var errs []error
+	for key, value := range map[string]string{"http_proxy": c.HTTPProxy, "https_proxy": c.HTTPSProxy} {
+		if value == "" {
+			continue
+		}
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid %s %q: must be an http or https URL", key, value))
+		}
+	}
+	return errs
+}
+
+// containerProxy returns the proxy settings of the task's template, from
+// the task config or else the client's options.
+func (d *LxcDriver) containerProxy(config *LxcDriverConfig) lxcProxy {
+	return lxcProxy{
+		HTTP:    firstNonEmpty(config.HTTPProxy, d.config.Read(lxcHTTPProxyConfigOption)),
+		HTTPS:   firstNonEmpty(config.HTTPSProxy, d.config.Read(lxcHTTPSProxyConfigOption)),
+		NoProxy: firstNonEmpty(config.NoProxy, d.config.Read(lxcNoProxyConfigOption)),
+	}
+}
@@ -0,0 +1,48 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	dstructs "github.com/hashicorp/nomad/client/driver/structs"
+)
+
+// lxcRecycleAt returns when a container started at the given time is to be
+// recycled, or the zero time if the task config sets no max_uptime. The
+// jitter is drawn uniformly so containers started together are not all
+// recycled at once.
+func lxcRecycleAt(config *LxcDriverConfig, started time.Time) time.Time {
+	if config.MaxUptime == "" {
+		return time.Time{}
+	}
+
+	// The durations were checked by validate()
+	uptime, _ := time.ParseDuration(config.MaxUptime)
+	if config.MaxUptimeJitter != "" {
+		if jitter, _ := time.ParseDuration(config.MaxUptimeJitter); jitter > 0 {
+			uptime += time.Duration(rand.Int63n(int64(jitter)))
+		}
+	}
+	return started.Add(uptime)
+}
+
+// recycle stops and destroys the container once it has reached its maximum
+// uptime. The task fails so that it is restarted, or rescheduled, on a fresh
+// container created from the template.
+func (h *lxcDriverHandle) recycle() *dstructs.WaitResult {
+	name := h.container.Name()
+	h.logger.Printf("[INFO] driver.lxc: container %q reached its maximum uptime, recycling it", name)
+	h.emitEvent("Container reached its maximum uptime, recycling it")
+
+	h.stop()
+	if err := unloadAppArmorProfile(h.backend, h.container); err != nil {
+		h.logger.Printf("[WARN] driver.lxc: %v", err)
+	}
+	if err := h.container.Destroy(); err != nil {
+		h.logger.Printf("[ERR] driver.lxc: error destroying container %q: %v", name, err)
+	}
+	return &dstructs.WaitResult{Err: fmt.Errorf("container reached its maximum uptime")}
+}
@@ -0,0 +1,148 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/nomad/client/config"
+)
+
+// lxcReloadableOptions are the client options of the driver applied when the
+// client's config is reloaded, as they are read each time they are used.
+// Others, such as the LXC path, only take effect once the client restarts as
+// containers and handles depend on them.
+var lxcReloadableOptions = map[string]bool{
+	lxcVolumesConfigOption:               true,
+	lxcNameCollisionConfigOption:         true,
+	lxcAppArmorConfigOption:              true,
+	lxcBackupDestinationConfigOption:     true,
+	lxcBackupTimeoutConfigOption:         true,
+	lxcCoreDumpsConfigOption:             true,
+	lxcFailureWindowConfigOption:         true,
+	lxcStorageOvercommitConfigOption:     true,
+	lxcStorageMaxUsedConfigOption:        true,
+	lxcImageMirrorConfigOption:           true,
+	lxcKeyServerMirrorConfigOption:       true,
+	lxcIPWaitConfigOption:                true,
+	lxcCreateTimeoutConfigOption:         true,
+	lxcCreateParallelismConfigOption:     true,
+	lxcStartParallelismConfigOption:      true,
+	lxcPrestartCheckConfigOption:         true,
+	lxcPrestartCheckTimeoutConfigOption:  true,
+	lxcProvisionTimeoutConfigOption:      true,
+	lxcPrivilegedProvisionConfigOption:   true,
+	lxcRequireSwapAccountingConfigOption: true,
+	lxcCrashLoopThresholdConfigOption:    true,
+	lxcLogShipperConfigOption:            true,
+	lxcEnforceNetworkMbitsConfigOption:   true,
+	lxcPrepullConfigOption:               true,
+	lxcWarmPoolConfigOption:              true,
+}
+
+// lxcReloaded holds the reloadable options of the driver as last reloaded.
+var lxcReloaded = &lxcReloadedOptions{}
+
+type lxcReloadedOptions struct {
+	// options are the reloadable options, or nil if the client's config
+	// was never reloaded
+	options map[string]string
+	lock    sync.RWMutex
+}
+
+// isLxcOption returns whether the client option configures the driver.
+func isLxcOption(key string) bool {
+	return strings.HasPrefix(key, "driver.lxc.") || key == lxcVolumesConfigOption
+}
+
+// IsLxcReloadableOption returns whether the client option is one of the
+// driver's applied on reload. The mirror options may be suffixed with a
+// datacenter.
+func IsLxcReloadableOption(key string) bool {
+	if lxcReloadableOptions[key] {
+		return true
+	}
+	return strings.HasPrefix(key, lxcImageMirrorConfigOption+".") || strings.HasPrefix(key, lxcKeyServerMirrorConfigOption+".")
+}
+
+// apply returns the client config with the reloaded options in place of the
+// ones the client started with. The config is shared with the client, so it
+// is copied rather than modified.
+func (o *lxcReloadedOptions) apply(cfg *config.Config) *config.Config {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	if o.options == nil || cfg == nil {
+		return cfg
+	}
+
+	nc := *cfg
+	nc.Options = make(map[string]string, len(cfg.Options))
+	for k, v := range cfg.Options {
+		if !IsLxcReloadableOption(k) {
+			nc.Options[k] = v
+		}
+	}
+	for k, v := range o.options {
+		nc.Options[k] = v
+	}
+	return &nc
+}
+
+// ReloadLxcOptions applies the reloadable options of the driver from the
+// reloaded client options, so that operators can tune a busy node without
+// restarting the client and recovering every task. Changes to other options
+// of the driver are logged as requiring a restart. It returns the options
+// that changed.
+func ReloadLxcOptions(cfg *config.Config, options map[string]string, logger *log.Logger) []string {
+	current := lxcReloaded.apply(cfg).Options
+
+	keys := make(map[string]bool)
+	for k := range current {
+		keys[k] = true
+	}
+	for k := range options {
+		keys[k] = true
+	}
+
+	var changed []string
+	for k := range keys {
+		if !isLxcOption(k) {
+			continue
+		}
+		old, hadOld := current[k]
+		value, hasValue := options[k]
+		if old == value && hadOld == hasValue {
+			continue
+		}
+		if !IsLxcReloadableOption(k) {
+			logger.Printf("[WARN] driver.lxc: client option %q changed, restart the client to apply it", k)
+			continue
+		}
+		changed = append(changed, k)
+	}
+	sort.Strings(changed)
+
+	reloaded := make(map[string]string)
+	for k, v := range options {
+		if IsLxcReloadableOption(k) {
+			reloaded[k] = v
+		}
+	}
+	lxcReloaded.lock.Lock()
+	lxcReloaded.options = reloaded
+	lxcReloaded.lock.Unlock()
+	lxcCreatePhase.wake()
+	lxcStartPhase.wake()
+
+	for _, k := range changed {
+		if value, ok := options[k]; ok {
+			logger.Printf("[INFO] driver.lxc: client option %q reloaded as %q", k, value)
+		} else {
+			logger.Printf("[INFO] driver.lxc: client option %q reloaded as unset", k)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,242 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+// lxcRootfsDir returns the host directory of the container's root
+// filesystem. Only directory and btrfs backed root filesystems can be written
+// to before the container starts.
+func lxcRootfsDir(c lxcContainerAPI) (string, error) {
+	rootfs := lxcRootfsSpec(c)
+	for _, prefix := range []string{"dir:", "btrfs:"} {
+		rootfs = strings.TrimPrefix(rootfs, prefix)
+	}
+	if !filepath.IsAbs(rootfs) {
+		return "", fmt.Errorf("container root filesystem %q is not directory backed", rootfs)
+	}
+	return rootfs, nil
+}
+
+// lxcRootfsSpec returns the container's root filesystem as LXC configures
+// it, prefixed by its backend, such as "zfs:tank/lxc/web".
+func lxcRootfsSpec(c lxcContainerAPI) string {
+	for _, key := range []string{"lxc.rootfs.path", "lxc.rootfs"} {
+		if v := c.ConfigItem(key); len(v) != 0 && v[0] != "" {
+			return strings.TrimSpace(v[0])
+		}
+	}
+	return ""
+}
+
+// copyIntoRootfs copies the task directory paths into the container's root
+// filesystem.
+func (d *LxcDriver) copyIntoRootfs(c lxcContainerAPI, taskDir string, copies []LxcRootfsCopyConfig) error {
+	rootfs, err := lxcRootfsDir(c)
+	if err != nil {
+		return fmt.Errorf("unable to copy into container: %v", err)
+	}
+
+	for _, cp := range copies {
+		dst, err := rootfsPath(rootfs, cp.Target)
+		if err != nil {
+			return fmt.Errorf("unable to copy %q into container: %v", cp.Source, err)
+		}
+		d.emitEvent("Copying %s into the container at %s", cp.Source, cp.Target)
+		if err := copyTree(filepath.Join(taskDir, cp.Source), dst); err != nil {
+			return fmt.Errorf("unable to copy %q into container: %v", cp.Source, err)
+		}
+	}
+	return nil
+}
+
+// rootfsPath returns the host path of the absolute container path within the
+// root filesystem, refusing paths that traverse symlinks inside it, which
+// could point outside of the container.
+func rootfsPath(rootfs, target string) (string, error) {
+	path := rootfs
+	for _, part := range strings.Split(filepath.Clean(target), string(filepath.Separator)) {
+		if part == "" {
+			continue
+		}
+		path = filepath.Join(path, part)
+		fi, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("path %q traverses a symlink in the container", target)
+		}
+	}
+	return filepath.Join(rootfs, filepath.Clean(target)), nil
+}
+
+// copyTree copies the file or directory at src to dst, preserving modes and
+// symlinks.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case fi.IsDir():
+			if err := os.MkdirAll(target, fi.Mode().Perm()); err != nil {
+				return err
+			}
+			return os.Chmod(target, fi.Mode().Perm())
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			os.Remove(target)
+			return os.Symlink(link, target)
+		case fi.Mode().IsRegular():
+			return copyRegularFile(path, target, fi.Mode().Perm())
+		default:
+			// Devices, sockets and pipes aren't copied
+			return nil
+		}
+	})
+}
+
+// copyRegularFile copies the regular file src to dst with the given permissions.
+func copyRegularFile(src, dst string, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, perm)
+}
+
+// lxcRootfsArchiveTemplateName is the name of the template unpacking rootfs
+// archives, written to the task directory.
+const lxcRootfsArchiveTemplateName = ".lxc-rootfs-archive"
+
+// lxcRootfsArchiveTemplate is the template unpacking the archive given with
+// --archive into the container's root filesystem. It runs once LXC set up
+// the root filesystem, whatever its backend.
+const lxcRootfsArchiveTemplate = `#!/bin/sh
+set -e
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--rootfs) rootfs="$2"; shift 2 ;;
+	--rootfs=*) rootfs="${1#*=}"; shift ;;
+	--archive) archive="$2"; shift 2 ;;
+	*) shift ;;
+	esac
+done
+if [ -z "$rootfs" ] || [ -z "$archive" ]; then
+	echo "usage: $0 --rootfs <path> --archive <path>" >&2
+	exit 1
+fi
+tar -xpf "$archive" --numeric-owner -C "$rootfs"
+`
+
+// validateRootfsArchive checks the rootfs_archive key, which replaces the
+// template and takes none of its keys.
+func (c *LxcDriverConfig) validateRootfsArchive() []error {
+	if c.RootfsArchive == "" {
+		return nil
+	}
+
+	var errs []error
+	if err := c.validateRootfsArchivePath(); err != nil {
+		errs = append(errs, err)
+	}
+	invalid := []struct {
+		key string
+		set bool
+	}{
+		{"template", c.Template != ""},
+		{"oci_image", c.OCIImage != ""},
+		{"template_args", len(c.TemplateArgs) != 0},
+		{"image", len(c.Image) != 0},
+		{"release", c.Release != ""},
+		{"arch", c.Arch != ""},
+		{"flush_cache", c.FlushCache},
+	}
+	for _, o := range invalid {
+		if o.set {
+			errs = append(errs, fmt.Errorf("%q can't be combined with rootfs_archive", o.key))
+		}
+	}
+	return errs
+}
+
+// rootfsArchiveTemplate writes the template unpacking the rootfs archive,
+// such as one fetched with an artifact, to the task directory, and returns
+// its path and arguments.
+func rootfsArchiveTemplate(taskDir, archive string) (string, []string, error) {
+	// The archive is unpacked as root, so it must not resolve to a host
+	// file out of the task directory
+	dir, err := filepath.EvalSymlinks(taskDir)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to resolve task directory: %v", err)
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(taskDir, archive))
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to find rootfs archive %q in task directory: %v", archive, err)
+	}
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", nil, fmt.Errorf("rootfs archive %q escapes the task directory", archive)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to find rootfs archive %q in task directory: %v", archive, err)
+	}
+	if !fi.Mode().IsRegular() {
+		return "", nil, fmt.Errorf("rootfs archive %q is not a regular file", archive)
+	}
+
+	template := filepath.Join(taskDir, lxcRootfsArchiveTemplateName)
+	if err := ioutil.WriteFile(template, []byte(lxcRootfsArchiveTemplate), 0755); err != nil {
+		return "", nil, fmt.Errorf("unable to write rootfs archive template: %v", err)
+	}
+	return template, []string{"--archive", path}, nil
+}
+
+// validateRootfsArchivePath checks that the rootfs archive stays in the
+// task directory. The path is checked again once interpolated.
+func (c *LxcDriverConfig) validateRootfsArchivePath() error {
+	if filepath.IsAbs(c.RootfsArchive) {
+		return fmt.Errorf("rootfs_archive %q must be relative to the task directory", c.RootfsArchive)
+	} else if escapes, err := structs.PathEscapesAllocDir("", c.RootfsArchive); err != nil || escapes {
+		return fmt.Errorf("rootfs_archive %q escapes the task directory", c.RootfsArchive)
+	}
+	return nil
+}
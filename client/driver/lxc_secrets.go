@@ -0,0 +1,211 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// lxcSecretsDir is the directory of the task's secrets dir holding the
+	// copies of the secrets projected into the container
+	lxcSecretsDir = ".lxc"
+
+	// lxcSecretsSyncIntv is how often projected secrets are updated from
+	// their sources, which templates may render again at any time
+	lxcSecretsSyncIntv = 5 * time.Second
+
+	// lxcSecretDefaultMode is the mode of projected secrets not setting one
+	lxcSecretDefaultMode = 0400
+)
+
+// lxcSecret is a file of the task's secrets dir projected into the
+// container. The container bind-mounts a copy of the file, rather than the
+// file itself, as templates replace files they render again and a file
+// bind mount would keep showing the replaced file. The copy is updated in
+// place.
+type lxcSecret struct {
+	// Source is the host path of the file in the secrets dir
+	Source string
+
+	// Path is the host path of the copy mounted in the container
+	Path string
+
+	// Mode is the mode of the copy, which is owned by the host IDs mapped
+	// to the configured container IDs
+	Mode os.FileMode
+	UID  int
+	GID  int
+}
+
+// lxcSecrets returns the secrets of the task config, with the container's
+// user and group IDs mapped to the host's.
+func lxcSecrets(c lxcContainerAPI, secretsDir string, configs []LxcSecretConfig) ([]*lxcSecret, error) {
+	secrets := make([]*lxcSecret, 0, len(configs))
+	for i, s := range configs {
+		mode := os.FileMode(lxcSecretDefaultMode)
+		if s.Mode != "" {
+			// The mode was checked by validate()
+			m, _ := strconv.ParseUint(s.Mode, 8, 32)
+			mode = os.FileMode(m)
+		}
+		uid, err := lxcHostID(c, "u", s.UID)
+		if err != nil {
+			return nil, fmt.Errorf("secret[%d]: %v", i, err)
+		}
+		gid, err := lxcHostID(c, "g", s.GID)
+		if err != nil {
+			return nil, fmt.Errorf("secret[%d]: %v", i, err)
+		}
+
+		secrets = append(secrets, &lxcSecret{
+			Source: filepath.Join(secretsDir, s.Source),
+			Path:   filepath.Join(secretsDir, lxcSecretsDir, fmt.Sprintf("%d-%s", i, filepath.Base(s.Target))),
+			Mode:   mode,
+			UID:    uid,
+			GID:    gid,
+		})
+	}
+	return secrets, nil
+}
+
+// lxcHostID returns the host ID that the container's user ("u") or group
+// ("g") ID is mapped to by its lxc.idmap entries. Containers without ID
+// maps share the host's IDs.
+func lxcHostID(c lxcContainerAPI, kind string, id int) (int, error) {
+	mapped := false
+	for _, entry := range lxcIDMap(c) {
+		fields := strings.Fields(entry)
+		if len(fields) != 4 || (fields[0] != kind && fields[0] != "b") {
+			continue
+		}
+		mapped = true
+		nsID, err1 := strconv.Atoi(fields[1])
+		hostID, err2 := strconv.Atoi(fields[2])
+		count, err3 := strconv.Atoi(fields[3])
+		if err1 != nil || err2 != nil || err3 != nil {
+			return 0, fmt.Errorf("invalid ID map %q", entry)
+		}
+		if id >= nsID && id < nsID+count {
+			return hostID + id - nsID, nil
+		}
+	}
+	if mapped {
+		return 0, fmt.Errorf("ID %d is not mapped in the container", id)
+	}
+	return id, nil
+}
+
+// lxcIDMap returns the container's lxc.idmap entries, which are empty if it
+// shares the host's IDs.
+func lxcIDMap(c lxcContainerAPI) []string {
+	// LXC 2.1 renamed lxc.id_map to lxc.idmap
+	entries := c.ConfigItem("lxc.idmap")
+	if len(entries) == 0 || entries[0] == "" {
+		entries = c.ConfigItem("lxc.id_map")
+	}
+	if len(entries) == 1 && entries[0] == "" {
+		return nil
+	}
+	return entries
+}
+
+// resolveSource returns the path of the secret's source with its symlinks
+// resolved, or an error if it resolves outside of the secrets dir, which
+// holds the directory of the copies.
+func (s *lxcSecret) resolveSource() (string, error) {
+	dir, err := filepath.EvalSymlinks(filepath.Dir(filepath.Dir(s.Path)))
+	if err != nil {
+		return "", fmt.Errorf("unable to read secret: %v", err)
+	}
+	source, err := filepath.EvalSymlinks(s.Source)
+	if err != nil {
+		return "", fmt.Errorf("unable to read secret: %v", err)
+	}
+	rel, err := filepath.Rel(dir, source)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("secret %q escapes the secrets directory", s.Source)
+	}
+	return source, nil
+}
+
+// project updates the copy of the secret if its source changed, and
+// returns whether it did. The copy is written in place so that the file
+// mounted in the container is the same.
+func (s *lxcSecret) project() (bool, error) {
+	source, err := s.resolveSource()
+	if err != nil {
+		return false, err
+	}
+	data, err := ioutil.ReadFile(source)
+	if err != nil {
+		return false, fmt.Errorf("unable to read secret: %v", err)
+	}
+	if current, err := ioutil.ReadFile(s.Path); err == nil && bytes.Equal(current, data) {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0700); err != nil {
+		return false, fmt.Errorf("unable to project secret: %v", err)
+	}
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, s.Mode)
+	if err != nil {
+		return false, fmt.Errorf("unable to project secret: %v", err)
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(s.Path, s.Mode)
+	}
+	if err == nil {
+		err = os.Chown(s.Path, s.UID, s.GID)
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to project secret: %v", err)
+	}
+	return true, nil
+}
+
+// syncSecrets updates the secrets projected into the container from their
+// sources.
+func (h *lxcDriverHandle) syncSecrets() error {
+	h.secretsLock.Lock()
+	defer h.secretsLock.Unlock()
+
+	for _, s := range h.secrets {
+		updated, err := s.project()
+		if err != nil {
+			return fmt.Errorf("%s: %v", filepath.Base(s.Source), err)
+		}
+		if updated {
+			h.logger.Printf("[DEBUG] driver.lxc: updated secret %s of container %q", filepath.Base(s.Source), h.container.Name())
+		}
+	}
+	return nil
+}
+
+// monitorSecrets periodically updates the projected secrets until stopped.
+func (h *lxcDriverHandle) monitorSecrets(stopCh <-chan bool) {
+	ticker := time.NewTicker(lxcSecretsSyncIntv)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+
+		if err := h.syncSecrets(); err != nil {
+			h.logger.Printf("[WARN] driver.lxc: unable to update secrets of container %q: %v", h.container.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+// lxcDefaultImageVariant is the variant the download template uses when none
+// is given.
+const lxcDefaultImageVariant = "default"
+
+// cachedImageSerial returns the serial of the image of the template options
+// in the download template's cache, or "" if it isn't cached. The template
+// records the serial of each image it caches.
+func (d *LxcDriver) cachedImageSerial(options lxc.TemplateOptions) string {
+	variant := options.Variant
+	if variant == "" {
+		variant = lxcDefaultImageVariant
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(d.cacheDir(), lxcDownloadTemplate, options.Distro, options.Release, options.Arch, variant, "build_id"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+// pinImageSerial makes the download template use its cached image if it is
+// the pinned serial, rather than replacing it with the latest one. The
+// image server only serves the latest serials, so the cache is what keeps
+// the pinned image available.
+func (d *LxcDriver) pinImageSerial(options *lxc.TemplateOptions, serial string) {
+	if d.cachedImageSerial(*options) == serial {
+		d.logger.Printf("[DEBUG] driver.lxc: using cached image %s/%s/%s serial %s", options.Distro, options.Release, options.Arch, serial)
+		options.ForceCache = true
+		options.FlushCache = false
+	}
+}
+
+// checkImageSerial returns an error if the image the download template
+// created the container from isn't the pinned serial.
+func (d *LxcDriver) checkImageSerial(options lxc.TemplateOptions, serial string) error {
+	actual := d.cachedImageSerial(options)
+	if actual == "" {
+		return fmt.Errorf("unable to determine the serial of image %s/%s/%s pinned to %s", options.Distro, options.Release, options.Arch, serial)
+	}
+	if actual != serial {
+		return fmt.Errorf("image %s/%s/%s has serial %s, not the pinned %s", options.Distro, options.Release, options.Arch, actual, serial)
+	}
+	return nil
+}
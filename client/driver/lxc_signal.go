@@ -0,0 +1,85 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+// setHaltSignal sets the signal sent to the container's init process to shut
+// it down to the task's kill_signal, if set.
+func setHaltSignal(c lxcContainerAPI, task *structs.Task) error {
+	if task.KillSignal == "" {
+		return nil
+	}
+	sig, err := getTaskKillSignal(task.KillSignal)
+	if err != nil {
+		return err
+	}
+
+	// LXC 2.1 renamed lxc.haltsignal to lxc.signal.halt
+	value := strconv.Itoa(int(sig.(syscall.Signal)))
+	if err := c.SetConfigItem("lxc.signal.halt", value); err != nil {
+		if err := c.SetConfigItem("lxc.haltsignal", value); err != nil {
+			return fmt.Errorf("error setting kill signal: %v", err)
+		}
+	}
+	return nil
+}
+
+// Signal sends the signal to the container's init process, or to the
+// process whose PID is written to the task's signal_pidfile in the
+// container.
+func (h *lxcDriverHandle) Signal(s os.Signal) error {
+	sig, ok := s.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("unsupported signal %v", s)
+	}
+
+	// Signals commonly ask processes to reload their config, so update the
+	// projected secrets first rather than waiting for the next sync
+	if err := h.syncSecrets(); err != nil {
+		h.logger.Printf("[WARN] driver.lxc: unable to update secrets of container %q: %v", h.container.Name(), err)
+	}
+	if h.signalPidfile == "" {
+		return syscall.Kill(h.initPid, sig)
+	}
+
+	// The PID is in the container's PID namespace, so the signal is sent
+	// from within the container
+	pid, err := h.readContainerPidfile(h.signalPidfile)
+	if err != nil {
+		return err
+	}
+	out, code, err := h.attach(context.Background(), []string{"kill", "-" + strconv.Itoa(int(sig)), strconv.Itoa(pid)}, nil)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("unable to signal process %d: %s", pid, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// readContainerPidfile reads a PID file of the running container through
+// its init process's root.
+func (h *lxcDriverHandle) readContainerPidfile(path string) (int, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(fmt.Sprintf("/proc/%d/root", h.initPid), path))
+	if err != nil {
+		return 0, fmt.Errorf("unable to read signal_pidfile: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid PID %q in signal_pidfile", strings.TrimSpace(string(raw)))
+	}
+	return pid, nil
+}
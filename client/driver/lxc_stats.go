@@ -0,0 +1,123 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// lxcStatsIntervalConfigOption is the key for the interval at which the
+	// node's stats collector samples the containers' cgroups
+	lxcStatsIntervalConfigOption = "driver.lxc.stats_interval"
+	lxcStatsIntervalDefault      = time.Second
+)
+
+// lxcStats is the node's stats collector. It samples every container on one
+// schedule and serves the cached results to Stats, so the cost of collecting
+// stats is bounded by the interval rather than by how often and for how many
+// containers stats are requested.
+var lxcStats = &lxcStatsCollector{
+	handles: make(map[*lxcDriverHandle]struct{}),
+}
+
+// lxcStatsCollector samples the resource usage of the registered handles.
+// Its goroutine only runs while handles are registered.
+type lxcStatsCollector struct {
+	handles map[*lxcDriverHandle]struct{}
+	stopCh  chan struct{}
+	lock    sync.Mutex
+
+	// filesSampled is when the containers' open files were last counted
+	filesSampled time.Time
+}
+
+// statsInterval returns the configured stats collection interval.
+func (d *LxcDriver) statsInterval() time.Duration {
+	raw := d.config.Read(lxcStatsIntervalConfigOption)
+	if raw == "" {
+		return lxcStatsIntervalDefault
+	}
+	interval, err := time.ParseDuration(raw)
+	if err != nil || interval <= 0 {
+		d.logger.Printf("[WARN] driver.lxc: invalid %s %q, using %v", lxcStatsIntervalConfigOption, raw, lxcStatsIntervalDefault)
+		return lxcStatsIntervalDefault
+	}
+	return interval
+}
+
+// register starts sampling the handle's container, starting the collector at
+// the given interval if it isn't running.
+func (s *lxcStatsCollector) register(h *lxcDriverHandle, interval time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.handles[h] = struct{}{}
+	if s.stopCh == nil {
+		s.stopCh = make(chan struct{})
+		go s.run(interval, s.stopCh)
+	}
+}
+
+// deregister stops sampling the handle's container, stopping the collector
+// once no handles are left.
+func (s *lxcStatsCollector) deregister(h *lxcDriverHandle) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.handles, h)
+	if len(s.handles) == 0 && s.stopCh != nil {
+		close(s.stopCh)
+		s.stopCh = nil
+	}
+}
+
+func (s *lxcStatsCollector) run(interval time.Duration, stopCh chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.collect()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// collect samples every registered container and caches the results on
+// their handles.
+func (s *lxcStatsCollector) collect() {
+	s.lock.Lock()
+	handles := make([]*lxcDriverHandle, 0, len(s.handles))
+	for h := range s.handles {
+		handles = append(handles, h)
+	}
+	s.lock.Unlock()
+
+	for _, h := range handles {
+		usage, err := h.sampleStats()
+		if err != nil || usage == nil {
+			continue
+		}
+		h.statsLock.Lock()
+		h.latestStats = usage
+		h.statsLock.Unlock()
+	}
+
+	if time.Since(s.filesSampled) < lxcFileStatsIntv {
+		return
+	}
+	s.filesSampled = time.Now()
+	namespaces, err := lxcPidNamespaces()
+	if err != nil {
+		return
+	}
+	for _, h := range handles {
+		if usage, err := h.sampleFileUsage(namespaces); err == nil {
+			h.recordFileUsage(usage)
+		}
+	}
+}
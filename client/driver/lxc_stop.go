@@ -0,0 +1,145 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+// lxcStopSignalDefault is the graceful stop signal if the stop block sets a
+// signal_timeout without a signal.
+const lxcStopSignalDefault = "SIGTERM"
+
+// lxcStop is the stop block of the task config, resolved: the stages the
+// container goes through when it is stopped before the LXC shutdown.
+type lxcStop struct {
+	// Signal is sent to the process of the signal_pidfile, or to the init
+	// process, and given SignalTimeout to stop it. Zero skips the stage.
+	Signal        syscall.Signal
+	SignalTimeout time.Duration
+
+	// ShutdownTimeout bounds the LXC shutdown, sending the halt signal,
+	// instead of the kill timeout
+	ShutdownTimeout time.Duration
+}
+
+// validateStop checks the stop block of the task config.
+func (c *LxcDriverConfig) validateStop() []error {
+	if len(c.Stop) == 0 {
+		return nil
+	}
+	s := c.Stop[0]
+	var errs []error
+	if s.Signal != "" {
+		if s.SignalTimeout == "" {
+			errs = append(errs, fmt.Errorf("stop[0]: signal requires signal_timeout"))
+		}
+		if _, err := getTaskKillSignal(s.Signal); err != nil {
+			errs = append(errs, fmt.Errorf("stop[0]: invalid signal: %v", err))
+		}
+	}
+	for key, value := range map[string]string{"signal_timeout": s.SignalTimeout, "shutdown_timeout": s.ShutdownTimeout} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil {
+			errs = append(errs, fmt.Errorf("stop[0]: invalid %s %q: %v", key, value, err))
+		} else if d <= 0 {
+			errs = append(errs, fmt.Errorf("stop[0]: %s must be positive", key))
+		}
+	}
+	return errs
+}
+
+// newLxcStop resolves the stop block of the task config, or returns nil if
+// the task config has none.
+func newLxcStop(config []LxcStopConfig) *lxcStop {
+	if len(config) == 0 {
+		return nil
+	}
+	c := config[0]
+
+	// The values were checked in Validate()
+	s := &lxcStop{}
+	if c.SignalTimeout != "" {
+		signal := c.Signal
+		if signal == "" {
+			signal = lxcStopSignalDefault
+		}
+		sig, _ := getTaskKillSignal(signal)
+		s.Signal = sig.(syscall.Signal)
+		s.SignalTimeout, _ = time.ParseDuration(c.SignalTimeout)
+	}
+	if c.ShutdownTimeout != "" {
+		s.ShutdownTimeout, _ = time.ParseDuration(c.ShutdownTimeout)
+	}
+	return s
+}
+
+// shutdownTimeout returns how long the LXC shutdown of the container may
+// take before it is stopped.
+func (h *lxcDriverHandle) shutdownTimeout() time.Duration {
+	if h.stopStages != nil && h.stopStages.ShutdownTimeout > 0 {
+		return h.stopStages.ShutdownTimeout
+	}
+	return h.killTimeout
+}
+
+// stopWithSignal sends the graceful stop signal and waits for it to take
+// effect, returning whether the container stopped. A signaled init process
+// is waited on to stop the container, while the process of the
+// signal_pidfile is waited on to exit before the container is shut down.
+func (h *lxcDriverHandle) stopWithSignal() bool {
+	name := h.container.Name()
+	s := h.stopStages
+	h.logger.Printf("[INFO] driver.lxc: sending %s to container %q", s.Signal, name)
+
+	if h.signalPidfile == "" {
+		if err := syscall.Kill(h.initPid, s.Signal); err != nil {
+			h.logger.Printf("[INFO] driver.lxc: signaling container %q failed: %v", name, err)
+			return false
+		}
+		return h.container.Wait(lxc.STOPPED, s.SignalTimeout)
+	}
+
+	pid, err := h.readContainerPidfile(h.signalPidfile)
+	if err == nil {
+		err = h.Signal(s.Signal)
+	}
+	if err != nil {
+		h.logger.Printf("[INFO] driver.lxc: signaling container %q failed: %v", name, err)
+		return false
+	}
+	// The process is looked up through the /proc of the container's root,
+	// where it has its PID
+	proc := filepath.Join(fmt.Sprintf("/proc/%d/root/proc", h.initPid), strconv.Itoa(pid))
+	deadline := time.Now().Add(s.SignalTimeout)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(proc); os.IsNotExist(err) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	h.logger.Printf("[INFO] driver.lxc: process %d of container %q didn't exit within %s", pid, name, s.SignalTimeout)
+	return false
+}
+
+// killInit kills the init process of a container that LXC failed to stop,
+// which takes the rest of the container down with it.
+func (h *lxcDriverHandle) killInit() {
+	if !h.container.Running() || h.initPid <= 0 {
+		return
+	}
+	name := h.container.Name()
+	h.logger.Printf("[WARN] driver.lxc: killing init process %d of container %q", h.initPid, name)
+	if err := syscall.Kill(h.initPid, syscall.SIGKILL); err != nil {
+		h.logger.Printf("[ERR] driver.lxc: error killing init process of container %q: %v", name, err)
+	}
+}
@@ -0,0 +1,177 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+	lxc "gopkg.in/lxc/go-lxc.v2"
+)
+
+const (
+	// lxcBackendDir, lxcBackendBtrfs, lxcBackendZFS, lxcBackendLVM and
+	// lxcBackendOverlay are the storage backends of the root filesystems of
+	// containers. Btrfs backed containers get a subvolume of their own, ZFS
+	// backed containers a dataset under LXC's lxc.bdev.zfs.root, LVM backed
+	// containers a logical volume in LXC's lxc.bdev.lvm.vg, thin if the
+	// group has lxc.bdev.lvm.thin_pool, and overlay backed containers an
+	// upper directory over their rootfs directory. LXC deletes them with
+	// the container.
+	lxcBackendDir     = "dir"
+	lxcBackendBtrfs   = "btrfs"
+	lxcBackendZFS     = "zfs"
+	lxcBackendLVM     = "lvm"
+	lxcBackendOverlay = "overlay"
+
+	// btrfsSuperMagic is the statfs(2) type of btrfs filesystems
+	btrfsSuperMagic = 0x9123683e
+
+	// lxcStorageMaxUsedConfigOption is the key for the percentage of the
+	// filesystem of the LXC path above which containers aren't created, so
+	// that tasks fail to start rather than fill it up while running. Zero
+	// disables the check.
+	lxcStorageMaxUsedConfigOption = "driver.lxc.storage_max_used_percent"
+)
+
+// lxcBackends are the storage backends tasks may set, by name.
+var lxcBackends = map[string]lxc.BackendStore{
+	lxcBackendDir:     lxc.Directory,
+	lxcBackendBtrfs:   lxc.Btrfs,
+	lxcBackendZFS:     lxc.ZFS,
+	lxcBackendLVM:     lxc.LVM,
+	lxcBackendOverlay: lxc.Overlayfs,
+}
+
+// validateBackend checks the storage backend of the task config and the
+// size of the root filesystem, which the dir and overlay backends can't
+// bound.
+func (c *LxcDriverConfig) validateBackend() []error {
+	var errs []error
+	if _, ok := lxcBackends[c.Backend]; c.Backend != "" && !ok {
+		errs = append(errs, fmt.Errorf("backend must be %q, %q, %q, %q or %q, got %q",
+			lxcBackendDir, lxcBackendBtrfs, lxcBackendZFS, lxcBackendLVM, lxcBackendOverlay, c.Backend))
+	}
+	switch {
+	case c.RootfsSizeMB < 0:
+		errs = append(errs, fmt.Errorf("rootfs_size_mb must not be negative"))
+	case c.RootfsSizeMB > 0 && (c.Backend == "" || c.Backend == lxcBackendDir || c.Backend == lxcBackendOverlay):
+		errs = append(errs, fmt.Errorf("rootfs_size_mb requires the %q, %q or %q backend", lxcBackendBtrfs, lxcBackendZFS, lxcBackendLVM))
+	}
+	return errs
+}
+
+// isBtrfs returns whether the path is on a btrfs filesystem.
+func isBtrfs(path string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return false
+	}
+	return uint32(st.Type) == btrfsSuperMagic
+}
+
+// checkBackend returns an error if containers of the storage backend can't
+// be created under the LXC path, which LXC would otherwise only report in
+// the container's log.
+func (d *LxcDriver) checkBackend(backend, lxcPath string) error {
+	switch backend {
+	case lxcBackendBtrfs:
+		if !isBtrfs(lxcPath) {
+			return fmt.Errorf("backend %q requires the LXC path %s to be on a btrfs filesystem", backend, lxcPath)
+		}
+	case lxcBackendZFS:
+		if _, err := d.backend.LookPath("zfs"); err != nil {
+			return fmt.Errorf("backend %q requires zfs on the client: %v", backend, err)
+		}
+	case lxcBackendLVM:
+		if _, err := d.backend.LookPath("lvcreate"); err != nil {
+			return fmt.Errorf("backend %q requires lvcreate on the client: %v", backend, err)
+		}
+	case lxcBackendOverlay:
+		f, err := os.Open(procFilesystems)
+		if err != nil {
+			return fmt.Errorf("backend %q requires overlayfs support in the kernel: %v", backend, err)
+		}
+		defer f.Close()
+		if !kernelHasFilesystem(f, "overlay") {
+			return fmt.Errorf("backend %q requires overlayfs support in the kernel", backend)
+		}
+	}
+	return nil
+}
+
+// statfsUsedPercent returns the percentage of the filesystem in use, out of
+// the space available to unprivileged users as df(1) reports it.
+func statfsUsedPercent(st *syscall.Statfs_t) int {
+	used := st.Blocks - st.Bfree
+	if used+st.Bavail == 0 {
+		return 0
+	}
+	return int((used*100 + used + st.Bavail - 1) / (used + st.Bavail))
+}
+
+// checkStorageSpace returns a recoverable error if the filesystem of the LXC
+// path is fuller than the configured limit, so the task is rescheduled
+// before its container is created. ZFS and LVM backed root filesystems are
+// created in their pool or volume group rather than under the LXC path, so
+// they aren't checked.
+func (d *LxcDriver) checkStorageSpace(backend, lxcPath string) error {
+	limit := d.config.ReadIntDefault(lxcStorageMaxUsedConfigOption, 0)
+	if limit <= 0 || backend == lxcBackendZFS || backend == lxcBackendLVM {
+		return nil
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(lxcPath, &st); err != nil {
+		d.logger.Printf("[DEBUG] driver.lxc: unable to check space of LXC path %s: %v", lxcPath, err)
+		return nil
+	}
+	if used := statfsUsedPercent(&st); used > limit {
+		return structs.NewRecoverableError(fmt.Errorf("LXC path %s is %d%% full, above the %d%% limit of %s",
+			lxcPath, used, limit, lxcStorageMaxUsedConfigOption), true)
+	}
+	return nil
+}
+
+// limitRootfsSize bounds the writable space of the container's root
+// filesystem, which otherwise shares the free space of the pool or
+// filesystem it was created on. ZFS datasets get a refquota, which leaves
+// out snapshots, and btrfs subvolumes a qgroup limit, which requires quotas
+// to be enabled on the filesystem. Logical volumes, which LXC creates with
+// a fixed size, are resized along with their filesystem.
+func (d *LxcDriver) limitRootfsSize(c lxcContainerAPI, sizeMB int) error {
+	size := strconv.Itoa(sizeMB) + "M"
+	rootfs := lxcRootfsSpec(c)
+	var name string
+	var args []string
+	switch {
+	case strings.HasPrefix(rootfs, lxcBackendZFS+":"):
+		name, args = "zfs", []string{"set", "refquota=" + size, strings.TrimPrefix(rootfs, lxcBackendZFS+":")}
+	case strings.HasPrefix(rootfs, lxcBackendBtrfs+":"):
+		name, args = "btrfs", []string{"qgroup", "limit", size, strings.TrimPrefix(rootfs, lxcBackendBtrfs+":")}
+	case strings.HasPrefix(rootfs, lxcBackendLVM+":"):
+		name, args = "lvresize", []string{"--resizefs", "--force", "-L", size, strings.TrimPrefix(rootfs, lxcBackendLVM+":")}
+	default:
+		return fmt.Errorf("unable to limit size of container root filesystem %q: not btrfs, zfs or lvm backed", rootfs)
+	}
+	d.logger.Printf("[DEBUG] driver.lxc: limiting container root filesystem %q to %s", rootfs, size)
+	if out, err := d.backend.CombinedOutput(context.Background(), name, args...); err != nil {
+		return fmt.Errorf("unable to limit size of container root filesystem: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// fingerprintBtrfs advertises whether the LXC path is on btrfs, so that
+// tasks using the btrfs backend can be constrained to the nodes supporting
+// it.
+func (d *LxcDriver) fingerprintBtrfs(node *structs.Node) {
+	if isBtrfs(readLxcPath(d.config)) {
+		node.Attributes["driver.lxc.btrfs"] = "1"
+	} else {
+		delete(node.Attributes, "driver.lxc.btrfs")
+	}
+}
@@ -0,0 +1,64 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+const (
+	// lxcRequireSwapAccountingConfigOption is the key for refusing tasks
+	// setting memory_swap_mb on nodes without swap accounting, rather than
+	// starting them without their swap limit.
+	lxcRequireSwapAccountingConfigOption = "driver.lxc.require_swap_accounting"
+
+	// lxcSwapAccountingAttr is the node attribute set if the kernel accounts
+	// the swap usage of cgroups, which swap limits rely on
+	lxcSwapAccountingAttr = "driver.lxc.swap_accounting"
+)
+
+// sysCgroupDir is where the cgroup hierarchies are mounted.
+var sysCgroupDir = cgroupV2Mount
+
+// lxcSwapAccounting returns whether the kernel accounts the swap usage of
+// cgroups. It is disabled by booting with swapaccount=0, and by default on
+// some older kernels, in which case the swap files of the memory controller
+// are missing. The root of the unified hierarchy has none, so its children
+// are checked instead.
+func lxcSwapAccounting() bool {
+	if _, err := os.Stat(filepath.Join(sysCgroupDir, "memory", "memory.memsw.limit_in_bytes")); err == nil {
+		return true
+	}
+	matches, _ := filepath.Glob(filepath.Join(sysCgroupDir, "*", "memory.swap.max"))
+	return len(matches) != 0
+}
+
+// fingerprintSwapAccounting advertises whether swap limits are enforced on
+// the node, so jobs relying on them can be constrained to nodes that do.
+func (d *LxcDriver) fingerprintSwapAccounting(node *structs.Node) {
+	if lxcSwapAccounting() {
+		node.Attributes[lxcSwapAccountingAttr] = "1"
+	} else {
+		delete(node.Attributes, lxcSwapAccountingAttr)
+	}
+}
+
+// checkSwapAccounting returns whether the task's swap limit can be enforced.
+// Without swap accounting tasks setting one are refused if the client
+// requires it, and otherwise started without it with a task event saying
+// so.
+func (d *LxcDriver) checkSwapAccounting(limits LxcLimitsConfig) (bool, error) {
+	if limits.MemorySwapMB == 0 || lxcSwapAccounting() {
+		return true, nil
+	}
+	if d.config.ReadBoolDefault(lxcRequireSwapAccountingConfigOption, false) {
+		return false, fmt.Errorf("limits[0]: memory_swap_mb can't be enforced as swap accounting is disabled on this node, boot it with swapaccount=1")
+	}
+	d.logger.Printf("[WARN] driver.lxc: not enforcing memory_swap_mb as swap accounting is disabled")
+	d.emitEvent("Swap limit of %d MB is not enforced as swap accounting is disabled on the node", limits.MemorySwapMB)
+	return false, nil
+}
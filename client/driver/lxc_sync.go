@@ -0,0 +1,110 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/client/allocdir"
+)
+
+// lxcSyncTimeoutDefault bounds how long the sync step may delay stopping the
+// container if the task config doesn't set a timeout.
+const lxcSyncTimeoutDefault = 5 * time.Minute
+
+// lxcSync is the sync step run before the container is stopped, copying
+// paths out of the container with rsync.
+type lxcSync struct {
+	// Paths are the absolute paths inside the container to copy
+	Paths []string
+
+	// Destination is the rsync destination: a directory on the host or a
+	// remote rsync target
+	Destination string
+
+	// Args are extra arguments passed to rsync
+	Args []string
+
+	Timeout time.Duration
+}
+
+// isRemoteSyncDestination returns whether the sync destination is a remote
+// rsync target, such as "host:/path" or "rsync://host/module".
+func isRemoteSyncDestination(dest string) bool {
+	return strings.Contains(dest, ":")
+}
+
+// newLxcSync resolves the sync block of the task config. Local destinations
+// are relative to the shared alloc dir and default to its data dir, so that
+// allocations with a migrating ephemeral disk carry the synced data to their
+// replacement.
+func newLxcSync(config []LxcSyncConfig, sharedAllocDir string) *lxcSync {
+	if len(config) == 0 {
+		return nil
+	}
+	c := config[0]
+
+	dest := c.Destination
+	if dest == "" {
+		dest = allocdir.SharedDataDir
+	}
+	if !isRemoteSyncDestination(dest) {
+		dest = filepath.Join(sharedAllocDir, dest) + "/"
+	}
+
+	timeout := lxcSyncTimeoutDefault
+	if c.Timeout != "" {
+		// the format was checked in Validate()
+		timeout, _ = time.ParseDuration(c.Timeout)
+	}
+
+	return &lxcSync{
+		Paths:       c.Paths,
+		Destination: dest,
+		Args:        c.Args,
+		Timeout:     timeout,
+	}
+}
+
+// rsyncArgs returns the rsync arguments copying the paths out of the root of
+// the container with the given init process. Paths keep their structure
+// below the destination.
+func (s *lxcSync) rsyncArgs(initPid int) []string {
+	args := append([]string{"-a", "--relative"}, s.Args...)
+	for _, p := range s.Paths {
+		// The "/./" marker makes --relative keep only the container path
+		args = append(args, fmt.Sprintf("/proc/%d/root/.%s", initPid, p))
+	}
+	return append(args, s.Destination)
+}
+
+// syncData runs the sync step against the running container, reporting its
+// progress as task events.
+func (h *lxcDriverHandle) syncData() error {
+	if !isRemoteSyncDestination(h.sync.Destination) {
+		if err := os.MkdirAll(h.sync.Destination, 0777); err != nil {
+			return fmt.Errorf("failed to create sync destination: %v", err)
+		}
+	}
+
+	h.emitEvent("Syncing %d path(s) from container to %s", len(h.sync.Paths), h.sync.Destination)
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.sync.Timeout)
+	defer cancel()
+	out, err := h.backend.CombinedOutput(ctx, "rsync", h.sync.rsyncArgs(h.initPid)...)
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("rsync timed out after %v", h.sync.Timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("rsync failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	h.emitEvent("Synced container data to %s in %v", h.sync.Destination, time.Since(start).Truncate(time.Millisecond))
+	return nil
+}
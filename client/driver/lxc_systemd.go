@@ -0,0 +1,112 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// lxcSystemdIntervalDefault is how often the container's systemd is
+	// checked for failed units if the systemd block doesn't set an interval.
+	lxcSystemdIntervalDefault = 30 * time.Second
+
+	// lxcSystemdIntervalMin is the shortest allowed check interval.
+	lxcSystemdIntervalMin = 5 * time.Second
+)
+
+// lxcSystemdFailedCmd lists the failed units of the container's systemd.
+// systemctl queries systemd over its D-Bus socket inside the container.
+var lxcSystemdFailedCmd = []string{"systemctl", "list-units", "--state=failed", "--plain", "--no-legend", "--no-pager"}
+
+// newLxcSystemdInterval resolves the check interval of the systemd block of
+// the task config. It is zero if the block isn't given.
+func newLxcSystemdInterval(config []LxcSystemdConfig) time.Duration {
+	if len(config) == 0 {
+		return 0
+	}
+	if config[0].Interval == "" {
+		return lxcSystemdIntervalDefault
+	}
+
+	// the format was checked in Validate()
+	interval, _ := time.ParseDuration(config[0].Interval)
+	return interval
+}
+
+// parseFailedUnits returns the sorted unit names listed by systemctl.
+func parseFailedUnits(r io.Reader) []string {
+	var units []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Older systemctl versions mark failed units with a bullet even
+		// in plain mode
+		if len(fields) != 0 && fields[0] == "●" {
+			fields = fields[1:]
+		}
+		if len(fields) != 0 {
+			units = append(units, fields[0])
+		}
+	}
+	sort.Strings(units)
+	return units
+}
+
+// failedUnits runs systemctl in the container and returns its failed units.
+func (h *lxcDriverHandle) failedUnits() ([]string, error) {
+	out, code, err := h.attach(context.Background(), lxcSystemdFailedCmd, nil)
+	if err != nil {
+		return nil, err
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("systemctl exited with status %d: %s", code, strings.TrimSpace(string(out)))
+	}
+	return parseFailedUnits(bytes.NewReader(out)), nil
+}
+
+// monitorSystemd periodically checks the container's systemd for failed
+// units until stopCh is closed. Changes in the failed units are reported as
+// task events, so a container that booted but is degraded doesn't pass for
+// healthy.
+func (h *lxcDriverHandle) monitorSystemd(stopCh <-chan bool) {
+	name := h.container.Name()
+	var failed string
+
+	ticker := time.NewTicker(h.systemdInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+
+		units, err := h.failedUnits()
+		if err != nil {
+			// systemd may still be booting, or not be the init system
+			h.logger.Printf("[DEBUG] driver.lxc: unable to check systemd units of container %q: %v", name, err)
+			continue
+		}
+
+		current := strings.Join(units, ", ")
+		if current == failed {
+			continue
+		}
+		if current == "" {
+			h.logger.Printf("[INFO] driver.lxc: systemd units of container %q recovered", name)
+			h.emitEvent("No failed systemd units in container")
+		} else {
+			h.logger.Printf("[WARN] driver.lxc: container %q is degraded, failed systemd units: %s", name, current)
+			h.emitEvent("Container degraded, failed systemd units: %s", current)
+		}
+		failed = current
+	}
+}
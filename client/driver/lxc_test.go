@@ -4,15 +4,29 @@ package driver
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/hashicorp/nomad/client/config"
+	"github.com/hashicorp/nomad/client/driver/env"
+	cstructs "github.com/hashicorp/nomad/client/structs"
 	ctestutil "github.com/hashicorp/nomad/client/testutil"
 	"github.com/hashicorp/nomad/nomad/structs"
 	"github.com/hashicorp/nomad/testutil"
@@ -334,3 +348,4994 @@ func TestLxcDriver_Start_NoVolumes(t *testing.T) {
 	}
 
 }
+
+func TestLxcDriver_Start_NameCollision(t *testing.T) {
+	if !testutil.IsTravis() {
+		t.Parallel()
+	}
+	if !lxcPresent(t) {
+		t.Skip("lxc not present")
+	}
+	ctestutil.RequireRoot(t)
+
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template": "/usr/share/lxc/templates/lxc-busybox",
+		},
+		KillTimeout: 10 * time.Second,
+		Resources:   structs.DefaultResources(),
+	}
+
+	ctx := testDriverContexts(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	// Leave a container behind under the task's name
+	containerName := lxcContainerName(task.Name, ctx.DriverCtx.allocID)
+	c, err := lxc.NewContainer(containerName, lxc.DefaultConfigPath())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := c.Create(lxc.TemplateOptions{Template: "/usr/share/lxc/templates/lxc-busybox"}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer c.Destroy()
+
+	// The default policy fails the task
+	d := NewLxcDriver(ctx.DriverCtx)
+	if _, err := d.Start(ctx.ExecCtx, task); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected name collision error, got: %v", err)
+	}
+
+	// Recreating replaces the leftover container
+	ctx.DriverCtx.config.Options = map[string]string{lxcNameCollisionConfigOption: lxcNameCollisionRecreate}
+	d = NewLxcDriver(ctx.DriverCtx)
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	lxcHandle, _ := sresp.Handle.(*lxcDriverHandle)
+	defer func() {
+		lxcHandle.container.Stop()
+		lxcHandle.container.Destroy()
+	}()
+}
+
+func TestLxcDriver_Template_TaskDir(t *testing.T) {
+	t.Parallel()
+
+	taskDir, err := ioutil.TempDir("", "lxc-template")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(taskDir)
+
+	if err := os.MkdirAll(filepath.Join(taskDir, "local"), 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	script := filepath.Join(taskDir, "local", "lxc-custom")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\nexit 0\n"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	archive := filepath.Join(taskDir, "local", "lxc-custom.tar.gz")
+	if err := ioutil.WriteFile(archive, []byte{0x1f, 0x8b, 0x08, 0x00}, 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Installed templates and absolute paths are passed through
+	for _, tmpl := range []string{"busybox", "/usr/share/lxc/templates/lxc-busybox"} {
+		out, err := lxcTemplate(taskDir, tmpl)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if out != tmpl {
+			t.Fatalf("expected %q; got %q", tmpl, out)
+		}
+	}
+
+	out, err := lxcTemplate(taskDir, "local/lxc-custom")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out != script {
+		t.Fatalf("expected %q; got %q", script, out)
+	}
+	fi, err := os.Stat(script)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if fi.Mode().Perm()&0111 != 0111 {
+		t.Fatalf("expected template to be executable; got mode %v", fi.Mode())
+	}
+
+	for _, tmpl := range []string{"local/missing", "local/lxc-custom.tar.gz", "local/"} {
+		if _, err := lxcTemplate(taskDir, tmpl); err == nil {
+			t.Fatalf("expected error for template %q", tmpl)
+		}
+	}
+}
+
+func TestLxcDriver_KernelStaleAttributes(t *testing.T) {
+	t.Parallel()
+	d := &LxcDriver{DriverContext: DriverContext{logger: testLogger()}}
+	node := &structs.Node{Attributes: make(map[string]string)}
+	for _, key := range lxcKernelAttributes {
+		node.Attributes[key] = "stale"
+	}
+
+	// Features the kernel lost are no longer advertised
+	d.fingerprintKernel(node)
+	for _, key := range lxcKernelAttributes {
+		if node.Attributes[key] == "stale" {
+			t.Fatalf("expected stale attribute %q to be removed", key)
+		}
+	}
+}
+
+func TestLxcDriver_KernelHasFilesystem(t *testing.T) {
+	t.Parallel()
+	filesystems := "nodev\tsysfs\nnodev\ttmpfs\n\text4\nnodev\toverlay\n"
+
+	if !kernelHasFilesystem(strings.NewReader(filesystems), "overlay") {
+		t.Fatalf("expected overlay to be detected")
+	}
+	if kernelHasFilesystem(strings.NewReader(filesystems), "btrfs") {
+		t.Fatalf("did not expect btrfs to be detected")
+	}
+}
+
+func TestLxcDriver_ParseKernelVersion(t *testing.T) {
+	t.Parallel()
+	cases := map[string]string{
+		"4.15.0-20-generic":     "4.15.0",
+		"5.12.0":                "5.12.0",
+		"5.4.0.rc1":             "5.4.0",
+		"4.14.35-1818.el7uek.x": "4.14.35",
+	}
+	for release, expected := range cases {
+		v, err := parseKernelVersion(release)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", release, err)
+		}
+		if v.String() != expected {
+			t.Fatalf("expected %q for %q; got %q", expected, release, v.String())
+		}
+	}
+}
+
+func TestLxcDriver_ParseToolVersion(t *testing.T) {
+	t.Parallel()
+	cases := map[string]string{
+		"2.0.8\n":                       "2.0.8",
+		"Version: 3.6\n":                "3.6",
+		"lxc-attach 3.0.3 (built 2018)": "3.0.3",
+		"unknown":                       "",
+	}
+	for out, expected := range cases {
+		if v := parseToolVersion([]byte(out)); v != expected {
+			t.Fatalf("expected %q for %q; got %q", expected, out, v)
+		}
+	}
+}
+
+func TestLxcDriver_ParseThinPools(t *testing.T) {
+	t.Parallel()
+	out := []byte(`  vg1,fast,twi-aotz--,2048.00,
+  vg0,root,-wi-ao----,20480.00,
+  vg0,thin,twi-aotz--,10240.00,
+  vg0,web-1,Vwi-aotz--,8192.00,thin
+  vg0,web-2,Vwi-a-tz--,4096.00,thin
+`)
+	pools := parseThinPools(out)
+	expected := []*lxcThinPool{
+		{name: "vg0/thin", sizeMB: 10240, committedMB: 12288},
+		{name: "vg1/fast", sizeMB: 2048},
+	}
+	if !reflect.DeepEqual(pools, expected) {
+		t.Fatalf("expected %v; got %v", expected, pools)
+	}
+}
+
+func TestLxcDriver_Fake_FingerprintStorage(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{Name: "foo", Driver: "lxc", Resources: structs.DefaultResources()}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	backend.paths = map[string]string{"lvs": "/sbin/lvs"}
+	backend.run = func(name string, args []string) ([]byte, error) {
+		return []byte(`  vg0,thin,twi-aotz--,10240.00,
+  vg0,web-1,Vwi-aotz--,8192.00,thin
+  vg0,web-2,Vwi-a-tz--,4096.00,thin
+`), nil
+	}
+
+	node := &structs.Node{Attributes: make(map[string]string)}
+	d.fingerprintLVM(node)
+	expected := map[string]string{
+		"driver.lxc.lvm.pools":             "vg0/thin",
+		"driver.lxc.storage.capacity_mb":   "10240",
+		"driver.lxc.storage.committed_mb":  "12288",
+		"driver.lxc.storage.overcommitted": "1",
+	}
+	if !reflect.DeepEqual(node.Attributes, expected) {
+		t.Fatalf("expected %v; got %v", expected, node.Attributes)
+	}
+
+	// Over-commitment up to the configured limit is allowed
+	d.config.Options[lxcStorageOvercommitConfigOption] = "150"
+	d.fingerprintLVM(node)
+	if v := node.Attributes["driver.lxc.storage.overcommitted"]; v != "0" {
+		t.Fatalf("expected node within the overcommit limit, got %q", v)
+	}
+}
+
+func TestLxcDriver_Fake_FingerprintVolumeGroups(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{Name: "foo", Driver: "lxc", Resources: structs.DefaultResources()}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	backend.paths = map[string]string{"vgs": "/sbin/vgs"}
+	backend.run = func(name string, args []string) ([]byte, error) {
+		return []byte(`  vg0,20480.00,4096.50
+  data-vg,102400.00,0
+`), nil
+	}
+
+	node := &structs.Node{Attributes: map[string]string{"driver.lxc.lvm.vg.gone.free_mb": "1"}}
+	d.fingerprintVolumeGroups(node)
+	expected := map[string]string{
+		"driver.lxc.lvm.vg.vg0.size_mb":     "20480",
+		"driver.lxc.lvm.vg.vg0.free_mb":     "4096",
+		"driver.lxc.lvm.vg.data-vg.size_mb": "102400",
+		"driver.lxc.lvm.vg.data-vg.free_mb": "0",
+	}
+	if !reflect.DeepEqual(node.Attributes, expected) {
+		t.Fatalf("expected %v; got %v", expected, node.Attributes)
+	}
+
+	// Nodes without LVM advertise no volume groups
+	backend.paths = nil
+	d.fingerprintVolumeGroups(node)
+	if len(node.Attributes) != 0 {
+		t.Fatalf("expected no attributes, got %v", node.Attributes)
+	}
+}
+
+func TestLxcDriver_Fake_FingerprintTimeout(t *testing.T) {
+	task := &structs.Task{Name: "foo", Driver: "lxc", Resources: structs.DefaultResources()}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	oldTimeout := lxcFingerprintExecTimeout
+	lxcFingerprintExecTimeout = 50 * time.Millisecond
+	defer func() { lxcFingerprintExecTimeout = oldTimeout }()
+
+	// The tools are executables whose versions are cached until they change
+	dir, err := ioutil.TempDir("", "lxc-tools")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	attach := filepath.Join(dir, "lxc-attach")
+	if err := ioutil.WriteFile(attach, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	backend.paths = map[string]string{"lxc-attach": attach, "lvs": "/sbin/lvs", "vgs": "/sbin/vgs"}
+
+	// The LVM tools hang, as they do on lock contention
+	hung := make(chan struct{})
+	defer close(hung)
+	backend.run = func(name string, args []string) ([]byte, error) {
+		if name == attach {
+			return []byte("2.0.8"), nil
+		}
+		<-hung
+		return nil, nil
+	}
+
+	node := &structs.Node{Attributes: make(map[string]string)}
+	start := time.Now()
+	d.fingerprintTools(node)
+	d.fingerprintLVM(node)
+	d.fingerprintVolumeGroups(node)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected hung tools to time out, took %v", elapsed)
+	}
+	if v := node.Attributes["driver.lxc.attach.version"]; v != "2.0.8" {
+		t.Fatalf("expected lxc-attach version, got %q", v)
+	}
+	if _, ok := node.Attributes["driver.lxc.lvm.pools"]; ok {
+		t.Fatalf("expected no pools, got %v", node.Attributes)
+	}
+
+	// Versions aren't determined again until the tool changes
+	backend.commands = nil
+	d.fingerprintTools(node)
+	if len(backend.commands) != 0 {
+		t.Fatalf("expected cached versions, got %v", backend.commands)
+	}
+	if err := ioutil.WriteFile(attach, []byte("#!/bin/sh\nexit 0\n"), 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	d.fingerprintTools(node)
+	if len(backend.commands) != 1 || backend.commands[0][0] != attach {
+		t.Fatalf("expected upgraded tool to be run, got %v", backend.commands)
+	}
+}
+
+func TestLxcDriver_Validate_Blocks(t *testing.T) {
+	t.Parallel()
+	d := NewLxcDriver(NewEmptyDriverContext())
+
+	valid := map[string]interface{}{
+		"template": "download",
+		"image": []map[string]interface{}{
+			{"distro": "ubuntu", "release": "xenial", "arch": "amd64", "serial": "20181017_07:42"},
+		},
+		"mount": []map[string]interface{}{
+			{"source": "/srv/data", "target": "srv/data", "readonly": true},
+			{"source": "local/cache", "target": "var/cache/app"},
+		},
+		"network": []map[string]interface{}{
+			{
+				"type":           "veth",
+				"bridge":         "br0",
+				"name":           "eth1",
+				"ipv4_address":   "192.168.1.10/24",
+				"ipv4_gateway":   "192.168.1.1",
+				"ipv6_address":   "2001:db8::10/64",
+				"ipv6_gateway":   "2001:db8::1",
+				"ipv6_accept_ra": false,
+				"routes":         []string{"10.8.0.0/16 via 192.168.1.254", "2001:db8:1::/48 via 2001:db8::fe"},
+				"hwaddr":         "00:16:3e:xx:xx:xx",
+				"vlan_id":        100,
+			},
+		},
+		"limits": []map[string]interface{}{
+			{"cpuset_cpus": "0-1,3", "memory_swap_mb": 256, "pids_max": 1024, "egress_mbits": 100, "ingress_mbits": 200},
+		},
+		"sync": []map[string]interface{}{
+			{"paths": []string{"/var/lib/app"}, "destination": "data/app", "timeout": "2m"},
+		},
+		"systemd": []map[string]interface{}{
+			{"interval": "1m"},
+		},
+		"timezone":         "America/Argentina/Buenos_Aires",
+		"cgroup_namespace": "host",
+		"time_offset": []map[string]interface{}{
+			{"monotonic": "-1h", "boottime": "36h"},
+		},
+		"shared_volume": []map[string]interface{}{
+			{"name": "cache", "target": "var/cache/app"},
+			{"name": "config", "target": "etc/app", "readonly": true},
+		},
+		"rootfs_copy": []map[string]interface{}{
+			{"source": "local/app", "target": "/opt/app"},
+		},
+		"usage_alerts": []map[string]interface{}{
+			{"open_files": 4096, "inotify_watches": 8192},
+		},
+		"port_map": []map[string]string{
+			{"http": "8080", "admin": "${NOMAD_META_admin_port}"},
+		},
+		"core_dumps": []map[string]interface{}{
+			{"max_size_mb": 512},
+		},
+		"dns_servers":        []string{"10.0.0.2", "2001:db8::53", "${NOMAD_META_dns}"},
+		"dns_search_domains": []string{"service.consul", "example.com"},
+		"extra_hosts":        []string{"db.example.com:10.0.0.5", "ipv6.example.com:2001:db8::5", "app:${NOMAD_META_app_ip}"},
+		"secret": []map[string]interface{}{
+			{"source": "key.pem", "target": "/etc/ssl/private/key.pem", "mode": "0600", "uid": 33, "gid": 33},
+			{"source": "app.env", "target": "/etc/app/env"},
+		},
+	}
+	if err := d.Validate(valid); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Other templates only take the release, arch and flush_cache
+	busybox := map[string]interface{}{
+		"template":    "busybox",
+		"release":     "1.28",
+		"arch":        "amd64",
+		"flush_cache": true,
+	}
+	if err := d.Validate(busybox); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	app := map[string]interface{}{
+		"template": "busybox",
+		"mode":     "app",
+		"command":  "/usr/bin/myapp",
+		"args":     []string{"-listen", ":${NOMAD_PORT_http}"},
+	}
+	if err := d.Validate(app); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	host := map[string]interface{}{
+		"template":     "busybox",
+		"network_mode": "host",
+		"network":      []map[string]interface{}{{"type": "none"}},
+	}
+	if err := d.Validate(host); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	archive := map[string]interface{}{
+		"rootfs_archive": "local/rootfs.tar.xz",
+		"backend":        "btrfs",
+		"mode":           "app",
+		"command":        "/usr/bin/myapp",
+	}
+	if err := d.Validate(archive); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	oci := map[string]interface{}{
+		"oci_image": "docker://alpine:3.8",
+		"mode":      "app",
+		"command":   "/bin/sleep",
+		"args":      []string{"60"},
+	}
+	if err := d.Validate(oci); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sidecar := map[string]interface{}{
+		"template":     "busybox",
+		"network_mode": "task:proxy",
+		"port_map":     []map[string]string{{"http": "8080"}},
+	}
+	if err := d.Validate(sidecar); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, mode := range []string{"netns:mesh", "netns:/proc/1/ns/net"} {
+		if err := d.Validate(map[string]interface{}{"template": "busybox", "network_mode": mode}); err != nil {
+			t.Fatalf("unexpected error for %q: %v", mode, err)
+		}
+	}
+
+	invalid := map[string]map[string]interface{}{
+		"missing template": {
+			"mode": "system",
+		},
+		"invalid image serial": {
+			"template":     "download",
+			"distro":       "ubuntu",
+			"release":      "bionic",
+			"arch":         "amd64",
+			"image_serial": "2018 10 17",
+		},
+		"image serial without download": {
+			"template":     "busybox",
+			"image_serial": "20181017_07:42",
+		},
+		"image server token with password": {
+			"template":              "download",
+			"distro":                "ubuntu",
+			"release":               "bionic",
+			"arch":                  "amd64",
+			"image_server_username": "deploy",
+			"image_server_password": "secret",
+			"image_server_token":    "secret",
+		},
+		"image server credentials without download": {
+			"template":           "busybox",
+			"image_server_token": "secret",
+		},
+		"invalid proxy": {
+			"template":   "busybox",
+			"http_proxy": "proxy.example.com:3128",
+		},
+		"rootfs archive with template": {
+			"template":       "busybox",
+			"rootfs_archive": "local/rootfs.tar.gz",
+		},
+		"rootfs archive escaping the task directory": {
+			"rootfs_archive": "../rootfs.tar.gz",
+		},
+		"unknown backend": {
+			"template": "busybox",
+			"backend":  "rbd",
+		},
+		"negative rootfs size": {
+			"template":       "download",
+			"backend":        "zfs",
+			"rootfs_size_mb": -1,
+		},
+		"rootfs size without backend": {
+			"template":       "download",
+			"rootfs_size_mb": 512,
+		},
+		"invalid preserve_on_failure": {
+			"template":            "download",
+			"preserve_on_failure": "a while",
+		},
+		"negative preserve_on_failure": {
+			"template":            "download",
+			"preserve_on_failure": "-1h",
+		},
+		"invalid create timeout": {
+			"template":       "busybox",
+			"create_timeout": "0s",
+		},
+		"stop signal without timeout": {
+			"template": "busybox",
+			"stop": []map[string]interface{}{
+				{"signal": "SIGINT"},
+			},
+		},
+		"invalid stop shutdown timeout": {
+			"template": "busybox",
+			"stop": []map[string]interface{}{
+				{"shutdown_timeout": "-5s"},
+			},
+		},
+		"oci image with template": {
+			"template":  "busybox",
+			"oci_image": "alpine:3.8",
+		},
+		"oci image with release": {
+			"oci_image": "alpine:3.8",
+			"release":   "3.8",
+		},
+		"oci image with image server": {
+			"oci_image":    "alpine:3.8",
+			"image_server": "images.example.com",
+		},
+		"unknown image key": {
+			"template": "download",
+			"image":    []map[string]interface{}{{"distribution": "ubuntu"}},
+		},
+		"duplicate image blocks": {
+			"template": "download",
+			"image":    []map[string]interface{}{{"distro": "ubuntu"}, {"distro": "debian"}},
+		},
+		"image conflicts with flat key": {
+			"template": "download",
+			"distro":   "debian",
+			"image":    []map[string]interface{}{{"distro": "ubuntu"}},
+		},
+		"mount missing target": {
+			"template": "busybox",
+			"mount":    []map[string]interface{}{{"source": "/srv"}},
+		},
+		"mount absolute target": {
+			"template": "busybox",
+			"mount":    []map[string]interface{}{{"source": "/srv", "target": "/srv"}},
+		},
+		"unsupported network": {
+			"template": "busybox",
+			"network":  []map[string]interface{}{{"type": "bogus"}},
+		},
+		"veth without bridge": {
+			"template": "busybox",
+			"network":  []map[string]interface{}{{"type": "veth"}},
+		},
+		"invalid interface name": {
+			"template": "busybox",
+			"network":  []map[string]interface{}{{"type": "veth", "bridge": "br0", "name": "a-very-long-interface"}},
+		},
+		"invalid dns server": {
+			"template":    "busybox",
+			"dns_servers": []string{"8.8.8"},
+		},
+		"invalid dns search domain": {
+			"template":           "busybox",
+			"dns_search_domains": []string{"example com"},
+		},
+		"unknown network mode": {
+			"template":     "busybox",
+			"network_mode": "bridge",
+		},
+		"task network mode without task": {
+			"template":     "busybox",
+			"network_mode": "task:",
+		},
+		"netns network mode without namespace": {
+			"template":     "busybox",
+			"network_mode": "netns:",
+		},
+		"netns network mode with relative path": {
+			"template":     "busybox",
+			"network_mode": "netns:run/mesh",
+		},
+		"netns network mode with port_map": {
+			"template":     "busybox",
+			"network_mode": "netns:mesh",
+			"port_map":     []map[string]string{{"http": "8080"}},
+		},
+		"host network mode with veth": {
+			"template":     "busybox",
+			"network_mode": "host",
+			"network":      []map[string]interface{}{{"type": "veth", "bridge": "br0"}},
+		},
+		"unknown mode": {
+			"template": "busybox",
+			"mode":     "vm",
+		},
+		"app mode without command": {
+			"template": "busybox",
+			"mode":     "app",
+		},
+		"command in system mode": {
+			"template": "busybox",
+			"command":  "/usr/bin/myapp",
+		},
+		"systemd in app mode": {
+			"template": "busybox",
+			"mode":     "app",
+			"command":  "/usr/bin/myapp",
+			"systemd":  []map[string]interface{}{{"interval": "1m"}},
+		},
+		"console_log in app mode": {
+			"template":    "busybox",
+			"mode":        "app",
+			"command":     "/usr/bin/myapp",
+			"console_log": true,
+		},
+		"extra host without address": {
+			"template":    "busybox",
+			"extra_hosts": []string{"db.example.com"},
+		},
+		"extra host with invalid address": {
+			"template":    "busybox",
+			"extra_hosts": []string{"db.example.com:10.0.0"},
+		},
+		"bridge without veth": {
+			"template": "busybox",
+			"network":  []map[string]interface{}{{"bridge": "br0"}},
+		},
+		"address without veth": {
+			"template": "busybox",
+			"network":  []map[string]interface{}{{"ipv4_address": "192.168.1.10/24"}},
+		},
+		"address without prefix length": {
+			"template": "busybox",
+			"network":  []map[string]interface{}{{"type": "veth", "bridge": "br0", "ipv4_address": "192.168.1.10"}},
+		},
+		"gateway without address": {
+			"template": "busybox",
+			"network":  []map[string]interface{}{{"type": "veth", "bridge": "br0", "ipv4_gateway": "192.168.1.1"}},
+		},
+		"invalid route": {
+			"template": "busybox",
+			"network": []map[string]interface{}{{
+				"type":         "veth",
+				"bridge":       "br0",
+				"ipv4_address": "192.168.1.10/24",
+				"routes":       []string{"10.8.0.0/16 192.168.1.254"},
+			}},
+		},
+		"invalid ipv6 address": {
+			"template": "busybox",
+			"network":  []map[string]interface{}{{"type": "veth", "bridge": "br0", "ipv6_address": "192.168.1.10/24"}},
+		},
+		"ipv6 gateway without address": {
+			"template": "busybox",
+			"network":  []map[string]interface{}{{"type": "veth", "bridge": "br0", "ipv6_gateway": "2001:db8::1"}},
+		},
+		"ipv6 route without ipv6 address": {
+			"template": "busybox",
+			"network": []map[string]interface{}{{
+				"type":         "veth",
+				"bridge":       "br0",
+				"ipv4_address": "192.168.1.10/24",
+				"routes":       []string{"2001:db8:1::/48 via 2001:db8::fe"},
+			}},
+		},
+		"route of mixed families": {
+			"template": "busybox",
+			"network": []map[string]interface{}{{
+				"type":         "veth",
+				"bridge":       "br0",
+				"ipv4_address": "192.168.1.10/24",
+				"routes":       []string{"10.8.0.0/16 via 2001:db8::fe"},
+			}},
+		},
+		"macvlan without parent": {
+			"template": "busybox",
+			"network":  []map[string]interface{}{{"type": "macvlan"}},
+		},
+		"invalid ipvlan mode": {
+			"template": "busybox",
+			"network":  []map[string]interface{}{{"type": "ipvlan", "parent": "eth0", "mode": "bridge"}},
+		},
+		"parent with veth": {
+			"template": "busybox",
+			"network":  []map[string]interface{}{{"type": "veth", "bridge": "br0", "parent": "eth0"}},
+		},
+		"auto gateway with macvlan": {
+			"template": "busybox",
+			"network": []map[string]interface{}{{
+				"type":         "macvlan",
+				"parent":       "eth0",
+				"ipv4_address": "192.168.1.10/24",
+				"ipv4_gateway": "auto",
+			}},
+		},
+		"port map without veth": {
+			"template": "busybox",
+			"port_map": []map[string]string{{"http": "8080"}},
+		},
+		"port map invalid port": {
+			"template": "busybox",
+			"network":  []map[string]interface{}{{"type": "veth", "bridge": "br0"}},
+			"port_map": []map[string]string{{"http": "80000"}},
+		},
+		"negative core dumps size": {
+			"template":   "busybox",
+			"core_dumps": []map[string]interface{}{{"max_size_mb": -1}},
+		},
+		"negative usage alert": {
+			"template":     "busybox",
+			"usage_alerts": []map[string]interface{}{{"open_files": -1}},
+		},
+		"invalid cpuset": {
+			"template": "busybox",
+			"limits":   []map[string]interface{}{{"cpuset_cpus": "0-"}},
+		},
+		"negative egress mbits": {
+			"template": "busybox",
+			"network":  []map[string]interface{}{{"type": "veth", "bridge": "br0"}},
+			"limits":   []map[string]interface{}{{"egress_mbits": -1}},
+		},
+		"vlan without vlan_id": {
+			"template": "busybox",
+			"network":  []map[string]interface{}{{"type": "vlan", "parent": "eth0"}},
+		},
+		"vlan_id out of range": {
+			"template": "busybox",
+			"network":  []map[string]interface{}{{"type": "vlan", "parent": "eth0", "vlan_id": 4095}},
+		},
+		"vlan_id on macvlan": {
+			"template": "busybox",
+			"network":  []map[string]interface{}{{"type": "macvlan", "parent": "eth0", "vlan_id": 10}},
+		},
+		"invalid hwaddr": {
+			"template": "busybox",
+			"network":  []map[string]interface{}{{"type": "veth", "bridge": "br0", "hwaddr": "00:16:3e:12:34"}},
+		},
+		"hwaddr without interface": {
+			"template": "busybox",
+			"network":  []map[string]interface{}{{"hwaddr": "00:16:3e:12:34:56"}},
+		},
+		"ingress mbits without veth": {
+			"template": "busybox",
+			"limits":   []map[string]interface{}{{"ingress_mbits": 100}},
+		},
+		"sync relative path": {
+			"template": "busybox",
+			"sync":     []map[string]interface{}{{"paths": []string{"var/lib/app"}}},
+		},
+		"sync destination escapes": {
+			"template": "busybox",
+			"sync":     []map[string]interface{}{{"paths": []string{"/srv"}, "destination": "../other"}},
+		},
+		"rootfs copy escaping source": {
+			"template":    "busybox",
+			"rootfs_copy": []map[string]interface{}{{"source": "../../etc", "target": "/opt/app"}},
+		},
+		"rootfs copy relative target": {
+			"template":    "busybox",
+			"rootfs_copy": []map[string]interface{}{{"source": "local/app", "target": "opt/app"}},
+		},
+		"secret escaping source": {
+			"template": "busybox",
+			"secret":   []map[string]interface{}{{"source": "../local/key.pem", "target": "/etc/key.pem"}},
+		},
+		"secret relative target": {
+			"template": "busybox",
+			"secret":   []map[string]interface{}{{"source": "key.pem", "target": "etc/key.pem"}},
+		},
+		"secret invalid mode": {
+			"template": "busybox",
+			"secret":   []map[string]interface{}{{"source": "key.pem", "target": "/etc/key.pem", "mode": "rw"}},
+		},
+		"secret duplicate target": {
+			"template": "busybox",
+			"secret": []map[string]interface{}{
+				{"source": "a.pem", "target": "/etc/key.pem"},
+				{"source": "b.pem", "target": "/etc/key.pem"},
+			},
+		},
+		"shared volume invalid name": {
+			"template":      "busybox",
+			"shared_volume": []map[string]interface{}{{"name": "../etc", "target": "etc"}},
+		},
+		"shared volume duplicate target": {
+			"template": "busybox",
+			"shared_volume": []map[string]interface{}{
+				{"name": "a", "target": "data"},
+				{"name": "b", "target": "data"},
+			},
+		},
+		"sync invalid timeout": {
+			"template": "busybox",
+			"sync":     []map[string]interface{}{{"paths": []string{"/srv"}, "timeout": "soon"}},
+		},
+		"timezone escapes zoneinfo": {
+			"template": "busybox",
+			"timezone": "../../etc/shadow",
+		},
+		"invalid cgroup namespace": {
+			"template":         "busybox",
+			"cgroup_namespace": "shared",
+		},
+		"invalid time offset": {
+			"template":    "busybox",
+			"time_offset": []map[string]interface{}{{"boottime": "tomorrow"}},
+		},
+		"systemd interval too short": {
+			"template": "busybox",
+			"systemd":  []map[string]interface{}{{"interval": "1s"}},
+		},
+		"empty provision command": {
+			"template":       "busybox",
+			"provision_cmds": []string{"systemctl enable app", " "},
+		},
+		"invalid max uptime": {
+			"template":   "busybox",
+			"max_uptime": "-1h",
+		},
+		"max uptime jitter without max uptime": {
+			"template":          "busybox",
+			"max_uptime_jitter": "1h",
+		},
+		"download key for other template": {
+			"template": "busybox",
+			"distro":   "ubuntu",
+		},
+		"download image block for other template": {
+			"template": "/usr/share/lxc/templates/lxc-download",
+			"image":    []map[string]interface{}{{"variant": "cloud"}},
+		},
+		"download missing arch": {
+			"template": "download",
+			"distro":   "ubuntu",
+			"release":  "xenial",
+		},
+		"invalid image variant": {
+			"template":      "download",
+			"image":         []map[string]interface{}{{"distro": "ubuntu", "release": "xenial", "arch": "amd64"}},
+			"image_variant": "cloud init",
+		},
+		"image server with scheme": {
+			"template": "download",
+			"image":    []map[string]interface{}{{"distro": "ubuntu", "release": "xenial", "arch": "amd64", "server": "https://images.example.com"}},
+		},
+	}
+	for name, config := range invalid {
+		if err := d.Validate(config); err == nil {
+			t.Fatalf("%s: expected validation error", name)
+		}
+	}
+}
+
+func TestLxcDriver_NewLxcDriverConfig(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name: "foo",
+		Config: map[string]interface{}{
+			"template": "download",
+			"arch":     "amd64",
+			"image": []map[string]interface{}{
+				{"distro": "ubuntu", "release": "xenial", "variant": "cloud"},
+			},
+			"volumes": []string{"/tmp:mnt/tmp", "/srv/${node.unique.name}:srv/${NOMAD_ALLOC_INDEX}"},
+			"mount": []map[string]interface{}{
+				{"source": "local/data", "target": "data", "readonly": true},
+			},
+		},
+	}
+	taskEnv := env.NewTaskEnv(
+		map[string]string{"NOMAD_ALLOC_INDEX": "2"},
+		map[string]string{"node.unique.name": "node1"},
+	)
+
+	c, err := NewLxcDriverConfig(task, taskEnv)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if c.Distro != "ubuntu" || c.Release != "xenial" || c.Arch != "amd64" || c.ImageVariant != "cloud" {
+		t.Fatalf("image block not merged: %+v", c)
+	}
+	expected := []LxcMountConfig{
+		{Source: "local/data", Target: "data", ReadOnly: true},
+		{Source: "/tmp", Target: "mnt/tmp"},
+		{Source: "/srv/node1", Target: "srv/2"},
+	}
+	if !reflect.DeepEqual(c.Mounts, expected) {
+		t.Fatalf("expected mounts %+v; got %+v", expected, c.Mounts)
+	}
+	if c.Network[0].Type != "none" {
+		t.Fatalf("expected default network type none; got %q", c.Network[0].Type)
+	}
+
+	// Targets may not become absolute through interpolation
+	task.Config["volumes"] = []string{"/tmp:${NOMAD_TARGET}"}
+	taskEnv = env.NewTaskEnv(map[string]string{"NOMAD_TARGET": "/etc"}, nil)
+	if _, err := NewLxcDriverConfig(task, taskEnv); err == nil {
+		t.Fatalf("expected error for interpolated absolute target")
+	}
+
+	// Secrets may not escape the secrets directory through interpolation
+	delete(task.Config, "volumes")
+	task.Config["secret"] = []map[string]interface{}{
+		{"source": "${NOMAD_META_source}", "target": "${NOMAD_META_target}"},
+	}
+	taskEnv = env.NewTaskEnv(map[string]string{"NOMAD_META_source": "../../../../etc/shadow", "NOMAD_META_target": "/etc/shadow"}, nil)
+	if _, err := NewLxcDriverConfig(task, taskEnv); err == nil || !strings.Contains(err.Error(), "escapes the secrets directory") {
+		t.Fatalf("expected error for interpolated escaping source, got %v", err)
+	}
+	taskEnv = env.NewTaskEnv(map[string]string{"NOMAD_META_source": "key.pem", "NOMAD_META_target": "etc/../shadow"}, nil)
+	if _, err := NewLxcDriverConfig(task, taskEnv); err == nil || !strings.Contains(err.Error(), "clean absolute container path") {
+		t.Fatalf("expected error for interpolated relative target, got %v", err)
+	}
+
+	// So may the rootfs archive
+	delete(task.Config, "secret")
+	task.Config["rootfs_archive"] = "local/${NOMAD_META_archive}"
+	taskEnv = env.NewTaskEnv(map[string]string{"NOMAD_META_archive": "../../../../srv/host.tar"}, nil)
+	if _, err := NewLxcDriverConfig(task, taskEnv); err == nil || !strings.Contains(err.Error(), "escapes the task directory") {
+		t.Fatalf("expected error for interpolated escaping archive, got %v", err)
+	}
+}
+
+func TestLxcDriver_ParseContainerName(t *testing.T) {
+	allocID := "8b6fd1a2-5b7e-4f0d-9b0c-3c1f0c5a7e21"
+	cases := []struct {
+		name    string
+		task    string
+		allocID string
+		ok      bool
+	}{
+		{lxcContainerName("web", allocID), "web", allocID, true},
+		{lxcContainerName("web-frontend", allocID), "web-frontend", allocID, true},
+		{"-" + allocID, "", "", false},
+		{allocID, "", "", false},
+		{"web", "", "", false},
+		{"web-8b6fd1a2", "", "", false},
+	}
+	for _, c := range cases {
+		task, allocID, ok := parseLxcContainerName(c.name)
+		if task != c.task || allocID != c.allocID || ok != c.ok {
+			t.Errorf("parseLxcContainerName(%q) = %q, %q, %v; want %q, %q, %v",
+				c.name, task, allocID, ok, c.task, c.allocID, c.ok)
+		}
+	}
+}
+
+func TestLxcDriver_Sync(t *testing.T) {
+	if s := newLxcSync(nil, "/alloc"); s != nil {
+		t.Fatalf("expected no sync step, got %#v", s)
+	}
+
+	s := newLxcSync([]LxcSyncConfig{{Paths: []string{"/var/lib/app", "/etc/app"}}}, "/alloc")
+	if s.Destination != "/alloc/data/" || s.Timeout != lxcSyncTimeoutDefault {
+		t.Fatalf("unexpected defaults: %#v", s)
+	}
+	expected := []string{"-a", "--relative", "/proc/42/root/./var/lib/app", "/proc/42/root/./etc/app", "/alloc/data/"}
+	if args := s.rsyncArgs(42); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("got %v; want %v", args, expected)
+	}
+
+	s = newLxcSync([]LxcSyncConfig{{
+		Paths:       []string{"/srv"},
+		Destination: "backup:/srv/nomad",
+		Args:        []string{"--delete"},
+		Timeout:     "30s",
+	}}, "/alloc")
+	expected = []string{"-a", "--relative", "--delete", "/proc/7/root/./srv", "backup:/srv/nomad"}
+	if args := s.rsyncArgs(7); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("got %v; want %v", args, expected)
+	}
+	if s.Timeout != 30*time.Second {
+		t.Fatalf("unexpected timeout: %v", s.Timeout)
+	}
+}
+
+func TestLxcDriver_SystemdUnits(t *testing.T) {
+	if i := newLxcSystemdInterval(nil); i != 0 {
+		t.Fatalf("expected no systemd checks, got %v", i)
+	}
+	if i := newLxcSystemdInterval([]LxcSystemdConfig{{}}); i != lxcSystemdIntervalDefault {
+		t.Fatalf("unexpected default interval: %v", i)
+	}
+	if i := newLxcSystemdInterval([]LxcSystemdConfig{{Interval: "2m"}}); i != 2*time.Minute {
+		t.Fatalf("unexpected interval: %v", i)
+	}
+
+	out := `nginx.service loaded failed failed A high performance web server
+● apt-daily.service loaded failed failed Daily apt download activities
+
+`
+	expected := []string{"apt-daily.service", "nginx.service"}
+	if units := parseFailedUnits(strings.NewReader(out)); !reflect.DeepEqual(units, expected) {
+		t.Fatalf("got %v; want %v", units, expected)
+	}
+	if units := parseFailedUnits(strings.NewReader("")); len(units) != 0 {
+		t.Fatalf("expected no failed units, got %v", units)
+	}
+}
+
+func TestLxcDriver_Timezone(t *testing.T) {
+	if offset := lxcTimeOffset(-90 * time.Minute); offset != "-5400000000000ns" {
+		t.Fatalf("unexpected offset: %q", offset)
+	}
+
+	hostZoneinfo, err := ioutil.TempDir("", "zoneinfo")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(hostZoneinfo)
+	oldHostZoneinfo := hostZoneinfoDir
+	hostZoneinfoDir = hostZoneinfo
+	defer func() { hostZoneinfoDir = oldHostZoneinfo }()
+	if err := os.MkdirAll(filepath.Join(hostZoneinfo, "Europe"), 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(hostZoneinfo, "Europe", "Berlin"), []byte("TZif"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	rootfs, err := ioutil.TempDir("", "rootfs")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(rootfs)
+	etc := filepath.Join(rootfs, "etc")
+	if err := os.MkdirAll(etc, 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := os.Symlink("/usr/share/zoneinfo/Etc/UTC", filepath.Join(etc, "localtime")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(etc, "timezone"), []byte("Etc/UTC\n"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Without the timezone database the host's zone file is copied
+	if err := setRootfsTimezone(rootfs, "Europe/Berlin"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(etc, "localtime")); err != nil || string(data) != "TZif" {
+		t.Fatalf("unexpected localtime: %q %v", data, err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(etc, "timezone")); string(data) != "Europe/Berlin\n" {
+		t.Fatalf("unexpected timezone: %q", data)
+	}
+
+	// With it, localtime links to the container's zone file
+	zone := filepath.Join(rootfs, "usr", "share", "zoneinfo", "Asia", "Tokyo")
+	if err := os.MkdirAll(filepath.Dir(zone), 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := ioutil.WriteFile(zone, []byte("TZif"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := setRootfsTimezone(rootfs, "Asia/Tokyo"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if link, err := os.Readlink(filepath.Join(etc, "localtime")); err != nil || link != "/usr/share/zoneinfo/Asia/Tokyo" {
+		t.Fatalf("unexpected localtime link: %q %v", link, err)
+	}
+
+	if err := setRootfsTimezone(rootfs, "Mars/Olympus_Mons"); err == nil {
+		t.Fatalf("expected error for an unknown timezone")
+	}
+}
+
+func TestLxcDriver_ContainerEnv(t *testing.T) {
+	d := &LxcDriver{DriverContext: DriverContext{
+		config: &config.Config{Options: map[string]string{
+			"driver.lxc.env.LANG":       "C.UTF-8",
+			"driver.lxc.env.HTTP_PROXY": "http://proxy:3128",
+			"driver.lxc.env.":           "ignored",
+			"driver.lxc.enable":         "1",
+		}},
+	}}
+	defaults := d.defaultEnv()
+	if len(defaults) != 2 || defaults["LANG"] != "C.UTF-8" {
+		t.Fatalf("unexpected default environment: %v", defaults)
+	}
+
+	task := &structs.Task{
+		Name: "web",
+		Env:  map[string]string{"LANG": "de_DE.UTF-8", "APP": "${NOMAD_TASK_NAME}"},
+	}
+	taskEnv := env.NewTaskEnv(map[string]string{"LANG": "de_DE.UTF-8", "APP": "web"}, nil)
+	expected := []string{"APP=web", "HTTP_PROXY=http://proxy:3128", "LANG=de_DE.UTF-8", "TZ=Etc/UTC"}
+	if vars := containerEnv(defaults, task, taskEnv, "Etc/UTC"); !reflect.DeepEqual(vars, expected) {
+		t.Fatalf("got %v; want %v", vars, expected)
+	}
+}
+
+func TestLxcDriver_CgroupDir(t *testing.T) {
+	taskEnv := env.NewTaskEnv(map[string]string{"NOMAD_ALLOC_ID": "2f3b9a1e"}, nil)
+	cases := []struct {
+		parent   string
+		expected string
+		err      bool
+	}{
+		{"", "", false},
+		{"nomad.slice", "nomad.slice/web-2f3b9a1e", false},
+		{"/nomad.slice/${NOMAD_ALLOC_ID}/", "nomad.slice/2f3b9a1e/web-2f3b9a1e", false},
+		{"../escape", "", true},
+		{"nomad//slice", "", true},
+	}
+	for _, c := range cases {
+		dir, err := lxcCgroupDir(c.parent, "web-2f3b9a1e", taskEnv)
+		if (err != nil) != c.err || dir != c.expected {
+			t.Fatalf("%q: got %q, %v; want %q", c.parent, dir, err, c.expected)
+		}
+	}
+}
+
+func TestLxcDriver_ImageMirrors(t *testing.T) {
+	d := &LxcDriver{DriverContext: DriverContext{
+		config: &config.Config{Options: map[string]string{
+			"driver.lxc.image_mirror":          "images.internal",
+			"driver.lxc.image_mirror.dc2":      "images.dc2.internal",
+			"driver.lxc.gpg_key_server_mirror": "keys.internal",
+		}},
+		logger: testLogger(),
+		node:   &structs.Node{Datacenter: "dc1"},
+	}}
+
+	options := lxc.TemplateOptions{Server: "images.example.com", KeyServer: "pool.sks-keyservers.net"}
+	d.applyImageMirrors(&options)
+	if options.Server != "images.internal" || options.KeyServer != "keys.internal" {
+		t.Fatalf("unexpected servers: %q %q", options.Server, options.KeyServer)
+	}
+
+	d.node.Datacenter = "dc2"
+	options = lxc.TemplateOptions{}
+	d.applyImageMirrors(&options)
+	if options.Server != "images.dc2.internal" || options.KeyServer != "keys.internal" {
+		t.Fatalf("unexpected servers: %q %q", options.Server, options.KeyServer)
+	}
+
+	d.config.Options = nil
+	options = lxc.TemplateOptions{Server: "images.example.com"}
+	d.applyImageMirrors(&options)
+	if options.Server != "images.example.com" || options.KeyServer != "" {
+		t.Fatalf("unexpected servers: %q %q", options.Server, options.KeyServer)
+	}
+}
+
+func TestLxcDriver_StatsCollector(t *testing.T) {
+	s := &lxcStatsCollector{handles: make(map[*lxcDriverHandle]struct{})}
+	h1, h2 := &lxcDriverHandle{}, &lxcDriverHandle{}
+
+	s.register(h1, time.Hour)
+	stopCh := s.stopCh
+	if stopCh == nil {
+		t.Fatalf("expected collector to be running")
+	}
+	s.register(h2, time.Hour)
+	if s.stopCh != stopCh {
+		t.Fatalf("expected a single collector")
+	}
+
+	s.deregister(h1)
+	if s.stopCh == nil {
+		t.Fatalf("expected collector to keep running while handles are registered")
+	}
+	s.deregister(h2)
+	if s.stopCh != nil {
+		t.Fatalf("expected collector to stop")
+	}
+	select {
+	case <-stopCh:
+	default:
+		t.Fatalf("expected collector goroutine to be stopped")
+	}
+
+	// Stats serves the cached sample
+	usage := &cstructs.TaskResourceUsage{Timestamp: 42}
+	h1.latestStats = usage
+	if out, err := h1.Stats(); err != nil || out != usage {
+		t.Fatalf("expected cached stats, got %v, %v", out, err)
+	}
+}
+
+func TestLxcDriver_ParseUnifiedCgroup(t *testing.T) {
+	v1 := "12:pids:/lxc/foo\n11:memory:/lxc/foo\n0::/init.scope\n"
+	if path, ok := parseUnifiedCgroup(strings.NewReader(v1)); !ok || path != "/init.scope" {
+		t.Fatalf("got %q, %v", path, ok)
+	}
+	if _, ok := parseUnifiedCgroup(strings.NewReader("12:pids:/lxc/foo\n")); ok {
+		t.Fatalf("expected no unified cgroup")
+	}
+}
+
+func TestLxcDriver_WatchCgroupEmpty(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lxc-cgroup")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	events := filepath.Join(dir, "cgroup.events")
+	if err := ioutil.WriteFile(events, []byte("populated 1\nfrozen 0\n"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	stopCh := make(chan bool)
+	defer close(stopCh)
+	emptyCh, err := watchCgroupEmpty(events, stopCh)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	select {
+	case <-emptyCh:
+		t.Fatalf("cgroup reported empty while populated")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := ioutil.WriteFile(events, []byte("populated 0\nfrozen 0\n"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case <-emptyCh:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("cgroup exit not detected")
+	}
+}
+
+func TestLxcDriver_PhaseParallelism(t *testing.T) {
+	events := make(chan string, 1)
+	d := &LxcDriver{DriverContext: DriverContext{
+		config: &config.Config{Options: map[string]string{"test.parallelism": "1"}},
+		emitEvent: func(m string, args ...interface{}) {
+			events <- fmt.Sprintf(m, args...)
+		},
+	}}
+	p := &lxcPhase{name: "create", option: "test.parallelism"}
+
+	release, err := p.acquire(context.Background(), d)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	acquired := make(chan struct{})
+	go func() {
+		release, err := p.acquire(context.Background(), d)
+		if err != nil {
+			t.Errorf("err: %v", err)
+			return
+		}
+		release()
+		close(acquired)
+	}()
+
+	select {
+	case e := <-events:
+		if !strings.Contains(e, "container create") {
+			t.Fatalf("unexpected event: %q", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected waiting event")
+	}
+	select {
+	case <-acquired:
+		t.Fatalf("acquired slot beyond parallelism")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Waiting for a slot stops once the context is done
+	waitCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.acquire(waitCtx, d); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	<-events
+
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("slot not released")
+	}
+
+	// Phases are unlimited by default
+	unlimited := &lxcPhase{name: "start", option: "test.unset"}
+	if _, err := unlimited.acquire(context.Background(), d); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := unlimited.acquire(context.Background(), d); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestLxcDriver_ConfigHash(t *testing.T) {
+	c1 := &LxcDriverConfig{Template: "download", Distro: "ubuntu", Release: "xenial"}
+	c2 := &LxcDriverConfig{Template: "download", Distro: "ubuntu", Release: "xenial"}
+	h1, err := c1.hash()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if h2, _ := c2.hash(); h1 != h2 {
+		t.Fatalf("expected identical configs to hash equally: %q != %q", h1, h2)
+	}
+
+	c2.Release = "bionic"
+	if h2, _ := c2.hash(); h1 == h2 {
+		t.Fatalf("expected different configs to hash differently")
+	}
+}
+
+func TestLxcDriver_CopyIntoRootfs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lxc-rootfs")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "local", "app")
+	rootfs := filepath.Join(dir, "rootfs")
+	for _, d := range []string{filepath.Join(src, "bin"), filepath.Join(rootfs, "opt"), filepath.Join(rootfs, "etc")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "bin", "app"), []byte("#!/bin/sh\n"), 0750); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := os.Symlink("bin/app", filepath.Join(src, "run")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	dst, err := rootfsPath(rootfs, "/opt/app")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := copyTree(src, dst); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	fi, err := os.Stat(filepath.Join(rootfs, "opt", "app", "bin", "app"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if fi.Mode().Perm() != 0750 {
+		t.Fatalf("expected mode 0750, got %v", fi.Mode().Perm())
+	}
+	if link, err := os.Readlink(filepath.Join(rootfs, "opt", "app", "run")); err != nil || link != "bin/app" {
+		t.Fatalf("expected symlink to be preserved, got %q, %v", link, err)
+	}
+
+	// Symlinks inside the rootfs may point to the host
+	if err := os.Symlink("/etc", filepath.Join(rootfs, "srv")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := rootfsPath(rootfs, "/srv/app"); err == nil {
+		t.Fatalf("expected error traversing a symlink")
+	}
+}
+
+// testFakeLxcDriver returns an LXC driver using the in-memory backend, with
+// containers created under a temporary LXC path.
+func testFakeLxcDriver(t *testing.T, task *structs.Task) (*testContext, *LxcDriver, *fakeLxcBackend) {
+	ctx := testDriverContexts(t, task)
+	ctx.DriverCtx.config.Options = map[string]string{
+		"driver.lxc.path":      filepath.Join(ctx.AllocDir.AllocDir, "lxc"),
+		"driver.lxc.cache_dir": filepath.Join(ctx.AllocDir.AllocDir, "cache"),
+	}
+	backend := newFakeLxcBackend()
+	d := NewLxcDriver(ctx.DriverCtx).(*LxcDriver)
+	d.backend = backend
+	return ctx, d, backend
+}
+
+func TestLxcDriver_Fake_StartOpenKill(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:        "foo",
+		Driver:      "lxc",
+		Config:      map[string]interface{}{"template": "busybox"},
+		Env:         map[string]string{"APP_ENV": "test"},
+		KillTimeout: 10 * time.Second,
+		Resources:   structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	d.config.Options["driver.lxc.cgroup_parent"] = "nomad.slice"
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	lxcPath := readLxcPath(d.config)
+	c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), lxcPath)
+	if c == nil || !c.Running() {
+		t.Fatalf("expected running container")
+	}
+	for _, mnt := range []string{" local ", " alloc ", " secrets "} {
+		if !c.hasConfig("lxc.mount.entry", mnt) {
+			t.Fatalf("missing mount %q in %v", mnt, c.ConfigItem("lxc.mount.entry"))
+		}
+	}
+	if !c.hasConfig("lxc.environment", "APP_ENV=test") {
+		t.Fatalf("missing environment in %v", c.ConfigItem("lxc.environment"))
+	}
+	if v := c.CgroupItem("memory.limit_in_bytes"); len(v) != 1 || v[0] != strconv.Itoa(task.Resources.MemoryMB*1024*1024) {
+		t.Fatalf("unexpected memory limit: %v", v)
+	}
+	if !c.hasConfig("lxc.cgroup.dir", "nomad.slice/"+c.Name()) {
+		t.Fatalf("unexpected cgroup dir: %v", c.ConfigItem("lxc.cgroup.dir"))
+	}
+	if readLxcConfigHash(c) == "" {
+		t.Fatalf("expected config hash to be recorded")
+	}
+
+	// Recover the container from the handle ID
+	handle, err := d.Open(ctx.ExecCtx, sresp.Handle.ID())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if handle.(*lxcDriverHandle).container != lxcContainerAPI(c) {
+		t.Fatalf("expected handle of the same container")
+	}
+
+	if err := handle.Kill(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case res := <-handle.WaitCh():
+		if !res.Successful() {
+			t.Fatalf("err: %v", res)
+		}
+	case <-time.After(time.Duration(testutil.TestMultiplier()*5) * time.Second):
+		t.Fatalf("timeout")
+	}
+	if c.Running() {
+		t.Fatalf("expected container to be stopped")
+	}
+	close(sresp.Handle.(*lxcDriverHandle).doneCh)
+}
+
+func TestLxcDriver_Fake_StartFailureCleanup(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:      "foo",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox"},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	backend.startErr = fmt.Errorf("no init")
+
+	if _, err := d.Start(ctx.ExecCtx, task); err == nil || !strings.Contains(err.Error(), "no init") {
+		t.Fatalf("expected start error, got %v", err)
+	}
+	c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), readLxcPath(d.config))
+	if c == nil || c.Defined() {
+		t.Fatalf("expected failed container to be destroyed")
+	}
+}
+
+func TestLxcDriver_Fake_CrashLoop(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:      "foo",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox"},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	var events []string
+	d.DriverContext.emitEvent = func(m string, args ...interface{}) {
+		events = append(events, fmt.Sprintf(m, args...))
+	}
+	backend.startErr = fmt.Errorf("no init")
+	name := lxcContainerName(task.Name, ctx.DriverCtx.allocID)
+
+	// Containers are destroyed until they crash loop, and then kept for the
+	// next attempt to reuse
+	for i := 1; i <= lxcCrashLoopThresholdDefault+1; i++ {
+		if _, err := d.Start(ctx.ExecCtx, task); err == nil || !strings.Contains(err.Error(), "no init") {
+			t.Fatalf("expected start error, got %v", err)
+		}
+		c := backend.container(name, readLxcPath(d.config))
+		if kept := i >= lxcCrashLoopThresholdDefault; c.Defined() != kept {
+			t.Fatalf("attempt %d: expected container to be kept %v, got %v", i, kept, c.Defined())
+		}
+	}
+	var loops, reuses int
+	for _, e := range events {
+		if strings.Contains(e, "crash looping with") {
+			loops++
+		}
+		if strings.Contains(e, "Reusing existing container") {
+			reuses++
+		}
+	}
+	if loops != 2 || reuses != 1 {
+		t.Fatalf("expected 2 crash loop events and 1 reuse, got %v", events)
+	}
+
+	backend.startErr = nil
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	h := sresp.Handle.(*lxcDriverHandle)
+	defer close(h.doneCh)
+
+	// Exiting right after starting counts as a crash, while running for
+	// long enough clears them
+	events = nil
+	h.emitEvent = d.DriverContext.emitEvent
+	h.recordExit()
+	if len(events) != 1 || !strings.Contains(events[0], "exited right after starting") {
+		t.Fatalf("expected crash loop event, got %v", events)
+	}
+	h.started = time.Now().Add(-lxcCrashLoopMinUptime)
+	h.recordExit()
+	if crashes := lxcCrashLoops.record(name, time.Now()); crashes != 1 {
+		t.Fatalf("expected crashes to be cleared, got %d", crashes)
+	}
+}
+
+func TestLxcDriver_AppArmorProfile(t *testing.T) {
+	t.Parallel()
+	config := &LxcDriverConfig{
+		CgroupNamespace: lxcCgroupNamespaceHost,
+		Network:         []LxcNetworkConfig{{Type: "none"}},
+	}
+	mounts := []lxcAppArmorMount{{Target: "local"}, {Target: "data/ro", ReadOnly: true}}
+	profile := lxcAppArmorProfile("web-1234", mounts, config)
+
+	expected := []string{
+		"profile nomad-web-1234 flags=(attach_disconnected,mediate_deleted) {",
+		"#include <abstractions/lxc/container-base>",
+		"deny mount fstype=devpts,",
+		"deny mount fstype=cgroup,",
+		"deny network raw,",
+		"deny umount /local/,",
+		"deny umount /data/ro/,",
+		"deny remount /data/ro/,",
+		"deny /data/ro/** wl,",
+	}
+	for _, line := range expected {
+		if !strings.Contains(profile, line) {
+			t.Fatalf("missing %q in profile:\n%s", line, profile)
+		}
+	}
+	if strings.Contains(profile, "deny remount /local/,") {
+		t.Fatalf("unexpected remount rule for writable mount:\n%s", profile)
+	}
+
+	// Containers in the host's network mode may observe its traffic
+	config.NetworkMode = lxcNetworkModeHost
+	if profile := lxcAppArmorProfile("web-1234", mounts, config); strings.Contains(profile, "deny network") {
+		t.Fatalf("unexpected network rules in host network mode:\n%s", profile)
+	}
+}
+
+func TestLxcDriver_Fake_AppArmorProfile(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:      "foo",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox"},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	d.config.Options[lxcAppArmorConfigOption] = "true"
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer close(sresp.Handle.(*lxcDriverHandle).doneCh)
+	c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), readLxcPath(d.config))
+	if !c.hasConfig("lxc.apparmor.profile", lxcAppArmorProfileName(c.Name())) {
+		t.Fatalf("unexpected apparmor profile: %v", c.ConfigItem("lxc.apparmor.profile"))
+	}
+	file := filepath.Join(filepath.Dir(c.ConfigFileName()), lxcAppArmorProfileFile)
+	if data, err := ioutil.ReadFile(file); err != nil || !strings.Contains(string(data), "deny umount /secrets/,") {
+		t.Fatalf("unexpected profile file: %v\n%s", err, data)
+	}
+
+	// The profile is unloaded before the container is destroyed
+	if err := unloadAppArmorProfile(backend, c); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var actions []string
+	for _, cmd := range backend.commands {
+		if cmd[0] == "apparmor_parser" {
+			actions = append(actions, cmd[1])
+		}
+	}
+	if !reflect.DeepEqual(actions, []string{"--replace", "--remove"}) {
+		t.Fatalf("unexpected apparmor_parser commands: %v", backend.commands)
+	}
+}
+
+func TestLxcDriver_FailureSummary(t *testing.T) {
+	t.Parallel()
+	s := &lxcFailureSummary{}
+	var buf bytes.Buffer
+	logger := log.New(&buf, "", 0)
+
+	names := []string{"web-1", "web-2", "db-1"}
+	errs := []error{
+		fmt.Errorf("unable to create container: thin pool metadata full"),
+		fmt.Errorf("unable to create container: thin pool metadata full"),
+		fmt.Errorf("unable to start container db-1: no init"),
+	}
+	for i, name := range names {
+		s.record(logger, time.Hour, lxcFailureReason(name, errs[i]))
+	}
+	s.timer.Stop()
+
+	expected := `3 container(s) failed to start in the last 1h0m0s: 2 with "unable to create container: thin pool metadata full", 1 with "unable to start container <container>: no init"`
+	s.flush()
+	if out := strings.TrimSpace(buf.String()); out != "[WARN] driver.lxc: "+expected {
+		t.Fatalf("unexpected summary: %q", out)
+	}
+
+	// A new window starts with the next failure
+	if s.timer != nil || s.summary() != "" {
+		t.Fatalf("expected summary to be reset")
+	}
+}
+
+func TestLxcDriver_Fake_MaxUptime(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:      "foo",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox", "max_uptime": "100ms", "max_uptime_jitter": "50ms"},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	started := time.Now()
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	recycleAt := sresp.Handle.(*lxcDriverHandle).recycleAt
+	if recycleAt.Before(started.Add(100*time.Millisecond)) || recycleAt.After(time.Now().Add(150*time.Millisecond)) {
+		t.Fatalf("unexpected recycle time %v for start at %v", recycleAt, started)
+	}
+
+	// The container is destroyed so the restarted task gets a fresh one
+	select {
+	case res := <-sresp.Handle.WaitCh():
+		if res.Successful() || !strings.Contains(res.Err.Error(), "maximum uptime") {
+			t.Fatalf("unexpected result: %v", res)
+		}
+	case <-time.After(time.Duration(testutil.TestMultiplier()*5) * time.Second):
+		t.Fatalf("timeout")
+	}
+	c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), readLxcPath(d.config))
+	if c.Defined() {
+		t.Fatalf("expected recycled container to be destroyed")
+	}
+}
+
+func TestLxcDriver_Fake_Exec(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:      "foo",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox"},
+		Env:       map[string]string{"APP_ENV": "test"},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	if !d.Abilities().Exec {
+		t.Fatalf("expected exec ability")
+	}
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer close(sresp.Handle.(*lxcDriverHandle).doneCh)
+
+	var env []string
+	backend.attach = func(args []string, e []string) (string, int) {
+		env = e
+		// Commands are killed in the container once their deadline passes
+		if len(args) < 5 || strings.Join(args[:3], " ") != "timeout -s KILL" {
+			return "", -1
+		}
+		args = args[4:]
+		switch args[0] {
+		case "/bin/check":
+			return "degraded\n", 2 << 8
+		case "/bin/killed":
+			return "", int(syscall.SIGKILL)
+		case "/bin/missing":
+			return "", -1
+		}
+		return strings.Join(args, " "), 0
+	}
+
+	out, code, err := sresp.Handle.Exec(context.Background(), "/bin/echo", []string{"hello"})
+	if err != nil || code != 0 || string(out) != "/bin/echo hello" {
+		t.Fatalf("unexpected exec result: %q %d %v", out, code, err)
+	}
+	if len(env) == 0 || env[0] != lxcAttachPath || !reflect.DeepEqual(env[1:], sresp.Handle.(*lxcDriverHandle).env) {
+		t.Fatalf("unexpected exec environment: %v", env)
+	}
+	found := false
+	for _, v := range env {
+		found = found || v == "APP_ENV=test"
+	}
+	if !found {
+		t.Fatalf("expected task environment in %v", env)
+	}
+
+	if out, code, err := sresp.Handle.Exec(context.Background(), "/bin/check", nil); err != nil || code != 2 || string(out) != "degraded\n" {
+		t.Fatalf("unexpected exec result: %q %d %v", out, code, err)
+	}
+	if _, code, err := sresp.Handle.Exec(context.Background(), "/bin/killed", nil); err != nil || code != 128+int(syscall.SIGKILL) {
+		t.Fatalf("unexpected exec result: %d %v", code, err)
+	}
+	if _, _, err := sresp.Handle.Exec(context.Background(), "/bin/missing", nil); err == nil {
+		t.Fatalf("expected attach error")
+	}
+}
+
+func TestLxcDriver_Fake_ExecTimeout(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:      "foo",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox"},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer close(sresp.Handle.(*lxcDriverHandle).doneCh)
+
+	// The hung command runs until the container's timeout command kills it
+	var running int32
+	backend.attach = func(args []string, e []string) (string, int) {
+		if reflect.DeepEqual(args, []string{"timeout", "-s", "KILL", "1", "true"}) {
+			return "", 0
+		}
+		atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		if len(args) < 5 || args[0] != "timeout" {
+			t.Errorf("expected command to run with a timeout, got %v", args)
+			return "", -1
+		}
+		secs, err := strconv.Atoi(args[3])
+		if err != nil {
+			t.Errorf("invalid timeout %q", args[3])
+			return "", -1
+		}
+		time.Sleep(time.Duration(secs) * time.Second)
+		return "", int(syscall.SIGKILL)
+	}
+
+	execCtx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := sresp.Handle.Exec(execCtx, "/bin/hang", nil); err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected exec to return at its deadline, took %v", elapsed)
+	}
+
+	// Nothing is left running once the command is killed
+	testutil.WaitForResult(func() (bool, error) {
+		n := atomic.LoadInt32(&running)
+		return n == 0, fmt.Errorf("%d commands still running", n)
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+
+	// Images without a usable timeout command, such as an old busybox
+	// only taking -t, run commands without one
+	h := sresp.Handle.(*lxcDriverHandle)
+	h.attachTimeout = nil
+	var probes int
+	backend.attach = func(args []string, e []string) (string, int) {
+		if args[0] == "timeout" {
+			probes++
+			return "timeout: invalid option -- 's'", 1 << 8
+		}
+		return "ok", 0
+	}
+	for i := 0; i < 2; i++ {
+		out, code, err := h.Exec(context.Background(), "/bin/check", nil)
+		if err != nil || code != 0 || string(out) != "ok" {
+			t.Fatalf("expected command to run without timeout, got %q %d %v", out, code, err)
+		}
+	}
+	if probes != 1 {
+		t.Fatalf("expected the timeout command to be probed once, got %d", probes)
+	}
+}
+
+func TestLxcDriver_Fake_Signal(t *testing.T) {
+	t.Parallel()
+	pidfile, err := ioutil.TempFile("", "lxc-signal")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.Remove(pidfile.Name())
+	pidfile.WriteString("42\n")
+	pidfile.Close()
+
+	// The fake container's init is the test process, whose root is the
+	// host's, so the PID file is read from the host path
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template":       "busybox",
+			"signal_pidfile": pidfile.Name(),
+		},
+		KillSignal: "SIGINT",
+		Resources:  structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	if !d.Abilities().SendSignals {
+		t.Fatalf("expected signal ability")
+	}
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer close(sresp.Handle.(*lxcDriverHandle).doneCh)
+	c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), readLxcPath(d.config))
+	if !c.hasConfig("lxc.signal.halt", "2") {
+		t.Fatalf("expected kill_signal to be the container's halt signal")
+	}
+
+	var killed []string
+	backend.attach = func(args []string, env []string) (string, int) {
+		killed = args
+		if args[2] == "43" {
+			return "no such process", 1 << 8
+		}
+		return "", 0
+	}
+	if err := sresp.Handle.Signal(syscall.SIGHUP); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if expected := []string{"kill", "-1", "42"}; !reflect.DeepEqual(killed, expected) {
+		t.Fatalf("expected %v, got %v", expected, killed)
+	}
+
+	ioutil.WriteFile(pidfile.Name(), []byte("43"), 0644)
+	if err := sresp.Handle.Signal(syscall.SIGHUP); err == nil || !strings.Contains(err.Error(), "no such process") {
+		t.Fatalf("expected signal error, got %v", err)
+	}
+	ioutil.WriteFile(pidfile.Name(), []byte("bogus"), 0644)
+	if err := sresp.Handle.Signal(syscall.SIGHUP); err == nil || !strings.Contains(err.Error(), "invalid PID") {
+		t.Fatalf("expected invalid PID error, got %v", err)
+	}
+
+	// Without a PID file the init process is signaled
+	h := sresp.Handle.(*lxcDriverHandle)
+	h.signalPidfile = ""
+	if err := h.Signal(syscall.Signal(0)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestLxcDriver_HostID(t *testing.T) {
+	t.Parallel()
+	c := &fakeLxcContainer{config: map[string][]string{}}
+	if id, err := lxcHostID(c, "u", 33); err != nil || id != 33 {
+		t.Fatalf("expected unmapped ID, got %d %v", id, err)
+	}
+
+	c.config["lxc.idmap"] = []string{"u 0 100000 1000", "g 0 200000 65536", "u 1000 1000 1"}
+	cases := []struct {
+		kind string
+		id   int
+		host int
+	}{
+		{"u", 33, 100033},
+		{"u", 1000, 1000},
+		{"g", 33, 200033},
+	}
+	for _, tc := range cases {
+		if id, err := lxcHostID(c, tc.kind, tc.id); err != nil || id != tc.host {
+			t.Fatalf("%s %d: expected %d, got %d %v", tc.kind, tc.id, tc.host, id, err)
+		}
+	}
+	if _, err := lxcHostID(c, "u", 1001); err == nil {
+		t.Fatalf("expected unmapped ID error")
+	}
+}
+
+func TestLxcDriver_Fake_Secrets(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template": "busybox",
+			"secret": []map[string]interface{}{
+				{"source": "key.pem", "target": "/etc/ssl/private/key.pem", "mode": "0640", "uid": os.Getuid(), "gid": os.Getgid()},
+			},
+		},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	// The source must exist when the task starts
+	if _, err := d.Start(ctx.ExecCtx, task); err == nil || !strings.Contains(err.Error(), "unable to read secret") {
+		t.Fatalf("expected missing secret error, got %v", err)
+	}
+
+	// Sources linking out of the secrets directory aren't read
+	source := filepath.Join(ctx.ExecCtx.TaskDir.SecretsDir, "key.pem")
+	outside := filepath.Join(ctx.ExecCtx.TaskDir.Dir, "outside.pem")
+	if err := ioutil.WriteFile(outside, []byte("host"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := os.Symlink(outside, source); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := d.Start(ctx.ExecCtx, task); err == nil || !strings.Contains(err.Error(), "escapes the secrets directory") {
+		t.Fatalf("expected escaping secret error, got %v", err)
+	}
+	if err := os.Remove(source); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := ioutil.WriteFile(source, []byte("v1"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer close(sresp.Handle.(*lxcDriverHandle).doneCh)
+
+	c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), readLxcPath(d.config))
+	projected := filepath.Join(ctx.ExecCtx.TaskDir.SecretsDir, lxcSecretsDir, "0-key.pem")
+	if !c.hasConfig("lxc.mount.entry", projected+" etc/ssl/private/key.pem none ro,bind,create=file") {
+		t.Fatalf("expected secret mount, got %v", c.ConfigItem("lxc.mount.entry"))
+	}
+	fi, err := os.Stat(projected)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if fi.Mode().Perm() != 0640 {
+		t.Fatalf("expected mode 0640, got %v", fi.Mode())
+	}
+
+	// Templates replace the files they render, while the mounted copy is
+	// updated in place before signals are delivered
+	tmp := source + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte("v2"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := os.Rename(tmp, source); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := sresp.Handle.Signal(syscall.Signal(0)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if data, err := ioutil.ReadFile(projected); err != nil || string(data) != "v2" {
+		t.Fatalf("expected updated secret, got %q %v", data, err)
+	}
+	updated, err := os.Stat(projected)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !os.SameFile(fi, updated) {
+		t.Fatalf("expected secret to be updated in place")
+	}
+}
+
+func TestLxcDriver_CheckLxcPath(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "lxc-path")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := checkLxcPath(dir, false); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := checkLxcPath(filepath.Join(dir, "missing"), false); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("expected missing path error, got %v", err)
+	}
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := checkLxcPath(file, false); err == nil {
+		t.Fatalf("expected error for a file")
+	}
+
+	// A directory on the same filesystem as its parent is no mount point
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := checkLxcPath(sub, true); err == nil || !strings.Contains(err.Error(), "mount point") {
+		t.Fatalf("expected mount point error, got %v", err)
+	}
+	if err := checkLxcPath("/", true); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestLxcDriver_Fake_LxcPathFailover(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:      "foo",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox"},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	primary := filepath.Join(ctx.AllocDir.AllocDir, "nvme")
+	fallback := filepath.Join(ctx.AllocDir.AllocDir, "hdd")
+	if err := os.Mkdir(fallback, 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	d.config.Options["driver.lxc.path"] = primary + ", " + fallback
+	var events []string
+	d.DriverContext.emitEvent = func(m string, args ...interface{}) {
+		events = append(events, fmt.Sprintf(m, args...))
+	}
+
+	// The primary path's mount is missing
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	name := lxcContainerName(task.Name, ctx.DriverCtx.allocID)
+	if c := backend.container(name, fallback); c == nil || !c.Running() {
+		t.Fatalf("expected container under the fallback path")
+	}
+	if len(events) == 0 || !strings.Contains(events[0], "fallback LXC path "+fallback) {
+		t.Fatalf("expected fallback event, got %v", events)
+	}
+
+	// The handle records where the container is, even once the primary
+	// path is back
+	if err := os.Mkdir(primary, 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	handle, err := d.Open(ctx.ExecCtx, sresp.Handle.ID())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if h := handle.(*lxcDriverHandle); h.lxcPath != fallback {
+		t.Fatalf("expected handle under %s, got %s", fallback, h.lxcPath)
+	}
+	if path := findLxcPath(backend, d.config, name); path != fallback {
+		t.Fatalf("expected container to be found under %s, got %s", fallback, path)
+	}
+	if path, err := d.selectLxcPath(name); err != nil || path != fallback {
+		t.Fatalf("expected existing container to stay under %s, got %s %v", fallback, path, err)
+	}
+	if path, err := d.selectLxcPath("other"); err != nil || path != primary {
+		t.Fatalf("expected new containers under %s, got %s %v", primary, path, err)
+	}
+	close(sresp.Handle.(*lxcDriverHandle).doneCh)
+	close(handle.(*lxcDriverHandle).doneCh)
+
+	os.RemoveAll(primary)
+	os.RemoveAll(fallback)
+	if _, err := d.selectLxcPath("other"); err == nil || !strings.Contains(err.Error(), "no usable LXC path") {
+		t.Fatalf("expected no usable path error, got %v", err)
+	}
+}
+
+func TestLxcDriver_ExitStatus(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "lxc-exit")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "foo-lxc.log")
+	log := `lxc-start foo 20171010120000.000 INFO     lxc_error - error.c:lxc_error_set_and_log:55 - Child <100> ended on error (1)
+lxc-start foo 20171010120000.000 INFO     lxc_start - start.c:lxc_fini:789 - Closing console
+lxc-start foo 20171010130000.000 INFO     lxc_error - error.c:lxc_error_set_and_log:61 - Child <200> ended on signal (9)
+lxc-start foo 20171010140000.000 INFO     lxc_error - error.c:lxc_error_set_and_log:55 - Child <100> ended on error (3)
+`
+	if err := ioutil.WriteFile(logFile, []byte(log), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	cases := []struct {
+		pid    int
+		code   int
+		signal int
+	}{
+		{100, 3, 0},
+		{200, 137, 9},
+		{300, 0, 0},
+	}
+	for _, tc := range cases {
+		code, signal, err := lxcExitStatus(logFile, tc.pid)
+		if err != nil || code != tc.code || signal != tc.signal {
+			t.Fatalf("pid %d: expected %d %d, got %d %d %v", tc.pid, tc.code, tc.signal, code, signal, err)
+		}
+	}
+	if _, _, err := lxcExitStatus(filepath.Join(dir, "missing"), 100); err == nil {
+		t.Fatalf("expected error reading a missing log")
+	}
+}
+
+func TestLxcDriver_Fake_ExitResult(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:      "foo",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox", "log_level": "error"},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	h := sresp.Handle.(*lxcDriverHandle)
+	defer close(h.doneCh)
+	if h.logFile != filepath.Join(ctx.ExecCtx.TaskDir.Dir, "foo-lxc.log") {
+		t.Fatalf("unexpected log file %q", h.logFile)
+	}
+
+	// The exit status is logged at the info level, which less verbose
+	// levels are raised to
+	c := backend.container(h.container.Name(), h.lxcPath)
+	if c.logLevel != lxc.INFO {
+		t.Fatalf("expected info log level, got %v", c.logLevel)
+	}
+
+	// The init process exits and LXC stops the container
+	initPid := h.initPid
+	if err := h.container.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	line := fmt.Sprintf("INFO lxc_error - error.c:lxc_error_set_and_log:55 - Child <%d> ended on error (2)\n", initPid)
+	if err := ioutil.WriteFile(h.logFile, []byte(line), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if res := h.exited(); res.ExitCode != 2 || res.Signal != 0 || res.Err != nil {
+		t.Fatalf("unexpected result: %v", res)
+	}
+
+	// Powering off from within the container is a clean exit
+	line = fmt.Sprintf("INFO lxc_error - error.c:lxc_error_set_and_log:61 - Child <%d> ended on signal (2)\n", initPid)
+	if err := ioutil.WriteFile(h.logFile, []byte(line), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if res := h.exited(); !res.Successful() {
+		t.Fatalf("expected success, got %v", res)
+	}
+
+	// Containers stopped by the driver aren't failures
+	line = fmt.Sprintf("INFO lxc_error - error.c:lxc_error_set_and_log:61 - Child <%d> ended on signal (9)\n", initPid)
+	if err := ioutil.WriteFile(h.logFile, []byte(line), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if res := h.exited(); res.Signal != 9 || res.ExitCode != 137 {
+		t.Fatalf("unexpected result: %v", res)
+	}
+
+	// Exits whose status can't be read from the log are failures
+	if err := os.Remove(h.logFile); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if res := h.exited(); res.Successful() || res.Err == nil || !strings.Contains(res.Err.Error(), "exit status unknown") {
+		t.Fatalf("expected unknown exit status, got %v", res)
+	}
+
+	h.stop()
+	if res := h.exited(); !res.Successful() {
+		t.Fatalf("expected success, got %v", res)
+	}
+}
+
+func TestLxcDriver_Fake_DetectStopped(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:      "foo",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox"},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, _ := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	h := sresp.Handle.(*lxcDriverHandle)
+	defer close(h.doneCh)
+
+	// The fake's init is the test process, which keeps running, so the exit
+	// is only seen through the container's state
+	line := fmt.Sprintf("INFO lxc_error - error.c:lxc_error_set_and_log:55 - Child <%d> ended on error (1)\n", h.initPid)
+	if err := ioutil.WriteFile(h.logFile, []byte(line), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := h.container.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	select {
+	case res := <-h.WaitCh():
+		if res.ExitCode != 1 {
+			t.Fatalf("unexpected result: %v", res)
+		}
+	case <-time.After(time.Duration(testutil.TestMultiplier()*5) * time.Second):
+		t.Fatalf("timeout")
+	}
+}
+
+func TestLxcDriver_Fake_PrestartCheck(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:      "foo",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox"},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	// Without a check nothing is run
+	if _, err := d.Prestart(ctx.ExecCtx, task); err != nil || len(backend.commands) != 0 {
+		t.Fatalf("unexpected prestart: %v %v", err, backend.commands)
+	}
+
+	d.config.Options["driver.lxc.prestart_check"] = "/usr/local/bin/lxc-policy --strict"
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	backend.run = func(name string, args []string) ([]byte, error) {
+		return []byte("storage headroom below 10%\n"), exitErr
+	}
+	_, err := d.Prestart(ctx.ExecCtx, task)
+	if err == nil || !strings.Contains(err.Error(), "rejected by prestart check: storage headroom below 10%") {
+		t.Fatalf("expected rejection, got %v", err)
+	}
+	if structs.IsRecoverable(err) {
+		t.Fatalf("expected rejection to be unrecoverable")
+	}
+	if cmd := backend.commands[0]; !reflect.DeepEqual(cmd, []string{"/usr/local/bin/lxc-policy", "--strict"}) {
+		t.Fatalf("unexpected command %v", cmd)
+	}
+	var input lxcPrestartCheckInput
+	if err := json.Unmarshal(backend.inputs[0], &input); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if input.Task != "foo" || input.AllocID != ctx.DriverCtx.allocID || input.Config["template"] != "busybox" || input.Resources.MemoryMB != task.Resources.MemoryMB {
+		t.Fatalf("unexpected input %+v", input)
+	}
+
+	// Failing to run the check may be retried
+	backend.run = func(name string, args []string) ([]byte, error) {
+		return nil, fmt.Errorf("permission denied")
+	}
+	if _, err := d.Prestart(ctx.ExecCtx, task); err == nil || !structs.IsRecoverable(err) {
+		t.Fatalf("expected recoverable error, got %v", err)
+	}
+
+	backend.run = nil
+	if _, err := d.Prestart(ctx.ExecCtx, task); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestLxcDriver_Fake_Maintenance(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:      "foo",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox"},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, _ := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	node := &structs.Node{Attributes: make(map[string]string)}
+
+	m, err := SetLxcMaintenance(d.config, true, "liblxc upgrade")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !m.Paused || m.Since.IsZero() {
+		t.Fatalf("expected maintenance, got %+v", m)
+	}
+
+	// The driver is withdrawn from scheduling and refuses new starts
+	if apply, err := d.Fingerprint(d.config, node); err != nil || apply {
+		t.Fatalf("expected driver not to apply: %v %v", apply, err)
+	}
+	if _, ok := node.Attributes["driver.lxc"]; ok || node.Attributes[lxcPausedAttr] != "1" {
+		t.Fatalf("unexpected attributes %v", node.Attributes)
+	}
+	_, err = d.Prestart(ctx.ExecCtx, task)
+	if err == nil || !strings.Contains(err.Error(), "paused for maintenance: liblxc upgrade") || !structs.IsRecoverable(err) {
+		t.Fatalf("expected recoverable maintenance error, got %v", err)
+	}
+
+	// Pausing again keeps when maintenance started
+	m2, err := SetLxcMaintenance(d.config, true, "kernel upgrade")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !m2.Since.Equal(m.Since) || m2.Reason != "kernel upgrade" {
+		t.Fatalf("unexpected maintenance %+v", m2)
+	}
+
+	if _, err := SetLxcMaintenance(d.config, false, ""); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if apply, err := d.Fingerprint(d.config, node); err != nil || !apply {
+		t.Fatalf("expected driver to apply: %v %v", apply, err)
+	}
+	if _, ok := node.Attributes[lxcPausedAttr]; ok || node.Attributes["driver.lxc"] != "1" {
+		t.Fatalf("unexpected attributes %v", node.Attributes)
+	}
+	if _, err := d.Prestart(ctx.ExecCtx, task); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestLxcDriver_Fake_Network(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:      "foo",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox"},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer close(sresp.Handle.(*lxcDriverHandle).doneCh)
+	c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), readLxcPath(d.config))
+	if !c.hasConfig("lxc.net.0.type", "none") || len(c.ConfigItem("lxc.net.0.link")) != 0 {
+		t.Fatalf("expected host network, got %v", c.config)
+	}
+
+	// Veth networks need their bridge to exist
+	c2 := &fakeLxcContainer{config: map[string][]string{}}
+	if err := setLxcNetwork(c2, LxcNetworkConfig{Type: "veth", Bridge: "nomadtestbr0"}); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected missing bridge error, got %v", err)
+	}
+
+	n := LxcNetworkConfig{
+		Type:        "veth",
+		Bridge:      "br0",
+		IPv4Address: "192.168.1.10/24",
+		IPv4Gateway: "auto",
+		Routes:      []string{"10.8.1.0/16 via 192.168.1.254"},
+	}
+	items := lxcNetworkItems(n)
+	expected := [][2]string{
+		{"type", "veth"}, {"link", "br0"}, {"name", "eth0"}, {"flags", "up"},
+		{"ipv4.address", "192.168.1.10/24"}, {"ipv4.gateway", "auto"},
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Fatalf("expected %v, got %v", expected, items)
+	}
+
+	// Routes are added by a hook, with their destination normalized
+	if err := setLxcRoutes(c, n.Routes); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	hooks := c.ConfigItem("lxc.hook.start-host")
+	if len(hooks) != 1 {
+		t.Fatalf("expected routes hook, got %v", c.config)
+	}
+	hook, err := ioutil.ReadFile(hooks[0])
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !strings.Contains(string(hook), `nsenter --target "$LXC_PID" --net ip route replace 10.8.0.0/16 via 192.168.1.254`) {
+		t.Fatalf("unexpected routes hook:\n%s", hook)
+	}
+
+	// Macvlan and ipvlan networks attach to a parent interface, which must
+	// exist
+	if err := setLxcNetwork(c2, LxcNetworkConfig{Type: "macvlan", Parent: "nomadtesteth0"}); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected missing parent error, got %v", err)
+	}
+	// IPv6 is configured alongside IPv4, and router advertisements through
+	// the interface's sysctl
+	acceptRA := false
+	n = LxcNetworkConfig{
+		Type:         "veth",
+		Bridge:       "br0",
+		IPv6Address:  "2001:db8::10/64",
+		IPv6Gateway:  "auto",
+		IPv6AcceptRA: &acceptRA,
+	}
+	items = lxcNetworkItems(n)
+	expected = [][2]string{
+		{"type", "veth"}, {"link", "br0"}, {"name", "eth0"}, {"flags", "up"},
+		{"ipv6.address", "2001:db8::10/64"}, {"ipv6.gateway", "auto"},
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Fatalf("expected %v, got %v", expected, items)
+	}
+	c3 := &fakeLxcContainer{name: "foo", config: map[string][]string{}}
+	if err := setLxcNetwork(c3, LxcNetworkConfig{Type: "none", IPv6AcceptRA: &acceptRA}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !c3.hasConfig("lxc.sysctl.net.ipv6.conf.eth0.accept_ra", "0") {
+		t.Fatalf("expected accept_ra sysctl, got %v", c3.config)
+	}
+
+	items = lxcNetworkItems(LxcNetworkConfig{Type: "ipvlan", Parent: "eth1", Mode: "l2", Name: "lan0"})
+	expected = [][2]string{
+		{"type", "ipvlan"}, {"link", "eth1"}, {"name", "lan0"}, {"flags", "up"}, {"ipvlan.mode", "l2"},
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Fatalf("expected %v, got %v", expected, items)
+	}
+
+	// VLAN interfaces are tagged with their ID, as are veth interfaces on
+	// VLAN aware bridges
+	items = lxcNetworkItems(LxcNetworkConfig{Type: "vlan", Parent: "eth1", VlanID: 42, HWAddr: "00:16:3e:12:34:56"})
+	expected = [][2]string{
+		{"type", "vlan"}, {"link", "eth1"}, {"name", "eth0"}, {"flags", "up"},
+		{"hwaddr", "00:16:3e:12:34:56"}, {"vlan.id", "42"},
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Fatalf("expected %v, got %v", expected, items)
+	}
+	items = lxcNetworkItems(LxcNetworkConfig{Type: "veth", Bridge: "br0", VlanID: 42})
+	if last := items[len(items)-1]; last != [2]string{"veth.vlan.id", "42"} {
+		t.Fatalf("unexpected items %v", items)
+	}
+}
+
+func TestLxcDriver_FileUsage(t *testing.T) {
+	t.Parallel()
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer syscall.Close(fd)
+	dir, err := ioutil.TempDir("", "lxc-inotify")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := syscall.InotifyAddWatch(fd, dir, syscall.IN_CREATE); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	usage := &lxcFileUsage{}
+	lxcProcessFileUsage(os.Getpid(), usage)
+	if usage.OpenFiles == 0 || usage.InotifyInstances == 0 || usage.InotifyWatches == 0 {
+		t.Fatalf("expected open files and inotify usage, got %+v", usage)
+	}
+}
+
+func TestLxcDriver_Fake_UsageAlerts(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template":     "busybox",
+			"usage_alerts": []map[string]interface{}{{"open_files": 1}},
+		},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, _ := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	var events []string
+	d.DriverContext.emitEvent = func(m string, args ...interface{}) {
+		events = append(events, fmt.Sprintf(m, args...))
+	}
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	h := sresp.Handle.(*lxcDriverHandle)
+	defer close(h.doneCh)
+
+	// The fake container's init is the test process
+	namespaces, err := lxcPidNamespaces()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	usage, err := h.sampleFileUsage(namespaces)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if usage.OpenFiles <= 1 {
+		t.Fatalf("expected open files, got %+v", usage)
+	}
+
+	events = nil
+	h.recordFileUsage(usage)
+	h.recordFileUsage(usage)
+	if len(events) != 1 || !strings.Contains(events[0], "above the alert threshold of 1") {
+		t.Fatalf("expected a single alert event, got %v", events)
+	}
+	h.recordFileUsage(&lxcFileUsage{})
+	if len(events) != 2 || !strings.Contains(events[1], "back under") {
+		t.Fatalf("expected recovery event, got %v", events)
+	}
+}
+
+func TestLxcDriver_ContainerNetwork(t *testing.T) {
+	t.Parallel()
+	d := &LxcDriver{DriverContext: DriverContext{
+		config: &config.Config{Options: map[string]string{lxcIPWaitConfigOption: "100ms"}},
+		logger: testLogger(),
+	}}
+	c := &fakeLxcContainer{name: "foo", state: lxc.RUNNING}
+
+	// Containers sharing the host's network advertise the host's address
+	if n := d.containerNetwork(c, LxcNetworkConfig{Type: "none"}); n != nil {
+		t.Fatalf("expected no network, got %+v", n)
+	}
+
+	// Static addresses are known without asking LXC
+	n := d.containerNetwork(c, LxcNetworkConfig{Type: "veth", Bridge: "br0", IPv4Address: "10.0.3.10/24"})
+	if n == nil || n.IP != "10.0.3.10" || !n.AutoAdvertise {
+		t.Fatalf("unexpected network %+v", n)
+	}
+
+	n = d.containerNetwork(c, LxcNetworkConfig{Type: "macvlan", Parent: "eth0", IPv4Address: "192.168.1.20/24"})
+	if n == nil || n.IP != "192.168.1.20" || !n.AutoAdvertise {
+		t.Fatalf("unexpected network %+v", n)
+	}
+
+	n = d.containerNetwork(c, LxcNetworkConfig{Type: "veth", Bridge: "br0", IPv6Address: "2001:db8::10/64"})
+	if n == nil || n.IP != "2001:db8::10" {
+		t.Fatalf("unexpected network %+v", n)
+	}
+
+	// Otherwise the address is read from the interface, once it has one
+	if n := d.containerNetwork(c, LxcNetworkConfig{Type: "veth", Bridge: "br0"}); n != nil {
+		t.Fatalf("expected no network without an address, got %+v", n)
+	}
+
+	// Global IPv6 addresses are advertised if there is no IPv4 address
+	c.ipv6 = map[string][]string{"eth0": {"fe80::1", "2001:db8::15"}}
+	n = d.containerNetwork(c, LxcNetworkConfig{Type: "veth", Bridge: "br0"})
+	if n == nil || n.IP != "2001:db8::15" {
+		t.Fatalf("unexpected network %+v", n)
+	}
+
+	c.ipv4 = map[string][]string{"eth1": {"10.0.3.15"}}
+	n = d.containerNetwork(c, LxcNetworkConfig{Type: "veth", Bridge: "br0", Name: "eth1"})
+	if n == nil || n.IP != "10.0.3.15" || !n.AutoAdvertise {
+		t.Fatalf("unexpected network %+v", n)
+	}
+}
+
+func TestLxcDriver_PortForwards(t *testing.T) {
+	t.Parallel()
+	backend := newFakeLxcBackend()
+	d := &LxcDriver{
+		DriverContext: DriverContext{config: &config.Config{}, logger: testLogger()},
+		backend:       backend,
+	}
+	task := &structs.Task{
+		Name: "foo",
+		Resources: &structs.Resources{
+			Networks: []*structs.NetworkResource{{
+				IP:            "10.0.0.5",
+				ReservedPorts: []structs.Port{{Label: "admin", Value: 9000}},
+				DynamicPorts:  []structs.Port{{Label: "http", Value: 23456}},
+			}},
+		},
+	}
+	portMap := map[string]int{"http": 8080}
+
+	// Mapped ports are the container's port in NOMAD_PORT_<label>
+	vars := lxcPortEnv(task, portMap)
+	expectedEnv := map[string]string{
+		"NOMAD_PORT_http":       "8080",
+		"NOMAD_HOST_PORT_http":  "23456",
+		"NOMAD_ADDR_http":       "10.0.0.5:23456",
+		"NOMAD_IP_http":         "10.0.0.5",
+		"NOMAD_PORT_admin":      "9000",
+		"NOMAD_HOST_PORT_admin": "9000",
+		"NOMAD_ADDR_admin":      "10.0.0.5:9000",
+		"NOMAD_IP_admin":        "10.0.0.5",
+	}
+	if !reflect.DeepEqual(vars, expectedEnv) {
+		t.Fatalf("expected %v, got %v", expectedEnv, vars)
+	}
+
+	// Containers sharing the host's network need no forwards
+	network := &cstructs.DriverNetwork{IP: "10.0.3.10"}
+	forwards, err := d.forwardPorts("foo", task, LxcNetworkConfig{Type: "none"}, portMap, nil)
+	if err != nil || forwards != nil || len(backend.commands) != 0 {
+		t.Fatalf("expected no forwards, got %v, %v", forwards, err)
+	}
+	if _, err := d.forwardPorts("foo", task, LxcNetworkConfig{Type: "veth"}, portMap, nil); err == nil {
+		t.Fatalf("expected error forwarding ports to a container without an address")
+	}
+
+	if _, err := d.forwardPorts("foo", task, LxcNetworkConfig{Type: "veth"}, portMap, &cstructs.DriverNetwork{IP: "2001:db8::10"}); err == nil {
+		t.Fatalf("expected error forwarding ports to a container without an IPv4 address")
+	}
+
+	forwards, err = d.forwardPorts("foo", task, LxcNetworkConfig{Type: "veth"}, portMap, network)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(forwards) != 4 || len(backend.commands) != 12 {
+		t.Fatalf("expected 4 forwards of 3 rules, got %v with commands %v", forwards, backend.commands)
+	}
+	expected := "iptables -w -t nat -A PREROUTING -d 10.0.0.5 -p tcp --dport 23456 -m comment --comment nomad: foo -j DNAT --to-destination 10.0.3.10:8080"
+	var found bool
+	for _, cmd := range backend.commands {
+		found = found || strings.Join(cmd, " ") == expected
+	}
+	if !found {
+		t.Fatalf("expected %q in %v", expected, backend.commands)
+	}
+
+	// The rules are removed when the container stops
+	h := &lxcDriverHandle{backend: backend, logger: d.logger, portForwards: forwards}
+	backend.commands = nil
+	h.removePortForwards()
+	if len(backend.commands) != 12 || backend.commands[0][4] != "-D" {
+		t.Fatalf("expected rules to be deleted, got %v", backend.commands)
+	}
+
+	// Rules added before a failure are rolled back
+	backend.commands = nil
+	backend.run = func(name string, args []string) ([]byte, error) {
+		if args[3] == "-A" && strings.Contains(strings.Join(args, " "), "udp --dport 9000") {
+			return []byte("iptables: No chain/target/match by that name."), fmt.Errorf("exit status 1")
+		}
+		return nil, nil
+	}
+	if _, err := d.forwardPorts("foo", task, LxcNetworkConfig{Type: "veth"}, portMap, network); err == nil || !strings.Contains(err.Error(), "No chain") {
+		t.Fatalf("expected forwarding error, got %v", err)
+	}
+	var added, deleted int
+	for _, cmd := range backend.commands {
+		switch cmd[4] {
+		case "-A", "-I":
+			added++
+		case "-D":
+			deleted++
+		}
+	}
+	if added-1 != deleted {
+		t.Fatalf("expected the %d rules added to be deleted, got %d", added-1, deleted)
+	}
+}
+
+func TestLxcDriver_Fake_DNS(t *testing.T) {
+	// Not parallel as the host's resolv.conf is swapped for a file
+	f, err := ioutil.TempFile("", "resolv.conf")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("nameserver 127.0.0.53\nnameserver 10.0.0.2\nsearch host.example.com\n")
+	f.Close()
+	defer func(p string) { hostResolvConf = p }(hostResolvConf)
+	hostResolvConf = f.Name()
+
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template":           "busybox",
+			"dns_servers":        []string{"10.0.0.53", "2001:db8::53"},
+			"dns_search_domains": []string{"service.consul", "example.com"},
+		},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	// The image's resolv.conf links to a local resolver
+	name := lxcContainerName(task.Name, ctx.DriverCtx.allocID)
+	etc := filepath.Join(readLxcPath(d.config), name, "rootfs", "etc")
+	if err := os.MkdirAll(etc, 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := os.Symlink("../run/systemd/resolve/stub-resolv.conf", filepath.Join(etc, "resolv.conf")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer close(sresp.Handle.(*lxcDriverHandle).doneCh)
+
+	fi, err := os.Lstat(filepath.Join(etc, "resolv.conf"))
+	if err != nil || !fi.Mode().IsRegular() {
+		t.Fatalf("expected resolv.conf to be replaced, got %v, %v", fi, err)
+	}
+	raw, _ := ioutil.ReadFile(filepath.Join(etc, "resolv.conf"))
+	expected := "nameserver 10.0.0.53\nnameserver 2001:db8::53\nsearch service.consul example.com\n"
+	if !strings.HasSuffix(string(raw), expected) {
+		t.Fatalf("expected resolv.conf %q, got %q", expected, raw)
+	}
+	c := backend.container(name, readLxcPath(d.config))
+	if c.hasConfig("lxc.mount.entry", "etc/resolv.conf") {
+		t.Fatalf("expected no resolv.conf mount, got %v", c.config)
+	}
+
+	// Other root filesystems get a generated file mounted, using the host's
+	// nameservers if the task only sets search domains
+	other, _ := backend.NewContainer("other", readLxcPath(d.config))
+	other.SetConfigItem("lxc.rootfs.path", "overlayfs:/var/lib/lxc/base/rootfs:/var/lib/lxc/other/delta0")
+	config := &LxcDriverConfig{
+		DNSSearchDomains: []string{"service.consul"},
+		Network:          []LxcNetworkConfig{{Type: lxcNetworkVeth}},
+	}
+	mnt, err := d.setContainerDNS(other, config, ctx.ExecCtx.TaskDir.Dir)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	path := filepath.Join(ctx.ExecCtx.TaskDir.Dir, lxcResolvConfFile)
+	if mnt != path+" etc/resolv.conf none ro,bind,create=file" {
+		t.Fatalf("unexpected mount entry %q", mnt)
+	}
+	raw, _ = ioutil.ReadFile(path)
+	expected = "nameserver 10.0.0.2\nsearch service.consul\n"
+	if !strings.HasSuffix(string(raw), expected) {
+		t.Fatalf("expected resolv.conf %q, got %q", expected, raw)
+	}
+}
+
+func TestLxcDriver_Fake_LogShipper(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template": "busybox",
+			"mode":     "app",
+			"command":  "/app",
+		},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, _ := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	sock := filepath.Join(ctx.ExecCtx.TaskDir.Dir, "shipper.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer l.Close()
+	d.config.Options[lxcLogShipperConfigOption] = lxcLogShipperSocketPrefix + sock
+
+	// Lines of previous runs aren't shipped
+	stdout := filepath.Join(ctx.ExecCtx.TaskDir.LogDir, "foo.stdout.0")
+	if err := ioutil.WriteFile(stdout, []byte("old\n"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer close(sresp.Handle.(*lxcDriverHandle).doneCh)
+
+	f, err := os.OpenFile(stdout, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	f.WriteString("hello\npartial")
+	f.Close()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var record lxcLogRecord
+	if err := json.NewDecoder(conn).Decode(&record); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if record.Line != "hello" || record.Stream != "stdout" || record.Task != "foo" ||
+		record.AllocID != ctx.DriverCtx.allocID || record.ContainerName != lxcContainerName("foo", ctx.DriverCtx.allocID) {
+		t.Fatalf("unexpected record %+v", record)
+	}
+
+	// Commands read the records on their standard input
+	out := filepath.Join(ctx.ExecCtx.TaskDir.Dir, "shipped")
+	w, err := openLxcLogShipper("tee -a " + out)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	w.Write([]byte("{}\n"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if raw, err := ioutil.ReadFile(out); err != nil || string(raw) != "{}\n" {
+		t.Fatalf("unexpected shipped records %q: %v", raw, err)
+	}
+}
+
+func TestLxcDriver_LogShipStream(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "logship")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	stdout := filepath.Join(dir, "foo.stdout.0")
+	if err := ioutil.WriteFile(stdout, []byte("hello\npartial"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	out := filepath.Join(dir, "shipped")
+	shipper := &lxcLogShipper{shipper: "tee -a " + out, name: "foo", logger: testLogger()}
+	h := &lxcDriverHandle{logShipping: &lxcLogShipping{
+		Files:   map[string]string{"stdout": stdout},
+		offsets: map[string]int64{},
+	}}
+
+	// Only what was appended is read
+	h.shipStream(shipper, "stdout")
+	if offset := h.logShipping.offsets["stdout"]; offset != 6 {
+		t.Fatalf("expected offset 6, got %d", offset)
+	}
+	if size := cap(h.logShipping.buf); size != 13 {
+		t.Fatalf("expected a buffer of the appended size, got %d", size)
+	}
+
+	// Logs that didn't grow aren't read
+	h.logShipping.offsets["stdout"] = 13
+	h.logShipping.buf = nil
+	h.shipStream(shipper, "stdout")
+	if h.logShipping.buf != nil {
+		t.Fatalf("expected unchanged log not to be read")
+	}
+	h.logShipping.offsets["stdout"] = 6
+
+	// The buffer is reused for the rest of the line
+	f, err := os.OpenFile(stdout, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	f.WriteString(" line\n")
+	f.Close()
+	h.logShipping.buf = make([]byte, 0, 64)
+	buf := h.logShipping.buf
+	h.shipStream(shipper, "stdout")
+	if offset := h.logShipping.offsets["stdout"]; offset != 19 {
+		t.Fatalf("expected offset 19, got %d", offset)
+	}
+	if &h.logShipping.buf[:1][0] != &buf[:1][0] {
+		t.Fatalf("expected the buffer to be reused")
+	}
+
+	shipper.close()
+	raw, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var lines []string
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	for dec.More() {
+		var record lxcLogRecord
+		if err := dec.Decode(&record); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		lines = append(lines, record.Line)
+	}
+	if expected := []string{"hello", "partial line"}; !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("expected %v, got %v", expected, lines)
+	}
+}
+
+func TestLxcDriver_Fake_Netns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "netns")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(orig string) { netnsDir = orig }(netnsDir)
+	netnsDir = dir
+
+	task := &structs.Task{
+		Name:      "foo",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox", "network_mode": "netns:mesh"},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	// The namespace may be created after the task starts
+	ns := filepath.Join(dir, "mesh")
+	go func() {
+		time.Sleep(2 * lxcIPPollIntv)
+		ioutil.WriteFile(ns, nil, 0644)
+	}()
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer close(sresp.Handle.(*lxcDriverHandle).doneCh)
+	c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), readLxcPath(d.config))
+	if !c.hasConfig("lxc.namespace.share.net", ns) {
+		t.Fatalf("expected network namespace %q to be joined, got %v", ns, c.ConfigItem("lxc.namespace.share.net"))
+	}
+	if sresp.Network != nil {
+		t.Fatalf("expected no driver network, got %+v", sresp.Network)
+	}
+}
+
+func TestLxcDriver_Fake_DiskStats(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template": "busybox",
+			"shared_volume": []map[string]interface{}{
+				{"name": "scratch", "target": "tmp"},
+				{"name": "missing", "target": "does/not/exist"},
+			},
+		},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, _ := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	h := sresp.Handle.(*lxcDriverHandle)
+	defer close(h.doneCh)
+
+	// The fake container's init is the test process, so its filesystems
+	// are the host's
+	usage, err := h.sampleStats()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ds := usage.ResourceUsage.DiskStats
+	if ds == nil {
+		t.Fatalf("expected disk stats")
+	}
+	root, tmp := ds.Filesystems["/"], ds.Filesystems["/tmp"]
+	if root == nil || tmp == nil || len(ds.Filesystems) != 2 {
+		t.Fatalf("unexpected filesystems %v", ds.Filesystems)
+	}
+	if root.Used+root.Available == 0 {
+		t.Fatalf("expected root filesystem usage, got %+v", root)
+	}
+	if ds.Used < root.Used || ds.Used > root.Used+tmp.Used {
+		t.Fatalf("unexpected total usage %d of %+v and %+v", ds.Used, root, tmp)
+	}
+
+	// The volumes are kept across client restarts
+	pid := &lxcPID{}
+	if err := json.Unmarshal([]byte(h.ID()), pid); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !reflect.DeepEqual(pid.Volumes, []string{"tmp", "does/not/exist"}) {
+		t.Fatalf("unexpected volumes %v", pid.Volumes)
+	}
+}
+
+func TestLxcDriver_Fake_SharedNetwork(t *testing.T) {
+	t.Parallel()
+	proxy := &structs.Task{
+		Name:      "proxy",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox"},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, proxy)
+	defer ctx.AllocDir.Destroy()
+
+	sresp, err := d.Start(ctx.ExecCtx, proxy)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer close(sresp.Handle.(*lxcDriverHandle).doneCh)
+	proxyContainer := backend.container(lxcContainerName(proxy.Name, ctx.DriverCtx.allocID), readLxcPath(d.config))
+
+	app := &structs.Task{
+		Name:      "app",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox", "network_mode": "task:proxy"},
+		Resources: structs.DefaultResources(),
+	}
+	sresp, err = d.Start(ctx.ExecCtx, app)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer close(sresp.Handle.(*lxcDriverHandle).doneCh)
+	c := backend.container(lxcContainerName(app.Name, ctx.DriverCtx.allocID), readLxcPath(d.config))
+	if !c.hasConfig("lxc.namespace.share.net", strconv.Itoa(proxyContainer.InitPid())) {
+		t.Fatalf("expected network to be shared with the proxy, got %v", c.ConfigItem("lxc.namespace.share.net"))
+	}
+
+	// Tasks can't join their own network
+	loop := &structs.Task{
+		Name:      "loop",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox", "network_mode": "task:loop"},
+		Resources: structs.DefaultResources(),
+	}
+	if _, err := d.Start(ctx.ExecCtx, loop); err == nil || !strings.Contains(err.Error(), "own network") {
+		t.Fatalf("expected error, got %v", err)
+	}
+
+	// The joined container's interface is read from its config
+	proxyContainer.SetConfigItem("lxc.net.0.type", "veth")
+	proxyContainer.SetConfigItem("lxc.net.0.name", "eth1")
+	if n := lxcContainerNetworkConfig(proxyContainer); n.Type != "veth" || n.interfaceName() != "eth1" {
+		t.Fatalf("unexpected network config %+v", n)
+	}
+}
+
+func TestLxcDriver_Fake_AppMode(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template": "busybox",
+			"mode":     "app",
+			"command":  "/bin/sh",
+			"args":     []string{"-c", "echo ${NOMAD_TASK_NAME}; sleep 1"},
+		},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer close(sresp.Handle.(*lxcDriverHandle).doneCh)
+
+	c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), readLxcPath(d.config))
+	if v := c.ConfigItem("lxc.init.cmd"); len(v) != 1 || v[0] != "/bin/sh -c 'echo foo; sleep 1'" {
+		t.Fatalf("unexpected init command %v", v)
+	}
+	stdout := filepath.Join(ctx.ExecCtx.TaskDir.LogDir, "foo.stdout.0")
+	if !c.hasConfig("lxc.console.logfile", stdout) {
+		t.Fatalf("expected console to be written to %q, got %v", stdout, c.ConfigItem("lxc.console.logfile"))
+	}
+	if !c.hasConfig("lxc.signal.halt", strconv.Itoa(int(syscall.SIGTERM))) {
+		t.Fatalf("expected SIGTERM halt signal, got %v", c.ConfigItem("lxc.signal.halt"))
+	}
+
+	if cmd := lxcInitCmd("/app", []string{"it's", "", "a b"}); cmd != `/app "it's" '' 'a b'` {
+		t.Fatalf("unexpected quoting %q", cmd)
+	}
+}
+
+func TestLxcDriver_Fake_ExtraHosts(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template":    "busybox",
+			"extra_hosts": []string{"db.example.com:10.0.0.5", "ipv6.example.com:2001:db8::5"},
+		},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	// The image's entries are kept, and those of an earlier start replaced
+	name := lxcContainerName(task.Name, ctx.DriverCtx.allocID)
+	etc := filepath.Join(readLxcPath(d.config), name, "rootfs", "etc")
+	if err := os.MkdirAll(etc, 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	hosts := "127.0.0.1\tlocalhost\n" + lxcHostsBegin + "\n10.0.0.1\tstale.example.com\n" + lxcHostsEnd + "\n10.0.0.9\tbuilt-in\n"
+	if err := ioutil.WriteFile(filepath.Join(etc, "hosts"), []byte(hosts), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer close(sresp.Handle.(*lxcDriverHandle).doneCh)
+
+	raw, _ := ioutil.ReadFile(filepath.Join(etc, "hosts"))
+	expected := "127.0.0.1\tlocalhost\n10.0.0.9\tbuilt-in\n" + lxcHostsBegin + "\n10.0.0.5\tdb.example.com\n2001:db8::5\tipv6.example.com\n" + lxcHostsEnd + "\n"
+	if string(raw) != expected {
+		t.Fatalf("expected hosts %q, got %q", expected, raw)
+	}
+
+	// Other root filesystems get a generated file mounted
+	other, _ := backend.NewContainer("other", readLxcPath(d.config))
+	other.SetConfigItem("lxc.rootfs.path", "overlayfs:/var/lib/lxc/base/rootfs:/var/lib/lxc/other/delta0")
+	mnt, err := d.setContainerHosts(other, &LxcDriverConfig{ExtraHosts: []string{"db.example.com:10.0.0.5"}}, ctx.ExecCtx.TaskDir.Dir)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	path := filepath.Join(ctx.ExecCtx.TaskDir.Dir, lxcHostsFile)
+	if mnt != path+" etc/hosts none ro,bind,create=file" {
+		t.Fatalf("unexpected mount entry %q", mnt)
+	}
+	raw, _ = ioutil.ReadFile(path)
+	if !strings.HasPrefix(string(raw), lxcDefaultHosts) || !strings.Contains(string(raw), "10.0.0.5\tdb.example.com\n") {
+		t.Fatalf("unexpected hosts file %q", raw)
+	}
+}
+
+func TestLxcDriver_Fake_Bandwidth(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:      "foo",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox"},
+		Resources: structs.DefaultResources(),
+	}
+	task.Resources.Networks = []*structs.NetworkResource{{MBits: 10}}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	config := &LxcDriverConfig{
+		Network: []LxcNetworkConfig{{Type: "veth", Bridge: "br0"}},
+		Limits:  []LxcLimitsConfig{{IngressMbits: 50}},
+	}
+	if b := d.containerBandwidth(config, task); b != (lxcBandwidth{Ingress: 50}) {
+		t.Fatalf("unexpected bandwidth %+v", b)
+	}
+	d.config.Options[lxcEnforceNetworkMbitsConfigOption] = "true"
+	if b := d.containerBandwidth(config, task); b != (lxcBandwidth{Egress: 10, Ingress: 50}) {
+		t.Fatalf("unexpected bandwidth %+v", b)
+	}
+	config.Network[0].Type = "none"
+	if b := d.containerBandwidth(config, task); b.shaped() {
+		t.Fatalf("expected containers without a veth network not to be shaped, got %+v", b)
+	}
+
+	name := lxcContainerName(task.Name, ctx.DriverCtx.allocID)
+	pair := lxcVethPair(name)
+	if len(pair) != 15 || !strings.HasPrefix(pair, "nomad") || pair != lxcVethPair(name) {
+		t.Fatalf("unexpected veth pair %q", pair)
+	}
+
+	if err := d.shapeBandwidth(name, lxcBandwidth{Egress: 10, Ingress: 800}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := [][]string{
+		{"tc", "qdisc", "replace", "dev", pair, "root", "tbf", "rate", "800mbit", "burst", "1000000", "latency", "50ms"},
+		{"tc", "qdisc", "replace", "dev", pair, "handle", "ffff:", "ingress"},
+		{"tc", "filter", "add", "dev", pair, "parent", "ffff:", "protocol", "all", "u32", "match", "u32", "0", "0",
+			"police", "rate", "10mbit", "burst", "32768", "drop", "flowid", ":1"},
+	}
+	if !reflect.DeepEqual(backend.commands, expected) {
+		t.Fatalf("unexpected commands %v", backend.commands)
+	}
+
+	backend.run = func(name string, args []string) ([]byte, error) {
+		return []byte("Cannot find device"), fmt.Errorf("exit status 1")
+	}
+	if err := d.shapeBandwidth(name, lxcBandwidth{Egress: 10}); err == nil || !strings.Contains(err.Error(), "Cannot find device") {
+		t.Fatalf("expected error, got %v", err)
+	}
+}
+
+func TestLxcDriver_Fake_OCIImage(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"oci_image":     "registry.example.com/${NOMAD_TASK_NAME}:1.2",
+			"template_args": []string{"--no-cache"},
+		},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	for image, url := range map[string]string{
+		"alpine:3.8":             "docker://alpine:3.8",
+		"docker://alpine:3.8":    "docker://alpine:3.8",
+		"oci:local/image:latest": "oci:local/image:latest",
+	} {
+		if v := lxcOCIImageURL(image); v != url {
+			t.Fatalf("expected URL %q for %q, got %q", url, image, v)
+		}
+	}
+
+	// The tools are checked before creating the container
+	if _, err := d.Start(ctx.ExecCtx, task); err == nil || !strings.Contains(err.Error(), "missing skopeo, umoci") {
+		t.Fatalf("expected missing tools error, got %v", err)
+	}
+
+	backend.paths = map[string]string{"skopeo": "/usr/bin/skopeo", "umoci": "/usr/bin/umoci"}
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer sresp.Handle.Kill()
+
+	c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), readLxcPath(d.config))
+	if c.options.Template != lxcOCITemplate {
+		t.Fatalf("expected template %q, got %q", lxcOCITemplate, c.options.Template)
+	}
+	expected := []string{"--url", "docker://registry.example.com/foo:1.2", "--no-cache"}
+	if !reflect.DeepEqual(c.options.ExtraArgs, expected) {
+		t.Fatalf("expected template args %v, got %v", expected, c.options.ExtraArgs)
+	}
+}
+
+func TestLxcDriver_Fake_ImageSerial(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template": "download",
+			"image": []map[string]interface{}{
+				{"distro": "ubuntu", "release": "bionic", "arch": "amd64", "serial": "20181017_07:42"},
+			},
+		},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	buildID := filepath.Join(d.cacheDir(), "download", "ubuntu", "bionic", "amd64", "default", "build_id")
+	if err := os.MkdirAll(filepath.Dir(buildID), 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A cache holding another serial is refreshed by the template, failing
+	// the task if the latest image isn't the pinned one
+	if err := ioutil.WriteFile(buildID, []byte("20181018_07:42\n"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	name := lxcContainerName(task.Name, ctx.DriverCtx.allocID)
+	if _, err := d.Start(ctx.ExecCtx, task); err == nil || !strings.Contains(err.Error(), "has serial 20181018_07:42, not the pinned 20181017_07:42") {
+		t.Fatalf("expected serial mismatch, got %v", err)
+	}
+	c := backend.container(name, readLxcPath(d.config))
+	if c.Defined() {
+		t.Fatalf("expected container of the wrong image to be destroyed")
+	}
+	if c.options.ForceCache {
+		t.Fatalf("expected the cache not to be forced")
+	}
+
+	// The cached image of the pinned serial is used
+	if err := ioutil.WriteFile(buildID, []byte("20181017_07:42\n"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer sresp.Handle.Kill()
+	if !c.options.ForceCache {
+		t.Fatalf("expected the cache to be forced")
+	}
+}
+
+func TestLxcDriver_Fake_ImageServerAuth(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template":              "download",
+			"distro":                "ubuntu",
+			"release":               "bionic",
+			"arch":                  "amd64",
+			"image_server":          "images.example.com",
+			"image_server_username": "deploy",
+			"image_server_password": "p@ss:${NOMAD_TASK_NAME}",
+		},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer sresp.Handle.Kill()
+	c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), readLxcPath(d.config))
+	if expected := "deploy:p%40ss%3Afoo@images.example.com"; c.options.Server != expected {
+		t.Fatalf("expected server %q, got %q", expected, c.options.Server)
+	}
+
+	// The client's token is used for tasks without credentials, sent to the
+	// default server
+	d.config.Options[lxcImageServerTokenConfigOption] = "s3cret"
+	config := &LxcDriverConfig{}
+	options := lxc.TemplateOptions{}
+	d.applyImageServerAuth(&options, config)
+	if expected := "token:s3cret@" + lxcDefaultImageServer; options.Server != expected {
+		t.Fatalf("expected server %q, got %q", expected, options.Server)
+	}
+
+	// Tasks with credentials don't use the client's
+	config.ImageServerUsername = "deploy"
+	options = lxc.TemplateOptions{Server: "images.example.com"}
+	d.applyImageServerAuth(&options, config)
+	if expected := "deploy@images.example.com"; options.Server != expected {
+		t.Fatalf("expected server %q, got %q", expected, options.Server)
+	}
+}
+
+func TestLxcDriver_Fake_Proxy(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template":    "busybox",
+			"https_proxy": "http://proxy.example.com:3128",
+		},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	d.config.Options[lxcHTTPProxyConfigOption] = "http://proxy.example.com:8080"
+	d.config.Options[lxcHTTPSProxyConfigOption] = "http://proxy.example.com:8443"
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer sresp.Handle.Kill()
+	c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), readLxcPath(d.config))
+	environ := make(map[string]bool)
+	for _, v := range c.environ {
+		environ[v] = true
+	}
+	for _, v := range []string{
+		"http_proxy=http://proxy.example.com:8080",
+		"HTTP_PROXY=http://proxy.example.com:8080",
+		"https_proxy=http://proxy.example.com:3128",
+		"HTTPS_PROXY=http://proxy.example.com:3128",
+	} {
+		if !environ[v] {
+			t.Fatalf("expected %q in the template's environment %v", v, c.environ)
+		}
+	}
+
+	// The settings are only in the template's environment
+	if v := os.Getenv("https_proxy"); v == "http://proxy.example.com:3128" {
+		t.Fatalf("expected the client's environment to be left as is")
+	}
+}
+
+func TestLxcDriver_TemplateEnviron(t *testing.T) {
+	t.Parallel()
+	env := lxcTemplateEnviron(map[string]string{"PATH": "/opt/bin", "LXC_CACHE_PATH": "/var/cache/lxc"})
+	seen := make(map[string]string)
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		if _, ok := seen[parts[0]]; ok {
+			t.Fatalf("duplicate variable %q in %v", parts[0], env)
+		}
+		seen[parts[0]] = parts[1]
+	}
+	if seen["PATH"] != "/opt/bin" || seen["LXC_CACHE_PATH"] != "/var/cache/lxc" {
+		t.Fatalf("expected the variables to be set in %v", env)
+	}
+	if home, ok := os.LookupEnv("HOME"); ok && seen["HOME"] != home {
+		t.Fatalf("expected the client's environment in %v", env)
+	}
+}
+
+func TestLxcDriver_CreateArgs(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		options  lxc.TemplateOptions
+		expected string
+	}{
+		{
+			lxc.TemplateOptions{Distro: "ubuntu", Release: "bionic", Arch: "amd64", Server: "images.example.com", ExtraArgs: []string{"--no-validate"}},
+			"-n web -P /var/lib/lxc -t download -B dir -- --dist ubuntu --release bionic --arch amd64 --server images.example.com --no-validate",
+		},
+		{
+			lxc.TemplateOptions{Template: "busybox", Backend: lxc.ZFS, Arch: "arm64", Variant: "ignored"},
+			"-n web -P /var/lib/lxc -t busybox -B zfs -- --arch arm64",
+		},
+	}
+	for _, tc := range cases {
+		if args := strings.Join(lxcCreateArgs("web", "/var/lib/lxc", tc.options), " "); args != tc.expected {
+			t.Fatalf("expected %q, got %q", tc.expected, args)
+		}
+	}
+}
+
+func TestLxcDriver_Fake_CacheLock(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:      "foo",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox"},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, _ := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	var events []string
+	var lock sync.Mutex
+	d.DriverContext.emitEvent = func(m string, args ...interface{}) {
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, fmt.Sprintf(m, args...))
+	}
+
+	options := lxc.TemplateOptions{Template: "download", Distro: "ubuntu", Release: "bionic", Arch: "amd64"}
+	if name := lxcCacheLockName(options); name != ".nomad-download-ubuntu-bionic-amd64.lock" {
+		t.Fatalf("unexpected lock name %q", name)
+	}
+	if name := lxcCacheLockName(lxc.TemplateOptions{Template: "/srv/lxc-my app"}); name != ".nomad-lxc_my_app.lock" {
+		t.Fatalf("unexpected lock name %q", name)
+	}
+
+	unlock, err := d.lockTemplateCache(context.Background(), options)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	locked := make(chan struct{})
+	go func() {
+		unlock, err := d.lockTemplateCache(context.Background(), options)
+		if err != nil {
+			t.Errorf("err: %v", err)
+			return
+		}
+		unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatalf("expected the cache to stay locked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Waiting for the lock stops once the context is done
+	waitCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := d.lockTemplateCache(waitCtx, options); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the cache to be locked once unlocked")
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(events) != 2 || !strings.Contains(events[0], "image cache") {
+		t.Fatalf("expected waiting events, got %v", events)
+	}
+
+	// Other images have locks of their own
+	unlock, err = d.lockTemplateCache(context.Background(), options)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer unlock()
+	options.Release = "xenial"
+	unlockOther, err := d.lockTemplateCache(context.Background(), options)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	unlockOther()
+}
+
+func TestLxcDriver_Fake_Prepull(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{Name: "foo", Driver: "lxc", Resources: structs.DefaultResources()}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	d.config.Options[lxcPrepullConfigOption] = "ubuntu/bionic/amd64, alpine/3.8/amd64/cloud"
+
+	node := &structs.Node{Attributes: make(map[string]string)}
+	d.fingerprintPrepull(node)
+	if v, ok := node.Attributes["driver.lxc.images"]; ok {
+		t.Fatalf("expected no cached images, got %q", v)
+	}
+
+	// The images are cached by creating and destroying a container
+	for _, name := range []string{"nomad-prepull-ubuntu_bionic_amd64", "nomad-prepull-alpine_3.8_amd64_cloud"} {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			lxcPrepulls.lock.Lock()
+			running := len(lxcPrepulls.running)
+			lxcPrepulls.lock.Unlock()
+			if running == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected images to be cached")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		c := backend.container(name, readLxcPath(d.config))
+		if c == nil || c.Defined() {
+			t.Fatalf("expected container %q to be created and destroyed", name)
+		}
+		if c.options.Template != lxcDownloadTemplate {
+			t.Fatalf("expected %q to be created with the download template, got %+v", name, c.options)
+		}
+	}
+
+	for _, image := range []string{"ubuntu/bionic/amd64/default", "alpine/3.8/amd64/cloud"} {
+		dir := filepath.Join(d.cacheDir(), "download", image)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "build_id"), []byte("20181017_07:42\n"), 0644); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	d.fingerprintPrepull(node)
+	if v := node.Attributes["driver.lxc.images"]; v != "alpine/3.8/amd64/cloud,ubuntu/bionic/amd64" {
+		t.Fatalf("unexpected cached images %q", v)
+	}
+
+	for _, value := range []string{"ubuntu/bionic", "ubuntu/bionic/amd64/default/extra", "ubuntu/../amd64"} {
+		if _, err := parseLxcPrepullImages(value); err == nil {
+			t.Fatalf("expected error for %q", value)
+		}
+	}
+}
+
+func TestLxcDriver_Fake_RootfsArchive(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"rootfs_archive": "local/${NOMAD_TASK_NAME}.tar.gz",
+		},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	if _, err := d.Start(ctx.ExecCtx, task); err == nil || !strings.Contains(err.Error(), "unable to find rootfs archive") {
+		t.Fatalf("expected missing archive error, got %v", err)
+	}
+
+	// Archives linking out of the task directory aren't unpacked
+	archive := filepath.Join(ctx.ExecCtx.TaskDir.Dir, "local", "foo.tar.gz")
+	outside := filepath.Join(ctx.AllocDir.AllocDir, "host.tar.gz")
+	if err := ioutil.WriteFile(outside, []byte("archive"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := os.Symlink(outside, archive); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := d.Start(ctx.ExecCtx, task); err == nil || !strings.Contains(err.Error(), "escapes the task directory") {
+		t.Fatalf("expected escaping archive error, got %v", err)
+	}
+	if err := os.Remove(archive); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := ioutil.WriteFile(archive, []byte("archive"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer sresp.Handle.Kill()
+
+	c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), readLxcPath(d.config))
+	template := filepath.Join(ctx.ExecCtx.TaskDir.Dir, lxcRootfsArchiveTemplateName)
+	if c.options.Template != template {
+		t.Fatalf("expected template %q, got %q", template, c.options.Template)
+	}
+	if expected := []string{"--archive", archive}; !reflect.DeepEqual(c.options.ExtraArgs, expected) {
+		t.Fatalf("expected template args %v, got %v", expected, c.options.ExtraArgs)
+	}
+	if fi, err := os.Stat(template); err != nil || fi.Mode().Perm()&0111 == 0 {
+		t.Fatalf("expected executable template, got %v", err)
+	}
+
+	// The template unpacks the archive into the rootfs
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not found")
+	}
+	src, err := ioutil.TempDir("", "rootfs")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(src)
+	if err := os.MkdirAll(filepath.Join(src, "etc"), 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "etc", "hostname"), []byte("app\n"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if out, err := exec.Command("tar", "-czf", archive, "-C", src, ".").CombinedOutput(); err != nil {
+		t.Fatalf("err: %v: %s", err, out)
+	}
+	rootfs := filepath.Join(src, "rootfs")
+	if err := os.Mkdir(rootfs, 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	args := append([]string{"--path", src, "--name", "foo", "--rootfs", rootfs}, c.options.ExtraArgs...)
+	if out, err := exec.Command(template, args...).CombinedOutput(); err != nil {
+		t.Fatalf("err: %v: %s", err, out)
+	}
+	if raw, err := ioutil.ReadFile(filepath.Join(rootfs, "etc", "hostname")); err != nil || string(raw) != "app\n" {
+		t.Fatalf("expected the archive to be unpacked, got %q: %v", raw, err)
+	}
+}
+
+func TestLxcDriver_Fake_Backend(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:      "foo",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox", "backend": "btrfs"},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	lxcPath := readLxcPath(d.config)
+	if err := os.MkdirAll(lxcPath, 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The LXC path must be on btrfs
+	if isBtrfs(lxcPath) {
+		t.Skip("test directory is on btrfs")
+	}
+	if _, err := d.Start(ctx.ExecCtx, task); err == nil || !strings.Contains(err.Error(), "btrfs filesystem") {
+		t.Fatalf("expected btrfs error, got %v", err)
+	}
+
+	// ZFS requires the zfs tool
+	task.Config["backend"] = "zfs"
+	if _, err := d.Start(ctx.ExecCtx, task); err == nil || !strings.Contains(err.Error(), "requires zfs") {
+		t.Fatalf("expected zfs error, got %v", err)
+	}
+
+	// LVM requires the LVM tools
+	task.Config["backend"] = "lvm"
+	if _, err := d.Start(ctx.ExecCtx, task); err == nil || !strings.Contains(err.Error(), "requires lvcreate") {
+		t.Fatalf("expected lvm error, got %v", err)
+	}
+
+	// Overlay requires the kernel's overlayfs
+	task.Config["backend"] = "overlay"
+	overlay := false
+	if f, err := os.Open(procFilesystems); err == nil {
+		overlay = kernelHasFilesystem(f, "overlay")
+		f.Close()
+	}
+	if !overlay {
+		if _, err := d.Start(ctx.ExecCtx, task); err == nil || !strings.Contains(err.Error(), "overlayfs support") {
+			t.Fatalf("expected overlay error, got %v", err)
+		}
+	} else {
+		// A task of its own keeps clear of the crash loop of the others
+		overlayTask := task.Copy()
+		overlayTask.Name = "bar"
+		sresp, err := d.Start(ctx.ExecCtx, overlayTask)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		c := backend.container(lxcContainerName(overlayTask.Name, ctx.DriverCtx.allocID), lxcPath)
+		if c.options.Backend != lxc.Overlayfs {
+			t.Fatalf("expected overlay backend, got %v", c.options.Backend)
+		}
+		if err := sresp.Handle.Kill(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	task.Config["backend"] = "dir"
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer sresp.Handle.Kill()
+	c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), lxcPath)
+	if c.options.Backend != lxc.Directory {
+		t.Fatalf("expected dir backend, got %v", c.options.Backend)
+	}
+
+	// Btrfs subvolumes are directories on the host
+	other, _ := backend.NewContainer("other", lxcPath)
+	other.SetConfigItem("lxc.rootfs.path", "btrfs:/var/lib/lxc/other/rootfs")
+	if dir, err := lxcRootfsDir(other); err != nil || dir != "/var/lib/lxc/other/rootfs" {
+		t.Fatalf("unexpected rootfs dir %q: %v", dir, err)
+	}
+
+	// Overlay root filesystems are only merged while the container runs
+	other.SetConfigItem("lxc.rootfs.path", "overlay:/var/lib/lxc/other/rootfs:/var/lib/lxc/other/delta0")
+	if _, err := lxcRootfsDir(other); err == nil {
+		t.Fatalf("expected overlay rootfs not to be directory backed")
+	}
+	if err := (&LxcDriverConfig{Backend: lxcBackendOverlay, RootfsSizeMB: 512}).validateBackend(); len(err) == 0 {
+		t.Fatalf("expected rootfs_size_mb to be refused with the overlay backend")
+	}
+}
+
+func TestLxcDriver_Fake_RootfsSize(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template":       "busybox",
+			"backend":        "zfs",
+			"rootfs_size_mb": 512,
+		},
+		KillTimeout: 10 * time.Second,
+		Resources:   structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	backend.paths = map[string]string{"zfs": "/sbin/zfs"}
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer sresp.Handle.Kill()
+
+	name := lxcContainerName(task.Name, ctx.DriverCtx.allocID)
+	expected := []string{"zfs", "set", "refquota=512M", "lxc/" + name}
+	var found bool
+	for _, cmd := range backend.commands {
+		if reflect.DeepEqual(cmd, expected) {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected %v, got commands %v", expected, backend.commands)
+	}
+
+	// Btrfs subvolumes are limited through their qgroup
+	other, _ := backend.NewContainer("other", readLxcPath(d.config))
+	other.SetConfigItem("lxc.rootfs.path", "btrfs:/var/lib/lxc/other/rootfs")
+	if err := d.limitRootfsSize(other, 256); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected = []string{"btrfs", "qgroup", "limit", "256M", "/var/lib/lxc/other/rootfs"}
+	if cmd := backend.commands[len(backend.commands)-1]; !reflect.DeepEqual(cmd, expected) {
+		t.Fatalf("expected %v, got %v", expected, cmd)
+	}
+
+	// Logical volumes are resized
+	other.SetConfigItem("lxc.rootfs.path", "lvm:/dev/lxc/other")
+	if err := d.limitRootfsSize(other, 2048); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected = []string{"lvresize", "--resizefs", "--force", "-L", "2048M", "/dev/lxc/other"}
+	if cmd := backend.commands[len(backend.commands)-1]; !reflect.DeepEqual(cmd, expected) {
+		t.Fatalf("expected %v, got %v", expected, cmd)
+	}
+
+	// A failed limit destroys the new container
+	backend.run = func(name string, args []string) ([]byte, error) {
+		return []byte("cannot set property"), fmt.Errorf("exit status 1")
+	}
+	task.Name = "bar"
+	if _, err := d.Start(ctx.ExecCtx, task); err == nil || !strings.Contains(err.Error(), "cannot set property") {
+		t.Fatalf("expected limit error, got %v", err)
+	}
+}
+
+func TestLxcDriver_Fake_StorageSpace(t *testing.T) {
+	t.Parallel()
+	for _, c := range []struct {
+		st       syscall.Statfs_t
+		expected int
+	}{
+		{syscall.Statfs_t{}, 0},
+		{syscall.Statfs_t{Blocks: 100, Bfree: 100, Bavail: 95}, 0},
+		{syscall.Statfs_t{Blocks: 100, Bfree: 50, Bavail: 45}, 53},
+		{syscall.Statfs_t{Blocks: 100, Bfree: 5, Bavail: 0}, 100},
+	} {
+		if used := statfsUsedPercent(&c.st); used != c.expected {
+			t.Fatalf("expected %d%% used for %+v, got %d%%", c.expected, c.st, used)
+		}
+	}
+
+	task := &structs.Task{
+		Name:      "foo",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox"},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, _ := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	lxcPath := readLxcPath(d.config)
+	if err := os.MkdirAll(lxcPath, 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(lxcPath, &st); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	used := statfsUsedPercent(&st)
+	if used == 0 {
+		t.Skip("test directory is on an empty filesystem")
+	}
+
+	d.config.Options[lxcStorageMaxUsedConfigOption] = strconv.Itoa(used - 1)
+	if _, err := d.Start(ctx.ExecCtx, task); err == nil || !strings.Contains(err.Error(), "full") || !structs.IsRecoverable(err) {
+		t.Fatalf("expected recoverable space error, got %v", err)
+	}
+
+	// ZFS backed root filesystems aren't created under the LXC path
+	if err := d.checkStorageSpace(lxcBackendZFS, lxcPath); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	d.config.Options[lxcStorageMaxUsedConfigOption] = "100"
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	sresp.Handle.Kill()
+}
+
+func TestLxcDriver_Fake_Cleanup(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:        "foo",
+		Driver:      "lxc",
+		Config:      map[string]interface{}{"template": "busybox"},
+		KillTimeout: 10 * time.Second,
+		Resources:   structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	presp, err := d.Prestart(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	name := lxcContainerName(task.Name, ctx.DriverCtx.allocID)
+	res := presp.CreatedResources.Copy()
+	if expected := []string{name}; !reflect.DeepEqual(res.Resources[lxcContainerResKey], expected) {
+		t.Fatalf("expected created resources %v, got %v", expected, res.Resources)
+	}
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	c := backend.container(name, readLxcPath(d.config))
+
+	// The container is destroyed even if it is still running
+	if err := d.Cleanup(ctx.ExecCtx, res); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if c.Defined() {
+		t.Fatalf("expected container to be destroyed")
+	}
+	if len(res.Resources) != 0 {
+		t.Fatalf("expected cleaned up resources to be removed, got %v", res.Resources)
+	}
+	sresp.Handle.Kill()
+
+	// Containers that were never created or are already gone are skipped
+	if err := d.Cleanup(ctx.ExecCtx, presp.CreatedResources.Copy()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestLxcDriver_Fake_PreserveOnFailure(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template":            "busybox",
+			"preserve_on_failure": "1h",
+		},
+		KillTimeout: 10 * time.Second,
+		Resources:   structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	var events []string
+	d.DriverContext.emitEvent = func(m string, args ...interface{}) {
+		events = append(events, fmt.Sprintf(m, args...))
+	}
+	presp, err := d.Prestart(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Containers failing to start are kept rather than destroyed
+	backend.startErr = fmt.Errorf("no init")
+	if _, err := d.Start(ctx.ExecCtx, task); err == nil {
+		t.Fatalf("expected start error")
+	}
+	name := lxcContainerName(task.Name, ctx.DriverCtx.allocID)
+	c := backend.container(name, readLxcPath(d.config))
+	if !c.Defined() {
+		t.Fatalf("expected failed container to be preserved")
+	}
+	if until := lxcPreservedUntil(c); time.Until(until) < 59*time.Minute {
+		t.Fatalf("expected container to be preserved for an hour, got until %v", until)
+	}
+	if len(events) == 0 || !strings.HasPrefix(events[len(events)-1], "Preserving the failed container") {
+		t.Fatalf("expected preserve event, got %v", events)
+	}
+
+	// Containers starting again are no longer preserved
+	backend.startErr = nil
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if until := lxcPreservedUntil(c); !until.IsZero() {
+		t.Fatalf("expected preservation to be cleared, got until %v", until)
+	}
+	sresp.Handle.Kill()
+
+	// Cleanup leaves preserved containers until their time is up
+	if err := preserveContainer(c, time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := d.Cleanup(ctx.ExecCtx, presp.CreatedResources.Copy()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !c.Defined() {
+		t.Fatalf("expected preserved container to be kept")
+	}
+	testutil.WaitForResult(func() (bool, error) {
+		return !c.Defined(), fmt.Errorf("preserved container not destroyed")
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+}
+
+func TestLxcDriver_Fake_WarmPool(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template": "download",
+			"distro":   "ubuntu",
+			"release":  "bionic",
+			"arch":     "amd64",
+		},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	d.config.Options[lxcPrepullConfigOption] = "ubuntu/bionic/amd64"
+	d.config.Options[lxcWarmPoolConfigOption] = "2"
+	image := lxcPrepullImage{Distro: "ubuntu", Release: "bionic", Arch: "amd64"}
+	lxcPath := readLxcPath(d.config)
+
+	waitForPool := func(size int) {
+		testutil.WaitForResult(func() (bool, error) {
+			if n := len(d.pooledContainers(image, lxcPath)); n != size {
+				return false, fmt.Errorf("expected %d pooled containers, got %d", size, n)
+			}
+			return true, nil
+		}, func(err error) {
+			t.Fatalf("err: %v", err)
+		})
+	}
+
+	// Pools are only filled once their image is cached
+	d.fingerprintWarmPool()
+	waitForPool(0)
+	dir := filepath.Join(d.cacheDir(), "download", "ubuntu/bionic/amd64/default")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "build_id"), []byte("20181017_07:42\n"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	d.fingerprintWarmPool()
+	waitForPool(2)
+	pooled := d.pooledContainers(image, lxcPath)
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer sresp.Handle.Kill()
+
+	// The task's container was renamed from the pool, which is refilled
+	name := lxcContainerName(task.Name, ctx.DriverCtx.allocID)
+	c := backend.container(name, lxcPath)
+	if c == nil || !c.Defined() {
+		t.Fatalf("expected container %q", name)
+	}
+	if readLxcConfigHash(c) == "" {
+		t.Fatalf("expected config hash of claimed container to be recorded")
+	}
+	if p := backend.container(pooled[0], lxcPath); p.Defined() {
+		t.Fatalf("expected pooled container %q to be claimed", pooled[0])
+	}
+	waitForPool(2)
+
+	// Shrinking the pool destroys the extra containers
+	d.config.Options[lxcWarmPoolConfigOption] = "0"
+	d.fingerprintWarmPool()
+	waitForPool(0)
+
+	// Containers the pool can't create are created for the task
+	config := &LxcDriverConfig{Template: lxcDownloadTemplate, Distro: "ubuntu", Release: "bionic", Arch: "amd64"}
+	if _, ok := config.poolImage(); !ok {
+		t.Fatalf("expected %+v to be claimable", config)
+	}
+	config.Backend = lxcBackendBtrfs
+	if _, ok := config.poolImage(); ok {
+		t.Fatalf("expected %+v not to be claimable", config)
+	}
+}
+
+func TestLxcDriver_Fake_WarmPoolCreating(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{Name: "foo", Driver: "lxc", Resources: structs.DefaultResources()}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	d.config.Options[lxcPrepullConfigOption] = "alpine/3.8/amd64"
+	d.config.Options[lxcWarmPoolConfigOption] = "1"
+	image := lxcPrepullImage{Distro: "alpine", Release: "3.8", Arch: "amd64"}
+	lxcPath := readLxcPath(d.config)
+	dir := filepath.Join(d.cacheDir(), "download", "alpine/3.8/amd64/default")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "build_id"), []byte("20181017_07:42\n"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// A container left half created by a previous client
+	leftover := lxcPoolCreatingPrefix + "leftover"
+	c, err := backend.NewContainer(leftover, lxcPath)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := createFakeLxcContainer(backend, c); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Containers are defined before their template finished, and stay out
+	// of the pool until then
+	backend.createDelay = 500 * time.Millisecond
+	d.fingerprintWarmPool()
+	var creating string
+	testutil.WaitForResult(func() (bool, error) {
+		for _, name := range backend.DefinedContainerNames(lxcPath) {
+			if strings.HasPrefix(name, lxcPoolCreatingPrefix) && name != leftover {
+				creating = name
+				return true, nil
+			}
+		}
+		return false, fmt.Errorf("expected a container being created")
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+	if pooled := d.pooledContainers(image, lxcPath); len(pooled) != 0 {
+		t.Fatalf("expected no pooled container while creating, got %v", pooled)
+	}
+	if d.claimPooled(image, lxcContainerName(task.Name, ctx.DriverCtx.allocID), lxcPath) {
+		t.Fatalf("expected the container being created not to be claimed")
+	}
+
+	// The container being created isn't destroyed, unlike the leftover
+	d.fingerprintWarmPool()
+	if !backend.container(creating, lxcPath).Defined() {
+		t.Fatalf("expected container %q being created to be kept", creating)
+	}
+	if backend.container(leftover, lxcPath).Defined() {
+		t.Fatalf("expected leftover container %q to be destroyed", leftover)
+	}
+
+	// Created containers are renamed into the pool
+	testutil.WaitForResult(func() (bool, error) {
+		if n := len(d.pooledContainers(image, lxcPath)); n != 1 {
+			return false, fmt.Errorf("expected 1 pooled container, got %d", n)
+		}
+		return true, nil
+	}, func(err error) {
+		t.Fatalf("err: %v", err)
+	})
+	pooled := d.pooledContainers(image, lxcPath)[0]
+	if !strings.HasSuffix(pooled, strings.TrimPrefix(creating, lxcPoolCreatingPrefix)) {
+		t.Fatalf("expected %q to be renamed into the pool, got %q", creating, pooled)
+	}
+	if c := backend.container(pooled, lxcPath); c.options.Template != lxcDownloadTemplate {
+		t.Fatalf("expected pooled container to be created from the download template, got %q", c.options.Template)
+	}
+}
+
+func TestLxcDriver_Fake_StopStages(t *testing.T) {
+	t.Parallel()
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	go cmd.Wait()
+	defer cmd.Process.Kill()
+
+	pidfile, err := ioutil.TempFile("", "lxc-stop")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.Remove(pidfile.Name())
+	fmt.Fprintf(pidfile, "%d\n", cmd.Process.Pid)
+	pidfile.Close()
+
+	// The fake container's init is the test process, so the process of the
+	// PID file is looked up in the host's /proc
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template":       "busybox",
+			"signal_pidfile": pidfile.Name(),
+			"stop": []map[string]interface{}{
+				{"signal": "SIGINT", "signal_timeout": "10s", "shutdown_timeout": "3s"},
+			},
+		},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	h := sresp.Handle.(*lxcDriverHandle)
+	expected := &lxcStop{Signal: syscall.SIGINT, SignalTimeout: 10 * time.Second, ShutdownTimeout: 3 * time.Second}
+	if !reflect.DeepEqual(h.stopStages, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, h.stopStages)
+	}
+	if h.shutdownTimeout() != 3*time.Second {
+		t.Fatalf("expected shutdown timeout, got %s", h.shutdownTimeout())
+	}
+
+	// The signaled process exiting moves on to the shutdown
+	var signaled []string
+	backend.attach = func(args []string, env []string) (string, int) {
+		signaled = args
+		cmd.Process.Kill()
+		return "", 0
+	}
+	start := time.Now()
+	if err := h.Kill(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected shutdown once the process exited, took %s", elapsed)
+	}
+	if expected := []string{"kill", "-2", strconv.Itoa(cmd.Process.Pid)}; !reflect.DeepEqual(signaled, expected) {
+		t.Fatalf("expected %v, got %v", expected, signaled)
+	}
+	if c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), readLxcPath(d.config)); c.Running() {
+		t.Fatalf("expected container to be stopped")
+	}
+
+	// Handles without a stop block shut down within the kill timeout
+	h.stopStages = nil
+	h.killTimeout = 7 * time.Second
+	if h.shutdownTimeout() != 7*time.Second {
+		t.Fatalf("expected kill timeout, got %s", h.shutdownTimeout())
+	}
+	if s := newLxcStop([]LxcStopConfig{{SignalTimeout: "1s"}}); s.Signal != syscall.SIGTERM {
+		t.Fatalf("expected default signal SIGTERM, got %s", s.Signal)
+	}
+}
+
+func TestLxcDriver_Fake_CreateTimeout(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template":       "busybox",
+			"create_timeout": "50ms",
+		},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	// The task's timeout overrides the client's
+	d.config.Options[lxcCreateTimeoutConfigOption] = "1h"
+	backend.createDelay = time.Hour
+	_, err := d.Start(ctx.ExecCtx, task)
+	if err == nil || !strings.Contains(err.Error(), "timed out after 50ms") {
+		t.Fatalf("expected create timeout, got %v", err)
+	}
+	if !structs.IsRecoverable(err) {
+		t.Fatalf("expected a recoverable error, got %v", err)
+	}
+
+	// The stuck template is killed rather than left to create the container
+	c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), readLxcPath(d.config))
+	c.lock.Lock()
+	created := c.options.Template != ""
+	c.lock.Unlock()
+	if created || c.Defined() {
+		t.Fatalf("expected container not to be created")
+	}
+
+	// A template finishing as the deadline passes created the container
+	backend.createDelay = 100 * time.Millisecond
+	backend.createIgnoresCtx = true
+	if err := d.createContainer(c, lxc.TemplateOptions{Template: "busybox"}, nil, 10*time.Millisecond); err != nil {
+		t.Fatalf("expected created container to be kept, got %v", err)
+	}
+	if !c.Defined() {
+		t.Fatalf("expected container to be created")
+	}
+
+	config := &LxcDriverConfig{}
+	if timeout := d.createTimeout(config); timeout != time.Hour {
+		t.Fatalf("expected client create timeout, got %s", timeout)
+	}
+}
+
+func TestLxcDriver_Fake_CreateTimeoutWaiting(t *testing.T) {
+	// Not parallel as the create slots are shared by the node's drivers
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template":       "busybox",
+			"create_timeout": "50ms",
+		},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	// Waiting for a create slot counts against the timeout
+	d.config.Options[lxcCreateParallelismConfigOption] = "1"
+	release, err := lxcCreatePhase.acquire(context.Background(), d)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	_, err = d.Start(ctx.ExecCtx, task)
+	release()
+	if err == nil || !strings.Contains(err.Error(), "timed out after 50ms") {
+		t.Fatalf("expected create timeout, got %v", err)
+	}
+	if !structs.IsRecoverable(err) {
+		t.Fatalf("expected a recoverable error, got %v", err)
+	}
+	if c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), readLxcPath(d.config)); c.Defined() {
+		t.Fatalf("expected container not to be created")
+	}
+}
+
+func TestLxcDriver_Fake_SwapAccounting(t *testing.T) {
+	// Not parallel as the cgroup mount is swapped for a directory
+	dir, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(p string) { sysCgroupDir = p }(sysCgroupDir)
+	sysCgroupDir = dir
+
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template": "busybox",
+			"limits":   []map[string]interface{}{{"memory_swap_mb": 128}},
+		},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	var events []string
+	d.DriverContext.emitEvent = func(m string, args ...interface{}) {
+		events = append(events, fmt.Sprintf(m, args...))
+	}
+
+	node := &structs.Node{Attributes: map[string]string{lxcSwapAccountingAttr: "1"}}
+	d.fingerprintSwapAccounting(node)
+	if _, ok := node.Attributes[lxcSwapAccountingAttr]; ok {
+		t.Fatalf("expected no %s attribute", lxcSwapAccountingAttr)
+	}
+
+	// Tasks are refused if the client requires swap accounting
+	d.config.Options[lxcRequireSwapAccountingConfigOption] = "true"
+	if _, err := d.Start(ctx.ExecCtx, task); err == nil || !strings.Contains(err.Error(), "swapaccount=1") {
+		t.Fatalf("expected swap accounting error, got %v", err)
+	}
+	if len(backend.containers) != 0 {
+		t.Fatalf("expected no container to be created")
+	}
+
+	// and otherwise started without their swap limit
+	delete(d.config.Options, lxcRequireSwapAccountingConfigOption)
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	close(sresp.Handle.(*lxcDriverHandle).doneCh)
+	c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), readLxcPath(d.config))
+	if v := c.CgroupItem("memory.memsw.limit_in_bytes"); len(v) != 0 {
+		t.Fatalf("expected no swap limit, got %v", v)
+	}
+	if len(events) != 1 || !strings.Contains(events[0], "not enforced") {
+		t.Fatalf("expected swap limit event, got %v", events)
+	}
+
+	// The unified hierarchy has the swap files in the root's children
+	if err := os.MkdirAll(filepath.Join(dir, "system.slice"), 0755); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "system.slice", "memory.swap.max"), []byte("max\n"), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	d.fingerprintSwapAccounting(node)
+	if node.Attributes[lxcSwapAccountingAttr] != "1" {
+		t.Fatalf("expected %s attribute, got %v", lxcSwapAccountingAttr, node.Attributes)
+	}
+	if enforce, err := d.checkSwapAccounting(LxcLimitsConfig{MemorySwapMB: 128}); !enforce || err != nil {
+		t.Fatalf("expected swap limit to be enforced, got %v, %v", enforce, err)
+	}
+}
+
+func TestLxcDriver_Fake_Arches(t *testing.T) {
+	// Not parallel as the binfmt_misc directory is swapped for a directory
+	dir, err := ioutil.TempDir("", "binfmt_misc")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(p string) { binfmtMiscDir = p }(binfmtMiscDir)
+	binfmtMiscDir = dir
+
+	handlers := map[string]string{
+		"status":       "enabled\n",
+		"qemu-aarch64": "enabled\ninterpreter /usr/bin/qemu-aarch64-static\nflags: OCF\noffset 0\n",
+		"qemu-ppc64le": "enabled\ninterpreter /usr/bin/qemu-ppc64le-static\nflags: F\noffset 0\n",
+		"qemu-riscv64": "enabled\ninterpreter /usr/bin/qemu-riscv64\nflags: \noffset 0\n",
+		"qemu-s390x":   "disabled\ninterpreter /usr/bin/qemu-s390x-static\nflags: F\noffset 0\n",
+	}
+	for name, content := range handlers {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template": "busybox",
+			"arch":     "riscv64",
+		},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, _ := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	var events []string
+	d.DriverContext.emitEvent = func(m string, args ...interface{}) {
+		events = append(events, fmt.Sprintf(m, args...))
+	}
+
+	node := &structs.Node{Attributes: map[string]string{"driver.lxc.arch.mips.emulated": "1"}}
+	d.fingerprintArches(node)
+	var emulated []string
+	for key := range node.Attributes {
+		emulated = append(emulated, key)
+	}
+	sort.Strings(emulated)
+	expected := []string{"driver.lxc.arch.arm64.emulated", "driver.lxc.arch.ppc64el.emulated"}
+	if runtime.GOARCH == "arm64" {
+		expected = expected[1:]
+	}
+	if !reflect.DeepEqual(emulated, expected) {
+		t.Fatalf("expected emulated arches %v, got %v", expected, emulated)
+	}
+
+	// Handlers without the F flag can't be used in containers
+	if _, err := d.Start(ctx.ExecCtx, task); err == nil || !strings.Contains(err.Error(), "neither native nor emulated") {
+		t.Fatalf("expected arch error, got %v", err)
+	}
+
+	if err := d.checkArch("ppc64le"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(events) != 1 || !strings.Contains(events[0], "ppc64el container through emulation") {
+		t.Fatalf("expected emulation event, got %v", events)
+	}
+	if err := d.checkArch(runtime.GOARCH); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected no event for native arch, got %v", events)
+	}
+}
+
+func TestLxcDriver_Fake_CoreDumps(t *testing.T) {
+	// Not parallel as the core_pattern is swapped for a file
+	f, err := ioutil.TempFile("", "core_pattern")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("core\n")
+	f.Close()
+	defer func(p string) { procCorePattern = p }(procCorePattern)
+	procCorePattern = f.Name()
+
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template":   "busybox",
+			"core_dumps": []map[string]interface{}{{"max_size_mb": 1}},
+		},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	var events []string
+	d.DriverContext.emitEvent = func(m string, args ...interface{}) {
+		events = append(events, fmt.Sprintf(m, args...))
+	}
+
+	// The node advertises collecting core dumps once configured to
+	node := &structs.Node{Attributes: make(map[string]string)}
+	d.fingerprintCoreDumps(node)
+	if _, ok := node.Attributes[lxcCoreDumpsAttr]; ok {
+		t.Fatalf("expected no %s attribute", lxcCoreDumpsAttr)
+	}
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	h := sresp.Handle.(*lxcDriverHandle)
+	defer close(h.doneCh)
+	if len(events) != 1 || !strings.Contains(events[0], `core_pattern is "core"`) {
+		t.Fatalf("expected core_pattern event, got %v", events)
+	}
+
+	c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), readLxcPath(d.config))
+	dir := filepath.Join(ctx.ExecCtx.TaskDir.Dir, lxcCoreDumpsDir)
+	if !c.hasConfig("lxc.prlimit.core", "1048576") || !c.hasConfig("lxc.mount.entry", dir+" var/crash/nomad none rw,bind") {
+		t.Fatalf("expected core dumps config, got %v", c.config)
+	}
+
+	d.config.Options[lxcCoreDumpsConfigOption] = "true"
+	d.fingerprintCoreDumps(node)
+	if node.Attributes[lxcCoreDumpsAttr] != "1" {
+		t.Fatalf("expected %s attribute, got %v", lxcCoreDumpsAttr, node.Attributes)
+	}
+	if raw, _ := ioutil.ReadFile(f.Name()); string(raw) != lxcCorePattern {
+		t.Fatalf("expected core_pattern %q, got %q", lxcCorePattern, raw)
+	}
+
+	// Dumps are reported once fully written, and the oldest removed beyond
+	// the cap
+	events = nil
+	reported, pending := make(map[string]bool), make(map[string]int64)
+	old := time.Now().Add(-time.Minute)
+	for name, size := range map[string]int{"core.old.1.1": 768 * 1024, "core.app.2.2": 512 * 1024} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, make([]byte, size), 0600); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if strings.Contains(name, "old") {
+			os.Chtimes(path, old, old)
+		}
+	}
+	h.checkCoreDumps(reported, pending)
+	if len(events) != 0 {
+		t.Fatalf("expected dumps to be reported once written, got %v", events)
+	}
+	h.checkCoreDumps(reported, pending)
+	if len(events) != 2 || !strings.Contains(events[1], "dumps/core.app.2.2") {
+		t.Fatalf("expected dumps to be reported, got %v", events)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "core.old.1.1")); !os.IsNotExist(err) {
+		t.Fatalf("expected oldest dump to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "core.app.2.2")); err != nil {
+		t.Fatalf("expected newest dump to be kept: %v", err)
+	}
+}
+
+func TestLxcDriver_ReloadOptions(t *testing.T) {
+	// Not parallel as the reloaded options are shared by the node's drivers
+	defer func() {
+		lxcReloaded.lock.Lock()
+		lxcReloaded.options = nil
+		lxcReloaded.lock.Unlock()
+	}()
+	cfg := &config.Config{Options: map[string]string{
+		lxcVolumesConfigOption:          "false",
+		lxcPathConfigOption:             "/var/lib/lxc",
+		lxcStartParallelismConfigOption: "1",
+		"driver.raw_exec.enable":        "1",
+	}}
+	d := NewLxcDriver(&DriverContext{config: cfg, logger: testLogger(), emitEvent: func(string, ...interface{}) {}}).(*LxcDriver)
+
+	release, err := lxcStartPhase.acquire(context.Background(), d)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	acquired := make(chan func())
+	go func() {
+		release, err := lxcStartPhase.acquire(context.Background(), d)
+		if err != nil {
+			t.Errorf("err: %v", err)
+			return
+		}
+		acquired <- release
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("expected start to wait for a slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Only the options that can change at runtime are reloaded
+	changed := ReloadLxcOptions(cfg, map[string]string{
+		lxcVolumesConfigOption:          "true",
+		lxcPathConfigOption:             "/srv/lxc",
+		lxcStartParallelismConfigOption: "2",
+		lxcImageMirrorConfigOption:      "https://mirror.example.com",
+	}, testLogger())
+	expected := []string{lxcImageMirrorConfigOption, lxcStartParallelismConfigOption, lxcVolumesConfigOption}
+	if !reflect.DeepEqual(changed, expected) {
+		t.Fatalf("expected %v, got %v", expected, changed)
+	}
+
+	// Raising the limit lets the waiting start through
+	select {
+	case release2 := <-acquired:
+		release2()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected start to acquire a slot once the limit was raised")
+	}
+	release()
+
+	reloaded := NewLxcDriver(&DriverContext{config: cfg, logger: testLogger()}).(*LxcDriver).config
+	if !reloaded.ReadBoolDefault(lxcVolumesConfigOption, false) || reloaded.Read(lxcImageMirrorConfigOption) == "" {
+		t.Fatalf("expected reloaded options, got %v", reloaded.Options)
+	}
+	if reloaded.Read(lxcPathConfigOption) != "/var/lib/lxc" || reloaded.Read("driver.raw_exec.enable") != "1" {
+		t.Fatalf("expected options requiring a restart to be kept, got %v", reloaded.Options)
+	}
+	if cfg.Options[lxcVolumesConfigOption] != "false" {
+		t.Fatalf("expected client config to be left alone, got %v", cfg.Options)
+	}
+
+	// Reloading the same options changes nothing, and unset options fall
+	// back to their default
+	if changed := ReloadLxcOptions(cfg, reloaded.Options, testLogger()); len(changed) != 0 {
+		t.Fatalf("expected no changes, got %v", changed)
+	}
+	if changed := ReloadLxcOptions(cfg, nil, testLogger()); !reflect.DeepEqual(changed, expected) {
+		t.Fatalf("expected %v, got %v", expected, changed)
+	}
+}
+
+func TestLxcDriver_Fake_Provision(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:   "foo",
+		Driver: "lxc",
+		Config: map[string]interface{}{
+			"template":       "busybox",
+			"provision_cmds": []string{"systemd-machine-id-setup", "exit 3"},
+		},
+		Env:       map[string]string{"APP_ENV": "test"},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, backend := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+	backend.run = func(name string, args []string) ([]byte, error) {
+		if args[len(args)-1] == "exit 3" {
+			return []byte("boom"), fmt.Errorf("exit status 3")
+		}
+		return nil, nil
+	}
+
+	// Privileged containers are only provisioned if the operator allows it
+	_, err := d.Start(ctx.ExecCtx, task)
+	if err == nil || !strings.Contains(err.Error(), lxcPrivilegedProvisionConfigOption) {
+		t.Fatalf("expected privileged provisioning error, got %v", err)
+	}
+	if len(backend.commands) != 0 {
+		t.Fatalf("expected no provisioning command, got %v", backend.commands)
+	}
+	d.config.Options[lxcPrivilegedProvisionConfigOption] = "true"
+
+	_, err = d.Start(ctx.ExecCtx, task)
+	if err == nil || !strings.Contains(err.Error(), "provisioning command 2 failed") || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected provisioning error, got %v", err)
+	}
+	c := backend.container(lxcContainerName(task.Name, ctx.DriverCtx.allocID), readLxcPath(d.config))
+	if c.Defined() {
+		t.Fatalf("expected container failing to provision to be destroyed")
+	}
+
+	// The commands run in a chroot of the root filesystem with the
+	// container's environment
+	rootfs := filepath.Join(readLxcPath(d.config), c.Name(), "rootfs")
+	first := strings.Join(backend.commands[0], " ")
+	for _, part := range []string{"env -i " + lxcAttachPath, "APP_ENV=test", "chroot " + rootfs + " /bin/sh -c systemd-machine-id-setup"} {
+		if !strings.Contains(first, part) {
+			t.Fatalf("expected %q in provisioning command %q", part, first)
+		}
+	}
+
+	// The commands of unprivileged containers run with their ID map, which
+	// needs no operator consent
+	backend.commands = nil
+	d.config.Options[lxcPrivilegedProvisionConfigOption] = "false"
+	c.config["lxc.rootfs.path"] = []string{"dir:" + rootfs}
+	c.config["lxc.idmap"] = []string{"u 0 100000 65536", "g 0 100000 65536"}
+	if err := d.provisionRootfs(c, []string{"systemd-machine-id-setup"}, nil); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	first = strings.Join(backend.commands[0], " ")
+	expected := "lxc-usernsexec -m u:0:100000:65536 -m g:0:100000:65536 -- env -i " + lxcAttachPath + " chroot " + rootfs
+	if !strings.HasPrefix(first, expected) {
+		t.Fatalf("expected provisioning command to start with %q, got %q", expected, first)
+	}
+}
+
+func TestLxcDriver_Fake_OpenMissing(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{Name: "foo", Driver: "lxc", Resources: structs.DefaultResources()}
+	ctx, d, _ := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	id := fmt.Sprintf(`{"ContainerName":%q,"LxcPath":%q}`, lxcContainerName(task.Name, ctx.DriverCtx.allocID), readLxcPath(d.config))
+	if _, err := d.Open(ctx.ExecCtx, id); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestLxcDriver_Fake_OpenMigrate(t *testing.T) {
+	t.Parallel()
+	task := &structs.Task{
+		Name:      "foo",
+		Driver:    "lxc",
+		Config:    map[string]interface{}{"template": "busybox"},
+		Resources: structs.DefaultResources(),
+	}
+	ctx, d, _ := testFakeLxcDriver(t, task)
+	defer ctx.AllocDir.Destroy()
+
+	sresp, err := d.Start(ctx.ExecCtx, task)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	h := sresp.Handle.(*lxcDriverHandle)
+	defer close(h.doneCh)
+	var pid lxcPID
+	if err := json.Unmarshal([]byte(h.ID()), &pid); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if pid.Version != lxcHandleVersion {
+		t.Fatalf("expected handle version %d, got %d", lxcHandleVersion, pid.Version)
+	}
+
+	// Unversioned handles written by older clients are upgraded
+	id := fmt.Sprintf(`{"ContainerName":%q,"InitPid":%d,"LxcPath":%q}`, pid.ContainerName, pid.InitPid, pid.LxcPath)
+	handle, err := d.Open(ctx.ExecCtx, id)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	opened := handle.(*lxcDriverHandle)
+	defer close(opened.doneCh)
+	if opened.logFile != h.logFile {
+		t.Fatalf("expected log file %q, got %q", h.logFile, opened.logFile)
+	}
+	if len(opened.env) == 0 {
+		t.Fatalf("expected environment of the container's init")
+	}
+
+	// Handles of newer clients are rejected rather than losing their fields
+	id = fmt.Sprintf(`{"Version":%d,"ContainerName":%q,"LxcPath":%q}`, lxcHandleVersion+1, pid.ContainerName, pid.LxcPath)
+	if _, err := d.Open(ctx.ExecCtx, id); err == nil || !strings.Contains(err.Error(), "newer than the supported version") {
+		t.Fatalf("expected version error, got %v", err)
+	}
+}
+
+func TestLxcDriver_Fake_DestroyContainer(t *testing.T) {
+	backend := newFakeLxcBackend()
+	oldBackend := defaultLxcBackend
+	defaultLxcBackend = backend
+	defer func() { defaultLxcBackend = oldBackend }()
+
+	lxcPath, err := ioutil.TempDir("", "lxc")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(lxcPath)
+	cfg := &config.Config{Options: map[string]string{"driver.lxc.path": lxcPath}}
+
+	name := lxcContainerName("web", "2f3b9a1e-0c4d-4e5f-8a6b-7c8d9e0f1a2b")
+	c, _ := backend.NewContainer(name, lxcPath)
+	if err := createFakeLxcContainer(backend, c); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := c.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := backend.NewContainer("unmanaged", lxcPath); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	containers, err := LxcContainers(cfg)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(containers) != 1 || containers[0].Task != "web" || containers[0].State != "RUNNING" {
+		t.Fatalf("unexpected containers: %#v", containers)
+	}
+
+	if err := DestroyLxcContainer(cfg, name); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if c.Defined() {
+		t.Fatalf("expected container to be destroyed")
+	}
+	if err := DestroyLxcContainer(cfg, name); err == nil {
+		t.Fatalf("expected error destroying a missing container")
+	}
+}
+
+func TestLxcDriver_Fake_PrunePreserved(t *testing.T) {
+	backend := newFakeLxcBackend()
+	oldBackend := defaultLxcBackend
+	defaultLxcBackend = backend
+	defer func() { defaultLxcBackend = oldBackend }()
+
+	lxcPath, err := ioutil.TempDir("", "lxc")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(lxcPath)
+	cfg := &config.Config{Options: map[string]string{"driver.lxc.path": lxcPath}}
+	logger := testLogger()
+
+	preserved := lxcContainerName("web", "2f3b9a1e-0c4d-4e5f-8a6b-7c8d9e0f1a2b")
+	expired := lxcContainerName("web", "5d1c7b3a-9e8f-4a2b-8c6d-1e0f2a3b4c5d")
+	for _, name := range []string{preserved, expired} {
+		c, _ := backend.NewContainer(name, lxcPath)
+		if err := createFakeLxcContainer(backend, c); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	pc := backend.container(preserved, lxcPath)
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := preserveContainer(pc, until); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	ec := backend.container(expired, lxcPath)
+	if err := preserveContainer(ec, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Both containers are orphaned, as the client doesn't know their allocs
+	containers, err := LxcContainers(cfg)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	for _, container := range containers {
+		container.Orphaned = true
+		switch container.Name {
+		case preserved:
+			if !container.PreservedUntil.Equal(until) {
+				t.Fatalf("expected %q preserved until %v, got %v", preserved, until, container.PreservedUntil)
+			}
+		case expired:
+			if !container.PreservedUntil.IsZero() {
+				t.Fatalf("expected %q not to be preserved, got %v", expired, container.PreservedUntil)
+			}
+		}
+	}
+
+	// Only the container whose preservation ran out is pruned
+	pruned, err := PruneLxcContainers(cfg, containers, logger)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(pruned) != 1 || pruned[0].Name != expired {
+		t.Fatalf("expected only %q to be pruned, got %#v", expired, pruned)
+	}
+	if !pc.Defined() || ec.Defined() {
+		t.Fatalf("expected only the preserved container to be kept")
+	}
+
+	// Preserved containers can't be destroyed one at a time either
+	if err := DestroyLxcContainer(cfg, preserved); err == nil || !strings.Contains(err.Error(), "preserved until") {
+		t.Fatalf("expected preserved error, got %v", err)
+	}
+	if !pc.Defined() {
+		t.Fatalf("expected preserved container to be kept")
+	}
+}
+
+func TestLxcDriver_Fake_DestroyContainers(t *testing.T) {
+	backend := newFakeLxcBackend()
+	oldBackend := defaultLxcBackend
+	defaultLxcBackend = backend
+	defer func() { defaultLxcBackend = oldBackend }()
+
+	dir, err := ioutil.TempDir("", "lxc")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	lxcPath := filepath.Join(dir, "lxc")
+	cfg := &config.Config{StateDir: dir, Options: map[string]string{"driver.lxc.path": lxcPath}}
+
+	var containers []lxcContainerAPI
+	for _, allocID := range []string{
+		"2f3b9a1e-0c4d-4e5f-8a6b-7c8d9e0f1a2b",
+		"6a1d0c2e-3b4f-4a5e-9c8d-1e2f3a4b5c6d",
+		"9e8d7c6b-5a4f-4e3d-8c2b-1a0f9e8d7c6b",
+	} {
+		c, _ := backend.NewContainer(lxcContainerName("web", allocID), lxcPath)
+		if err := createFakeLxcContainer(backend, c); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if err := c.Start(); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		containers = append(containers, c)
+	}
+	unmanaged, _ := backend.NewContainer("unmanaged", lxcPath)
+	if err := createFakeLxcContainer(backend, unmanaged); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// The tasks would be restarted unless the driver is in maintenance
+	if _, err := DestroyLxcContainers(cfg, 2, testLogger()); err == nil || !strings.Contains(err.Error(), "must be in maintenance") {
+		t.Fatalf("expected maintenance error, got %v", err)
+	}
+
+	if _, err := SetLxcMaintenance(cfg, true, "decommission"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	report, err := DestroyLxcContainers(cfg, 2, testLogger())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if len(report.Destroyed) != len(containers) || len(report.Failed) != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	for _, c := range containers {
+		if c.Defined() {
+			t.Fatalf("expected container %q to be destroyed", c.Name())
+		}
+	}
+	if !unmanaged.Defined() {
+		t.Fatalf("expected unmanaged container to be kept")
+	}
+}
+
+func TestLxcDriver_Fake_Backup(t *testing.T) {
+	backend := newFakeLxcBackend()
+	backend.run = func(name string, args []string) ([]byte, error) {
+		// Write the archives tar is asked to create
+		if name == "tar" {
+			for i, arg := range args {
+				if arg == "-cf" || arg == "-czf" {
+					return nil, ioutil.WriteFile(args[i+1], []byte("archive"), 0644)
+				}
+			}
+		}
+		return nil, nil
+	}
+
+	lxcPath, err := ioutil.TempDir("", "lxc")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer os.RemoveAll(lxcPath)
+	dest := filepath.Join(lxcPath, "backups")
+	cfg := &config.Config{Options: map[string]string{"driver.lxc.path": lxcPath}}
+
+	name := lxcContainerName("web", "2f3b9a1e-0c4d-4e5f-8a6b-7c8d9e0f1a2b")
+	c, _ := backend.NewContainer(name, lxcPath)
+	if err := createFakeLxcContainer(backend, c); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	now := time.Date(2018, 3, 1, 12, 30, 0, 0, time.UTC)
+	if _, err := backupLxcContainer(backend, cfg, name, false, now); err == nil || !strings.Contains(err.Error(), "no backup destination") {
+		t.Fatalf("expected missing destination error, got: %v", err)
+	}
+
+	cfg.Options[lxcBackupDestinationConfigOption] = dest
+	if _, err := backupLxcContainer(backend, cfg, name, true, now); err == nil || !strings.Contains(err.Error(), "must be running") {
+		t.Fatalf("expected error checkpointing a stopped container, got: %v", err)
+	}
+	if _, err := backupLxcContainer(backend, cfg, "unmanaged", false, now); err == nil {
+		t.Fatalf("expected error backing up an unmanaged container")
+	}
+
+	// Back up a running container with its process state
+	if err := c.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	backup, err := backupLxcContainer(backend, cfg, name, true, now)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	expected := filepath.Join(dest, name+"-20180301T123000Z.tar.gz")
+	if backup.Location != expected || backup.Size != int64(len("archive")) || !backup.Checkpoint {
+		t.Fatalf("unexpected backup: %#v", backup)
+	}
+	if _, err := os.Stat(expected); err != nil {
+		t.Fatalf("expected archive at destination: %v", err)
+	}
+	if !c.Running() {
+		t.Fatalf("expected container to be unfrozen, got state %v", c.State())
+	}
+	rootfsCmd := backend.commands[len(backend.commands)-2]
+	if !strings.Contains(strings.Join(rootfsCmd, " "), fmt.Sprintf("-C /proc/%d/root", os.Getpid())) {
+		t.Fatalf("expected root filesystem to be copied through init, got: %v", rootfsCmd)
+	}
+
+	// Remote destinations are uploaded with rsync
+	cfg.Options[lxcBackupDestinationConfigOption] = "backup@host:/srv/lxc"
+	backup, err = backupLxcContainer(backend, cfg, name, false, now)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if backup.Location != "backup@host:/srv/lxc/"+name+"-20180301T123000Z.tar.gz" {
+		t.Fatalf("unexpected location: %q", backup.Location)
+	}
+	last := backend.commands[len(backend.commands)-1]
+	if last[0] != "rsync" || last[2] != backup.Location {
+		t.Fatalf("expected rsync upload, got: %v", last)
+	}
+}
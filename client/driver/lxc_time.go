@@ -0,0 +1,103 @@
+//+build linux,lxc
+
+package driver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// zoneinfoDir is the directory of the timezone database in containers.
+const zoneinfoDir = "/usr/share/zoneinfo"
+
+var (
+	// hostZoneinfoDir is the directory of the host's timezone database.
+	hostZoneinfoDir = "/usr/share/zoneinfo"
+
+	// lxcTimezoneRe matches timezone names such as "UTC" and
+	// "America/Argentina/Buenos_Aires", which can't escape the zoneinfo dir.
+	lxcTimezoneRe = regexp.MustCompile(`^[A-Za-z0-9_+-]+(/[A-Za-z0-9_+-]+)*$`)
+)
+
+// setContainerTime configures the timezone and time namespace offsets of the
+// container before it starts.
+func (d *LxcDriver) setContainerTime(c lxcContainerAPI, config *LxcDriverConfig) error {
+	if tz := config.Timezone; tz != "" {
+		// TZ is set with the container's environment, but services started
+		// by an init system don't inherit it, so point the container's
+		// /etc/localtime at the zone as well where possible
+		if rootfs, err := lxcRootfsDir(c); err != nil {
+			d.logger.Printf("[WARN] driver.lxc: only setting TZ for container %q: %v", c.Name(), err)
+		} else if err := setRootfsTimezone(rootfs, tz); err != nil {
+			return fmt.Errorf("unable to set timezone: %v", err)
+		}
+	}
+
+	if len(config.TimeOffset) != 0 {
+		offsets := []struct{ key, offset string }{
+			{"lxc.time.offset.monotonic", config.TimeOffset[0].Monotonic},
+			{"lxc.time.offset.boot", config.TimeOffset[0].Boottime},
+		}
+		for _, o := range offsets {
+			if o.offset == "" {
+				continue
+			}
+			// the format was checked in Validate()
+			dur, _ := time.ParseDuration(o.offset)
+			if err := c.SetConfigItem(o.key, lxcTimeOffset(dur)); err != nil {
+				return fmt.Errorf("error setting %s, which requires LXC 4.0.4 and Linux 5.6 or later: %v", o.key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// lxcTimeOffset formats a clock offset for LXC's time namespace keys.
+func lxcTimeOffset(d time.Duration) string {
+	return fmt.Sprintf("%dns", d.Nanoseconds())
+}
+
+// setRootfsTimezone points /etc/localtime of the root filesystem at the
+// timezone, and updates /etc/timezone on distributions that have one. Root
+// filesystems without the timezone database get a copy of the host's zone
+// file.
+func setRootfsTimezone(rootfs, tz string) error {
+	etc, err := rootfsPath(rootfs, "/etc")
+	if err != nil {
+		return err
+	}
+
+	// The zone is only checked for with Lstat since it may itself be a link
+	// that resolves within the container
+	zone := filepath.Join(zoneinfoDir, tz)
+	fi, err := os.Lstat(filepath.Join(rootfs, zone))
+	hasZone := err == nil && !fi.IsDir()
+	hostZone := filepath.Join(hostZoneinfoDir, tz)
+	if !hasZone {
+		if _, err := os.Stat(hostZone); err != nil {
+			return fmt.Errorf("unknown timezone %q: %v", tz, err)
+		}
+	}
+
+	localtime := filepath.Join(etc, "localtime")
+	if err := os.Remove(localtime); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if hasZone {
+		if err := os.Symlink(zone, localtime); err != nil {
+			return err
+		}
+	} else if err := copyRegularFile(hostZone, localtime, 0644); err != nil {
+		return err
+	}
+
+	timezone := filepath.Join(etc, "timezone")
+	if fi, err := os.Lstat(timezone); err == nil && fi.Mode().IsRegular() {
+		return ioutil.WriteFile(timezone, []byte(tz+"\n"), 0644)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/nomad/client/driver"
+	cstructs "github.com/hashicorp/nomad/client/structs"
+)
+
+// LxcContainers returns the containers created by the LXC driver on this
+// node. Containers whose allocation is not known to the client are marked as
+// orphaned.
+func (c *Client) LxcContainers() ([]*cstructs.LxcContainer, error) {
+	containers, err := driver.LxcContainers(c.config)
+	if err != nil {
+		return nil, err
+	}
+
+	allocs := c.getAllocRunners()
+	for _, container := range containers {
+		_, ok := allocs[container.AllocID]
+		container.Orphaned = !ok
+	}
+	return containers, nil
+}
+
+// LxcContainer returns the named container created by the LXC driver.
+func (c *Client) LxcContainer(name string) (*cstructs.LxcContainer, error) {
+	container, err := driver.LxcContainer(c.config, name)
+	if err != nil {
+		return nil, err
+	}
+
+	_, ok := c.getAllocRunners()[container.AllocID]
+	container.Orphaned = !ok
+	return container, nil
+}
+
+// LxcTaskContainer returns the container created by the LXC driver for the
+// task of the given allocation.
+func (c *Client) LxcTaskContainer(allocID, task string) (*cstructs.LxcContainer, error) {
+	alloc, err := c.GetClientAlloc(allocID)
+	if err != nil {
+		return nil, err
+	}
+
+	tg := alloc.Job.LookupTaskGroup(alloc.TaskGroup)
+	if tg == nil {
+		return nil, fmt.Errorf("unknown task group %q", alloc.TaskGroup)
+	}
+	t := tg.LookupTask(task)
+	if t == nil {
+		return nil, fmt.Errorf("unknown task name %q", task)
+	}
+	if t.Driver != "lxc" {
+		return nil, fmt.Errorf("task %q does not use the lxc driver", task)
+	}
+
+	return driver.LxcTaskContainer(c.config, allocID, task)
+}
+
+// PruneLxcContainers destroys the orphaned containers created by the LXC
+// driver and returns the ones that were removed. Containers preserved after
+// their task failed are kept until their preservation runs out, even once
+// their allocation is garbage collected.
+func (c *Client) PruneLxcContainers() ([]*cstructs.LxcContainer, error) {
+	containers, err := c.LxcContainers()
+	if err != nil {
+		return nil, err
+	}
+	return driver.PruneLxcContainers(c.config, containers, c.logger)
+}
+
+// pruneLxcContainers prunes the orphaned containers created by the LXC
+// driver periodically if the driver is configured to, such as the ones left
+// behind when the client crashed while creating or tearing down tasks. The
+// first pass runs once the client's state is restored.
+func (c *Client) pruneLxcContainers() {
+	interval := driver.LxcPruneInterval(c.config)
+	if interval <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if _, err := c.PruneLxcContainers(); err != nil {
+				c.logger.Printf("[WARN] client: failed to prune orphaned lxc containers: %v", err)
+			}
+			timer.Reset(interval)
+		case <-c.shutdownCh:
+			return
+		}
+	}
+}
+
+// DestroyLxcContainers stops and destroys all the containers created by the
+// LXC driver, destroying parallelism containers at once, to decommission the
+// node. The driver must be in maintenance.
+func (c *Client) DestroyLxcContainers(parallelism int) (*cstructs.LxcDestroyReport, error) {
+	c.logger.Printf("[INFO] client: destroying all lxc containers")
+	return driver.DestroyLxcContainers(c.config, parallelism, c.logger)
+}
+
+// BackupLxcContainer backs up the named container created by the LXC driver
+// to the configured backup destination.
+func (c *Client) BackupLxcContainer(name string, checkpoint bool) (*cstructs.LxcBackup, error) {
+	c.logger.Printf("[INFO] client: backing up lxc container %q", name)
+	return driver.BackupLxcContainer(c.config, name, checkpoint)
+}
+
+// LxcMaintenance returns whether the LXC driver is in maintenance.
+func (c *Client) LxcMaintenance() (*cstructs.LxcMaintenance, error) {
+	return driver.LxcMaintenance(c.config)
+}
+
+// SetLxcMaintenance puts the LXC driver into maintenance, where it starts no
+// new containers, or takes it out of it. The driver is fingerprinted again
+// so that the node is updated without waiting for the periodic fingerprint.
+func (c *Client) SetLxcMaintenance(paused bool, reason string) (*cstructs.LxcMaintenance, error) {
+	m, err := driver.SetLxcMaintenance(c.config, paused, reason)
+	if err != nil {
+		return nil, err
+	}
+	if paused {
+		c.logger.Printf("[INFO] client: pausing lxc driver for maintenance: %q", reason)
+	} else {
+		c.logger.Printf("[INFO] client: resuming lxc driver after maintenance")
+	}
+
+	c.fingerprintLxc()
+	return m, nil
+}
+
+// reloadLxcOptions applies the options of the LXC driver that can change
+// without restarting the client. The driver is fingerprinted again if any
+// changed, as some of its attributes derive from them.
+func (c *Client) reloadLxcOptions(options map[string]string) {
+	if changed := driver.ReloadLxcOptions(c.config, options, c.logger); len(changed) != 0 {
+		c.fingerprintLxc()
+	}
+}
+
+// fingerprintLxc fingerprints the LXC driver if it was detected, updating
+// the node without waiting for the periodic fingerprint.
+func (c *Client) fingerprintLxc() {
+	driverCtx := driver.NewDriverContext("", "", c.config, c.config.Node, c.logger, nil)
+	d, err := driver.NewDriver("lxc", driverCtx)
+	if err != nil {
+		return
+	}
+	c.configLock.Lock()
+	defer c.configLock.Unlock()
+	if _, ok := c.config.Node.Attributes["driver.lxc.version"]; ok {
+		if _, err := d.Fingerprint(c.config, c.config.Node); err != nil {
+			c.logger.Printf("[WARN] client: fingerprinting lxc driver failed: %v", err)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/md5"
 	"io"
 	"strconv"
+	"time"
 )
 
 // MemoryStats holds memory usage related stats
@@ -52,15 +53,52 @@ func (cs *CpuStats) Add(other *CpuStats) {
 	cs.Measured = joinStringSet(cs.Measured, other.Measured)
 }
 
-// ResourceUsage holds information related to cpu and memory stats
+// FilesystemStats holds the usage of a filesystem
+type FilesystemStats struct {
+	Used      uint64
+	Available uint64
+}
+
+// DiskStats holds filesystem usage related stats
+type DiskStats struct {
+	// Used and Available are the bytes used and available on the distinct
+	// filesystems of the task
+	Used      uint64
+	Available uint64
+
+	// Filesystems holds the usage of the filesystems by their mount point in
+	// the task. They are not aggregated across tasks.
+	Filesystems map[string]*FilesystemStats
+
+	// A list of fields whose values were actually sampled
+	Measured []string
+}
+
+func (ds *DiskStats) Add(other *DiskStats) {
+	ds.Used += other.Used
+	ds.Available += other.Available
+	ds.Measured = joinStringSet(ds.Measured, other.Measured)
+}
+
+// ResourceUsage holds information related to cpu, memory and disk stats
 type ResourceUsage struct {
 	MemoryStats *MemoryStats
 	CpuStats    *CpuStats
+
+	// DiskStats is only set by drivers reporting the usage of the task's
+	// filesystems
+	DiskStats *DiskStats
 }
 
 func (ru *ResourceUsage) Add(other *ResourceUsage) {
 	ru.MemoryStats.Add(other.MemoryStats)
 	ru.CpuStats.Add(other.CpuStats)
+	if other.DiskStats != nil {
+		if ru.DiskStats == nil {
+			ru.DiskStats = &DiskStats{}
+		}
+		ru.DiskStats.Add(other.DiskStats)
+	}
 }
 
 // TaskResourceUsage holds aggregated resource usage of all processes in a Task
@@ -184,3 +222,82 @@ func (d *DriverNetwork) Hash() []byte {
 	}
 	return h.Sum(nil)
 }
+
+// LxcContainer describes a container created by the LXC driver.
+type LxcContainer struct {
+	// Name is the container name, derived from the task and allocation
+	Name string
+
+	// AllocID and Task are the allocation and task the container was
+	// created for
+	AllocID string
+	Task    string
+
+	// State is the LXC state of the container: STOPPED, STARTING, RUNNING,
+	// STOPPING, ABORTING, FREEZING, FROZEN or THAWED
+	State string
+
+	// InitPid is the host PID of the container's init process and Uptime
+	// how long it has been running. Both are zero unless running.
+	InitPid int
+	Uptime  time.Duration
+
+	// LxcPath and RootFS are where the container and its root filesystem
+	// are stored
+	LxcPath string
+	RootFS  string
+
+	// Orphaned is set if the container's allocation is not known to the
+	// client
+	Orphaned bool
+
+	// PreservedUntil is until when the container is preserved after its
+	// task failed, or the zero time if it isn't. Preserved containers are
+	// not pruned.
+	PreservedUntil time.Time
+}
+
+// LxcBackup describes a backup of a container created by the LXC driver.
+type LxcBackup struct {
+	// Container is the name of the backed up container
+	Container string
+
+	// Location is where the backup archive was uploaded to
+	Location string
+
+	// Size is the size of the archive in bytes
+	Size int64
+
+	// Checkpoint is set if the archive holds the CRIU state of the
+	// container's processes
+	Checkpoint bool
+
+	// Duration is how long the backup took
+	Duration time.Duration
+}
+
+// LxcDestroyReport describes the destruction of all the containers created
+// by the LXC driver on a client.
+type LxcDestroyReport struct {
+	// Destroyed are the names of the destroyed containers
+	Destroyed []string
+
+	// Failed are the errors destroying containers, by container name
+	Failed map[string]string
+
+	// Duration is how long destroying the containers took
+	Duration time.Duration
+}
+
+// LxcMaintenance describes whether the LXC driver of a client is in
+// maintenance, refusing to start new containers.
+type LxcMaintenance struct {
+	// Paused is set while the driver is in maintenance
+	Paused bool
+
+	// Reason is the operator's reason for the maintenance, if given
+	Reason string
+
+	// Since is when the driver was paused
+	Since time.Time
+}
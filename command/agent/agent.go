@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -18,6 +19,7 @@ import (
 	version "github.com/hashicorp/go-version"
 	"github.com/hashicorp/nomad/client"
 	clientconfig "github.com/hashicorp/nomad/client/config"
+	"github.com/hashicorp/nomad/client/driver"
 	"github.com/hashicorp/nomad/command/agent/consul"
 	"github.com/hashicorp/nomad/nomad"
 	"github.com/hashicorp/nomad/nomad/structs"
@@ -767,11 +769,61 @@ func (a *Agent) Stats() map[string]map[string]string {
 func (a *Agent) ShouldReload(newConfig *Config) (bool, bool) {
 	a.configLock.Lock()
 	defer a.configLock.Unlock()
-	if a.config.TLSConfig.Equals(newConfig.TLSConfig) {
-		return false, false
+	if !a.config.TLSConfig.Equals(newConfig.TLSConfig) {
+		return true, true // requires a reload of both agent and http server
+	}
+
+	// The client applies the options of the LXC driver that can change at
+	// runtime, others need a restart
+	if a.config.Client != nil && newConfig.Client != nil {
+		_, reloaded, restart := reloadedClientOptions(a.config.Client.Options, newConfig.Client.Options, driver.IsLxcReloadableOption)
+		for _, k := range restart {
+			a.logger.Printf("[WARN] agent: client option %q changed, restart the agent to apply it", k)
+		}
+		if len(reloaded) != 0 {
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// reloadedClientOptions returns the client options with the changes of the
+// new options that are reloadable applied, and the sorted options whose
+// changes were applied and those whose changes need a restart.
+func reloadedClientOptions(current, options map[string]string, reloadable func(string) bool) (map[string]string, []string, []string) {
+	merged := make(map[string]string, len(current))
+	for k, v := range current {
+		merged[k] = v
 	}
 
-	return true, true // requires a reload of both agent and http server
+	keys := make(map[string]bool)
+	for k := range current {
+		keys[k] = true
+	}
+	for k := range options {
+		keys[k] = true
+	}
+	var reloaded, restart []string
+	for k := range keys {
+		old, hadOld := current[k]
+		value, hasValue := options[k]
+		if old == value && hadOld == hasValue {
+			continue
+		}
+		if !reloadable(k) {
+			restart = append(restart, k)
+			continue
+		}
+		reloaded = append(reloaded, k)
+		if hasValue {
+			merged[k] = value
+		} else {
+			delete(merged, k)
+		}
+	}
+	sort.Strings(reloaded)
+	sort.Strings(restart)
+	return merged, reloaded, restart
 }
 
 // Reload handles configuration changes for the agent. Provides a method that
@@ -784,6 +836,15 @@ func (a *Agent) Reload(newConfig *Config) error {
 		return fmt.Errorf("cannot reload agent with nil configuration")
 	}
 
+	// The client options that can change at runtime are passed on to the
+	// client, which applies them
+	if a.config.Client != nil && newConfig.Client != nil {
+		a.config.Client.Options, _, _ = reloadedClientOptions(a.config.Client.Options, newConfig.Client.Options, driver.IsLxcReloadableOption)
+	}
+	if a.config.TLSConfig.Equals(newConfig.TLSConfig) {
+		return nil
+	}
+
 	// This is just a TLS configuration reload, we don't need to refresh
 	// existing network connections
 	if !a.config.TLSConfig.IsEmpty() && !newConfig.TLSConfig.IsEmpty() {
@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/hashicorp/nomad/client/driver"
 	"github.com/hashicorp/nomad/helper"
 	sconfig "github.com/hashicorp/nomad/nomad/structs/config"
 	"github.com/stretchr/testify/assert"
@@ -940,3 +941,86 @@ func TestServer_ShouldReload_ReturnTrueForConfigChanges(t *testing.T) {
 	assert.True(shouldReloadAgent)
 	assert.True(shouldReloadHTTPServer)
 }
+
+func TestServer_ShouldReload_ReturnTrueForClientOptionChanges(t *testing.T) {
+	t.Parallel()
+	if !driver.IsLxcReloadableOption("driver.lxc.start_parallelism") {
+		t.Skip("lxc driver not built in")
+	}
+	assert := assert.New(t)
+
+	agent := &Agent{
+		logger: log.New(ioutil.Discard, "", 0),
+		config: &Config{
+			TLSConfig: &sconfig.TLSConfig{},
+			Client:    &ClientConfig{Options: map[string]string{"driver.lxc.start_parallelism": "4"}},
+		},
+	}
+	newConfig := &Config{
+		TLSConfig: &sconfig.TLSConfig{},
+		Client:    &ClientConfig{Options: map[string]string{"driver.lxc.start_parallelism": "8"}},
+	}
+
+	// Only the agent is reloaded, passing the options on to the client
+	shouldReloadAgent, shouldReloadHTTPServer := agent.ShouldReload(newConfig)
+	assert.True(shouldReloadAgent)
+	assert.False(shouldReloadHTTPServer)
+
+	assert.Nil(agent.Reload(newConfig))
+	assert.Equal("8", agent.GetConfig().Client.Options["driver.lxc.start_parallelism"])
+	assert.True(agent.GetConfig().TLSConfig.IsEmpty())
+}
+
+func TestServer_ShouldReload_ReturnFalseForRestartClientOptionChanges(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	agent := &Agent{
+		logger: log.New(ioutil.Discard, "", 0),
+		config: &Config{
+			TLSConfig: &sconfig.TLSConfig{},
+			Client:    &ClientConfig{Options: map[string]string{"driver.raw_exec.enable": "0"}},
+		},
+	}
+	newConfig := &Config{
+		TLSConfig: &sconfig.TLSConfig{},
+		Client:    &ClientConfig{Options: map[string]string{"driver.raw_exec.enable": "1"}},
+	}
+
+	// Options the client doesn't reload need a restart
+	shouldReloadAgent, shouldReloadHTTPServer := agent.ShouldReload(newConfig)
+	assert.False(shouldReloadAgent)
+	assert.False(shouldReloadHTTPServer)
+}
+
+func TestAgent_ReloadedClientOptions(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+
+	reloadable := func(k string) bool {
+		return strings.HasPrefix(k, "driver.lxc.")
+	}
+	current := map[string]string{
+		"driver.lxc.start_parallelism": "4",
+		"driver.lxc.create_timeout":    "10m",
+		"driver.raw_exec.enable":       "0",
+	}
+	options := map[string]string{
+		"driver.lxc.start_parallelism": "8",
+		"driver.lxc.log_shipper":       "unix:/run/ship.sock",
+		"driver.raw_exec.enable":       "1",
+		"driver.whitelist":             "lxc",
+	}
+
+	// Only the reloadable changes are applied, others keep their running
+	// values
+	merged, reloaded, restart := reloadedClientOptions(current, options, reloadable)
+	assert.Equal(map[string]string{
+		"driver.lxc.start_parallelism": "8",
+		"driver.lxc.log_shipper":       "unix:/run/ship.sock",
+		"driver.raw_exec.enable":       "0",
+	}, merged)
+	assert.Equal([]string{"driver.lxc.create_timeout", "driver.lxc.log_shipper", "driver.lxc.start_parallelism"}, reloaded)
+	assert.Equal([]string{"driver.raw_exec.enable", "driver.whitelist"}, restart)
+	assert.Equal("4", current["driver.lxc.start_parallelism"])
+}
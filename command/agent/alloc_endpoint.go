@@ -99,6 +99,8 @@ func (s *HTTPServer) ClientAllocRequest(resp http.ResponseWriter, req *http.Requ
 		return s.allocSnapshot(allocID, resp, req)
 	case "gc":
 		return s.allocGC(allocID, resp, req)
+	case "lxc":
+		return s.allocLxc(allocID, resp, req)
 	}
 
 	return nil, CodedError(404, resourceNotFoundErr)
@@ -161,6 +163,32 @@ func (s *HTTPServer) allocSnapshot(allocID string, resp http.ResponseWriter, req
 	return nil, nil
 }
 
+func (s *HTTPServer) allocLxc(allocID string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	if req.Method != "GET" {
+		return nil, CodedError(405, ErrInvalidMethod)
+	}
+
+	task := req.URL.Query().Get("task")
+	if task == "" {
+		return nil, CodedError(400, "must provide a task name")
+	}
+
+	var secret string
+	s.parseToken(req, &secret)
+
+	var namespace string
+	parseNamespace(req, &namespace)
+
+	// Check namespace read-job permissions
+	if aclObj, err := s.agent.Client().ResolveToken(secret); err != nil {
+		return nil, err
+	} else if aclObj != nil && !aclObj.AllowNsOp(namespace, acl.NamespaceCapabilityReadJob) {
+		return nil, structs.ErrPermissionDenied
+	}
+
+	return s.agent.Client().LxcTaskContainer(allocID, task)
+}
+
 func (s *HTTPServer) allocStats(allocID string, resp http.ResponseWriter, req *http.Request) (interface{}, error) {
 	var secret string
 	s.parseToken(req, &secret)
@@ -491,6 +491,26 @@ func TestHTTP_AllocSnapshot_Atomic(t *testing.T) {
 	})
 }
 
+func TestHTTP_AllocLxc(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	httpTest(t, nil, func(s *TestAgent) {
+		// A task name is required
+		req, err := http.NewRequest("GET", "/v1/client/allocation/123/lxc", nil)
+		assert.Nil(err)
+		_, err = s.Server.ClientAllocRequest(httptest.NewRecorder(), req)
+		assert.NotNil(err)
+		assert.Contains(err.Error(), "must provide a task name")
+
+		// Unknown allocations are an error
+		req, err = http.NewRequest("GET", "/v1/client/allocation/123/lxc?task=web", nil)
+		assert.Nil(err)
+		_, err = s.Server.ClientAllocRequest(httptest.NewRecorder(), req)
+		assert.NotNil(err)
+		assert.Contains(err.Error(), "unknown allocation ID")
+	})
+}
+
 func TestHTTP_AllocGC(t *testing.T) {
 	t.Parallel()
 	httpTest(t, nil, func(s *TestAgent) {
@@ -638,6 +638,9 @@ func (c *Command) handleReload() {
 
 	shouldReloadAgent, shouldReloadHTTPServer := c.agent.ShouldReload(newConf)
 	if shouldReloadAgent {
+		// Only TLS changes concern the server, client options don't
+		tlsChanged := !c.agent.GetConfig().TLSConfig.Equals(newConf.TLSConfig)
+
 		c.agent.logger.Printf("[DEBUG] agent: starting reload of agent config")
 		err := c.agent.Reload(newConf)
 		if err != nil {
@@ -645,7 +648,7 @@ func (c *Command) handleReload() {
 			return
 		}
 
-		if s := c.agent.Server(); s != nil {
+		if s := c.agent.Server(); s != nil && tlsChanged {
 			sconf, err := convertServerConfig(newConf, c.logOutput)
 			c.agent.logger.Printf("[DEBUG] agent: starting reload of server config")
 			if err != nil {
@@ -169,6 +169,11 @@ func (s *HTTPServer) registerHandlers(enableDebug bool) {
 	s.mux.HandleFunc("/v1/client/gc", s.wrap(s.ClientGCRequest))
 	s.mux.Handle("/v1/client/stats", wrapCORS(s.wrap(s.ClientStatsRequest)))
 	s.mux.Handle("/v1/client/allocation/", wrapCORS(s.wrap(s.ClientAllocRequest)))
+	s.mux.HandleFunc("/v1/client/lxc/containers", s.wrap(s.ClientLxcContainersRequest))
+	s.mux.HandleFunc("/v1/client/lxc/container/", s.wrap(s.ClientLxcContainerRequest))
+	s.mux.HandleFunc("/v1/client/lxc/prune", s.wrap(s.ClientLxcPruneRequest))
+	s.mux.HandleFunc("/v1/client/lxc/destroy", s.wrap(s.ClientLxcDestroyRequest))
+	s.mux.HandleFunc("/v1/client/lxc/maintenance", s.wrap(s.ClientLxcMaintenanceRequest))
 
 	s.mux.HandleFunc("/v1/agent/self", s.wrap(s.AgentSelfRequest))
 	s.mux.HandleFunc("/v1/agent/join", s.wrap(s.AgentJoinRequest))
@@ -0,0 +1,190 @@
+package agent
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+)
+
+func (s *HTTPServer) ClientLxcContainersRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	if s.agent.client == nil {
+		return nil, clientNotRunning
+	}
+	if req.Method != "GET" {
+		return nil, CodedError(405, ErrInvalidMethod)
+	}
+
+	var secret string
+	s.parseToken(req, &secret)
+
+	// Check node read permissions
+	if aclObj, err := s.agent.Client().ResolveToken(secret); err != nil {
+		return nil, err
+	} else if aclObj != nil && !aclObj.AllowNodeRead() {
+		return nil, structs.ErrPermissionDenied
+	}
+
+	return s.agent.Client().LxcContainers()
+}
+
+func (s *HTTPServer) ClientLxcContainerRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	if s.agent.client == nil {
+		return nil, clientNotRunning
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, "/v1/client/lxc/container/")
+	if name := strings.TrimSuffix(path, "/backup"); name != path {
+		return s.clientLxcBackup(resp, req, name)
+	}
+
+	if req.Method != "GET" {
+		return nil, CodedError(405, ErrInvalidMethod)
+	}
+
+	name := path
+	if name == "" || strings.Contains(name, "/") {
+		return nil, CodedError(404, resourceNotFoundErr)
+	}
+
+	var secret string
+	s.parseToken(req, &secret)
+
+	// Check node read permissions
+	if aclObj, err := s.agent.Client().ResolveToken(secret); err != nil {
+		return nil, err
+	} else if aclObj != nil && !aclObj.AllowNodeRead() {
+		return nil, structs.ErrPermissionDenied
+	}
+
+	return s.agent.Client().LxcContainer(name)
+}
+
+func (s *HTTPServer) ClientLxcPruneRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	if s.agent.client == nil {
+		return nil, clientNotRunning
+	}
+	if req.Method != "PUT" && req.Method != "POST" {
+		return nil, CodedError(405, ErrInvalidMethod)
+	}
+
+	var secret string
+	s.parseToken(req, &secret)
+
+	// Check node write permissions
+	if aclObj, err := s.agent.Client().ResolveToken(secret); err != nil {
+		return nil, err
+	} else if aclObj != nil && !aclObj.AllowNodeWrite() {
+		return nil, structs.ErrPermissionDenied
+	}
+
+	return s.agent.Client().PruneLxcContainers()
+}
+
+func (s *HTTPServer) ClientLxcDestroyRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	if s.agent.client == nil {
+		return nil, clientNotRunning
+	}
+	if req.Method != "PUT" && req.Method != "POST" {
+		return nil, CodedError(405, ErrInvalidMethod)
+	}
+
+	var parallelism int
+	if raw := req.URL.Query().Get("parallelism"); raw != "" {
+		var err error
+		if parallelism, err = strconv.Atoi(raw); err != nil || parallelism <= 0 {
+			return nil, CodedError(400, fmt.Sprintf("invalid parallelism value %q", raw))
+		}
+	}
+
+	var secret string
+	s.parseToken(req, &secret)
+
+	// Check node write permissions
+	if aclObj, err := s.agent.Client().ResolveToken(secret); err != nil {
+		return nil, err
+	} else if aclObj != nil && !aclObj.AllowNodeWrite() {
+		return nil, structs.ErrPermissionDenied
+	}
+
+	return s.agent.Client().DestroyLxcContainers(parallelism)
+}
+
+func (s *HTTPServer) clientLxcBackup(resp http.ResponseWriter, req *http.Request, name string) (interface{}, error) {
+	if req.Method != "PUT" && req.Method != "POST" {
+		return nil, CodedError(405, ErrInvalidMethod)
+	}
+	if name == "" || strings.Contains(name, "/") {
+		return nil, CodedError(404, resourceNotFoundErr)
+	}
+
+	var checkpoint bool
+	if raw := req.URL.Query().Get("checkpoint"); raw != "" {
+		var err error
+		if checkpoint, err = strconv.ParseBool(raw); err != nil {
+			return nil, CodedError(400, fmt.Sprintf("invalid checkpoint value %q", raw))
+		}
+	}
+
+	var secret string
+	s.parseToken(req, &secret)
+
+	// Check node write permissions
+	if aclObj, err := s.agent.Client().ResolveToken(secret); err != nil {
+		return nil, err
+	} else if aclObj != nil && !aclObj.AllowNodeWrite() {
+		return nil, structs.ErrPermissionDenied
+	}
+
+	return s.agent.Client().BackupLxcContainer(name, checkpoint)
+}
+
+func (s *HTTPServer) ClientLxcMaintenanceRequest(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	if s.agent.client == nil {
+		return nil, clientNotRunning
+	}
+
+	switch req.Method {
+	case "GET":
+		var secret string
+		s.parseToken(req, &secret)
+
+		// Check node read permissions
+		if aclObj, err := s.agent.Client().ResolveToken(secret); err != nil {
+			return nil, err
+		} else if aclObj != nil && !aclObj.AllowNodeRead() {
+			return nil, structs.ErrPermissionDenied
+		}
+
+		return s.agent.Client().LxcMaintenance()
+	case "PUT", "POST":
+		return s.clientLxcSetMaintenance(resp, req)
+	default:
+		return nil, CodedError(405, ErrInvalidMethod)
+	}
+}
+
+func (s *HTTPServer) clientLxcSetMaintenance(resp http.ResponseWriter, req *http.Request) (interface{}, error) {
+	raw := req.URL.Query().Get("enable")
+	if raw == "" {
+		return nil, CodedError(400, "missing enable value")
+	}
+	enable, err := strconv.ParseBool(raw)
+	if err != nil {
+		return nil, CodedError(400, fmt.Sprintf("invalid enable value %q", raw))
+	}
+
+	var secret string
+	s.parseToken(req, &secret)
+
+	// Check node write permissions
+	if aclObj, err := s.agent.Client().ResolveToken(secret); err != nil {
+		return nil, err
+	} else if aclObj != nil && !aclObj.AllowNodeWrite() {
+		return nil, structs.ErrPermissionDenied
+	}
+
+	return s.agent.Client().SetLxcMaintenance(enable, req.URL.Query().Get("reason"))
+}
@@ -0,0 +1,169 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/nomad/acl"
+	"github.com/hashicorp/nomad/nomad/mock"
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientLxcRequests_Methods(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	httpTest(t, nil, func(s *TestAgent) {
+		cases := []struct {
+			method  string
+			url     string
+			handler func(http.ResponseWriter, *http.Request) (interface{}, error)
+		}{
+			{"PUT", "/v1/client/lxc/containers", s.Server.ClientLxcContainersRequest},
+			{"PUT", "/v1/client/lxc/container/foo", s.Server.ClientLxcContainerRequest},
+			{"GET", "/v1/client/lxc/prune", s.Server.ClientLxcPruneRequest},
+			{"GET", "/v1/client/lxc/container/foo/backup", s.Server.ClientLxcContainerRequest},
+			{"DELETE", "/v1/client/lxc/maintenance", s.Server.ClientLxcMaintenanceRequest},
+			{"GET", "/v1/client/lxc/destroy", s.Server.ClientLxcDestroyRequest},
+		}
+		for _, c := range cases {
+			req, err := http.NewRequest(c.method, c.url, nil)
+			assert.Nil(err)
+			_, err = c.handler(httptest.NewRecorder(), req)
+			assert.NotNil(err, c.url)
+			assert.Equal(ErrInvalidMethod, err.Error(), c.url)
+		}
+	})
+}
+
+func TestClientLxcContainerRequest_NotFound(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	httpTest(t, nil, func(s *TestAgent) {
+		for _, url := range []string{"/v1/client/lxc/container/", "/v1/client/lxc/container/foo/bar"} {
+			req, err := http.NewRequest("GET", url, nil)
+			assert.Nil(err)
+			_, err = s.Server.ClientLxcContainerRequest(httptest.NewRecorder(), req)
+			assert.NotNil(err, url)
+			assert.Equal(resourceNotFoundErr, err.Error(), url)
+		}
+	})
+}
+
+func TestClientLxcBackupRequest_InvalidCheckpoint(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	httpTest(t, nil, func(s *TestAgent) {
+		req, err := http.NewRequest("PUT", "/v1/client/lxc/container/foo/backup?checkpoint=maybe", nil)
+		assert.Nil(err)
+		_, err = s.Server.ClientLxcContainerRequest(httptest.NewRecorder(), req)
+		assert.NotNil(err)
+		assert.Contains(err.Error(), "invalid checkpoint value")
+	})
+}
+
+func TestClientLxcMaintenanceRequest_InvalidEnable(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	httpTest(t, nil, func(s *TestAgent) {
+		cases := map[string]string{
+			"/v1/client/lxc/maintenance":              "missing enable value",
+			"/v1/client/lxc/maintenance?enable=maybe": "invalid enable value",
+		}
+		for url, expected := range cases {
+			req, err := http.NewRequest("PUT", url, nil)
+			assert.Nil(err)
+			_, err = s.Server.ClientLxcMaintenanceRequest(httptest.NewRecorder(), req)
+			assert.NotNil(err, url)
+			assert.Contains(err.Error(), expected, url)
+		}
+	})
+}
+
+func TestClientLxcDestroyRequest_InvalidParallelism(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	httpTest(t, nil, func(s *TestAgent) {
+		for _, url := range []string{"/v1/client/lxc/destroy?parallelism=0", "/v1/client/lxc/destroy?parallelism=many"} {
+			req, err := http.NewRequest("PUT", url, nil)
+			assert.Nil(err)
+			_, err = s.Server.ClientLxcDestroyRequest(httptest.NewRecorder(), req)
+			assert.NotNil(err, url)
+			assert.Contains(err.Error(), "invalid parallelism value", url)
+		}
+	})
+}
+
+func TestClientLxcRequests_ACL(t *testing.T) {
+	t.Parallel()
+	assert := assert.New(t)
+	httpACLTest(t, nil, func(s *TestAgent) {
+		state := s.Agent.server.State()
+
+		// Listing requires node read
+		{
+			req, err := http.NewRequest("GET", "/v1/client/lxc/containers", nil)
+			assert.Nil(err)
+			_, err = s.Server.ClientLxcContainersRequest(httptest.NewRecorder(), req)
+			assert.NotNil(err)
+			assert.Equal(structs.ErrPermissionDenied.Error(), err.Error())
+
+			token := mock.CreatePolicyAndToken(t, state, 1005, "deny", mock.NodePolicy(acl.PolicyDeny))
+			setToken(req, token)
+			_, err = s.Server.ClientLxcContainersRequest(httptest.NewRecorder(), req)
+			assert.NotNil(err)
+			assert.Equal(structs.ErrPermissionDenied.Error(), err.Error())
+		}
+
+		// Pruning requires node write
+		{
+			req, err := http.NewRequest("PUT", "/v1/client/lxc/prune", nil)
+			assert.Nil(err)
+			token := mock.CreatePolicyAndToken(t, state, 1007, "read", mock.NodePolicy(acl.PolicyRead))
+			setToken(req, token)
+			_, err = s.Server.ClientLxcPruneRequest(httptest.NewRecorder(), req)
+			assert.NotNil(err)
+			assert.Equal(structs.ErrPermissionDenied.Error(), err.Error())
+		}
+
+		// Backups require node write
+		{
+			req, err := http.NewRequest("PUT", "/v1/client/lxc/container/foo/backup", nil)
+			assert.Nil(err)
+			token := mock.CreatePolicyAndToken(t, state, 1009, "read-backup", mock.NodePolicy(acl.PolicyRead))
+			setToken(req, token)
+			_, err = s.Server.ClientLxcContainerRequest(httptest.NewRecorder(), req)
+			assert.NotNil(err)
+			assert.Equal(structs.ErrPermissionDenied.Error(), err.Error())
+		}
+
+		// Maintenance requires node read to read and node write to toggle
+		{
+			req, err := http.NewRequest("GET", "/v1/client/lxc/maintenance", nil)
+			assert.Nil(err)
+			_, err = s.Server.ClientLxcMaintenanceRequest(httptest.NewRecorder(), req)
+			assert.NotNil(err)
+			assert.Equal(structs.ErrPermissionDenied.Error(), err.Error())
+
+			req, err = http.NewRequest("PUT", "/v1/client/lxc/maintenance?enable=true", nil)
+			assert.Nil(err)
+			token := mock.CreatePolicyAndToken(t, state, 1011, "read-maintenance", mock.NodePolicy(acl.PolicyRead))
+			setToken(req, token)
+			_, err = s.Server.ClientLxcMaintenanceRequest(httptest.NewRecorder(), req)
+			assert.NotNil(err)
+			assert.Equal(structs.ErrPermissionDenied.Error(), err.Error())
+		}
+
+		// Destroying all containers requires node write
+		{
+			req, err := http.NewRequest("PUT", "/v1/client/lxc/destroy", nil)
+			assert.Nil(err)
+			token := mock.CreatePolicyAndToken(t, state, 1013, "read-destroy", mock.NodePolicy(acl.PolicyRead))
+			setToken(req, token)
+			_, err = s.Server.ClientLxcDestroyRequest(httptest.NewRecorder(), req)
+			assert.NotNil(err)
+			assert.Equal(structs.ErrPermissionDenied.Error(), err.Error())
+		}
+	})
+}
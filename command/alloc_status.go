@@ -473,6 +473,7 @@ func (c *AllocStatusCommand) outputTaskResources(alloc *api.Allocation, task str
 	// Display the rolled up stats. If possible prefer the live statistics
 	cpuUsage := strconv.Itoa(*resource.CPU)
 	memUsage := humanize.IBytes(uint64(*resource.MemoryMB * bytesPerMegabyte))
+	diskUsage := humanize.IBytes(uint64(*alloc.Resources.DiskMB * bytesPerMegabyte))
 	if stats != nil {
 		if ru, ok := stats.Tasks[task]; ok && ru != nil && ru.ResourceUsage != nil {
 			if cs := ru.ResourceUsage.CpuStats; cs != nil {
@@ -481,12 +482,15 @@ func (c *AllocStatusCommand) outputTaskResources(alloc *api.Allocation, task str
 			if ms := ru.ResourceUsage.MemoryStats; ms != nil {
 				memUsage = fmt.Sprintf("%v/%v", humanize.IBytes(ms.RSS), memUsage)
 			}
+			if ds := ru.ResourceUsage.DiskStats; ds != nil {
+				diskUsage = fmt.Sprintf("%v/%v", humanize.IBytes(ds.Used), diskUsage)
+			}
 		}
 	}
 	resourcesOutput = append(resourcesOutput, fmt.Sprintf("%v MHz|%v|%v|%v|%v",
 		cpuUsage,
 		memUsage,
-		humanize.IBytes(uint64(*alloc.Resources.DiskMB*bytesPerMegabyte)),
+		diskUsage,
 		*resource.IOPS,
 		firstAddr))
 	for i := 1; i < len(addr); i++ {
@@ -507,6 +511,7 @@ func (c *AllocStatusCommand) outputTaskResources(alloc *api.Allocation, task str
 func (c *AllocStatusCommand) outputVerboseResourceUsage(task string, resourceUsage *api.ResourceUsage) {
 	memoryStats := resourceUsage.MemoryStats
 	cpuStats := resourceUsage.CpuStats
+	diskStats := resourceUsage.DiskStats
 	if memoryStats != nil && len(memoryStats.Measured) > 0 {
 		c.Ui.Output("Memory Stats")
 
@@ -568,6 +573,25 @@ func (c *AllocStatusCommand) outputVerboseResourceUsage(task string, resourceUsa
 		out[1] = strings.Join(measuredStats, "|")
 		c.Ui.Output(formatList(out))
 	}
+
+	if diskStats != nil && len(diskStats.Filesystems) > 0 {
+		c.Ui.Output("")
+		c.Ui.Output("Disk Stats")
+
+		mounts := make([]string, 0, len(diskStats.Filesystems))
+		for mount := range diskStats.Filesystems {
+			mounts = append(mounts, mount)
+		}
+		sort.Strings(mounts)
+
+		out := make([]string, 0, len(mounts)+1)
+		out = append(out, "Mount|Used|Available")
+		for _, mount := range mounts {
+			fs := diskStats.Filesystems[mount]
+			out = append(out, fmt.Sprintf("%v|%v|%v", mount, humanize.IBytes(fs.Used), humanize.IBytes(fs.Available)))
+		}
+		c.Ui.Output(formatList(out))
+	}
 }
 
 // shortTaskStatus prints out the current state of each task.
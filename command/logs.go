@@ -33,6 +33,10 @@ Logs Specific Options:
   -stderr
     Display stderr logs.
 
+  -console
+    Display the console output of the task's container. Only available for
+    lxc tasks with console_log enabled.
+
   -verbose
     Show full information.
 
@@ -65,6 +69,7 @@ func (c *LogsCommand) AutocompleteFlags() complete.Flags {
 	return mergeAutocompleteFlags(c.Meta.AutocompleteFlags(FlagSetClient),
 		complete.Flags{
 			"-stderr":  complete.PredictNothing,
+			"-console": complete.PredictNothing,
 			"-verbose": complete.PredictNothing,
 			"-job":     complete.PredictAnything,
 			"-f":       complete.PredictNothing,
@@ -90,7 +95,7 @@ func (l *LogsCommand) AutocompleteArgs() complete.Predictor {
 }
 
 func (l *LogsCommand) Run(args []string) int {
-	var verbose, job, tail, stderr, follow bool
+	var verbose, job, tail, stderr, console, follow bool
 	var numLines, numBytes int64
 
 	flags := l.Meta.FlagSet("logs", FlagSetClient)
@@ -100,6 +105,7 @@ func (l *LogsCommand) Run(args []string) int {
 	flags.BoolVar(&tail, "tail", false, "")
 	flags.BoolVar(&follow, "f", false, "")
 	flags.BoolVar(&stderr, "stderr", false, "")
+	flags.BoolVar(&console, "console", false, "")
 	flags.Int64Var(&numLines, "n", -1, "")
 	flags.Int64Var(&numBytes, "c", -1, "")
 
@@ -108,6 +114,11 @@ func (l *LogsCommand) Run(args []string) int {
 	}
 	args = flags.Args()
 
+	if stderr && console {
+		l.Ui.Error("Only one of -stderr and -console may be set")
+		return 1
+	}
+
 	if numArgs := len(args); numArgs < 1 {
 		if job {
 			l.Ui.Error("Job ID required. See help:\n")
@@ -208,6 +219,8 @@ func (l *LogsCommand) Run(args []string) int {
 	logType := "stdout"
 	if stderr {
 		logType = "stderr"
+	} else if console {
+		logType = "console"
 	}
 
 	// We have a file, output it.
@@ -33,6 +33,15 @@ func TestLogsCommand_Fails(t *testing.T) {
 	}
 	ui.ErrorWriter.Reset()
 
+	// Fails on conflicting log types
+	if code := cmd.Run([]string{"-stderr", "-console", "foobar"}); code != 1 {
+		t.Fatalf("expected exit code 1, got: %d", code)
+	}
+	if out := ui.ErrorWriter.String(); !strings.Contains(out, "Only one of -stderr and -console") {
+		t.Fatalf("expected conflicting flags error, got: %s", out)
+	}
+	ui.ErrorWriter.Reset()
+
 	// Fails on connection failure
 	if code := cmd.Run([]string{"-address=nope", "foobar"}); code != 1 {
 		t.Fatalf("expected exit code 1, got: %d", code)
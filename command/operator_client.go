@@ -0,0 +1,29 @@
+package command
+
+import (
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+type OperatorClientCommand struct {
+	Meta
+}
+
+func (c *OperatorClientCommand) Help() string {
+	helpText := `
+Usage: nomad operator client <subcommand> [options]
+
+  The client operator command is used to inspect and maintain the resources
+  that task drivers create on Nomad clients.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *OperatorClientCommand) Synopsis() string {
+	return "Provides access to client driver resources"
+}
+
+func (c *OperatorClientCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
@@ -0,0 +1,70 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/nomad/api"
+	"github.com/mitchellh/cli"
+)
+
+type OperatorClientLxcCommand struct {
+	Meta
+}
+
+func (c *OperatorClientLxcCommand) Help() string {
+	helpText := `
+Usage: nomad operator client lxc <subcommand> [options]
+
+  The LXC operator command is used to list and inspect the containers created
+  by the LXC driver on a client, to back them up, to prune containers left
+  behind by allocations the client no longer knows about, and to pause new
+  starts of the driver for maintenance.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *OperatorClientLxcCommand) Synopsis() string {
+	return "Provides access to containers created by the LXC driver"
+}
+
+func (c *OperatorClientLxcCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+// lxcNodeID resolves the node to query given an ID or prefix, defaulting to
+// the local node if none is given.
+func lxcNodeID(client *api.Client, nodeID string) (string, error) {
+	if nodeID == "" {
+		return getLocalNodeID(client)
+	}
+
+	nodeID = sanatizeUUIDPrefix(nodeID)
+	nodes, _, err := client.Nodes().PrefixList(nodeID)
+	if err != nil {
+		return "", fmt.Errorf("Error querying node: %s", err)
+	}
+	if len(nodes) == 0 {
+		return "", fmt.Errorf("No node(s) with prefix or id %q found", nodeID)
+	}
+	if len(nodes) > 1 {
+		return "", fmt.Errorf("Prefix %q matched multiple nodes", nodeID)
+	}
+	return nodes[0].ID, nil
+}
+
+// formatLxcContainers formats containers as a table, truncating allocation
+// IDs to the given length.
+func formatLxcContainers(containers []*api.LxcContainer, length int) string {
+	out := make([]string, len(containers)+1)
+	out[0] = "Name|Alloc ID|Task|State|Orphaned"
+	for i, ct := range containers {
+		out[i+1] = fmt.Sprintf("%s|%s|%s|%s|%v",
+			ct.Name,
+			limit(ct.AllocID, length),
+			ct.Task,
+			ct.State,
+			ct.Orphaned)
+	}
+	return formatList(out)
+}
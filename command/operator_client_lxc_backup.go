@@ -0,0 +1,105 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/posener/complete"
+)
+
+type OperatorClientLxcBackupCommand struct {
+	Meta
+}
+
+func (c *OperatorClientLxcBackupCommand) Help() string {
+	helpText := `
+Usage: nomad operator client lxc backup [options] <container>
+
+  Backs up a container created by the LXC driver on a client. The root
+  filesystem and LXC config of the container are packaged into an archive and
+  uploaded to the backup destination configured on the client. Running
+  containers are frozen while they are copied.
+
+General Options:
+
+  ` + generalOptionsUsage() + `
+
+Backup Options:
+
+  -node=<node-id>
+    The ID or prefix of the client the container is on. Defaults to the client
+    of the agent being queried.
+
+  -checkpoint
+    Include a CRIU checkpoint of the container's processes in the backup. The
+    container must be running, and CRIU must be installed on the client.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *OperatorClientLxcBackupCommand) AutocompleteFlags() complete.Flags {
+	return mergeAutocompleteFlags(c.Meta.AutocompleteFlags(FlagSetClient),
+		complete.Flags{
+			"-node":       complete.PredictAnything,
+			"-checkpoint": complete.PredictNothing,
+		})
+}
+
+func (c *OperatorClientLxcBackupCommand) AutocompleteArgs() complete.Predictor {
+	return complete.PredictAnything
+}
+
+func (c *OperatorClientLxcBackupCommand) Synopsis() string {
+	return "Back up a container created by the LXC driver on a client"
+}
+
+func (c *OperatorClientLxcBackupCommand) Run(args []string) int {
+	var nodeID string
+	var checkpoint bool
+
+	flags := c.Meta.FlagSet("lxc backup", FlagSetClient)
+	flags.Usage = func() { c.Ui.Output(c.Help()) }
+	flags.StringVar(&nodeID, "node", "", "")
+	flags.BoolVar(&checkpoint, "checkpoint", false, "")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	// Check that we got exactly one container
+	args = flags.Args()
+	if len(args) != 1 {
+		c.Ui.Error(c.Help())
+		return 1
+	}
+	name := args[0]
+
+	// Set up a client.
+	client, err := c.Meta.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error initializing client: %s", err))
+		return 1
+	}
+
+	nodeID, err = lxcNodeID(client, nodeID)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	backup, err := client.Nodes().BackupLxcContainer(nodeID, name, checkpoint, nil)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error backing up container: %s", err))
+		return 1
+	}
+
+	basic := []string{
+		fmt.Sprintf("Container|%s", backup.Container),
+		fmt.Sprintf("Location|%s", backup.Location),
+		fmt.Sprintf("Size|%s", humanize.IBytes(uint64(backup.Size))),
+		fmt.Sprintf("Checkpoint|%v", backup.Checkpoint),
+		fmt.Sprintf("Duration|%s", backup.Duration),
+	}
+	c.Ui.Output(formatKV(basic))
+	return 0
+}
@@ -0,0 +1,111 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/posener/complete"
+)
+
+type OperatorClientLxcDestroyAllCommand struct {
+	Meta
+}
+
+func (c *OperatorClientLxcDestroyAllCommand) Help() string {
+	helpText := `
+Usage: nomad operator client lxc destroy-all [options]
+
+  Stops and destroys all the containers created by the LXC driver on a client,
+  along with their storage, several at a time. It is meant for decommissioning
+  a client faster than its allocations would be torn down one at a time. The
+  LXC driver on the client must first be put into maintenance with
+  "nomad operator client lxc maintenance -enable", so that the tasks of the
+  destroyed containers are not restarted in new ones.
+
+General Options:
+
+  ` + generalOptionsUsage() + `
+
+Destroy Options:
+
+  -node=<node-id>
+    The ID or prefix of the client. Defaults to the client of the agent being
+    queried.
+
+  -parallelism=<n>
+    How many containers are destroyed at once. Defaults to 8.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *OperatorClientLxcDestroyAllCommand) AutocompleteFlags() complete.Flags {
+	return mergeAutocompleteFlags(c.Meta.AutocompleteFlags(FlagSetClient),
+		complete.Flags{
+			"-node":        complete.PredictAnything,
+			"-parallelism": complete.PredictAnything,
+		})
+}
+
+func (c *OperatorClientLxcDestroyAllCommand) AutocompleteArgs() complete.Predictor {
+	return complete.PredictNothing
+}
+
+func (c *OperatorClientLxcDestroyAllCommand) Synopsis() string {
+	return "Destroy all containers created by the LXC driver on a client"
+}
+
+func (c *OperatorClientLxcDestroyAllCommand) Run(args []string) int {
+	var nodeID string
+	var parallelism int
+
+	flags := c.Meta.FlagSet("lxc destroy-all", FlagSetClient)
+	flags.Usage = func() { c.Ui.Output(c.Help()) }
+	flags.StringVar(&nodeID, "node", "", "")
+	flags.IntVar(&parallelism, "parallelism", 0, "")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if args = flags.Args(); len(args) != 0 || parallelism < 0 {
+		c.Ui.Error(c.Help())
+		return 1
+	}
+
+	// Set up a client.
+	client, err := c.Meta.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error initializing client: %s", err))
+		return 1
+	}
+
+	nodeID, err = lxcNodeID(client, nodeID)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	report, err := client.Nodes().DestroyLxcContainers(nodeID, parallelism, nil)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error destroying containers: %s", err))
+		return 1
+	}
+
+	c.Ui.Output(fmt.Sprintf("Destroyed %d container(s) in %s", len(report.Destroyed), report.Duration))
+	if len(report.Failed) == 0 {
+		return 0
+	}
+
+	names := make([]string, 0, len(report.Failed))
+	for name := range report.Failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]string, len(names)+1)
+	out[0] = "Name|Error"
+	for i, name := range names {
+		out[i+1] = fmt.Sprintf("%s|%s", name, report.Failed[name])
+	}
+	c.Ui.Error(fmt.Sprintf("Failed to destroy %d container(s)\n\n%s", len(names), formatList(out)))
+	return 2
+}
@@ -0,0 +1,101 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/posener/complete"
+)
+
+type OperatorClientLxcInspectCommand struct {
+	Meta
+}
+
+func (c *OperatorClientLxcInspectCommand) Help() string {
+	helpText := `
+Usage: nomad operator client lxc inspect [options] <container>
+
+  Displays the state and storage of a container created by the LXC driver on a
+  client.
+
+General Options:
+
+  ` + generalOptionsUsage() + `
+
+Inspect Options:
+
+  -node=<node-id>
+    The ID or prefix of the client the container is on. Defaults to the client
+    of the agent being queried.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *OperatorClientLxcInspectCommand) AutocompleteFlags() complete.Flags {
+	return mergeAutocompleteFlags(c.Meta.AutocompleteFlags(FlagSetClient),
+		complete.Flags{
+			"-node": complete.PredictAnything,
+		})
+}
+
+func (c *OperatorClientLxcInspectCommand) AutocompleteArgs() complete.Predictor {
+	return complete.PredictAnything
+}
+
+func (c *OperatorClientLxcInspectCommand) Synopsis() string {
+	return "Inspect a container created by the LXC driver on a client"
+}
+
+func (c *OperatorClientLxcInspectCommand) Run(args []string) int {
+	var nodeID string
+
+	flags := c.Meta.FlagSet("lxc inspect", FlagSetClient)
+	flags.Usage = func() { c.Ui.Output(c.Help()) }
+	flags.StringVar(&nodeID, "node", "", "")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	// Check that we got exactly one container
+	args = flags.Args()
+	if len(args) != 1 {
+		c.Ui.Error(c.Help())
+		return 1
+	}
+	name := args[0]
+
+	// Set up a client.
+	client, err := c.Meta.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error initializing client: %s", err))
+		return 1
+	}
+
+	nodeID, err = lxcNodeID(client, nodeID)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	container, err := client.Nodes().LxcContainer(nodeID, name, nil)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error inspecting container: %s", err))
+		return 1
+	}
+
+	basic := []string{
+		fmt.Sprintf("Name|%s", container.Name),
+		fmt.Sprintf("Alloc ID|%s", container.AllocID),
+		fmt.Sprintf("Task|%s", container.Task),
+		fmt.Sprintf("State|%s", container.State),
+		fmt.Sprintf("Init PID|%d", container.InitPid),
+		fmt.Sprintf("LXC Path|%s", container.LxcPath),
+		fmt.Sprintf("Root FS|%s", container.RootFS),
+		fmt.Sprintf("Orphaned|%v", container.Orphaned),
+	}
+	if !container.PreservedUntil.IsZero() {
+		basic = append(basic, fmt.Sprintf("Preserved Until|%s", formatTime(container.PreservedUntil)))
+	}
+	c.Ui.Output(formatKV(basic))
+	return 0
+}
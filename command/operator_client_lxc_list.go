@@ -0,0 +1,100 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/posener/complete"
+)
+
+type OperatorClientLxcListCommand struct {
+	Meta
+}
+
+func (c *OperatorClientLxcListCommand) Help() string {
+	helpText := `
+Usage: nomad operator client lxc list [options]
+
+  Lists the containers created by the LXC driver on a client, along with the
+  allocation and task each was created for. Containers whose allocation is no
+  longer known to the client are marked as orphaned.
+
+General Options:
+
+  ` + generalOptionsUsage() + `
+
+List Options:
+
+  -node=<node-id>
+    The ID or prefix of the client to list containers on. Defaults to the
+    client of the agent being queried.
+
+  -verbose
+    Display full allocation IDs.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *OperatorClientLxcListCommand) AutocompleteFlags() complete.Flags {
+	return mergeAutocompleteFlags(c.Meta.AutocompleteFlags(FlagSetClient),
+		complete.Flags{
+			"-node":    complete.PredictAnything,
+			"-verbose": complete.PredictNothing,
+		})
+}
+
+func (c *OperatorClientLxcListCommand) AutocompleteArgs() complete.Predictor {
+	return complete.PredictNothing
+}
+
+func (c *OperatorClientLxcListCommand) Synopsis() string {
+	return "List containers created by the LXC driver on a client"
+}
+
+func (c *OperatorClientLxcListCommand) Run(args []string) int {
+	var nodeID string
+	var verbose bool
+
+	flags := c.Meta.FlagSet("lxc list", FlagSetClient)
+	flags.Usage = func() { c.Ui.Output(c.Help()) }
+	flags.StringVar(&nodeID, "node", "", "")
+	flags.BoolVar(&verbose, "verbose", false, "")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if args = flags.Args(); len(args) != 0 {
+		c.Ui.Error(c.Help())
+		return 1
+	}
+
+	// Set up a client.
+	client, err := c.Meta.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error initializing client: %s", err))
+		return 1
+	}
+
+	nodeID, err = lxcNodeID(client, nodeID)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	containers, err := client.Nodes().LxcContainers(nodeID, nil)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error listing containers: %s", err))
+		return 1
+	}
+	if len(containers) == 0 {
+		c.Ui.Output("No containers found")
+		return 0
+	}
+
+	length := shortId
+	if verbose {
+		length = fullId
+	}
+	c.Ui.Output(formatLxcContainers(containers, length))
+	return 0
+}
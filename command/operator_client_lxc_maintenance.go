@@ -0,0 +1,126 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/posener/complete"
+)
+
+type OperatorClientLxcMaintenanceCommand struct {
+	Meta
+}
+
+func (c *OperatorClientLxcMaintenanceCommand) Help() string {
+	helpText := `
+Usage: nomad operator client lxc maintenance [options]
+
+  Toggles maintenance of the LXC driver on a client, or displays whether it is
+  in maintenance if neither -enable nor -disable is given. In maintenance the
+  driver starts no new containers and no new LXC tasks are placed on the
+  client, while the client stays eligible for tasks of other drivers and
+  running containers are not affected. Maintenance persists across restarts
+  of the client, so it can be used to drain LXC workloads before upgrading
+  liblxc or the kernel.
+
+General Options:
+
+  ` + generalOptionsUsage() + `
+
+Maintenance Options:
+
+  -node=<node-id>
+    The ID or prefix of the client. Defaults to the client of the agent being
+    queried.
+
+  -enable
+    Put the LXC driver into maintenance.
+
+  -disable
+    Take the LXC driver out of maintenance.
+
+  -reason=<reason>
+    The reason for the maintenance, which is reported by tasks that fail to
+    start while it lasts.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *OperatorClientLxcMaintenanceCommand) AutocompleteFlags() complete.Flags {
+	return mergeAutocompleteFlags(c.Meta.AutocompleteFlags(FlagSetClient),
+		complete.Flags{
+			"-node":    complete.PredictAnything,
+			"-enable":  complete.PredictNothing,
+			"-disable": complete.PredictNothing,
+			"-reason":  complete.PredictAnything,
+		})
+}
+
+func (c *OperatorClientLxcMaintenanceCommand) AutocompleteArgs() complete.Predictor {
+	return complete.PredictNothing
+}
+
+func (c *OperatorClientLxcMaintenanceCommand) Synopsis() string {
+	return "Pause or resume new starts of the LXC driver on a client"
+}
+
+func (c *OperatorClientLxcMaintenanceCommand) Run(args []string) int {
+	var nodeID, reason string
+	var enable, disable bool
+
+	flags := c.Meta.FlagSet("lxc maintenance", FlagSetClient)
+	flags.Usage = func() { c.Ui.Output(c.Help()) }
+	flags.StringVar(&nodeID, "node", "", "")
+	flags.BoolVar(&enable, "enable", false, "")
+	flags.BoolVar(&disable, "disable", false, "")
+	flags.StringVar(&reason, "reason", "", "")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	// Check that we got no arguments and at most one of enable and disable
+	if len(flags.Args()) != 0 || (enable && disable) {
+		c.Ui.Error(c.Help())
+		return 1
+	}
+
+	// Set up a client.
+	client, err := c.Meta.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error initializing client: %s", err))
+		return 1
+	}
+
+	nodeID, err = lxcNodeID(client, nodeID)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	if !enable && !disable {
+		m, err := client.Nodes().LxcMaintenance(nodeID, nil)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error reading LXC maintenance: %s", err))
+			return 1
+		}
+		basic := []string{fmt.Sprintf("Paused|%v", m.Paused)}
+		if m.Paused {
+			basic = append(basic,
+				fmt.Sprintf("Reason|%s", m.Reason),
+				fmt.Sprintf("Since|%s", formatTime(m.Since)))
+		}
+		c.Ui.Output(formatKV(basic))
+		return 0
+	}
+
+	if _, err := client.Nodes().SetLxcMaintenance(nodeID, enable, reason, nil); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error updating LXC maintenance: %s", err))
+		return 1
+	}
+	if enable {
+		c.Ui.Output(fmt.Sprintf("LXC driver on node %q paused for maintenance", limit(nodeID, shortId)))
+	} else {
+		c.Ui.Output(fmt.Sprintf("LXC driver on node %q resumed", limit(nodeID, shortId)))
+	}
+	return 0
+}
@@ -0,0 +1,101 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/posener/complete"
+)
+
+type OperatorClientLxcPruneCommand struct {
+	Meta
+}
+
+func (c *OperatorClientLxcPruneCommand) Help() string {
+	helpText := `
+Usage: nomad operator client lxc prune [options]
+
+  Stops and destroys the containers created by the LXC driver on a client
+  whose allocation is no longer known to the client. Such containers can be
+  left behind if a client loses its state or is restarted while tasks are being
+  torn down.
+
+General Options:
+
+  ` + generalOptionsUsage() + `
+
+Prune Options:
+
+  -node=<node-id>
+    The ID or prefix of the client to prune containers on. Defaults to the
+    client of the agent being queried.
+
+  -verbose
+    Display full allocation IDs.
+`
+	return strings.TrimSpace(helpText)
+}
+
+func (c *OperatorClientLxcPruneCommand) AutocompleteFlags() complete.Flags {
+	return mergeAutocompleteFlags(c.Meta.AutocompleteFlags(FlagSetClient),
+		complete.Flags{
+			"-node":    complete.PredictAnything,
+			"-verbose": complete.PredictNothing,
+		})
+}
+
+func (c *OperatorClientLxcPruneCommand) AutocompleteArgs() complete.Predictor {
+	return complete.PredictNothing
+}
+
+func (c *OperatorClientLxcPruneCommand) Synopsis() string {
+	return "Destroy orphaned containers created by the LXC driver on a client"
+}
+
+func (c *OperatorClientLxcPruneCommand) Run(args []string) int {
+	var nodeID string
+	var verbose bool
+
+	flags := c.Meta.FlagSet("lxc prune", FlagSetClient)
+	flags.Usage = func() { c.Ui.Output(c.Help()) }
+	flags.StringVar(&nodeID, "node", "", "")
+	flags.BoolVar(&verbose, "verbose", false, "")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if args = flags.Args(); len(args) != 0 {
+		c.Ui.Error(c.Help())
+		return 1
+	}
+
+	// Set up a client.
+	client, err := c.Meta.Client()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error initializing client: %s", err))
+		return 1
+	}
+
+	nodeID, err = lxcNodeID(client, nodeID)
+	if err != nil {
+		c.Ui.Error(err.Error())
+		return 1
+	}
+
+	pruned, err := client.Nodes().PruneLxcContainers(nodeID, nil)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error pruning containers: %s", err))
+		return 1
+	}
+	if len(pruned) == 0 {
+		c.Ui.Output("No orphaned containers found")
+		return 0
+	}
+
+	length := shortId
+	if verbose {
+		length = fullId
+	}
+	c.Ui.Output(fmt.Sprintf("Pruned %d container(s)\n\n%s", len(pruned), formatLxcContainers(pruned, length)))
+	return 0
+}
@@ -0,0 +1,54 @@
+package command
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mitchellh/cli"
+)
+
+func TestOperator_Client_Lxc_Implements(t *testing.T) {
+	t.Parallel()
+	var _ cli.Command = &OperatorClientCommand{}
+	var _ cli.Command = &OperatorClientLxcCommand{}
+	var _ cli.Command = &OperatorClientLxcListCommand{}
+	var _ cli.Command = &OperatorClientLxcInspectCommand{}
+	var _ cli.Command = &OperatorClientLxcPruneCommand{}
+	var _ cli.Command = &OperatorClientLxcBackupCommand{}
+	var _ cli.Command = &OperatorClientLxcDestroyAllCommand{}
+	var _ cli.Command = &OperatorClientLxcMaintenanceCommand{}
+}
+
+func TestOperator_Client_Lxc_Fails(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		cmd  cli.Command
+		args []string
+	}{
+		{&OperatorClientLxcListCommand{Meta: Meta{Ui: new(cli.MockUi)}}, []string{"extra"}},
+		{&OperatorClientLxcInspectCommand{Meta: Meta{Ui: new(cli.MockUi)}}, nil},
+		{&OperatorClientLxcInspectCommand{Meta: Meta{Ui: new(cli.MockUi)}}, []string{"a", "b"}},
+		{&OperatorClientLxcPruneCommand{Meta: Meta{Ui: new(cli.MockUi)}}, []string{"extra"}},
+		{&OperatorClientLxcBackupCommand{Meta: Meta{Ui: new(cli.MockUi)}}, nil},
+		{&OperatorClientLxcBackupCommand{Meta: Meta{Ui: new(cli.MockUi)}}, []string{"a", "b"}},
+		{&OperatorClientLxcDestroyAllCommand{Meta: Meta{Ui: new(cli.MockUi)}}, []string{"extra"}},
+		{&OperatorClientLxcDestroyAllCommand{Meta: Meta{Ui: new(cli.MockUi)}}, []string{"-parallelism=-1"}},
+		{&OperatorClientLxcMaintenanceCommand{Meta: Meta{Ui: new(cli.MockUi)}}, []string{"extra"}},
+		{&OperatorClientLxcMaintenanceCommand{Meta: Meta{Ui: new(cli.MockUi)}}, []string{"-enable", "-disable"}},
+	}
+	for _, c := range cases {
+		if code := c.cmd.Run(c.args); code != 1 {
+			t.Fatalf("expected exit code 1 for %T %v, got: %d", c.cmd, c.args, code)
+		}
+	}
+
+	// Fails on connection failure
+	ui := new(cli.MockUi)
+	cmd := &OperatorClientLxcListCommand{Meta: Meta{Ui: ui}}
+	if code := cmd.Run([]string{"-address=nope", "-node=12345678"}); code != 1 {
+		t.Fatalf("expected exit code 1, got: %d", code)
+	}
+	if out := ui.ErrorWriter.String(); !strings.Contains(out, "Error querying node") {
+		t.Fatalf("expected failed query error, got: %s", out)
+	}
+}
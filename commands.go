@@ -293,6 +293,54 @@ func Commands(metaPtr *command.Meta) map[string]cli.CommandFactory {
 			}, nil
 		},
 
+		"operator client": func() (cli.Command, error) {
+			return &command.OperatorClientCommand{
+				Meta: meta,
+			}, nil
+		},
+
+		"operator client lxc": func() (cli.Command, error) {
+			return &command.OperatorClientLxcCommand{
+				Meta: meta,
+			}, nil
+		},
+
+		"operator client lxc backup": func() (cli.Command, error) {
+			return &command.OperatorClientLxcBackupCommand{
+				Meta: meta,
+			}, nil
+		},
+
+		"operator client lxc destroy-all": func() (cli.Command, error) {
+			return &command.OperatorClientLxcDestroyAllCommand{
+				Meta: meta,
+			}, nil
+		},
+
+		"operator client lxc inspect": func() (cli.Command, error) {
+			return &command.OperatorClientLxcInspectCommand{
+				Meta: meta,
+			}, nil
+		},
+
+		"operator client lxc list": func() (cli.Command, error) {
+			return &command.OperatorClientLxcListCommand{
+				Meta: meta,
+			}, nil
+		},
+
+		"operator client lxc maintenance": func() (cli.Command, error) {
+			return &command.OperatorClientLxcMaintenanceCommand{
+				Meta: meta,
+			}, nil
+		},
+
+		"operator client lxc prune": func() (cli.Command, error) {
+			return &command.OperatorClientLxcPruneCommand{
+				Meta: meta,
+			}, nil
+		},
+
 		"operator raft": func() (cli.Command, error) {
 			return &command.OperatorRaftCommand{
 				Meta: meta,
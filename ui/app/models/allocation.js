@@ -80,6 +80,23 @@ export default Model.extend({
     return used / total;
   }),
 
+  // Disk stats are only reported by drivers measuring the usage of their
+  // tasks' filesystems, and are relative to the size of those filesystems
+  diskUsed: readOnly('stats.ResourceUsage.DiskStats.Used'),
+  diskSize: computed('diskUsed', 'stats.ResourceUsage.DiskStats.Available', function() {
+    const available = this.get('stats.ResourceUsage.DiskStats.Available') || 0;
+    return (this.get('diskUsed') || 0) + available;
+  }),
+
+  percentDisk: computed('diskUsed', 'diskSize', function() {
+    const used = this.get('diskUsed');
+    const total = this.get('diskSize');
+    if (!total || !used) {
+      return 0;
+    }
+    return used / total;
+  }),
+
   stats: computed('node.{isPartial,httpAddr}', function() {
     const nodeIsPartial = this.get('node.isPartial');
 
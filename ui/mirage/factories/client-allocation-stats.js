@@ -38,5 +38,16 @@ function generateResources() {
       RSS: 1486848,
       Swap: 0,
     },
+    DiskStats: {
+      Available: 7516192768,
+      Filesystems: {
+        '/': {
+          Available: 7516192768,
+          Used: 3221225472,
+        },
+      },
+      Measured: ['Used', 'Available'],
+      Used: 3221225472,
+    },
   };
 }
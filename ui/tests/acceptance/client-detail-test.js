@@ -194,6 +194,22 @@ test('each allocation should have high-level details for the allocation', functi
       `${formatBytes([allocStats.resourceUsage.MemoryStats.RSS])} / ${memoryUsed} MiB`,
       'Detailed memory information is in a tooltip'
     );
+
+    const diskStats = allocStats.resourceUsage.DiskStats;
+    const diskSize = diskStats.Used + diskStats.Available;
+    assert.equal(
+      allocationRow
+        .find('[data-test-disk]')
+        .text()
+        .trim(),
+      diskStats.Used / diskSize,
+      'Disk used'
+    );
+    assert.equal(
+      allocationRow.find('[data-test-disk] .tooltip').attr('aria-label'),
+      `${formatBytes([diskStats.Used])} / ${formatBytes([diskSize])}`,
+      'Detailed disk information is in a tooltip'
+    );
   });
 });
 
@@ -220,6 +220,20 @@ test('each allocation should show stats about the allocation, retrieved directly
     'Detailed memory information is in a tooltip'
   );
 
+  const diskStats = allocStats.resourceUsage.DiskStats;
+  const diskSize = diskStats.Used + diskStats.Available;
+  assert.equal(
+    allocationRow.querySelector('[data-test-disk]').textContent.trim(),
+    diskStats.Used / diskSize,
+    'Disk used'
+  );
+
+  assert.equal(
+    allocationRow.querySelector('[data-test-disk] .tooltip').getAttribute('aria-label'),
+    `${formatBytes([diskStats.Used])} / ${formatBytes([diskSize])}`,
+    'Detailed disk information is in a tooltip'
+  );
+
   const node = server.db.nodes.find(allocation.nodeId);
   const nodeStatsUrl = `//${node.httpAddr}/v1/client/allocation/${allocation.id}/stats`;
 